@@ -21,14 +21,26 @@ const (
 	// to record the last health assessment result.
 	HealthyCondition string = "Healthy"
 
+	// RolledBackCondition is the condition type used to record the
+	// rollback to the last healthy revision after failed health checks.
+	RolledBackCondition string = "RolledBack"
+
 	// PruneFailedReason represents the fact that the
 	// pruning of the Kustomization failed.
 	PruneFailedReason string = "PruneFailed"
 
+	// GarbageCollectingReason represents the fact that the objects
+	// of a deleted Kustomization are being removed from the cluster.
+	GarbageCollectingReason string = "GarbageCollecting"
+
 	// ArtifactFailedReason represents the fact that the
 	// artifact download of the kustomization failed.
 	ArtifactFailedReason string = "ArtifactFailed"
 
+	// ArtifactNotFoundReason represents the fact that the artifact
+	// is permanently missing from the source storage.
+	ArtifactNotFoundReason string = "ArtifactNotFound"
+
 	// BuildFailedReason represents the fact that the
 	// kustomize build of the Kustomization failed.
 	BuildFailedReason string = "BuildFailed"
@@ -40,4 +52,81 @@ const (
 	// ValidationFailedReason represents the fact that the
 	// validation of the Kustomization manifests has failed.
 	ValidationFailedReason string = "ValidationFailed"
+
+	// AdmissionDeniedReason represents the fact that an admission
+	// webhook denied the validation or the apply of the manifests.
+	AdmissionDeniedReason string = "AdmissionDenied"
+
+	// ReadOnlyReason represents the fact that the changes were
+	// not applied because the controller runs in read-only mode.
+	ReadOnlyReason string = "ReadOnly"
+
+	// DiffOnlyReason represents the fact that the changes were not
+	// applied because the Kustomization runs in diff-only mode.
+	DiffOnlyReason string = "DiffOnly"
+
+	// DependencyTimeoutReason represents the fact that the dependencies
+	// of the Kustomization were not ready within the dependency timeout.
+	DependencyTimeoutReason string = "DependencyTimeout"
+
+	// MaxDeltaExceededReason represents the fact that the revision was not
+	// applied because it modifies or deletes more objects than allowed.
+	MaxDeltaExceededReason string = "MaxDeltaExceeded"
+
+	// InvalidPathReason represents the fact that the path of the
+	// Kustomization points outside of the artifact root.
+	InvalidPathReason string = "InvalidPath"
+
+	// KustomizationMissingReason represents the fact that the path of the
+	// Kustomization contains neither a kustomization.yaml nor manifests.
+	KustomizationMissingReason string = "KustomizationMissing"
+
+	// HookFailedReason represents the fact that a pre-apply
+	// or post-apply hook Job of the Kustomization failed.
+	HookFailedReason string = "HookFailed"
+
+	// RollbackSucceededReason represents the fact that the last healthy
+	// revision was re-applied after the health checks of a new revision failed.
+	RollbackSucceededReason string = "RollbackSucceeded"
+
+	// RollbackFailedReason represents the fact that the rollback
+	// to the last healthy revision failed or was not possible.
+	RollbackFailedReason string = "RollbackFailed"
+
+	// AccessDeniedReason represents the fact that the Kustomization refers
+	// to an object in another namespace while cross-namespace references are disabled,
+	// or applies objects to namespaces not allowed by the namespace policy.
+	AccessDeniedReason string = "AccessDenied"
+
+	// PermissionDeniedReason represents the fact that the identity the
+	// Kustomization is reconciled with lacks the permissions to apply the objects.
+	PermissionDeniedReason string = "PermissionDenied"
+
+	// QuotaExceededReason represents the fact that the build output exceeds
+	// the object quota of the NamespaceConfig.
+	QuotaExceededReason string = "QuotaExceeded"
+
+	// KubeConfigAuthFailedReason represents the fact that the remote cluster
+	// rejected the credentials of the KubeConfig secret.
+	KubeConfigAuthFailedReason string = "KubeConfigAuthFailed"
+
+	// WindowClosedReason represents the fact that a new revision is not
+	// applied as it's outside of the schedule windows of the Kustomization.
+	WindowClosedReason string = "WindowClosed"
+
+	// PendingApprovalReason represents the fact that a new revision is not
+	// applied until it's approved with the approve annotation.
+	PendingApprovalReason string = "PendingApproval"
+
+	// CanaryFailedReason represents the fact that the Flagger canary
+	// analysis of a Deployment configured by the revision failed.
+	CanaryFailedReason string = "CanaryFailed"
+
+	// ProgressDeadlineExceededReason represents the fact that the Kustomization
+	// did not become ready within the progress deadline.
+	ProgressDeadlineExceededReason string = "ProgressDeadlineExceeded"
+
+	// ProgressingWithRetryReason represents the fact that the reconciliation
+	// failed and is retried at the retry interval.
+	ProgressingWithRetryReason string = "ProgressingWithRetry"
 )
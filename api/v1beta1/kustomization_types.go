@@ -24,6 +24,7 @@ import (
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 
 	"github.com/fluxcd/pkg/apis/kustomize"
 	"github.com/fluxcd/pkg/apis/meta"
@@ -37,13 +38,63 @@ const (
 	DisabledValue             = "disabled"
 )
 
+const (
+	// DeletionPolicyDelete removes the managed objects
+	// from the cluster when the Kustomization is deleted.
+	DeletionPolicyDelete = "Delete"
+
+	// DeletionPolicyOrphan leaves the managed objects
+	// on the cluster when the Kustomization is deleted.
+	DeletionPolicyOrphan = "Orphan"
+)
+
+const (
+	// ApplyMode applies the build output on the cluster.
+	ApplyMode = "Apply"
+
+	// DiffOnlyMode reports the changes the build output
+	// would make to the cluster, without applying them.
+	DiffOnlyMode = "DiffOnly"
+)
+
+const (
+	// ForceConflictPolicy takes the ownership of the
+	// fields managed by other field managers.
+	ForceConflictPolicy = "Force"
+
+	// SkipConflictPolicy leaves the fields managed by other field
+	// managers unchanged, the other fields of the object are applied.
+	SkipConflictPolicy = "Skip"
+
+	// FailConflictPolicy fails the apply if the object has fields
+	// managed by other field managers with different values.
+	FailConflictPolicy = "Fail"
+)
+
+const (
+	// GitHubProvider updates the commit statuses on GitHub or GitHub Enterprise.
+	GitHubProvider = "github"
+
+	// GitLabProvider updates the commit statuses on GitLab.
+	GitLabProvider = "gitlab"
+
+	// BitbucketProvider updates the commit statuses on Bitbucket Cloud.
+	BitbucketProvider = "bitbucket"
+)
+
 // KustomizationSpec defines the desired state of a kustomization.
 type KustomizationSpec struct {
-	// DependsOn may contain a dependency.CrossNamespaceDependencyReference slice
-	// with references to Kustomization resources that must be ready before this
-	// Kustomization can be reconciled.
+	// DependsOn may contain a DependencyReference slice with references to
+	// Kustomizations, or to other objects with a Ready condition such as
+	// HelmReleases, that must be ready before this Kustomization can be reconciled.
 	// +optional
-	DependsOn []dependency.CrossNamespaceDependencyReference `json:"dependsOn,omitempty"`
+	DependsOn []DependencyReference `json:"dependsOn,omitempty"`
+
+	// DependencyTimeout is the time to wait for the dependencies to become ready,
+	// after which the Kustomization is marked as failed. The dependencies are
+	// still checked at the requeue interval after the timeout.
+	// +optional
+	DependencyTimeout *metav1.Duration `json:"dependencyTimeout,omitempty"`
 
 	// Decrypt Kubernetes secrets before applying them on the cluster.
 	// +optional
@@ -53,6 +104,17 @@ type KustomizationSpec struct {
 	// +required
 	Interval metav1.Duration `json:"interval"`
 
+	// The interval at which to compare the checksum of the in-cluster state of the
+	// applied objects with the one recorded at the last reconciliation, without
+	// downloading the source artifact. The checksum accounts for the generation of
+	// the objects that have one and for the resource version of the others, hence
+	// the label and annotation changes of the objects with a generation are not
+	// detected. A full reconciliation is run when the checksums differ, or when
+	// the source revision changed. Must be shorter than the interval.
+	// When not specified, the state is checked at the interval.
+	// +optional
+	DriftCheckInterval *metav1.Duration `json:"driftCheckInterval,omitempty"`
+
 	// The interval at which to retry a previously failed reconciliation.
 	// When not specified, the controller uses the KustomizationSpec.Interval
 	// value to retry failures.
@@ -64,9 +126,19 @@ type KustomizationSpec struct {
 	// +optional
 	KubeConfig *KubeConfig `json:"kubeConfig,omitempty"`
 
+	// Clusters fan out the reconciliation to the remote clusters of the
+	// selected KubeConfig secrets. The build output is applied to each cluster
+	// with the cluster substitution variables, and the status of each cluster
+	// is recorded in status.clusters.
+	// When specified, Clusters takes precedence over KubeConfig.
+	// +optional
+	Clusters []ClusterTarget `json:"clusters,omitempty"`
+
 	// Path to the directory containing the kustomization.yaml file, or the
 	// set of plain YAMLs a kustomization.yaml should be generated for.
 	// Defaults to 'None', which translates to the root path of the SourceRef.
+	// The path must be relative to the root of the artifact and can't contain '..'.
+	// +kubebuilder:validation:Pattern="^([^/.][^/]*|\\.[^/.][^/]*|\\.\\.[^/]+|\\.)(/([^/.][^/]*|\\.[^/.][^/]*|\\.\\.[^/]+|\\.)?)*$"
 	// +optional
 	Path string `json:"path,omitempty"`
 
@@ -75,14 +147,51 @@ type KustomizationSpec struct {
 	// +optional
 	PostBuild *PostBuild `json:"postBuild,omitempty"`
 
-	// Prune enables garbage collection.
+	// Prune enables garbage collection. The controller labels the applied
+	// objects with the name and namespace of the Kustomization, and deletes
+	// the objects that are no longer part of the build output.
 	// +required
 	Prune bool `json:"prune"`
 
+	// PruneDisabledFor is a list of kinds e.g. 'PersistentVolumeClaim' or 'Namespace',
+	// the objects of these kinds are never deleted by the garbage collection.
+	// +optional
+	PruneDisabledFor []string `json:"pruneDisabledFor,omitempty"`
+
+	// PruneEnabledFor is a list of cluster-critical kinds e.g. 'Namespace' or
+	// 'CustomResourceDefinition', that the garbage collection is allowed to delete.
+	// The Namespaces, CustomResourceDefinitions, PersistentVolumes and the RBAC
+	// objects of the controller are never deleted unless their kind is listed.
+	// +optional
+	PruneEnabledFor []string `json:"pruneEnabledFor,omitempty"`
+
 	// A list of resources to be included in the health assessment.
 	// +optional
 	HealthChecks []meta.NamespacedObjectKindReference `json:"healthChecks,omitempty"`
 
+	// HealthChecksFrom is the path to a YAML file in the source artifact,
+	// containing a list of resources to be included in the health assessment.
+	// The resources are merged with the ones defined in spec.healthChecks.
+	// +optional
+	HealthChecksFrom string `json:"healthChecksFrom,omitempty"`
+
+	// HealthCheckExprs is a list of CEL expressions used to assess the health
+	// of the custom resources that don't follow the kstatus conventions.
+	// +optional
+	HealthCheckExprs []CustomHealthCheck `json:"healthCheckExprs,omitempty"`
+
+	// Wait instructs the controller to check the health of all the applied
+	// objects, in addition to the ones defined in spec.healthChecks.
+	// +optional
+	Wait bool `json:"wait,omitempty"`
+
+	// WaitForOperators instructs the controller to wait for the workloads that
+	// manage the custom resources defined in the same build to become ready,
+	// before applying the custom resources. The workloads are detected from the
+	// RBAC bindings of their service accounts.
+	// +optional
+	WaitForOperators bool `json:"waitForOperators,omitempty"`
+
 	// Strategic merge and JSON patches, defined as inline YAML objects,
 	// capable of targeting objects based on kind, label and annotation selectors.
 	// +optional
@@ -107,6 +216,12 @@ type KustomizationSpec struct {
 	// +optional
 	ServiceAccountName string `json:"serviceAccountName,omitempty"`
 
+	// Impersonation sets the user and groups to impersonate when reconciling
+	// this Kustomization, on top of the identity of the controller, of the
+	// ServiceAccountName or of the KubeConfig.
+	// +optional
+	Impersonation *Impersonation `json:"impersonation,omitempty"`
+
 	// Reference of the source where the kustomization file is.
 	// +required
 	SourceRef CrossNamespaceSourceReference `json:"sourceRef"`
@@ -124,16 +239,30 @@ type KustomizationSpec struct {
 	// +optional
 	TargetNamespace string `json:"targetNamespace,omitempty"`
 
+	// CreateNamespace instructs the controller to create the target namespace
+	// if it doesn't exist, before applying the objects. The namespace is labeled
+	// with the name and namespace of the Kustomization, and is never deleted
+	// by the controller. Requires spec.targetNamespace to be set.
+	// +optional
+	CreateNamespace bool `json:"createNamespace,omitempty"`
+
 	// Timeout for validation, apply and health checking operations.
-	// Defaults to 'Interval' duration.
+	// Defaults to 'Interval' duration, with a minimum of one minute.
 	// +optional
 	Timeout *metav1.Duration `json:"timeout,omitempty"`
 
+	// ProgressDeadline is the maximum duration for the Kustomization to become
+	// ready after the apply of a new revision or spec change. When exceeded,
+	// the Kustomization is marked as stalled, with the objects blocking
+	// its readiness listed in the Stalled condition message.
+	// +optional
+	ProgressDeadline *metav1.Duration `json:"progressDeadline,omitempty"`
+
 	// Validate the Kubernetes objects before applying them on the cluster.
-	// The validation strategy can be 'client' (local dry-run), 'server'
-	// (APIServer dry-run) or 'none'.
-	// When 'Force' is 'true', validation will fallback to 'client' if set to
-	// 'server' because server-side validation is not supported in this scenario.
+	// The validation strategy can be 'client' (checks that the kinds are
+	// served by the APIServer), 'server' (APIServer dry-run) or 'none'.
+	// When not specified, the objects are validated with an APIServer dry-run,
+	// and the objects whose kinds are not served yet are skipped.
 	// +kubebuilder:validation:Enum=none;client;server
 	// +optional
 	Validation string `json:"validation,omitempty"`
@@ -143,6 +272,229 @@ type KustomizationSpec struct {
 	// +kubebuilder:default:=false
 	// +optional
 	Force bool `json:"force,omitempty"`
+
+	// Atomic instructs the controller to validate all the objects with an
+	// APIServer dry-run before applying any of them, the apply is not started
+	// if any object fails the validation. When the apply fails, the objects
+	// applied and not applied are recorded in status.partialApply.
+	// +optional
+	Atomic bool `json:"atomic,omitempty"`
+
+	// Preview instructs the controller to record the build output and the
+	// changes it would make to the cluster, before applying a new revision.
+	// The preview is stored in a ConfigMap named after the Kustomization
+	// with the '-preview' suffix, in the same namespace.
+	// +kubebuilder:default:=false
+	// +optional
+	Preview bool `json:"preview,omitempty"`
+
+	// Attest instructs the controller to record the provenance of the applied
+	// build output as an in-toto statement, in a ConfigMap named after the
+	// Kustomization with the '-attestation' suffix, in the same namespace.
+	// +optional
+	Attest bool `json:"attest,omitempty"`
+
+	// DeletionPolicy determines whether the managed objects are deleted
+	// or orphaned when the Kustomization is deleted. Valid values are
+	// 'Delete' and 'Orphan'. When not specified, the objects are deleted
+	// only if garbage collection is enabled with spec.prune.
+	// +kubebuilder:validation:Enum=Delete;Orphan
+	// +optional
+	DeletionPolicy string `json:"deletionPolicy,omitempty"`
+
+	// Mode determines whether the build output is applied on the cluster,
+	// or only compared against the in-cluster state. Valid values are 'Apply'
+	// and 'DiffOnly', defaults to 'Apply'. In 'DiffOnly' mode, the changes
+	// are reported in the preview, the Ready condition and the events.
+	// +kubebuilder:validation:Enum=Apply;DiffOnly
+	// +optional
+	Mode string `json:"mode,omitempty"`
+
+	// ApplyOptions overrides the controller defaults for the batch size,
+	// the concurrency and the interval between batches when applying objects.
+	// +optional
+	ApplyOptions *ApplyOptions `json:"applyOptions,omitempty"`
+
+	// IgnoreDifferences is a list of field paths of the applied objects that
+	// are owned by other controllers e.g. the replicas of a Deployment scaled by
+	// an HPA. The in-cluster values of these fields are preserved on apply, and
+	// their changes are not reported as drift.
+	// +optional
+	IgnoreDifferences []IgnoreRule `json:"ignoreDifferences,omitempty"`
+
+	// MaxDelta is the maximum number or percentage of the inventory objects
+	// that a reconciliation can modify or delete, e.g. '10' or '30%'. When
+	// exceeded, the revision is not applied unless the Kustomization is annotated
+	// with 'kustomize.toolkit.fluxcd.io/allow-delta' set to the source revision.
+	// Overrides the controller default set with '--max-delta'.
+	// +kubebuilder:validation:XIntOrString
+	// +optional
+	MaxDelta *intstr.IntOrString `json:"maxDelta,omitempty"`
+
+	// ConflictPolicy determines how the controller handles the fields of the
+	// applied objects that are managed by other field managers with different
+	// values. Valid values are 'Force', 'Skip' and 'Fail', defaults to 'Force'.
+	// +kubebuilder:validation:Enum=Force;Skip;Fail
+	// +optional
+	ConflictPolicy string `json:"conflictPolicy,omitempty"`
+
+	// Hooks are Jobs of the build output run before and after the apply
+	// of a new revision, e.g. database migrations or smoke tests.
+	// +optional
+	Hooks *Hooks `json:"hooks,omitempty"`
+
+	// Rollback enables the re-apply of the last healthy revision when the
+	// health checks fail after applying a new revision. The build output of
+	// the last healthy revision is kept in the artifact cache of the controller.
+	// +optional
+	Rollback bool `json:"rollback,omitempty"`
+
+	// Schedule restricts the apply of new revisions to time windows,
+	// outside of the windows the changes are reported as in 'DiffOnly' mode.
+	// +optional
+	Schedule *Schedule `json:"schedule,omitempty"`
+
+	// RequireApproval defers the apply of new revisions until the Kustomization
+	// is annotated with 'kustomize.toolkit.fluxcd.io/approve' set to the source
+	// revision, the pending changes are reported as in 'DiffOnly' mode.
+	// +optional
+	RequireApproval bool `json:"requireApproval,omitempty"`
+
+	// CommitStatus enables the update of the commit status on the Git provider,
+	// with the result of the reconciliation of the applied revision.
+	// +optional
+	CommitStatus *CommitStatus `json:"commitStatus,omitempty"`
+}
+
+// IgnoreRule defines the field paths that the controller
+// ignores for the objects matching the target selector.
+type IgnoreRule struct {
+	// Paths is a list of JSON pointers to the ignored fields e.g. '/spec/replicas'.
+	// The '*' segment matches all the elements of an array
+	// e.g. '/webhooks/*/clientConfig/caBundle'.
+	// +required
+	Paths []string `json:"paths"`
+
+	// Target selects the objects the paths apply to,
+	// when not specified the paths apply to all objects.
+	// +optional
+	Target *kustomize.Selector `json:"target,omitempty"`
+}
+
+// Hooks defines the Jobs run before and after the apply of a new revision.
+// The hook Jobs are excluded from the apply, the inventory and the garbage
+// collection. A hook Job is recreated when it runs, and the reconciliation
+// fails if the Job fails or doesn't complete within the timeout.
+type Hooks struct {
+	// PreApply is the list of Jobs run in order before the objects are applied.
+	// +optional
+	PreApply []HookReference `json:"preApply,omitempty"`
+
+	// PostApply is the list of Jobs run in order after the objects
+	// are applied, pruned and the health checks passed.
+	// +optional
+	PostApply []HookReference `json:"postApply,omitempty"`
+}
+
+// Schedule defines the time windows during which the new revisions are applied.
+type Schedule struct {
+	// Windows during which the new revisions are applied.
+	// +required
+	Windows []ScheduleWindow `json:"windows"`
+
+	// TimeZone of the cron expressions, as an IANA time zone name
+	// e.g. 'Europe/London', defaults to UTC.
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// ScheduleWindow is a time window opening at the times of a cron expression.
+type ScheduleWindow struct {
+	// Cron expression in the five fields format at which the window opens,
+	// e.g. '0 22 * * 1-5' for 10pm on weekdays.
+	// +required
+	Cron string `json:"cron"`
+
+	// Duration for which the window stays open.
+	// +required
+	Duration metav1.Duration `json:"duration"`
+}
+
+// CommitStatus defines the Git provider on which the commit statuses are updated.
+type CommitStatus struct {
+	// Provider of the Git repository.
+	// +kubebuilder:validation:Enum=github;gitlab;bitbucket
+	// +required
+	Provider string `json:"provider"`
+
+	// Address of the Git repository e.g. 'https://github.com/org/repo',
+	// defaults to the URL of the GitRepository source. The HTTP addresses
+	// are rejected, as the credentials would be sent in cleartext.
+	// +optional
+	Address string `json:"address,omitempty"`
+
+	// SecretRef references the Secret holding the credentials of the Git provider,
+	// the 'token' key for GitHub and GitLab, the 'username' and 'password' keys
+	// of an app password for Bitbucket.
+	// +required
+	SecretRef meta.LocalObjectReference `json:"secretRef"`
+
+	// URL linked from the commit status, e.g. the dashboard of the cluster,
+	// defaults to the address of the repository on Bitbucket.
+	// +optional
+	URL string `json:"url,omitempty"`
+}
+
+// CommitStatusReport records the last commit status updated on the Git provider.
+type CommitStatusReport struct {
+	// Revision is the source revision of the commit.
+	// +required
+	Revision string `json:"revision"`
+
+	// State of the commit status, one of 'pending', 'success' or 'failure'.
+	// +required
+	State string `json:"state"`
+}
+
+// HookReference references a Job of the build output.
+type HookReference struct {
+	// Name of the Job.
+	// +required
+	Name string `json:"name"`
+
+	// Namespace of the Job, when not specified
+	// the Job is matched by name only.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// ApplyOptions defines how the objects are applied on the cluster.
+// The objects of each stage are applied in batches, the reconcile
+// budget is checked and the batch interval is awaited between batches.
+type ApplyOptions struct {
+	// BatchSize is the maximum number of objects applied in a batch.
+	// When set to 0, all the objects of a stage are applied in a single batch.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	BatchSize *int `json:"batchSize,omitempty"`
+
+	// Concurrency is the number of objects applied in parallel within a batch.
+	// When set to 1, the objects are applied serially in order.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Concurrency *int `json:"concurrency,omitempty"`
+
+	// BatchInterval is the time to wait between the batches, e.g. to
+	// lessen the load on admission webhooks.
+	// +optional
+	BatchInterval *metav1.Duration `json:"batchInterval,omitempty"`
+
+	// VerifyBatches waits for the objects of each batch to become ready,
+	// according to kstatus, and for the Jobs to complete, before applying
+	// the next batch. The apply fails if the objects of the batches are not
+	// ready within the timeout, shared by all the batches.
+	// +optional
+	VerifyBatches bool `json:"verifyBatches,omitempty"`
 }
 
 // Decryption defines how decryption is handled for Kubernetes manifests.
@@ -157,11 +509,66 @@ type Decryption struct {
 	SecretRef *meta.LocalObjectReference `json:"secretRef,omitempty"`
 }
 
+// Impersonation holds the user and groups to impersonate, e.g. to match
+// the authorization rules bound to the OIDC groups of a cluster.
+type Impersonation struct {
+	// User is the name of the user to impersonate.
+	// +required
+	User string `json:"user"`
+
+	// Groups are the groups to impersonate, in addition to the groups
+	// the API server assigns to the user.
+	// +optional
+	Groups []string `json:"groups,omitempty"`
+}
+
+// ClusterTarget selects the KubeConfig secrets of remote clusters,
+// in the namespace of the Kustomization.
+type ClusterTarget struct {
+	// SecretRef holds the name of a KubeConfig secret.
+	// +optional
+	SecretRef *meta.LocalObjectReference `json:"secretRef,omitempty"`
+
+	// SecretSelector selects the KubeConfig secrets by their labels.
+	// +optional
+	SecretSelector *metav1.LabelSelector `json:"secretSelector,omitempty"`
+
+	// Substitute holds the variables substituted in the build output applied
+	// to the selected clusters, in addition to the spec.postBuild variables.
+	// The cluster_name variable is set to the name of the KubeConfig secret.
+	// +optional
+	Substitute map[string]string `json:"substitute,omitempty"`
+}
+
+// ClusterStatus is the status of a remote cluster targeted by spec.clusters.
+type ClusterStatus struct {
+	// Name of the KubeConfig secret of the cluster.
+	// +required
+	Name string `json:"name"`
+
+	// Ready is true if the last revision was applied to the cluster
+	// and passed the health checks.
+	// +required
+	Ready bool `json:"ready"`
+
+	// Message holds the result of the last reconciliation of the cluster.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// LastAppliedRevision is the last revision successfully applied to the cluster.
+	// +optional
+	LastAppliedRevision string `json:"lastAppliedRevision,omitempty"`
+
+	// Inventory of the objects applied to the cluster.
+	// +optional
+	Inventory *ResourceInventory `json:"inventory,omitempty"`
+}
+
 // KubeConfig references a Kubernetes secret that contains a kubeconfig file.
 type KubeConfig struct {
-	// SecretRef holds the name to a secret that contains a 'value' key with
-	// the kubeconfig file as the value. It must be in the same namespace as
-	// the Kustomization.
+	// SecretRef holds the name to a secret that contains a 'value' key, or
+	// a 'value.yaml' key, with the kubeconfig file as the value. It must be
+	// in the same namespace as the Kustomization.
 	// It is recommended that the kubeconfig is self-contained, and the secret
 	// is regularly updated if credentials such as a cloud-access-token expire.
 	// Cloud specific `cmd-path` auth helpers will not function without adding
@@ -207,6 +614,34 @@ type SubstituteReference struct {
 	Name string `json:"name"`
 }
 
+// CustomHealthCheck defines the health check for custom resources
+// that don't follow the kstatus conventions, using CEL expressions
+// evaluated against the 'metadata', 'spec' and 'status' of the resources.
+type CustomHealthCheck struct {
+	// APIVersion of the custom resources, in the format 'group/version'.
+	// +required
+	APIVersion string `json:"apiVersion"`
+
+	// Kind of the custom resources.
+	// +required
+	Kind string `json:"kind"`
+
+	// Current is the CEL expression that determines if a custom resource
+	// has reached the desired state.
+	// +required
+	Current string `json:"current"`
+
+	// InProgress is the CEL expression that determines if a custom resource
+	// is still reconciling.
+	// +optional
+	InProgress string `json:"inProgress,omitempty"`
+
+	// Failed is the CEL expression that determines if a custom resource
+	// failed to reach the desired state.
+	// +optional
+	Failed string `json:"failed,omitempty"`
+}
+
 // KustomizationStatus defines the observed state of a kustomization.
 type KustomizationStatus struct {
 	// ObservedGeneration is the last reconciled generation.
@@ -225,17 +660,274 @@ type KustomizationStatus struct {
 	// +optional
 	LastAttemptedRevision string `json:"lastAttemptedRevision,omitempty"`
 
+	// PendingRevision is the source revision published while the last
+	// reconciliation was running. The controller requeues the Kustomization
+	// immediately to reconcile it, instead of waiting for the next interval.
+	// +optional
+	PendingRevision string `json:"pendingRevision,omitempty"`
+
+	// DependencyWaitStartTime is the time at which the controller started
+	// waiting for the dependencies to become ready.
+	// +optional
+	DependencyWaitStartTime *metav1.Time `json:"dependencyWaitStartTime,omitempty"`
+
+	// ProgressingSince is the time at which the controller started to
+	// reconcile the last attempted revision or generation, until it
+	// becomes ready.
+	// +optional
+	ProgressingSince *metav1.Time `json:"progressingSince,omitempty"`
+
+	// Failures is the number of consecutive failed reconciliations,
+	// it's reset after a successful reconciliation.
+	// +optional
+	Failures int64 `json:"failures,omitempty"`
+
+	// CustomResourceDefinitions is the list of the CRD names
+	// defined in the last applied build.
+	// +optional
+	CustomResourceDefinitions []string `json:"customResourceDefinitions,omitempty"`
+
 	meta.ReconcileRequestStatus `json:",inline"`
 
 	// The last successfully applied revision metadata.
 	// +optional
 	Snapshot *Snapshot `json:"snapshot,omitempty"`
+
+	// Inventory contains the list of Kubernetes resource object references
+	// that have been applied by the last reconciliation.
+	// +optional
+	Inventory *ResourceInventory `json:"inventory,omitempty"`
+
+	// Checkpoint records the progress of an apply interrupted
+	// after exceeding the controller's reconcile budget.
+	// +optional
+	Checkpoint *ApplyCheckpoint `json:"checkpoint,omitempty"`
+
+	// StateChecksum is the checksum of the in-cluster state of the managed
+	// objects, recorded after the last successful reconciliation.
+	// It is used to skip the build and apply when the source revision,
+	// the generation and the cluster state are unchanged.
+	// +optional
+	StateChecksum string `json:"stateChecksum,omitempty"`
+
+	// The preview of the last attempted revision, recorded when
+	// spec.preview is enabled.
+	// +optional
+	LastPreview *PreviewReport `json:"lastPreview,omitempty"`
+
+	// LastCommitStatus is the last commit status updated on the Git provider,
+	// recorded when spec.commitStatus is set.
+	// +optional
+	LastCommitStatus *CommitStatusReport `json:"lastCommitStatus,omitempty"`
+
+	// Changelog records the last applied revisions, newest first,
+	// with the number of objects changed by each apply.
+	// +optional
+	Changelog []ChangelogEntry `json:"changelog,omitempty"`
+
+	// LastApplySummary holds the number of objects per action
+	// of the last successful reconciliation.
+	// +optional
+	LastApplySummary *ApplySummary `json:"lastApplySummary,omitempty"`
+
+	// Clusters holds the status of the remote clusters targeted by spec.clusters.
+	// +optional
+	Clusters []ClusterStatus `json:"clusters,omitempty"`
+
+	// CanaryAnalysis holds the Flagger canaries of the Deployments configured
+	// by the last applied revision, until their analysis succeeds.
+	// +optional
+	CanaryAnalysis *CanaryAnalysis `json:"canaryAnalysis,omitempty"`
+
+	// PartialApply holds the objects applied and not applied by the last
+	// atomic apply, when it failed.
+	// +optional
+	PartialApply *PartialApply `json:"partialApply,omitempty"`
+}
+
+// PartialApply records the outcome of an atomic apply
+// interrupted by an error.
+type PartialApply struct {
+	// Revision is the source revision whose apply failed.
+	// +required
+	Revision string `json:"revision"`
+
+	// Applied is the list of objects applied on the cluster,
+	// in the 'kind/namespace/name' format, or 'kind/name' for cluster-scoped objects.
+	// +optional
+	Applied []string `json:"applied,omitempty"`
+
+	// NotApplied is the list of objects not applied on the cluster,
+	// in the 'kind/namespace/name' format, or 'kind/name' for cluster-scoped objects.
+	// +optional
+	NotApplied []string `json:"notApplied,omitempty"`
+}
+
+// CanaryAnalysis records the Flagger canaries whose
+// analysis was started by the apply of a revision.
+type CanaryAnalysis struct {
+	// Revision is the source revision that started the analysis.
+	// +required
+	Revision string `json:"revision"`
+
+	// StartedAt is the time at which the Deployments were configured.
+	// +required
+	StartedAt metav1.Time `json:"startedAt"`
+
+	// Canaries is the list of canaries in the 'namespace/name' format.
+	// +required
+	Canaries []string `json:"canaries"`
+}
+
+// ApplySummary records the actions performed on the objects by a reconciliation.
+type ApplySummary struct {
+	// Revision is the applied source revision.
+	// +required
+	Revision string `json:"revision"`
+
+	// Created is the number of objects created.
+	// +optional
+	Created int `json:"created,omitempty"`
+
+	// Configured is the number of objects configured or replaced.
+	// +optional
+	Configured int `json:"configured,omitempty"`
+
+	// Unchanged is the number of objects left unchanged.
+	// +optional
+	Unchanged int `json:"unchanged,omitempty"`
+
+	// Deleted is the number of objects deleted by the garbage collection.
+	// +optional
+	Deleted int `json:"deleted,omitempty"`
+
+	// Summary of the actions e.g. '1 created, 2 configured, 5 unchanged, 0 deleted'.
+	// +optional
+	Summary string `json:"summary,omitempty"`
+
+	// Kinds holds the number of changed objects per kind.
+	// +optional
+	Kinds []KindSummary `json:"kinds,omitempty"`
+}
+
+// KindSummary records the number of changed objects of a kind.
+type KindSummary struct {
+	// Kind of the objects in lowercase e.g. 'deployment'.
+	// +required
+	Kind string `json:"kind"`
+
+	// Created is the number of objects created.
+	// +optional
+	Created int `json:"created,omitempty"`
+
+	// Configured is the number of objects configured or replaced.
+	// +optional
+	Configured int `json:"configured,omitempty"`
+
+	// Deleted is the number of objects deleted by the garbage collection.
+	// +optional
+	Deleted int `json:"deleted,omitempty"`
+}
+
+// MaxChangelogEntries is the number of
+// revisions recorded in the changelog.
+const MaxChangelogEntries = 5
+
+// ChangelogEntry records the changes made by the apply of a revision.
+type ChangelogEntry struct {
+	// Revision is the applied source revision.
+	// +required
+	Revision string `json:"revision"`
+
+	// AppliedAt is the time at which the revision was applied.
+	// +required
+	AppliedAt metav1.Time `json:"appliedAt"`
+
+	// Created is the number of objects created.
+	// +optional
+	Created int `json:"created,omitempty"`
+
+	// Configured is the number of objects configured or replaced.
+	// +optional
+	Configured int `json:"configured,omitempty"`
+
+	// Deleted is the number of objects deleted by the garbage collection.
+	// +optional
+	Deleted int `json:"deleted,omitempty"`
 }
 
-// KustomizationProgressing resets the conditions of the given Kustomization to a single
-// ReadyCondition with status ConditionUnknown.
+// ApplyCheckpoint records the objects applied by a reconciliation that was
+// interrupted after exceeding the reconcile budget, the next reconciliation
+// resumes the apply from the checkpoint if the build output is unchanged.
+type ApplyCheckpoint struct {
+	// Checksum of the build output being applied.
+	// +required
+	Checksum string `json:"checksum"`
+
+	// Inventory of the objects applied so far.
+	// +required
+	Inventory ResourceInventory `json:"inventory"`
+}
+
+// PreviewReport summarizes the changes a revision would make to the cluster.
+type PreviewReport struct {
+	// Revision is the source revision the preview was generated for.
+	// +required
+	Revision string `json:"revision"`
+
+	// ConfigMapName is the name of the ConfigMap holding the build output
+	// and the diff against the cluster state.
+	// +required
+	ConfigMapName string `json:"configMapName"`
+
+	// Summary of the changes e.g. '1 created, 2 configured, 5 unchanged'.
+	// +optional
+	Summary string `json:"summary,omitempty"`
+}
+
+// SetKustomizationCondition sets the given condition of the Kustomization,
+// with the generation of the Kustomization as the observed generation.
+func SetKustomizationCondition(k *Kustomization, condition string, status metav1.ConditionStatus, reason, message string) {
+	apimeta.SetStatusCondition(k.GetStatusConditions(), metav1.Condition{
+		Type:               condition,
+		Status:             status,
+		Reason:             reason,
+		Message:            trimString(message, MaxConditionMessageLength),
+		ObservedGeneration: k.Generation,
+	})
+}
+
+// KustomizationProgressing sets the ReadyCondition of the given Kustomization
+// to ConditionUnknown and the ReconcilingCondition to ConditionTrue,
+// and removes the StalledCondition.
 func KustomizationProgressing(k Kustomization) Kustomization {
-	meta.SetResourceCondition(&k, meta.ReadyCondition, metav1.ConditionUnknown, meta.ProgressingReason, "reconciliation in progress")
+	SetKustomizationCondition(&k, meta.ReadyCondition, metav1.ConditionUnknown, meta.ProgressingReason, "reconciliation in progress")
+	SetKustomizationCondition(&k, meta.ReconcilingCondition, metav1.ConditionTrue, meta.ProgressingReason, "reconciliation in progress")
+	apimeta.RemoveStatusCondition(k.GetStatusConditions(), meta.StalledCondition)
+	return k
+}
+
+// KustomizationStalled registers a failed apply attempt of the given Kustomization
+// that can't be retried, and sets the StalledCondition to ConditionTrue.
+func KustomizationStalled(k Kustomization, revision, reason, message string) Kustomization {
+	k = KustomizationNotReady(k, revision, reason, message)
+	SetKustomizationStalled(&k, reason, message)
+	return k
+}
+
+// SetKustomizationStalled sets the StalledCondition of the Kustomization to ConditionTrue,
+// and removes the ReconcilingCondition so that the Kustomization is reported as failed.
+func SetKustomizationStalled(k *Kustomization, reason, message string) {
+	SetKustomizationCondition(k, meta.StalledCondition, metav1.ConditionTrue, reason, message)
+	apimeta.RemoveStatusCondition(k.GetStatusConditions(), meta.ReconcilingCondition)
+}
+
+// KustomizationGarbageCollecting sets the ReadyCondition of the given Kustomization
+// to ConditionUnknown and the ReconcilingCondition to ConditionTrue while its
+// objects are being deleted.
+func KustomizationGarbageCollecting(k Kustomization, message string) Kustomization {
+	SetKustomizationCondition(&k, meta.ReadyCondition, metav1.ConditionUnknown, GarbageCollectingReason, message)
+	SetKustomizationCondition(&k, meta.ReconcilingCondition, metav1.ConditionTrue, GarbageCollectingReason, message)
 	return k
 }
 
@@ -245,21 +937,24 @@ func SetKustomizationHealthiness(k *Kustomization, status metav1.ConditionStatus
 	case 0:
 		apimeta.RemoveStatusCondition(k.GetStatusConditions(), HealthyCondition)
 	default:
-		meta.SetResourceCondition(k, HealthyCondition, status, reason, trimString(message, MaxConditionMessageLength))
+		SetKustomizationCondition(k, HealthyCondition, status, reason, message)
 	}
 }
 
 // SetKustomizeReadiness sets the ReadyCondition, ObservedGeneration, and LastAttemptedRevision,
-// on the Kustomization.
+// on the Kustomization, and removes the ReconcilingCondition as the reconciliation is over.
 func SetKustomizationReadiness(k *Kustomization, status metav1.ConditionStatus, reason, message string, revision string) {
-	meta.SetResourceCondition(k, meta.ReadyCondition, status, reason, trimString(message, MaxConditionMessageLength))
+	SetKustomizationCondition(k, meta.ReadyCondition, status, reason, message)
+	apimeta.RemoveStatusCondition(k.GetStatusConditions(), meta.ReconcilingCondition)
 	k.Status.ObservedGeneration = k.Generation
 	k.Status.LastAttemptedRevision = revision
 }
 
-// KustomizationNotReady registers a failed apply attempt of the given Kustomization.
+// KustomizationNotReady registers a failed apply attempt of the given Kustomization,
+// the ReconcilingCondition is set to ConditionTrue as the reconciliation is retried.
 func KustomizationNotReady(k Kustomization, revision, reason, message string) Kustomization {
 	SetKustomizationReadiness(&k, metav1.ConditionFalse, reason, trimString(message, MaxConditionMessageLength), revision)
+	SetKustomizationCondition(&k, meta.ReconcilingCondition, metav1.ConditionTrue, ProgressingWithRetryReason, message)
 	if revision != "" {
 		k.Status.LastAttemptedRevision = revision
 	}
@@ -270,6 +965,7 @@ func KustomizationNotReady(k Kustomization, revision, reason, message string) Ku
 // including a Snapshot.
 func KustomizationNotReadySnapshot(k Kustomization, snapshot *Snapshot, revision, reason, message string) Kustomization {
 	SetKustomizationReadiness(&k, metav1.ConditionFalse, reason, trimString(message, MaxConditionMessageLength), revision)
+	SetKustomizationCondition(&k, meta.ReconcilingCondition, metav1.ConditionTrue, ProgressingWithRetryReason, message)
 	SetKustomizationHealthiness(&k, metav1.ConditionFalse, reason, reason)
 	k.Status.Snapshot = snapshot
 	k.Status.LastAttemptedRevision = revision
@@ -285,16 +981,37 @@ func KustomizationReady(k Kustomization, snapshot *Snapshot, revision, reason, m
 	return k
 }
 
-// GetTimeout returns the timeout with default.
+// GetTimeout returns the timeout if set, otherwise the interval
+// with a minimum of one minute.
 func (in Kustomization) GetTimeout() time.Duration {
-	duration := in.Spec.Interval.Duration
 	if in.Spec.Timeout != nil {
-		duration = in.Spec.Timeout.Duration
+		return in.Spec.Timeout.Duration
 	}
-	if duration < time.Minute {
+	if in.Spec.Interval.Duration < time.Minute {
 		return time.Minute
 	}
-	return duration
+	return in.Spec.Interval.Duration
+}
+
+// GetDeletionPolicy returns the deletion policy with default,
+// the default is Delete if pruning is enabled, Orphan otherwise.
+func (in Kustomization) GetDeletionPolicy() string {
+	if in.Spec.DeletionPolicy != "" {
+		return in.Spec.DeletionPolicy
+	}
+	if in.Spec.Prune {
+		return DeletionPolicyDelete
+	}
+	return DeletionPolicyOrphan
+}
+
+// GetDriftCheckInterval returns the drift check interval if it's
+// shorter than the interval, otherwise the interval.
+func (in Kustomization) GetDriftCheckInterval() time.Duration {
+	if in.Spec.DriftCheckInterval != nil && in.Spec.DriftCheckInterval.Duration < in.Spec.Interval.Duration {
+		return in.Spec.DriftCheckInterval.Duration
+	}
+	return in.Spec.Interval.Duration
 }
 
 // GetRetryInterval returns the retry interval
@@ -305,11 +1022,19 @@ func (in Kustomization) GetRetryInterval() time.Duration {
 	return in.Spec.Interval.Duration
 }
 
+// GetDependsOn returns the Kustomizations this Kustomization depends on,
+// the dependencies on other kinds are omitted.
 func (in Kustomization) GetDependsOn() (types.NamespacedName, []dependency.CrossNamespaceDependencyReference) {
+	var deps []dependency.CrossNamespaceDependencyReference
+	for _, d := range in.Spec.DependsOn {
+		if d.IsKustomization() {
+			deps = append(deps, dependency.CrossNamespaceDependencyReference{Namespace: d.Namespace, Name: d.Name})
+		}
+	}
 	return types.NamespacedName{
 		Namespace: in.Namespace,
 		Name:      in.Name,
-	}, in.Spec.DependsOn
+	}, deps
 }
 
 // GetStatusConditions returns a pointer to the Status.Conditions slice
@@ -324,6 +1049,12 @@ const (
 	// BucketIndexKey is the key used for indexing kustomizations
 	// based on their S3 sources.
 	BucketIndexKey string = ".metadata.bucket"
+	// KubeConfigIndexKey is the key used for indexing kustomizations
+	// based on their KubeConfig secrets.
+	KubeConfigIndexKey string = ".metadata.kubeConfig"
+	// DependsOnIndexKey is the key used for indexing kustomizations
+	// based on the Kustomizations they depend on.
+	DependsOnIndexKey string = ".metadata.dependsOn"
 )
 
 // +genclient
@@ -334,6 +1065,7 @@ const (
 // +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status",description=""
 // +kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].message",description=""
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description=""
+// +kubebuilder:printcolumn:name="Changes",type="string",JSONPath=".status.lastApplySummary.summary",description="",priority=1
 
 // Kustomization is the Schema for the kustomizations API.
 type Kustomization struct {
@@ -0,0 +1,147 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	KustomizationStatusReportKind = "KustomizationStatusReport"
+
+	// KustomizationStatusReportName is the name of the report
+	// generated by the controller in each namespace.
+	KustomizationStatusReportName = "kustomizations"
+)
+
+// KustomizationStatusReportStatus summarizes the state
+// of the Kustomizations in a namespace.
+type KustomizationStatusReportStatus struct {
+	// LastUpdateTime is the time of the last report update.
+	// +optional
+	LastUpdateTime *metav1.Time `json:"lastUpdateTime,omitempty"`
+
+	// Total is the number of Kustomizations in the namespace.
+	// +optional
+	Total int `json:"total"`
+
+	// Ready is the number of ready Kustomizations.
+	// +optional
+	Ready int `json:"ready"`
+
+	// Failing is the number of Kustomizations with a false Ready condition.
+	// +optional
+	Failing int `json:"failing"`
+
+	// Suspended is the number of suspended Kustomizations.
+	// +optional
+	Suspended int `json:"suspended"`
+
+	// Kustomizations holds the status summary of each Kustomization.
+	// At most 250 Kustomizations are listed, the ones that are not ready first.
+	// +optional
+	Kustomizations []KustomizationStatusSummary `json:"kustomizations,omitempty"`
+}
+
+// KustomizationStatusSummary holds the status summary of a Kustomization.
+type KustomizationStatusSummary struct {
+	// Name of the Kustomization.
+	// +required
+	Name string `json:"name"`
+
+	// Ready is the status of the Ready condition.
+	// +optional
+	Ready metav1.ConditionStatus `json:"ready,omitempty"`
+
+	// Reason of the Ready condition.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Message of the Ready condition.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Suspended is true if the reconciliation is suspended.
+	// +optional
+	Suspended bool `json:"suspended,omitempty"`
+
+	// LastAppliedRevision is the last successfully applied revision.
+	// +optional
+	LastAppliedRevision string `json:"lastAppliedRevision,omitempty"`
+
+	// LastAttemptedRevision is the revision of the last reconciliation attempt.
+	// +optional
+	LastAttemptedRevision string `json:"lastAttemptedRevision,omitempty"`
+
+	// LastFailure is the most recent failure, retained
+	// in the report after the Kustomization recovers.
+	// +optional
+	LastFailure *KustomizationFailure `json:"lastFailure,omitempty"`
+}
+
+// KustomizationFailure describes a failed reconciliation.
+type KustomizationFailure struct {
+	// Time of the failure.
+	// +required
+	Time metav1.Time `json:"time"`
+
+	// Reason of the failure.
+	// +required
+	Reason string `json:"reason"`
+
+	// Message of the failure.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Revision for which the reconciliation failed.
+	// +optional
+	Revision string `json:"revision,omitempty"`
+}
+
+// +genclient
+// +genclient:Namespaced
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:shortName=ksreport
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Total",type="integer",JSONPath=".status.total",description=""
+// +kubebuilder:printcolumn:name="Ready",type="integer",JSONPath=".status.ready",description=""
+// +kubebuilder:printcolumn:name="Failing",type="integer",JSONPath=".status.failing",description=""
+// +kubebuilder:printcolumn:name="Suspended",type="integer",JSONPath=".status.suspended",description=""
+// +kubebuilder:printcolumn:name="Updated",type="date",JSONPath=".status.lastUpdateTime",description=""
+
+// KustomizationStatusReport is the Schema for the kustomizationstatusreports API.
+// The reports are generated by the controller, one per namespace, and
+// are meant to be read by the namespace tenants.
+type KustomizationStatusReport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status KustomizationStatusReportStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// KustomizationStatusReportList contains a list of kustomization status reports.
+type KustomizationStatusReportList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KustomizationStatusReport `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&KustomizationStatusReport{}, &KustomizationStatusReportList{})
+}
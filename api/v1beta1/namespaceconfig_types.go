@@ -0,0 +1,128 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	NamespaceConfigKind = "NamespaceConfig"
+
+	// NamespaceConfigName is the name of the NamespaceConfig
+	// read by the controller in each namespace.
+	NamespaceConfigName = "default"
+)
+
+// NamespaceConfigSpec defines the settings merged into
+// every Kustomization in the namespace.
+type NamespaceConfigSpec struct {
+	// ServiceAccountName is the name of the Kubernetes service account to
+	// impersonate when reconciling the Kustomizations that don't specify one.
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// MinInterval is the lower bound of the Kustomizations reconciliation interval.
+	// +optional
+	MinInterval *metav1.Duration `json:"minInterval,omitempty"`
+
+	// MaxInterval is the upper bound of the Kustomizations reconciliation interval.
+	// +optional
+	MaxInterval *metav1.Duration `json:"maxInterval,omitempty"`
+
+	// Prune overrides the garbage collection setting of the Kustomizations.
+	// +optional
+	Prune *bool `json:"prune,omitempty"`
+
+	// Substitute holds the default values of the variables substituted
+	// in the Kustomizations manifests. The values defined in the
+	// Kustomization postBuild.substitute take precedence.
+	// +optional
+	Substitute map[string]string `json:"substitute,omitempty"`
+
+	// AllowedNamespaces is the list of namespaces the Kustomizations may
+	// apply objects to, the names can contain shell patterns such as 'team1-*'.
+	// Defaults to all the namespaces, or to the namespace of the Kustomizations
+	// when the controller restricts the tenants to their own namespace.
+	// Only read from the NamespaceConfigs of the controller namespace,
+	// named after the namespace of the Kustomizations.
+	// +optional
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+
+	// DeniedNamespaces is the list of namespaces the Kustomizations may not
+	// apply objects to, the names can contain shell patterns such as 'kube-*'.
+	// Takes precedence over the allowed namespaces.
+	// Only read from the NamespaceConfigs of the controller namespace,
+	// named after the namespace of the Kustomizations.
+	// +optional
+	DeniedNamespaces []string `json:"deniedNamespaces,omitempty"`
+
+	// Quota caps the number of objects the Kustomizations of the namespace can manage.
+	// Only read from the NamespaceConfigs of the controller namespace,
+	// named after the namespace of the Kustomizations.
+	// +optional
+	Quota *ObjectQuota `json:"quota,omitempty"`
+}
+
+// ObjectQuota defines the maximum number of objects managed by Kustomizations.
+type ObjectQuota struct {
+	// MaxObjects is the maximum number of objects in the build output of a Kustomization.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxObjects *int32 `json:"maxObjects,omitempty"`
+
+	// MaxNamespaceObjects is the maximum number of objects managed by all the
+	// Kustomizations of the namespace, counted from their inventories.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxNamespaceObjects *int32 `json:"maxNamespaceObjects,omitempty"`
+
+	// Kinds maps kinds e.g. 'Deployment' to the maximum number of objects
+	// of that kind in the build output of a Kustomization.
+	// +optional
+	Kinds map[string]int32 `json:"kinds,omitempty"`
+}
+
+// +genclient
+// +genclient:Namespaced
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:shortName=nsconfig
+// +kubebuilder:printcolumn:name="Service Account",type="string",JSONPath=".spec.serviceAccountName",description=""
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description=""
+
+// NamespaceConfig is the Schema for the namespaceconfigs API.
+// The controller merges the settings of the NamespaceConfig named 'default'
+// into the Kustomizations of the same namespace.
+type NamespaceConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec NamespaceConfigSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// NamespaceConfigList contains a list of namespace configs.
+type NamespaceConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NamespaceConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NamespaceConfig{}, &NamespaceConfigList{})
+}
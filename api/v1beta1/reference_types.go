@@ -16,7 +16,25 @@ limitations under the License.
 
 package v1beta1
 
-import "fmt"
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// WaitDependencyPolicy keeps checking the dependency
+	// at the requeue interval after the timeout.
+	WaitDependencyPolicy = "Wait"
+
+	// FailDependencyPolicy marks the Kustomization as stalled
+	// when the dependency is not ready within the timeout.
+	FailDependencyPolicy = "Fail"
+
+	// ProceedDependencyPolicy reconciles the Kustomization
+	// when the dependency is not ready within the timeout.
+	ProceedDependencyPolicy = "Proceed"
+)
 
 // CrossNamespaceSourceReference contains enough information to let you locate the
 // typed referenced object at cluster level
@@ -45,3 +63,77 @@ func (s *CrossNamespaceSourceReference) String() string {
 	}
 	return fmt.Sprintf("%s/%s", s.Kind, s.Name)
 }
+
+// DependencyReference contains enough information to locate a Kustomization,
+// or any other object with a Ready condition, that must be ready before
+// the Kustomization can be reconciled.
+type DependencyReference struct {
+	// API version of the referent, required if the kind is not Kustomization,
+	// defaults to helm.toolkit.fluxcd.io/v2beta1 for HelmRelease
+	// +optional
+	APIVersion string `json:"apiVersion,omitempty"`
+
+	// Kind of the referent, defaults to Kustomization
+	// +optional
+	Kind string `json:"kind,omitempty"`
+
+	// Name of the referent
+	// +required
+	Name string `json:"name"`
+
+	// Namespace of the referent, defaults to the Kustomization namespace
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// MatchRevision requires the dependency to be ready at the same source
+	// revision as the one being reconciled, e.g. to apply in order the infra
+	// and the apps changed by the same commit. Only applies to the dependencies
+	// of kind Kustomization, reconciled from the same source.
+	// +optional
+	MatchRevision bool `json:"matchRevision,omitempty"`
+
+	// ReadyCondition is the type of the condition that must be true for
+	// the dependency to be ready, e.g. 'Established' for a CRD.
+	// Defaults to 'Ready'. Only applies to the dependencies of other kinds.
+	// +optional
+	ReadyCondition string `json:"readyCondition,omitempty"`
+
+	// ReadyExpr is a CEL expression evaluated against the metadata, spec and
+	// status of the dependency, that must return true for the dependency to
+	// be ready, e.g. "status.phase == 'Active'", or 'true' to only wait for
+	// the object to exist. Takes precedence over ReadyCondition.
+	// Only applies to the dependencies of other kinds.
+	// +optional
+	ReadyExpr string `json:"readyExpr,omitempty"`
+
+	// Timeout is the time to wait for the dependency to become ready,
+	// overrides the spec.dependencyTimeout of the Kustomization.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// OnTimeout determines what happens when the dependency is not ready
+	// within the timeout. Valid values are 'Wait', 'Fail' and 'Proceed',
+	// defaults to 'Wait'. With 'Wait', the dependency is checked at the requeue
+	// interval. With 'Fail', the Kustomization is marked as stalled and the
+	// dependency is checked at the Kustomization interval. With 'Proceed',
+	// the Kustomization is reconciled without waiting for the dependency.
+	// +kubebuilder:validation:Enum=Wait;Fail;Proceed
+	// +optional
+	OnTimeout string `json:"onTimeout,omitempty"`
+}
+
+// IsKustomization returns true if the dependency refers to a Kustomization.
+func (d *DependencyReference) IsKustomization() bool {
+	return d.Kind == "" || d.Kind == KustomizationKind
+}
+
+func (d *DependencyReference) String() string {
+	kind := d.Kind
+	if kind == "" {
+		kind = KustomizationKind
+	}
+	if d.Namespace != "" {
+		return fmt.Sprintf("%s/%s/%s", kind, d.Namespace, d.Name)
+	}
+	return fmt.Sprintf("%s/%s", kind, d.Name)
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// ApprovalChangePredicate triggers an update event
+// when the approve annotation of a Kustomization changes.
+type ApprovalChangePredicate struct {
+	predicate.Funcs
+}
+
+func (ApprovalChangePredicate) Update(e event.UpdateEvent) bool {
+	if e.ObjectOld == nil || e.ObjectNew == nil {
+		return false
+	}
+
+	return e.ObjectOld.GetAnnotations()[approveAnnotation] != e.ObjectNew.GetAnnotations()[approveAnnotation]
+}
+
+func (ApprovalChangePredicate) Create(e event.CreateEvent) bool {
+	return false
+}
+
+func (ApprovalChangePredicate) Delete(e event.DeleteEvent) bool {
+	return false
+}
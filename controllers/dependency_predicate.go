@@ -0,0 +1,62 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"github.com/fluxcd/pkg/apis/meta"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// ReadyChangePredicate triggers an update event when a Kustomization
+// becomes ready, or applies a new revision while ready.
+type ReadyChangePredicate struct {
+	predicate.Funcs
+}
+
+func (ReadyChangePredicate) Update(e event.UpdateEvent) bool {
+	if e.ObjectOld == nil || e.ObjectNew == nil {
+		return false
+	}
+
+	oldKustomization, ok := e.ObjectOld.(*kustomizev1.Kustomization)
+	if !ok {
+		return false
+	}
+
+	newKustomization, ok := e.ObjectNew.(*kustomizev1.Kustomization)
+	if !ok {
+		return false
+	}
+
+	if !apimeta.IsStatusConditionTrue(newKustomization.Status.Conditions, meta.ReadyCondition) {
+		return false
+	}
+	return !apimeta.IsStatusConditionTrue(oldKustomization.Status.Conditions, meta.ReadyCondition) ||
+		oldKustomization.Status.LastAppliedRevision != newKustomization.Status.LastAppliedRevision
+}
+
+func (ReadyChangePredicate) Create(e event.CreateEvent) bool {
+	return false
+}
+
+func (ReadyChangePredicate) Delete(e event.DeleteEvent) bool {
+	return false
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// AccessDeniedError is returned when the Kustomization refers to an object
+// in another namespace while the cross-namespace references are disabled.
+// Retrying the reconciliation is pointless until the spec changes.
+type AccessDeniedError struct {
+	Ref string
+}
+
+func (e *AccessDeniedError) Error() string {
+	return fmt.Sprintf("cross-namespace reference to '%s' is not allowed", e.Ref)
+}
+
+// checkCrossNamespaceRefs returns an AccessDeniedError if the source or the
+// dependencies of the Kustomization are in another namespace. The KubeConfig,
+// decryption and substitution references are always in the Kustomization namespace.
+func checkCrossNamespaceRefs(kustomization kustomizev1.Kustomization) error {
+	namespace := kustomization.GetNamespace()
+	if ns := kustomization.Spec.SourceRef.Namespace; ns != "" && ns != namespace {
+		return &AccessDeniedError{Ref: kustomization.Spec.SourceRef.String()}
+	}
+	for _, d := range kustomization.Spec.DependsOn {
+		if d.Namespace != "" && d.Namespace != namespace {
+			return &AccessDeniedError{Ref: d.String()}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestCheckCrossNamespaceRefs(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    kustomizev1.KustomizationSpec
+		wantRef string
+	}{
+		{
+			name: "same namespace",
+			spec: kustomizev1.KustomizationSpec{
+				SourceRef: kustomizev1.CrossNamespaceSourceReference{Kind: "GitRepository", Name: "app"},
+				DependsOn: []kustomizev1.DependencyReference{{Name: "common", Namespace: "dev"}},
+			},
+		},
+		{
+			name: "source in another namespace",
+			spec: kustomizev1.KustomizationSpec{
+				SourceRef: kustomizev1.CrossNamespaceSourceReference{Kind: "GitRepository", Name: "app", Namespace: "flux-system"},
+			},
+			wantRef: "GitRepository/flux-system/app",
+		},
+		{
+			name: "dependency in another namespace",
+			spec: kustomizev1.KustomizationSpec{
+				SourceRef: kustomizev1.CrossNamespaceSourceReference{Kind: "GitRepository", Name: "app", Namespace: "dev"},
+				DependsOn: []kustomizev1.DependencyReference{{Name: "common"}, {Name: "infra", Namespace: "flux-system"}},
+			},
+			wantRef: "Kustomization/flux-system/infra",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := kustomizev1.Kustomization{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "dev"},
+				Spec:       tt.spec,
+			}
+			err := checkCrossNamespaceRefs(k)
+			if tt.wantRef == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var denied *AccessDeniedError
+			if !errors.As(err, &denied) {
+				t.Fatalf("expected AccessDeniedError, got %v", err)
+			}
+			if denied.Ref != tt.wantRef {
+				t.Errorf("expected ref %q, got %q", tt.wantRef, denied.Ref)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+	"github.com/fluxcd/kustomize-controller/pkg/validation"
+)
+
+// admissionReason returns the AdmissionDenied reason if the error
+// was caused by an admission webhook, otherwise the given reason.
+func admissionReason(err error, reason string) string {
+	var denied *validation.AdmissionDeniedError
+	if errors.As(err, &denied) {
+		return kustomizev1.AdmissionDeniedReason
+	}
+	return reason
+}
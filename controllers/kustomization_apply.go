@@ -0,0 +1,284 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+	"github.com/fluxcd/kustomize-controller/pkg/validation"
+)
+
+const (
+	// fieldManager is the name of the manager owning the fields
+	// of the objects applied by the controller.
+	fieldManager = "kustomize-controller"
+
+	createdAction    = "created"
+	configuredAction = "configured"
+	unchangedAction  = "unchanged"
+	replacedAction   = "replaced"
+	skippedAction    = "skipped"
+	deletedAction    = "deleted"
+
+	// IfNotPresentApplyPolicy creates the object if it doesn't exist,
+	// the changes to the object are not applied.
+	IfNotPresentApplyPolicy = "IfNotPresent"
+
+	// ReplaceApplyPolicy replaces the object with an update
+	// instead of patching it with server-side apply.
+	ReplaceApplyPolicy = "Replace"
+
+	// ForceApplyPolicy recreates the object when the apply fails
+	// due to an immutable field change, regardless of spec.force.
+	ForceApplyPolicy = "Force"
+)
+
+// csaFieldManagers are the field managers of the objects
+// applied with kubectl client-side apply.
+var csaFieldManagers = []string{fieldManager, "kubectl", "kubectl-client-side-apply", "before-first-apply"}
+
+// applyPolicyAnnotation is the annotation used to change
+// how an object is applied on the cluster.
+var applyPolicyAnnotation = fmt.Sprintf("%s/apply-policy", kustomizev1.GroupVersion.Group)
+
+// applyObjectOptions holds the options for applying an object.
+type applyObjectOptions struct {
+	// force recreates the object when the apply fails
+	// due to an immutable field change.
+	force bool
+	// dryRun applies the object using server-side dry-run.
+	dryRun bool
+	// ignorePaths are the fields applied with their in-cluster values.
+	ignorePaths []string
+	// conflictPolicy determines how the fields managed
+	// by other field managers are handled.
+	conflictPolicy string
+	// lastApplied is the applied checksum recorded by the last apply,
+	// the object is not applied if it matches the current checksum.
+	lastApplied string
+}
+
+// applyObject applies the object on the cluster using server-side apply,
+// handling the fields managed by other field managers according to the
+// conflict policy. When force is true and the apply fails due to an immutable field change,
+// the object is deleted and recreated.
+// The apply policy annotation of the object can change this behaviour,
+// to skip the objects that exist, to replace the objects instead of patching
+// them, or to recreate the objects regardless of force.
+// The ignored fields of existing objects are applied with their in-cluster values.
+// When neither the object nor its in-cluster counterpart changed since the last apply,
+// the object is not applied.
+// It returns the action performed on the object e.g. created, configured or unchanged,
+// and the applied checksum of the object, empty if it can't be used to skip the next apply.
+func applyObject(ctx context.Context, kubeClient client.Client, obj *unstructured.Unstructured, opts applyObjectOptions) (string, string, error) {
+	policy, err := applyPolicy(obj)
+	if err != nil {
+		return "", "", err
+	}
+	force := opts.force
+	if policy == ForceApplyPolicy {
+		force = true
+	}
+
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(obj.GroupVersionKind())
+	err = kubeClient.Get(ctx, client.ObjectKeyFromObject(obj), existing)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", "", err
+	}
+	exists := err == nil
+
+	if exists && policy == IfNotPresentApplyPolicy {
+		return skippedAction, "", nil
+	}
+
+	// the objects applied with kubectl before the server-side apply are migrated once,
+	// for the controller to remove the fields deleted from the manifests
+	if exists && policy != ReplaceApplyPolicy && !opts.dryRun {
+		if err := migrateManagedFields(ctx, kubeClient, existing); err != nil {
+			return "", "", err
+		}
+	}
+
+	if exists && opts.lastApplied != "" && !opts.dryRun {
+		checksum, err := appliedChecksum(obj, existing.GetResourceVersion())
+		if err != nil {
+			return "", "", err
+		}
+		if checksum == opts.lastApplied {
+			return unchangedAction, checksum, nil
+		}
+	}
+
+	applied := obj.DeepCopy()
+	if exists {
+		preserveIgnoredFields(applied, existing, opts.ignorePaths)
+	}
+	if exists && policy == ReplaceApplyPolicy {
+		applied.SetResourceVersion(existing.GetResourceVersion())
+		updateOpts := []client.UpdateOption{client.FieldOwner(fieldManager)}
+		if opts.dryRun {
+			updateOpts = append(updateOpts, client.DryRunAll)
+		}
+		err = kubeClient.Update(ctx, applied, updateOpts...)
+	} else {
+		err = serverSideApply(ctx, kubeClient, applied, existing, opts.conflictPolicy, opts.dryRun)
+	}
+	if err != nil {
+		if !force || !exists || opts.dryRun || !validation.IsImmutableError(err) {
+			return "", "", err
+		}
+		if err := recreateObject(ctx, kubeClient, existing, obj); err != nil {
+			return "", "", err
+		}
+		return replacedAction, "", nil
+	}
+
+	var checksum string
+	if !opts.dryRun {
+		if checksum, err = appliedChecksum(obj, applied.GetResourceVersion()); err != nil {
+			return "", "", err
+		}
+	}
+
+	switch {
+	case !exists:
+		return createdAction, checksum, nil
+	case applied.GetResourceVersion() != existing.GetResourceVersion():
+		return configuredAction, checksum, nil
+	default:
+		return unchangedAction, checksum, nil
+	}
+}
+
+// applyPolicy returns the value of the apply policy annotation of the object.
+func applyPolicy(obj *unstructured.Unstructured) (string, error) {
+	policy := obj.GetAnnotations()[applyPolicyAnnotation]
+	switch policy {
+	case "", IfNotPresentApplyPolicy, ReplaceApplyPolicy, ForceApplyPolicy:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("%s invalid %s annotation value '%s', must be one of %s, %s or %s",
+			objectID(obj), applyPolicyAnnotation, policy,
+			IfNotPresentApplyPolicy, ReplaceApplyPolicy, ForceApplyPolicy)
+	}
+}
+
+// recreateObject deletes the existing object, waits for its
+// finalization and applies the desired object.
+func recreateObject(ctx context.Context, kubeClient client.Client, existing, obj *unstructured.Unstructured) error {
+	// the object may have been deleted in the meantime, it's then created
+	err := kubeClient.Delete(ctx, existing, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	err = wait.PollImmediateUntil(stagePollInterval, func() (bool, error) {
+		current := &unstructured.Unstructured{}
+		current.SetGroupVersionKind(obj.GroupVersionKind())
+		err := kubeClient.Get(ctx, client.ObjectKeyFromObject(obj), current)
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}, ctx.Done())
+	if err != nil {
+		return fmt.Errorf("%s deletion failed: %w", objectID(obj), err)
+	}
+
+	return kubeClient.Patch(ctx, obj.DeepCopy(), client.Apply, client.ForceOwnership, client.FieldOwner(fieldManager))
+}
+
+// migrateManagedFields transfers the ownership of the fields applied with
+// kubectl client-side apply to the server-side apply field manager of the
+// controller. Without it, the fields removed from the manifests would be kept,
+// as they are still owned by the client-side apply managers, including the
+// last-applied-configuration annotation.
+func migrateManagedFields(ctx context.Context, kubeClient client.Client, existing *unstructured.Unstructured) error {
+	var entries []metav1.ManagedFieldsEntry
+	fields := &fieldpath.Set{}
+	migrate := false
+	for _, entry := range existing.GetManagedFields() {
+		csa := entry.Operation == metav1.ManagedFieldsOperationUpdate && isCSAFieldManager(entry.Manager)
+		ssa := entry.Operation == metav1.ManagedFieldsOperationApply && entry.Manager == fieldManager
+		if !csa && !ssa {
+			entries = append(entries, entry)
+			continue
+		}
+		migrate = migrate || csa
+		if entry.FieldsV1 == nil {
+			continue
+		}
+		set := &fieldpath.Set{}
+		if err := set.FromJSON(bytes.NewReader(entry.FieldsV1.Raw)); err != nil {
+			return fmt.Errorf("unable to decode the managed fields of '%s': %w", objectID(existing), err)
+		}
+		fields = fields.Union(set)
+	}
+	if !migrate {
+		return nil
+	}
+
+	raw, err := fields.ToJSON()
+	if err != nil {
+		return fmt.Errorf("unable to encode the managed fields of '%s': %w", objectID(existing), err)
+	}
+	now := metav1.Now()
+	entries = append(entries, metav1.ManagedFieldsEntry{
+		Manager:    fieldManager,
+		Operation:  metav1.ManagedFieldsOperationApply,
+		APIVersion: existing.GetAPIVersion(),
+		Time:       &now,
+		FieldsType: "FieldsV1",
+		FieldsV1:   &metav1.FieldsV1{Raw: raw},
+	})
+
+	// the patch fails if the object changed since it was read
+	patch, err := json.Marshal([]map[string]interface{}{
+		{"op": "test", "path": "/metadata/resourceVersion", "value": existing.GetResourceVersion()},
+		{"op": "replace", "path": "/metadata/managedFields", "value": entries},
+	})
+	if err != nil {
+		return err
+	}
+	if err := kubeClient.Patch(ctx, existing, client.RawPatch(types.JSONPatchType, patch)); err != nil {
+		return fmt.Errorf("%s managed fields migration failed: %w", objectID(existing), err)
+	}
+	return nil
+}
+
+// isCSAFieldManager returns true if the manager is used by kubectl client-side apply.
+func isCSAFieldManager(manager string) bool {
+	for _, m := range csaFieldManagers {
+		if m == manager {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestApplyPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		wantErr bool
+	}{
+		{name: "default", policy: ""},
+		{name: "create only", policy: IfNotPresentApplyPolicy},
+		{name: "replace", policy: ReplaceApplyPolicy},
+		{name: "force", policy: ForceApplyPolicy},
+		{name: "invalid", policy: "Merge", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &unstructured.Unstructured{}
+			obj.SetKind("ConfigMap")
+			obj.SetName("test")
+			if tt.policy != "" {
+				obj.SetAnnotations(map[string]string{applyPolicyAnnotation: tt.policy})
+			}
+			got, err := applyPolicy(obj)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err == nil && got != tt.policy {
+				t.Errorf("expected %q, got %q", tt.policy, got)
+			}
+		})
+	}
+}
+
+// applyPatchClient records the apply patches, which are not supported by the fake client.
+type applyPatchClient struct {
+	client.Client
+	applied []string
+}
+
+func (c *applyPatchClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() == client.Apply.Type() {
+		c.applied = append(c.applied, obj.GetName())
+		return nil
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func TestRecreateObjectNotFound(t *testing.T) {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("ConfigMap")
+	obj.SetNamespace("apps")
+	obj.SetName("backend")
+
+	// the existing object was deleted since it was read
+	kubeClient := &applyPatchClient{Client: fake.NewClientBuilder().Build()}
+	if err := recreateObject(context.TODO(), kubeClient, obj.DeepCopy(), obj); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(kubeClient.applied) != 1 || kubeClient.applied[0] != "backend" {
+		t.Errorf("expected the object to be applied, got %v", kubeClient.applied)
+	}
+}
+
+func TestMigrateManagedFields(t *testing.T) {
+	managedFields := func(manager string, operation metav1.ManagedFieldsOperationType, fields string) metav1.ManagedFieldsEntry {
+		return metav1.ManagedFieldsEntry{
+			Manager:    manager,
+			Operation:  operation,
+			APIVersion: "v1",
+			FieldsType: "FieldsV1",
+			FieldsV1:   &metav1.FieldsV1{Raw: []byte(fields)},
+		}
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("ConfigMap")
+	obj.SetNamespace("apps")
+	obj.SetName("backend")
+	obj.SetManagedFields([]metav1.ManagedFieldsEntry{
+		managedFields("kubectl-client-side-apply", metav1.ManagedFieldsOperationUpdate,
+			`{"f:data":{"f:key":{}},"f:metadata":{"f:annotations":{"f:kubectl.kubernetes.io/last-applied-configuration":{}}}}`),
+		managedFields(fieldManager, metav1.ManagedFieldsOperationApply, `{"f:data":{"f:other":{}}}`),
+		managedFields("kubectl-edit", metav1.ManagedFieldsOperationUpdate, `{"f:data":{"f:edited":{}}}`),
+	})
+	kubeClient := fake.NewClientBuilder().WithObjects(obj).Build()
+
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(obj.GroupVersionKind())
+	if err := kubeClient.Get(context.TODO(), client.ObjectKeyFromObject(obj), existing); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateManagedFields(context.TODO(), kubeClient, existing); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entries := existing.GetManagedFields()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 managed fields entries, got %+v", entries)
+	}
+	if entries[0].Manager != "kubectl-edit" {
+		t.Errorf("expected the fields of the other managers to be kept, got %s", entries[0].Manager)
+	}
+	migrated := entries[1]
+	if migrated.Manager != fieldManager || migrated.Operation != metav1.ManagedFieldsOperationApply {
+		t.Fatalf("expected the fields to be owned by the apply manager, got %s %s", migrated.Manager, migrated.Operation)
+	}
+	for _, field := range []string{"f:key", "f:other", "f:kubectl.kubernetes.io/last-applied-configuration"} {
+		if !strings.Contains(string(migrated.FieldsV1.Raw), field) {
+			t.Errorf("expected the migrated fields to contain %s, got %s", field, migrated.FieldsV1.Raw)
+		}
+	}
+
+	// the object is migrated once
+	version := existing.GetResourceVersion()
+	if err := migrateManagedFields(context.TODO(), kubeClient, existing); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if existing.GetResourceVersion() != version {
+		t.Errorf("expected the migrated object to be left untouched")
+	}
+}
+
+func TestApplyError(t *testing.T) {
+	obj := &unstructured.Unstructured{}
+	obj.SetKind("Deployment")
+	obj.SetNamespace("apps")
+	obj.SetName("backend")
+
+	err := applyError(context.TODO(), obj, errors.New(`admission webhook "validation.gatekeeper.sh" denied the request: [ns-must-have-owner] you must provide labels`))
+	if !strings.HasPrefix(err.Error(), "apply failed: deployment/apps/backend denied by policy 'ns-must-have-owner'") {
+		t.Errorf("expected the denial to list the object, got %s", err)
+	}
+	if reason := admissionReason(err, meta.ReconciliationFailedReason); reason != kustomizev1.AdmissionDeniedReason {
+		t.Errorf("expected the denial to be unwrapped, got %s", reason)
+	}
+
+	conflict := &ConflictError{ID: objectID(obj)}
+	if err := applyError(context.TODO(), obj, conflict); err != conflict {
+		t.Errorf("expected the conflict error to be returned as is, got %s", err)
+	}
+
+	err = applyError(context.TODO(), obj, errors.New("invalid"))
+	if err.Error() != "apply failed: deployment/apps/backend invalid" {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestObjectApplyOptions(t *testing.T) {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("apps/v1")
+	obj.SetKind("Deployment")
+	obj.SetNamespace("apps")
+	obj.SetName("backend")
+
+	kustomization := kustomizev1.Kustomization{}
+	kustomization.Spec.Force = true
+	kustomization.Spec.ConflictPolicy = kustomizev1.FailConflictPolicy
+	kustomization.Spec.IgnoreDifferences = []kustomizev1.IgnoreRule{{Paths: []string{"/spec/replicas"}}}
+
+	opts, err := objectApplyOptions(kustomization, obj)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !opts.force || opts.conflictPolicy != kustomizev1.FailConflictPolicy ||
+		len(opts.ignorePaths) != 1 || opts.ignorePaths[0] != "/spec/replicas" {
+		t.Errorf("expected the options of the Kustomization, got %+v", opts)
+	}
+	if opts.dryRun || opts.lastApplied != "" {
+		t.Errorf("expected the options to be set by the caller, got %+v", opts)
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// approveAnnotation is the annotation used to approve the apply
+// of a revision, its value must match the source revision.
+var approveAnnotation = fmt.Sprintf("%s/approve", kustomizev1.GroupVersion.Group)
+
+// applyDeferral holds why the apply of a new revision is deferred,
+// the changes are reported as in diff-only mode instead.
+type applyDeferral struct {
+	// Reason is the reason of the Ready condition.
+	Reason string
+	// Message explains what the apply is waiting for.
+	Message string
+	// RequeueAfter is the time after which the apply is retried.
+	RequeueAfter time.Duration
+}
+
+// deferApply returns the deferral of the apply of the revision, if it's pending approval
+// or outside of the schedule windows, or nil if the revision can be applied.
+// The last applied revision is never deferred, so that the drift can be corrected.
+// An error is returned if the schedule is invalid.
+func deferApply(kustomization kustomizev1.Kustomization, revision string, now time.Time) (*applyDeferral, error) {
+	if kustomization.Status.LastAppliedRevision == revision {
+		return nil, nil
+	}
+
+	if kustomization.Spec.RequireApproval && kustomization.GetAnnotations()[approveAnnotation] != revision {
+		return &applyDeferral{
+			Reason: kustomizev1.PendingApprovalReason,
+			Message: fmt.Sprintf("revision %s is pending approval, annotate the Kustomization with %s=%s to apply it",
+				revision, approveAnnotation, revision),
+			RequeueAfter: kustomization.Spec.Interval.Duration,
+		}, nil
+	}
+
+	if kustomization.Spec.Schedule != nil {
+		windows, err := parseSchedule(*kustomization.Spec.Schedule)
+		if err != nil {
+			return nil, err
+		}
+		if !windows.isOpen(now) {
+			opensAt := windows.nextOpen(now)
+			requeueAfter := kustomization.Spec.Interval.Duration
+			if untilOpen := opensAt.Sub(now); untilOpen < requeueAfter {
+				requeueAfter = untilOpen
+			}
+			return &applyDeferral{
+				Reason: kustomizev1.WindowClosedReason,
+				Message: fmt.Sprintf("revision %s not applied outside of the schedule windows, next window at %s",
+					revision, opensAt.Format(time.RFC3339)),
+				RequeueAfter: requeueAfter,
+			}, nil
+		}
+	}
+	return nil, nil
+}
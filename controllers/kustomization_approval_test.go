@@ -0,0 +1,118 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestDeferApply(t *testing.T) {
+	now := time.Date(2021, 6, 9, 15, 0, 0, 0, time.UTC)
+	revision := "main/5d6e7f8"
+	schedule := &kustomizev1.Schedule{Windows: []kustomizev1.ScheduleWindow{
+		{Cron: "0 22 * * *", Duration: metav1.Duration{Duration: 2 * time.Hour}},
+	}}
+
+	tests := []struct {
+		name         string
+		approval     bool
+		approved     string
+		schedule     *kustomizev1.Schedule
+		applied      string
+		wantReason   string
+		wantRequeue  time.Duration
+		wantContains string
+	}{
+		{name: "no gate"},
+		{
+			name:         "pending approval",
+			approval:     true,
+			approved:     "main/1a2b3c4",
+			wantReason:   kustomizev1.PendingApprovalReason,
+			wantRequeue:  10 * time.Minute,
+			wantContains: approveAnnotation + "=" + revision,
+		},
+		{name: "approved", approval: true, approved: revision},
+		{name: "already applied", approval: true, applied: revision},
+		{
+			name:         "approved outside of the windows",
+			approval:     true,
+			approved:     revision,
+			schedule:     schedule,
+			wantReason:   kustomizev1.WindowClosedReason,
+			wantRequeue:  10 * time.Minute,
+			wantContains: "2021-06-09T22:00:00Z",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := kustomizev1.Kustomization{
+				ObjectMeta: metav1.ObjectMeta{Name: "apps", Namespace: "prod"},
+				Spec: kustomizev1.KustomizationSpec{
+					Interval:        metav1.Duration{Duration: 10 * time.Minute},
+					RequireApproval: tt.approval,
+					Schedule:        tt.schedule,
+				},
+			}
+			if tt.approved != "" {
+				k.SetAnnotations(map[string]string{approveAnnotation: tt.approved})
+			}
+			k.Status.LastAppliedRevision = tt.applied
+
+			deferral, err := deferApply(k, revision, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantReason == "" {
+				if deferral != nil {
+					t.Errorf("expected the revision to be applied, got %+v", deferral)
+				}
+				return
+			}
+			if deferral == nil {
+				t.Fatal("expected the revision to be deferred")
+			}
+			if deferral.Reason != tt.wantReason || deferral.RequeueAfter != tt.wantRequeue {
+				t.Errorf("unexpected deferral %+v", deferral)
+			}
+			if !strings.Contains(deferral.Message, tt.wantContains) {
+				t.Errorf("expected the message to contain %q, got %q", tt.wantContains, deferral.Message)
+			}
+		})
+	}
+}
+
+func TestApprovalChangePredicate(t *testing.T) {
+	pending := &kustomizev1.Kustomization{}
+	approved := pending.DeepCopy()
+	approved.SetAnnotations(map[string]string{approveAnnotation: "main/5d6e7f8"})
+
+	p := ApprovalChangePredicate{}
+	if !p.Update(event.UpdateEvent{ObjectOld: pending, ObjectNew: approved}) {
+		t.Error("expected an event when the revision is approved")
+	}
+	if p.Update(event.UpdateEvent{ObjectOld: approved, ObjectNew: approved}) {
+		t.Error("expected no event when the approval doesn't change")
+	}
+}
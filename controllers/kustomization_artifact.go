@@ -0,0 +1,244 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fluxcd/pkg/untar"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ArtifactNotFoundError is returned when the source storage reports
+// the artifact as permanently missing. Retrying the download is pointless
+// until the source publishes a new artifact.
+type ArtifactNotFoundError struct {
+	URL    string
+	Status string
+}
+
+func (e *ArtifactNotFoundError) Error() string {
+	return fmt.Sprintf("artifact not found at %s, status: %s", e.URL, e.Status)
+}
+
+// isArtifactGone returns true if the status code
+// denotes a permanently missing artifact.
+func isArtifactGone(statusCode int) bool {
+	return statusCode == http.StatusNotFound || statusCode == http.StatusGone
+}
+
+// Artifact packaging formats supported by the controller.
+const (
+	tarGzipFormat = "tar.gz"
+	tarZstdFormat = "tar.zst"
+	zipFormat     = "zip"
+)
+
+// artifactFormatExtensions maps the file extensions
+// of the artifact URL to the packaging formats.
+var artifactFormatExtensions = map[string]string{
+	".tar.gz":  tarGzipFormat,
+	".tgz":     tarGzipFormat,
+	".tar.zst": tarZstdFormat,
+	".tzst":    tarZstdFormat,
+	".zip":     zipFormat,
+}
+
+// artifactFormatMagic maps the leading bytes of the artifact
+// to the packaging formats, used when the URL has no known extension.
+var artifactFormatMagic = []struct {
+	magic  []byte
+	format string
+}{
+	{[]byte{0x1f, 0x8b}, tarGzipFormat},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, tarZstdFormat},
+	{[]byte("PK\x03\x04"), zipFormat},
+}
+
+// artifactFormat returns the packaging format of the artifact based on the
+// extension of its URL, falling back to the magic number of the content.
+func artifactFormat(artifactURL string, body *bufio.Reader) (string, error) {
+	if u, err := url.Parse(artifactURL); err == nil {
+		p := strings.ToLower(u.Path)
+		for ext, format := range artifactFormatExtensions {
+			if strings.HasSuffix(p, ext) {
+				return format, nil
+			}
+		}
+	}
+
+	header, err := body.Peek(4)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	for _, m := range artifactFormatMagic {
+		if bytes.HasPrefix(header, m.magic) {
+			return m.format, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported artifact format for %s", artifactURL)
+}
+
+// extractArtifact decompresses the artifact read from r into dir.
+// All formats are converted to a gzip-compressed tarball so that the
+// extraction goes through the same path validation regardless of the format.
+func extractArtifact(r io.Reader, artifactURL string, dir string) error {
+	body := bufio.NewReader(r)
+	format, err := artifactFormat(artifactURL, body)
+	if err != nil {
+		return err
+	}
+
+	var tarball io.ReadCloser
+	switch format {
+	case tarGzipFormat:
+		tarball = ioutil.NopCloser(body)
+	case tarZstdFormat:
+		zr, err := zstd.NewReader(body)
+		if err != nil {
+			return fmt.Errorf("requires zstd-compressed body: %w", err)
+		}
+		tarball = gzipStream(func(w io.Writer) error {
+			defer zr.Close()
+			_, err := io.Copy(w, zr)
+			return err
+		})
+	case zipFormat:
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return fmt.Errorf("requires zip archive: %w", err)
+		}
+		tarball = gzipStream(func(w io.Writer) error {
+			return zipToTar(zr, w)
+		})
+	}
+	defer tarball.Close()
+
+	if _, err := untar.Untar(tarball, dir); err != nil {
+		return fmt.Errorf("%s: %w", format, err)
+	}
+	return nil
+}
+
+// gzipStream returns a reader of the gzip-compressed output of write.
+// Compression is disabled as the stream is consumed locally, closing
+// the reader stops the writer if the stream is not read to the end.
+func gzipStream(write func(w io.Writer) error) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		gw, _ := gzip.NewWriterLevel(pw, gzip.NoCompression)
+		err := write(gw)
+		if closeErr := gw.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// zipToTar writes the directories and regular files of the zip archive
+// as a tarball, other entries such as symlinks are skipped.
+func zipToTar(zr *zip.Reader, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	for _, f := range zr.File {
+		mode := f.Mode()
+		if !mode.IsDir() && !mode.IsRegular() {
+			continue
+		}
+		header, err := tar.FileInfoHeader(f.FileInfo(), "")
+		if err != nil {
+			return err
+		}
+		header.Name = f.Name
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if mode.IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// InvalidPathError is returned when the path of the Kustomization
+// points outside of the artifact root. Retrying the reconciliation is
+// pointless until the path or the artifact changes.
+type InvalidPathError struct {
+	Path   string
+	Reason string
+}
+
+func (e *InvalidPathError) Error() string {
+	return fmt.Sprintf("invalid kustomization path '%s': %s", e.Path, e.Reason)
+}
+
+// artifactPath returns the canonical path of the given relative path in the
+// artifact root, with the symlinks resolved. Absolute paths, paths containing
+// '..' and paths resolving outside of the root are rejected with an InvalidPathError.
+func artifactPath(root, path string) (string, error) {
+	if strings.HasPrefix(path, "/") || filepath.IsAbs(path) {
+		return "", &InvalidPathError{Path: path, Reason: "must be relative to the artifact root"}
+	}
+	for _, segment := range strings.Split(filepath.ToSlash(path), "/") {
+		if segment == ".." {
+			return "", &InvalidPathError{Path: path, Reason: "must not contain '..'"}
+		}
+	}
+
+	dirPath := filepath.Join(root, filepath.FromSlash(path))
+	resolved, err := filepath.EvalSymlinks(dirPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return dirPath, nil
+		}
+		return "", err
+	}
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(resolvedRoot, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &InvalidPathError{Path: path, Reason: "resolves outside of the artifact root"}
+	}
+	return resolved, nil
+}
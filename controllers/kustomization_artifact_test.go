@@ -0,0 +1,226 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestDownloadArtifactNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/gone.tar.gz":
+			w.WriteHeader(http.StatusGone)
+		case "/unavailable.tar.gz":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tmpDir, err := ioutil.TempDir("", "artifact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	httpClient := retryablehttp.NewClient()
+	httpClient.RetryMax = 0
+	httpClient.Logger = nil
+	r := &KustomizationReconciler{httpClient: httpClient}
+
+	for _, path := range []string{"/missing.tar.gz", "/gone.tar.gz"} {
+		err := r.download(context.TODO(), server.URL+path, tmpDir, nil)
+		var notFound *ArtifactNotFoundError
+		if !errors.As(err, &notFound) {
+			t.Errorf("expected artifact not found error for %s, got %v", path, err)
+		}
+	}
+
+	err = r.download(context.TODO(), server.URL+"/unavailable.tar.gz", tmpDir, nil)
+	var notFound *ArtifactNotFoundError
+	if err == nil || errors.As(err, &notFound) {
+		t.Errorf("expected transient error, got %v", err)
+	}
+}
+
+func TestExtractArtifact(t *testing.T) {
+	files := map[string]string{
+		"kustomization.yaml": "resources:\n- deploy.yaml\n",
+		"deploy/deploy.yaml": "kind: Deployment\n",
+	}
+
+	tarball := func(w io.Writer) {
+		tw := tar.NewWriter(w)
+		for name, content := range files {
+			if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write([]byte(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tgz bytes.Buffer
+	gw := gzip.NewWriter(&tgz)
+	tarball(gw)
+	gw.Close()
+
+	var tzst bytes.Buffer
+	zw, err := zstd.NewWriter(&tzst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarball(zw)
+	zw.Close()
+
+	var zipped bytes.Buffer
+	archive := zip.NewWriter(&zipped)
+	for name, content := range files {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	archive.Close()
+
+	tests := []struct {
+		name string
+		url  string
+		data []byte
+	}{
+		{"tar.gz", "http://source/artifact.tar.gz", tgz.Bytes()},
+		{"tar.zst", "http://source/artifact.tar.zst", tzst.Bytes()},
+		{"zip", "http://source/artifact.zip", zipped.Bytes()},
+		{"sniffed zip", "http://source/artifact?rev=1", zipped.Bytes()},
+		{"sniffed tar.zst", "http://source/artifact", tzst.Bytes()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir, err := ioutil.TempDir("", "artifact")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			if err := extractArtifact(bytes.NewReader(tt.data), tt.url, tmpDir); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for name, content := range files {
+				data, err := ioutil.ReadFile(filepath.Join(tmpDir, name))
+				if err != nil {
+					t.Fatalf("expected %s to be extracted: %v", name, err)
+				}
+				if string(data) != content {
+					t.Errorf("expected %s content %q, got %q", name, content, data)
+				}
+			}
+		})
+	}
+
+	tmpDir, err := ioutil.TempDir("", "artifact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := extractArtifact(bytes.NewReader([]byte("plain text")), "http://source/artifact", tmpDir); err == nil {
+		t.Error("expected error for unsupported format")
+	}
+	if err := extractArtifact(bytes.NewReader(tgz.Bytes()), "http://source/artifact.zip", tmpDir); err == nil {
+		t.Error("expected error for format mismatch")
+	}
+}
+
+func TestArtifactPath(t *testing.T) {
+	root, err := ioutil.TempDir("", "artifact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	outside, err := ioutil.TempDir("", "outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+
+	if err := os.MkdirAll(filepath.Join(root, "apps", "prod"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "apps"), filepath.Join(root, "current")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		want    string
+		invalid bool
+	}{
+		{path: "", want: "."},
+		{path: "./", want: "."},
+		{path: "./apps/prod/", want: "apps/prod"},
+		{path: "./current/prod", want: "apps/prod"},
+		{path: "./missing", want: "missing"},
+		{path: "/apps", invalid: true},
+		{path: "../apps", invalid: true},
+		{path: "./apps/../../etc", invalid: true},
+		{path: "./escape", invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := artifactPath(root, tt.path)
+			var invalidPath *InvalidPathError
+			if tt.invalid {
+				if !errors.As(err, &invalidPath) {
+					t.Errorf("expected invalid path error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resolvedRoot, _ := filepath.EvalSymlinks(root)
+			if got != filepath.Join(resolvedRoot, tt.want) && got != filepath.Join(root, tt.want) {
+				t.Errorf("expected %s, got %s", filepath.Join(root, tt.want), got)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// PartialApplyError is returned when an atomic apply fails,
+// it records the objects that were applied before the failure.
+type PartialApplyError struct {
+	// Applied is the list of objects applied on the cluster.
+	Applied []string
+	// NotApplied is the list of objects left unapplied.
+	NotApplied []string
+	// Err is the apply error.
+	Err error
+}
+
+func (e *PartialApplyError) Error() string {
+	return fmt.Sprintf("%v, atomic apply stopped after applying %d/%d objects",
+		e.Err, len(e.Applied), len(e.Applied)+len(e.NotApplied))
+}
+
+func (e *PartialApplyError) Unwrap() error {
+	return e.Err
+}
+
+// newPartialApplyError splits the objects of the build
+// in applied and not applied, according to the checkpoint.
+func newPartialApplyError(objects []*unstructured.Unstructured, checkpoint *applyCheckpoint, err error) *PartialApplyError {
+	partialErr := &PartialApplyError{Err: err}
+	for _, obj := range objects {
+		if checkpoint.isApplied(obj) {
+			partialErr.Applied = append(partialErr.Applied, objectID(obj))
+		} else {
+			partialErr.NotApplied = append(partialErr.NotApplied, objectID(obj))
+		}
+	}
+	return partialErr
+}
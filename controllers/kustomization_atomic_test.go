@@ -0,0 +1,84 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+	"github.com/fluxcd/kustomize-controller/pkg/validation"
+)
+
+func TestPartialApplyError(t *testing.T) {
+	objects, err := readObjects([]byte(`---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: test
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: first
+  namespace: test
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: second
+  namespace: test
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the namespace was applied by a previous reconciliation
+	kustomization := kustomizev1.Kustomization{}
+	previous := newApplyCheckpoint(kustomization, "sha1", len(objects), time.Now().Add(-time.Second))
+	previous.add(objects[0])
+	var budgetErr *BudgetExceededError
+	if !errors.As(previous.exceeded(), &budgetErr) {
+		t.Fatalf("expected budget exceeded error")
+	}
+	kustomization.Status.Checkpoint = budgetErr.Checkpoint
+
+	checkpoint := newApplyCheckpoint(kustomization, "sha1", len(objects), time.Time{})
+	checkpoint.add(objects[1])
+
+	denied := &validation.AdmissionDeniedError{Webhook: "validation.gatekeeper.sh", Message: "denied"}
+	partialErr := newPartialApplyError(objects, checkpoint, denied)
+
+	if want := []string{"namespace/test", "configmap/test/first"}; !reflect.DeepEqual(partialErr.Applied, want) {
+		t.Errorf("expected applied %v, got %v", want, partialErr.Applied)
+	}
+	if want := []string{"configmap/test/second"}; !reflect.DeepEqual(partialErr.NotApplied, want) {
+		t.Errorf("expected not applied %v, got %v", want, partialErr.NotApplied)
+	}
+	if !strings.Contains(partialErr.Error(), "after applying 2/3 objects") {
+		t.Errorf("expected the progress in the message, got %s", partialErr.Error())
+	}
+
+	// the apply error can still be inspected
+	var deniedErr *validation.AdmissionDeniedError
+	if !errors.As(partialErr, &deniedErr) {
+		t.Errorf("expected the admission denial to be unwrapped")
+	}
+}
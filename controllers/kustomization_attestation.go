@@ -0,0 +1,225 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+const (
+	attestationSuffix = "-attestation"
+	attestationKey    = "attestation.json"
+
+	inTotoStatementType  = "https://in-toto.io/Statement/v0.1"
+	slsaProvenanceType   = "https://slsa.dev/provenance/v0.2"
+	attestationBuildType = "https://toolkit.fluxcd.io/kustomize/v1beta1"
+	attestationBuilderID = "https://github.com/fluxcd/kustomize-controller"
+	dssePayloadType      = "application/vnd.in-toto+json"
+)
+
+// inTotoStatement is an in-toto statement with a SLSA provenance predicate,
+// describing the build output applied from a source revision.
+type inTotoStatement struct {
+	Type          string          `json:"_type"`
+	PredicateType string          `json:"predicateType"`
+	Subject       []inTotoSubject `json:"subject"`
+	Predicate     slsaProvenance  `json:"predicate"`
+}
+
+type inTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type slsaProvenance struct {
+	Builder    slsaBuilder    `json:"builder"`
+	BuildType  string         `json:"buildType"`
+	Invocation slsaInvocation `json:"invocation"`
+	Metadata   slsaMetadata   `json:"metadata"`
+	Materials  []slsaMaterial `json:"materials"`
+}
+
+type slsaBuilder struct {
+	ID string `json:"id"`
+}
+
+type slsaInvocation struct {
+	ConfigSource slsaConfigSource `json:"configSource"`
+}
+
+type slsaConfigSource struct {
+	URI        string            `json:"uri"`
+	Digest     map[string]string `json:"digest"`
+	EntryPoint string            `json:"entryPoint"`
+}
+
+type slsaMetadata struct {
+	BuildFinishedOn string `json:"buildFinishedOn"`
+}
+
+type slsaMaterial struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest"`
+}
+
+// dsseEnvelope is a Dead Simple Signing Envelope holding a signed statement.
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// newAttestation returns the provenance statement of the build output
+// generated from the given source artifact.
+func newAttestation(kustomization kustomizev1.Kustomization, artifact sourcev1.Artifact, manifests []byte, finished time.Time) inTotoStatement {
+	digest := sha256.Sum256(manifests)
+
+	// the revision format is '<branch|tag>/<commit>' for Git repositories
+	revision := artifact.Revision
+	if i := strings.LastIndex(revision, "/"); i >= 0 {
+		revision = revision[i+1:]
+	}
+
+	return inTotoStatement{
+		Type:          inTotoStatementType,
+		PredicateType: slsaProvenanceType,
+		Subject: []inTotoSubject{{
+			Name:   fmt.Sprintf("%s/%s/%s", kustomizev1.KustomizationKind, kustomization.GetNamespace(), kustomization.GetName()),
+			Digest: map[string]string{"sha256": hex.EncodeToString(digest[:])},
+		}},
+		Predicate: slsaProvenance{
+			Builder:   slsaBuilder{ID: attestationBuilderID},
+			BuildType: attestationBuildType,
+			Invocation: slsaInvocation{
+				ConfigSource: slsaConfigSource{
+					URI:        kustomization.Spec.SourceRef.String(),
+					Digest:     map[string]string{"sha1": revision},
+					EntryPoint: kustomization.Spec.Path,
+				},
+			},
+			Metadata: slsaMetadata{BuildFinishedOn: finished.UTC().Format(time.RFC3339)},
+			Materials: []slsaMaterial{{
+				URI:    artifact.URL,
+				Digest: map[string]string{"sha1": artifact.Checksum},
+			}},
+		},
+	}
+}
+
+// signAttestation wraps the statement in a DSSE envelope
+// signed with the given ed25519 key.
+func signAttestation(statement inTotoStatement, key ed25519.PrivateKey) (*dsseEnvelope, error) {
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return nil, err
+	}
+
+	// the signature is computed over the pre-authentication encoding
+	pae := fmt.Sprintf("DSSEv1 %d %s %d %s", len(dssePayloadType), dssePayloadType, len(payload), payload)
+	keyID := sha256.Sum256(key.Public().(ed25519.PublicKey))
+
+	return &dsseEnvelope{
+		PayloadType: dssePayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures: []dsseSignature{{
+			KeyID: hex.EncodeToString(keyID[:]),
+			Sig:   base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(pae))),
+		}},
+	}, nil
+}
+
+// readAttestationKey reads an ed25519 private key in PKCS #8 PEM format.
+func readAttestationKey(path string) (ed25519.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in '%s'", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the private key '%s': %w", path, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("the private key '%s' is not an ed25519 key", path)
+	}
+	return edKey, nil
+}
+
+// attest records the provenance of the applied build output in a ConfigMap
+// owned by the Kustomization. The statement is signed if the controller was
+// configured with a signing key.
+func (r *KustomizationReconciler) attest(ctx context.Context, kustomization kustomizev1.Kustomization, artifact sourcev1.Artifact, dirPath string) error {
+	manifests, err := ioutil.ReadFile(filepath.Join(dirPath, fmt.Sprintf("%s.yaml", kustomization.GetUID())))
+	if err != nil {
+		return err
+	}
+
+	statement := newAttestation(kustomization, artifact, manifests, time.Now())
+	var attestation interface{} = statement
+	if r.attestationKey != nil {
+		envelope, err := signAttestation(statement, r.attestationKey)
+		if err != nil {
+			return err
+		}
+		attestation = envelope
+	}
+	data, err := json.MarshalIndent(attestation, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	cm := &corev1.ConfigMap{}
+	cm.SetName(kustomization.GetName() + attestationSuffix)
+	cm.SetNamespace(kustomization.GetNamespace())
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, cm, func() error {
+		cm.Data = map[string]string{
+			attestationKey: string(data),
+		}
+		return controllerutil.SetControllerReference(&kustomization, cm, r.Scheme)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to write attestation ConfigMap '%s/%s': %w", cm.GetNamespace(), cm.GetName(), err)
+	}
+	return nil
+}
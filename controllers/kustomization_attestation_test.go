@@ -0,0 +1,108 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestAttestation(t *testing.T) {
+	kustomization := kustomizev1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "apps"},
+		Spec: kustomizev1.KustomizationSpec{
+			Path:      "./deploy",
+			SourceRef: kustomizev1.CrossNamespaceSourceReference{Kind: sourcev1.GitRepositoryKind, Name: "webapp"},
+		},
+	}
+	artifact := sourcev1.Artifact{
+		URL:      "http://source-controller/gitrepository/apps/webapp/a1afe267.tar.gz",
+		Revision: "main/a1afe267b54f38b46b487f6e938a6fd508278c07",
+		Checksum: "7c1f3f7e",
+	}
+
+	statement := newAttestation(kustomization, artifact, []byte("apiVersion: v1\n"), time.Now())
+	if name := statement.Subject[0].Name; name != "Kustomization/apps/backend" {
+		t.Errorf("unexpected subject name %s", name)
+	}
+	if digest := statement.Predicate.Invocation.ConfigSource.Digest["sha1"]; digest != "a1afe267b54f38b46b487f6e938a6fd508278c07" {
+		t.Errorf("unexpected source digest %s", digest)
+	}
+
+	tmpDir, err := ioutil.TempDir("", "attestation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(tmpDir, "key.pem")
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := readAttestationKey(keyFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	envelope, err := signAttestation(statement, key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(envelope.Signatures[0].Sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pae := fmt.Sprintf("DSSEv1 %d %s %d %s", len(envelope.PayloadType), envelope.PayloadType, len(payload), payload)
+	if !ed25519.Verify(public, []byte(pae), sig) {
+		t.Error("expected the signature to be valid")
+	}
+
+	var decoded inTotoStatement
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decoded.Subject[0].Digest["sha256"] != statement.Subject[0].Digest["sha256"] {
+		t.Error("expected the signed payload to contain the statement")
+	}
+}
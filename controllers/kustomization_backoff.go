@@ -0,0 +1,46 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// retryBackoff returns the interval at which a failing Kustomization is retried.
+// The retry interval is doubled for each consecutive failure after the first one,
+// up to the max interval. The backoff is disabled when max is not greater than
+// the retry interval.
+func retryBackoff(interval, max time.Duration, failures int64) time.Duration {
+	if max <= interval {
+		return interval
+	}
+	for i := int64(1); i < failures; i++ {
+		interval *= 2
+		if interval >= max {
+			return max
+		}
+	}
+	return interval
+}
+
+// isBootstrapping returns true if the bootstrap retries are enabled,
+// and the Kustomization has never been applied successfully.
+func (r *KustomizationReconciler) isBootstrapping(kustomization kustomizev1.Kustomization) bool {
+	return r.bootstrapRetry > 0 && kustomization.Status.LastAppliedRevision == ""
+}
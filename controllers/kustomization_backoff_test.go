@@ -0,0 +1,67 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		max      time.Duration
+		failures int64
+		want     time.Duration
+	}{
+		{name: "disabled", max: 0, failures: 5, want: time.Minute},
+		{name: "first failure", max: time.Hour, failures: 1, want: time.Minute},
+		{name: "third failure", max: time.Hour, failures: 3, want: 4 * time.Minute},
+		{name: "capped", max: 10 * time.Minute, failures: 5, want: 10 * time.Minute},
+		{name: "many failures", max: time.Hour, failures: 1000, want: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryBackoff(time.Minute, tt.max, tt.failures); got != tt.want {
+				t.Errorf("retryBackoff() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsBootstrapping(t *testing.T) {
+	k := kustomizev1.Kustomization{}
+	r := &KustomizationReconciler{}
+	if r.isBootstrapping(k) {
+		t.Error("expected bootstrap retries to be disabled by default")
+	}
+
+	r.bootstrapRetry = 5 * time.Second
+	if !r.isBootstrapping(k) {
+		t.Error("expected a Kustomization that was never applied to be bootstrapping")
+	}
+	if got := retryBackoff(r.bootstrapRetry, time.Minute, 3); got != 20*time.Second {
+		t.Errorf("expected the third bootstrap retry after 20s, got %v", got)
+	}
+
+	k.Status.LastAppliedRevision = "main/a1afe267b54f38b46b487f6e938a6fd508278c07"
+	if r.isBootstrapping(k) {
+		t.Error("expected an applied Kustomization not to be bootstrapping")
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+const (
+	backupInventoryKey = "inventory"
+	backupRevisionKey  = "lastAppliedRevision"
+)
+
+// inventoryBackupAnnotation is the annotation of the Kustomization that
+// enables the backup of its inventory, when set to "enabled".
+var inventoryBackupAnnotation = fmt.Sprintf("%s/inventory-backup", kustomizev1.GroupVersion.Group)
+
+// inventoryBackupLabel is the label of the backup ConfigMap that holds the name
+// of the Kustomization it was created for. ConfigMaps without this label are
+// never written nor read by the controller.
+var inventoryBackupLabel = fmt.Sprintf("%s/inventory-backup-of", kustomizev1.GroupVersion.Group)
+
+// inventoryBackupName returns the name of the backup ConfigMap of the Kustomization,
+// or an empty string if the backup is not enabled.
+func inventoryBackupName(kustomization kustomizev1.Kustomization) string {
+	if kustomization.GetAnnotations()[inventoryBackupAnnotation] != "enabled" {
+		return ""
+	}
+	return fmt.Sprintf("%s-inventory-backup", kustomization.GetName())
+}
+
+// isInventoryBackup returns true if the ConfigMap was created by the controller
+// to back up the inventory of the Kustomization.
+func isInventoryBackup(cm *corev1.ConfigMap, kustomization kustomizev1.Kustomization) bool {
+	return cm.GetLabels()[inventoryBackupLabel] == kustomization.GetName()
+}
+
+// exportInventory writes the inventory and the last applied revision
+// of the Kustomization to the backup ConfigMap, if the backup is enabled.
+// The ConfigMap is written with the client used to apply the manifests,
+// and an existing ConfigMap is only updated if it was created by the controller.
+// The ConfigMap is not owned by the Kustomization, so that it outlives
+// the deletion of the Kustomization and can be restored on a new cluster.
+func (r *KustomizationReconciler) exportInventory(ctx context.Context, kubeClient client.Client, kustomization kustomizev1.Kustomization) error {
+	name := inventoryBackupName(kustomization)
+	if name == "" || kustomization.Status.Inventory == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(kustomization.Status.Inventory)
+	if err != nil {
+		return err
+	}
+
+	cm := &corev1.ConfigMap{}
+	cm.SetName(name)
+	cm.SetNamespace(kustomization.GetNamespace())
+	_, err = controllerutil.CreateOrUpdate(ctx, kubeClient, cm, func() error {
+		if cm.GetResourceVersion() != "" && !isInventoryBackup(cm, kustomization) {
+			return fmt.Errorf("the ConfigMap was not created by the controller, missing label '%s: %s'",
+				inventoryBackupLabel, kustomization.GetName())
+		}
+		labels := cm.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[inventoryBackupLabel] = kustomization.GetName()
+		cm.SetLabels(labels)
+		cm.Data = map[string]string{
+			backupInventoryKey: string(data),
+			backupRevisionKey:  kustomization.Status.LastAppliedRevision,
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to write inventory backup ConfigMap '%s/%s': %w", cm.GetNamespace(), cm.GetName(), err)
+	}
+	return nil
+}
+
+// importInventory restores the inventory and the last applied revision of
+// the Kustomization from the backup ConfigMap, if the backup is enabled and
+// the Kustomization has never been applied on this cluster. This allows the
+// garbage collection to prune the objects removed from the source while the
+// Kustomization was recreated, instead of orphaning them.
+// ConfigMaps not created by the controller are ignored.
+func (r *KustomizationReconciler) importInventory(ctx context.Context, kubeClient client.Client, kustomization *kustomizev1.Kustomization) error {
+	name := inventoryBackupName(*kustomization)
+	if name == "" || kustomization.Status.Inventory != nil || kustomization.Status.LastAppliedRevision != "" {
+		return nil
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := kubeClient.Get(ctx, client.ObjectKey{Namespace: kustomization.GetNamespace(), Name: name}, cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("unable to read inventory backup ConfigMap '%s/%s': %w", kustomization.GetNamespace(), name, err)
+	}
+	if !isInventoryBackup(cm, *kustomization) {
+		return nil
+	}
+
+	inventory, err := decodeInventoryBackup(cm)
+	if err != nil {
+		return fmt.Errorf("invalid inventory backup ConfigMap '%s/%s': %w", kustomization.GetNamespace(), name, err)
+	}
+	kustomization.Status.Inventory = inventory
+	kustomization.Status.LastAppliedRevision = cm.Data[backupRevisionKey]
+	return nil
+}
+
+// decodeInventoryBackup returns the inventory recorded in the backup ConfigMap.
+func decodeInventoryBackup(cm *corev1.ConfigMap) (*kustomizev1.ResourceInventory, error) {
+	data, ok := cm.Data[backupInventoryKey]
+	if !ok {
+		return nil, fmt.Errorf("missing '%s' key", backupInventoryKey)
+	}
+	inventory := &kustomizev1.ResourceInventory{}
+	if err := json.Unmarshal([]byte(data), inventory); err != nil {
+		return nil, err
+	}
+	for _, entry := range inventory.Entries {
+		if _, err := inventoryObject(entry); err != nil {
+			return nil, err
+		}
+	}
+	return inventory, nil
+}
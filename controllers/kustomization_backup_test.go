@@ -0,0 +1,136 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestInventoryBackup(t *testing.T) {
+	objects, err := readObjects([]byte(`---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: backend
+  namespace: dev
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	kubeClient := fake.NewClientBuilder().Build()
+	r := &KustomizationReconciler{}
+
+	applied := kustomizev1.Kustomization{}
+	applied.SetName("backend")
+	applied.SetNamespace("dev")
+	applied.SetAnnotations(map[string]string{inventoryBackupAnnotation: "enabled"})
+	applied.Status.Inventory = newInventory(objects)
+	applied.Status.LastAppliedRevision = "main/5394cb7f48332b2de7c17dd8b8384bbc84b7e738"
+	if err := r.exportInventory(context.TODO(), kubeClient, applied); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the Kustomization is recreated on a new cluster
+	restored := kustomizev1.Kustomization{}
+	restored.SetName("backend")
+	restored.SetNamespace("dev")
+	restored.SetAnnotations(applied.GetAnnotations())
+	if err := r.importInventory(context.TODO(), kubeClient, &restored); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if restored.Status.Inventory == nil || len(restored.Status.Inventory.Entries) != 1 ||
+		restored.Status.Inventory.Entries[0] != applied.Status.Inventory.Entries[0] {
+		t.Errorf("expected inventory %v, got %v", applied.Status.Inventory, restored.Status.Inventory)
+	}
+	if restored.Status.LastAppliedRevision != applied.Status.LastAppliedRevision {
+		t.Errorf("expected revision %s, got %s", applied.Status.LastAppliedRevision, restored.Status.LastAppliedRevision)
+	}
+
+	// the backup is ignored once the Kustomization was applied
+	current := kustomizev1.Kustomization{}
+	current.SetNamespace("dev")
+	current.SetAnnotations(applied.GetAnnotations())
+	current.Status.LastAppliedRevision = "main/2ba1ac81b4a2b7b2e7b0e2bba2e2e8c8ae0e0e2a"
+	if err := r.importInventory(context.TODO(), kubeClient, &current); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if current.Status.Inventory != nil {
+		t.Errorf("expected the inventory not to be restored, got %v", current.Status.Inventory)
+	}
+
+	// a missing backup is ignored
+	missing := kustomizev1.Kustomization{}
+	missing.SetName("missing")
+	missing.SetNamespace("dev")
+	missing.SetAnnotations(applied.GetAnnotations())
+	if err := r.importInventory(context.TODO(), kubeClient, &missing); err != nil || missing.Status.Inventory != nil {
+		t.Errorf("expected missing backup to be ignored, got %v", err)
+	}
+}
+
+func TestInventoryBackupUnmanagedConfigMap(t *testing.T) {
+	unmanaged := &corev1.ConfigMap{}
+	unmanaged.SetName("backend-inventory-backup")
+	unmanaged.SetNamespace("dev")
+	unmanaged.Data = map[string]string{
+		backupInventoryKey: `{"entries":[{"id":"kube-system_coredns_apps_Deployment","v":"v1"}]}`,
+	}
+	kubeClient := fake.NewClientBuilder().WithObjects(unmanaged).Build()
+	r := &KustomizationReconciler{}
+
+	k := kustomizev1.Kustomization{}
+	k.SetName("backend")
+	k.SetNamespace("dev")
+	k.SetAnnotations(map[string]string{inventoryBackupAnnotation: "enabled"})
+
+	// a ConfigMap not created by the controller is not restored
+	if err := r.importInventory(context.TODO(), kubeClient, &k); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if k.Status.Inventory != nil {
+		t.Errorf("expected the inventory not to be restored, got %v", k.Status.Inventory)
+	}
+
+	// nor overwritten
+	k.Status.Inventory = &kustomizev1.ResourceInventory{}
+	err := r.exportInventory(context.TODO(), kubeClient, k)
+	if err == nil || !strings.Contains(err.Error(), "not created by the controller") {
+		t.Errorf("expected unmanaged ConfigMap error, got %v", err)
+	}
+	cm := &corev1.ConfigMap{}
+	if err := kubeClient.Get(context.TODO(), client.ObjectKeyFromObject(unmanaged), cm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cm.Data[backupInventoryKey] != unmanaged.Data[backupInventoryKey] {
+		t.Errorf("expected the ConfigMap not to be modified, got %v", cm.Data)
+	}
+
+	// the backup is disabled for other annotation values
+	k.SetAnnotations(map[string]string{inventoryBackupAnnotation: "kube-root-ca.crt"})
+	if name := inventoryBackupName(k); name != "" {
+		t.Errorf("expected the backup to be disabled, got %s", name)
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// applyOptions holds the batch size, the concurrency, the interval
+// between batches and the verification of the batches used when applying objects.
+type applyOptions struct {
+	batchSize     int
+	concurrency   int
+	batchInterval time.Duration
+	verifyBatches bool
+}
+
+// applyOptionsFor returns the controller defaults
+// overridden by the Kustomization spec.applyOptions.
+func (r *KustomizationReconciler) applyOptionsFor(kustomization kustomizev1.Kustomization) applyOptions {
+	opts := r.applyOptions
+	if o := kustomization.Spec.ApplyOptions; o != nil {
+		if o.BatchSize != nil {
+			opts.batchSize = *o.BatchSize
+		}
+		if o.Concurrency != nil {
+			opts.concurrency = *o.Concurrency
+		}
+		if o.BatchInterval != nil {
+			opts.batchInterval = o.BatchInterval.Duration
+		}
+		opts.verifyBatches = o.VerifyBatches
+	}
+	if opts.concurrency < 1 {
+		opts.concurrency = 1
+	}
+	return opts
+}
+
+// applyBatches splits the objects in batches of the given size,
+// a size lower than one results in a single batch.
+func applyBatches(objects []*unstructured.Unstructured, size int) [][]*unstructured.Unstructured {
+	if size < 1 || size >= len(objects) {
+		return [][]*unstructured.Unstructured{objects}
+	}
+	var batches [][]*unstructured.Unstructured
+	for size < len(objects) {
+		objects, batches = objects[size:], append(batches, objects[0:size:size])
+	}
+	return append(batches, objects)
+}
+
+// applyBatch applies the objects using up to the given number of workers,
+// and returns the action taken for each applied object. The reconcile budget
+// is checked before starting each object, and no object is started after
+// the first error.
+func applyBatch(ctx context.Context, checkpoint *applyCheckpoint, objects []*unstructured.Unstructured, concurrency int,
+	apply func(context.Context, *unstructured.Unstructured) (string, error)) (map[string]string, error) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		actions  = make(map[string]string, len(objects))
+		sem      = make(chan struct{}, concurrency)
+	)
+
+	for _, obj := range objects {
+		sem <- struct{}{}
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = checkpoint.exceeded()
+		}
+		stop := firstErr != nil
+		mu.Unlock()
+		if stop {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(obj *unstructured.Unstructured) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			action, err := apply(ctx, obj)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			checkpoint.add(obj)
+			actions[objectID(obj)] = action
+		}(obj)
+	}
+	wg.Wait()
+
+	return actions, firstErr
+}
+
+// verifyBatch blocks until the objects of the batch are ready, or the deadline
+// shared by all the batches of the apply expires.
+func verifyBatch(ctx context.Context, kubeClient client.Client, batch []*unstructured.Unstructured, deadline time.Time) error {
+	waitCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	if pending, err := pollObjects(waitCtx, kubeClient, batch); err != nil {
+		return fmt.Errorf("object %s is not ready: %w", pending, err)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func testConfigMaps(n int) []*unstructured.Unstructured {
+	var objects []*unstructured.Unstructured
+	for i := 0; i < n; i++ {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("ConfigMap")
+		obj.SetName(fmt.Sprintf("cm%d", i))
+		obj.SetNamespace("default")
+		objects = append(objects, obj)
+	}
+	return objects
+}
+
+func TestApplyBatches(t *testing.T) {
+	objects := testConfigMaps(5)
+	tests := []struct {
+		size     int
+		expected []int
+	}{
+		{size: 0, expected: []int{5}},
+		{size: 1, expected: []int{1, 1, 1, 1, 1}},
+		{size: 2, expected: []int{2, 2, 1}},
+		{size: 5, expected: []int{5}},
+		{size: 10, expected: []int{5}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("size %d", tt.size), func(t *testing.T) {
+			batches := applyBatches(objects, tt.size)
+			if len(batches) != len(tt.expected) {
+				t.Fatalf("expected %d batches, got %d", len(tt.expected), len(batches))
+			}
+			for i, batch := range batches {
+				if len(batch) != tt.expected[i] {
+					t.Errorf("expected %d objects in batch %d, got %d", tt.expected[i], i, len(batch))
+				}
+			}
+		})
+	}
+}
+
+func TestApplyOptionsFor(t *testing.T) {
+	r := &KustomizationReconciler{applyOptions: applyOptions{batchSize: 50}}
+	size, concurrency := 10, 4
+	kustomization := kustomizev1.Kustomization{}
+
+	if opts := r.applyOptionsFor(kustomization); opts.batchSize != 50 || opts.concurrency != 1 {
+		t.Errorf("expected controller defaults, got %+v", opts)
+	}
+
+	kustomization.Spec.ApplyOptions = &kustomizev1.ApplyOptions{
+		BatchSize:     &size,
+		Concurrency:   &concurrency,
+		BatchInterval: &metav1.Duration{Duration: time.Second},
+		VerifyBatches: true,
+	}
+	opts := r.applyOptionsFor(kustomization)
+	if opts.batchSize != 10 || opts.concurrency != 4 || opts.batchInterval != time.Second || !opts.verifyBatches {
+		t.Errorf("expected spec overrides, got %+v", opts)
+	}
+}
+
+func TestApplyBatch(t *testing.T) {
+	objects := testConfigMaps(10)
+
+	var running, peak int32
+	apply := func(ctx context.Context, obj *unstructured.Unstructured) (string, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return createdAction, nil
+	}
+
+	checkpoint := newApplyCheckpoint(kustomizev1.Kustomization{}, "sha1", len(objects), time.Time{})
+	actions, err := applyBatch(context.TODO(), checkpoint, objects, 3, apply)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(actions) != len(objects) {
+		t.Errorf("expected %d applied objects, got %d", len(objects), len(actions))
+	}
+	if peak > 3 {
+		t.Errorf("expected at most 3 concurrent applies, got %d", peak)
+	}
+	for _, obj := range objects {
+		if !checkpoint.isApplied(obj) {
+			t.Errorf("expected %s to be recorded in the checkpoint", objectID(obj))
+		}
+	}
+
+	// no object is started after the first error
+	failed := errors.New("denied")
+	serial := newApplyCheckpoint(kustomizev1.Kustomization{}, "sha1", len(objects), time.Time{})
+	actions, err = applyBatch(context.TODO(), serial, objects, 1, func(ctx context.Context, obj *unstructured.Unstructured) (string, error) {
+		if obj.GetName() == "cm2" {
+			return "", failed
+		}
+		return configuredAction, nil
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("expected apply error, got %v", err)
+	}
+	if len(actions) != 2 || serial.isApplied(objects[3]) {
+		t.Errorf("expected only the objects before the failure to be applied, got %v", actions)
+	}
+}
+
+func TestVerifyBatch(t *testing.T) {
+	objects, err := readObjects([]byte(`
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  namespace: default
+status:
+  active: 1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := objects[0]
+
+	// a Job is not ready while it runs
+	kubeClient := fake.NewClientBuilder().WithObjects(job.DeepCopy()).Build()
+	err = verifyBatch(context.TODO(), kubeClient, objects, time.Now().Add(100*time.Millisecond))
+	if err == nil || !strings.Contains(err.Error(), "object job/default/migrate (status 'InProgress') is not ready") {
+		t.Fatalf("expected the running Job not to be ready, got %v", err)
+	}
+
+	complete := job.DeepCopy()
+	_ = unstructured.SetNestedSlice(complete.Object, []interface{}{
+		map[string]interface{}{"type": "Complete", "status": "True"},
+	}, "status", "conditions")
+	kubeClient = fake.NewClientBuilder().WithObjects(complete).Build()
+	if err := verifyBatch(context.TODO(), kubeClient, objects, time.Now().Add(100*time.Millisecond)); err != nil {
+		t.Errorf("expected the completed Job to be ready, got %v", err)
+	}
+}
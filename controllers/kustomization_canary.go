@@ -0,0 +1,170 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// +kubebuilder:rbac:groups=flagger.app,resources=canaries,verbs=get;list
+
+// canaryRequeueInterval is the interval at which the
+// canary analysis in progress is checked.
+const canaryRequeueInterval = 30 * time.Second
+
+var canaryGVK = schema.GroupVersionKind{Group: "flagger.app", Version: "v1beta1", Kind: "Canary"}
+
+// CanaryFailedError is returned when the Flagger canary
+// analysis of a Deployment configured by the revision failed.
+type CanaryFailedError struct {
+	Canary  string
+	Message string
+}
+
+func (e *CanaryFailedError) Error() string {
+	return fmt.Sprintf("canary '%s' analysis failed: %s", e.Canary, e.Message)
+}
+
+// trackCanaries starts tracking the canaries of the Deployments configured by the apply,
+// and returns the canary analysis still in progress with a description of the progress
+// of each canary. The analysis of the previous revision is dropped when a new revision
+// is applied, and a CanaryFailedError is returned if the analysis of a canary failed.
+func trackCanaries(ctx context.Context, kubeClient client.Client, kustomization kustomizev1.Kustomization,
+	revision string, results applyResults) (*kustomizev1.CanaryAnalysis, []string, error) {
+	analysis := kustomization.Status.CanaryAnalysis
+	if analysis != nil && analysis.Revision != revision {
+		analysis = nil
+	}
+
+	if deployments := configuredDeployments(results); len(deployments) > 0 {
+		canaries, err := findCanaries(ctx, kubeClient, deployments)
+		if err != nil {
+			return analysis, nil, err
+		}
+		if len(canaries) > 0 {
+			analysis = &kustomizev1.CanaryAnalysis{
+				Revision:  revision,
+				StartedAt: metav1.NewTime(time.Now().Truncate(time.Second)),
+				Canaries:  canaries,
+			}
+		}
+	}
+	if analysis == nil {
+		return nil, nil, nil
+	}
+
+	progress, err := checkCanaries(ctx, kubeClient, *analysis)
+	if err == nil && len(progress) == 0 {
+		return nil, nil, nil
+	}
+	return analysis, progress, err
+}
+
+// configuredDeployments returns the names of the Deployments
+// configured by the apply, grouped by namespace.
+func configuredDeployments(results applyResults) map[string]map[string]bool {
+	deployments := map[string]map[string]bool{}
+	for _, obj := range results {
+		parts := strings.SplitN(obj.ID, "/", 3)
+		if obj.Action != configuredAction || len(parts) != 3 || parts[0] != "deployment" {
+			continue
+		}
+		if deployments[parts[1]] == nil {
+			deployments[parts[1]] = map[string]bool{}
+		}
+		deployments[parts[1]][parts[2]] = true
+	}
+	return deployments
+}
+
+// findCanaries returns the canaries targeting the Deployments in the 'namespace/name' format.
+// No canaries are returned when the Flagger CRDs are not installed on the cluster.
+func findCanaries(ctx context.Context, kubeClient client.Client, deployments map[string]map[string]bool) ([]string, error) {
+	var canaries []string
+	for namespace, names := range deployments {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(canaryGVK.GroupVersion().WithKind(canaryGVK.Kind + "List"))
+		if err := kubeClient.List(ctx, list, client.InNamespace(namespace)); err != nil {
+			if apimeta.IsNoMatchError(err) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unable to list the canaries in namespace '%s': %w", namespace, err)
+		}
+		for _, canary := range list.Items {
+			kind, _, _ := unstructured.NestedString(canary.Object, "spec", "targetRef", "kind")
+			name, _, _ := unstructured.NestedString(canary.Object, "spec", "targetRef", "name")
+			if kind == "Deployment" && names[name] {
+				canaries = append(canaries, canary.GetNamespace()+"/"+canary.GetName())
+			}
+		}
+	}
+	return canaries, nil
+}
+
+// checkCanaries returns the progress of the canaries whose analysis is in progress,
+// or a CanaryFailedError if an analysis failed. The phase of a canary is only taken into
+// account if it changed after the start of the analysis, as Flagger detects the changes
+// of the Deployments at the analysis interval. The deleted canaries are ignored.
+func checkCanaries(ctx context.Context, kubeClient client.Client, analysis kustomizev1.CanaryAnalysis) ([]string, error) {
+	var progress []string
+	for _, id := range analysis.Canaries {
+		parts := strings.SplitN(id, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		canary := &unstructured.Unstructured{}
+		canary.SetGroupVersionKind(canaryGVK)
+		if err := kubeClient.Get(ctx, client.ObjectKey{Namespace: parts[0], Name: parts[1]}, canary); err != nil {
+			if apierrors.IsNotFound(err) || apimeta.IsNoMatchError(err) {
+				continue
+			}
+			return nil, fmt.Errorf("unable to get canary '%s': %w", id, err)
+		}
+
+		phase, _, _ := unstructured.NestedString(canary.Object, "status", "phase")
+		var transition metav1.Time
+		if v, _, _ := unstructured.NestedString(canary.Object, "status", "lastTransitionTime"); v != "" {
+			_ = transition.UnmarshalQueryParameter(v)
+		}
+		if transition.Before(&analysis.StartedAt) {
+			progress = append(progress, fmt.Sprintf("%s waiting for the analysis to start", id))
+			continue
+		}
+
+		switch phase {
+		case "Succeeded", "Initialized":
+		case "Failed":
+			return nil, &CanaryFailedError{Canary: id, Message: conditionMessage(canary, "Promoted")}
+		default:
+			weight, _, _ := unstructured.NestedInt64(canary.Object, "status", "canaryWeight")
+			progress = append(progress, fmt.Sprintf("%s %s (weight %d%%)", id, phase, weight))
+		}
+	}
+	return progress, nil
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func canaryObject(name, target, phase string, transition time.Time) *unstructured.Unstructured {
+	canary := &unstructured.Unstructured{}
+	canary.SetGroupVersionKind(canaryGVK)
+	canary.SetName(name)
+	canary.SetNamespace("apps")
+	canary.Object["spec"] = map[string]interface{}{
+		"targetRef": map[string]interface{}{"apiVersion": "apps/v1", "kind": "Deployment", "name": target},
+	}
+	canary.Object["status"] = map[string]interface{}{
+		"phase":              phase,
+		"canaryWeight":       int64(20),
+		"lastTransitionTime": transition.UTC().Format(time.RFC3339),
+		"conditions": []interface{}{
+			map[string]interface{}{"type": "Promoted", "status": "False", "message": "Canary analysis failed, Deployment scaled to zero."},
+		},
+	}
+	return canary
+}
+
+func TestTrackCanaries(t *testing.T) {
+	before := time.Now().Add(-time.Hour)
+	after := time.Now().Add(time.Hour)
+	results := applyResults{
+		{ID: "deployment/apps/backend", Action: configuredAction},
+		{ID: "deployment/apps/frontend", Action: unchangedAction},
+		{ID: "service/apps/backend", Action: configuredAction},
+	}
+	k := kustomizev1.Kustomization{}
+	revision := "main/5d6e7f8"
+
+	tests := []struct {
+		name         string
+		canaries     []*unstructured.Unstructured
+		wantAnalysis bool
+		wantProgress []string
+		wantFailed   bool
+	}{
+		{name: "no canary"},
+		{
+			name:     "canary of an unchanged deployment",
+			canaries: []*unstructured.Unstructured{canaryObject("frontend", "frontend", "Progressing", after)},
+		},
+		{
+			name:         "analysis not started",
+			canaries:     []*unstructured.Unstructured{canaryObject("backend", "backend", "Succeeded", before)},
+			wantAnalysis: true,
+			wantProgress: []string{"apps/backend waiting for the analysis to start"},
+		},
+		{
+			name:         "analysis in progress",
+			canaries:     []*unstructured.Unstructured{canaryObject("backend", "backend", "Progressing", after)},
+			wantAnalysis: true,
+			wantProgress: []string{"apps/backend Progressing (weight 20%)"},
+		},
+		{
+			name:     "analysis succeeded",
+			canaries: []*unstructured.Unstructured{canaryObject("backend", "backend", "Succeeded", after)},
+		},
+		{
+			name:         "analysis failed",
+			canaries:     []*unstructured.Unstructured{canaryObject("backend", "backend", "Failed", after)},
+			wantAnalysis: true,
+			wantFailed:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder()
+			for _, canary := range tt.canaries {
+				builder = builder.WithObjects(canary)
+			}
+
+			analysis, progress, err := trackCanaries(context.TODO(), builder.Build(), k, revision, results)
+			var failed *CanaryFailedError
+			if got := errors.As(err, &failed); got != tt.wantFailed {
+				t.Fatalf("expected failed %v, got %v", tt.wantFailed, err)
+			}
+			if failed != nil && failed.Message != "Canary analysis failed, Deployment scaled to zero." {
+				t.Errorf("unexpected failure message %q", failed.Message)
+			}
+			if err != nil && !tt.wantFailed {
+				t.Fatal(err)
+			}
+			if got := analysis != nil; got != tt.wantAnalysis {
+				t.Fatalf("expected analysis %v, got %+v", tt.wantAnalysis, analysis)
+			}
+			if !reflect.DeepEqual(progress, tt.wantProgress) {
+				t.Errorf("expected progress %v, got %v", tt.wantProgress, progress)
+			}
+		})
+	}
+}
+
+func TestTrackCanariesRevision(t *testing.T) {
+	c := fake.NewClientBuilder().WithObjects(canaryObject("backend", "backend", "Progressing", time.Now().Add(time.Hour))).Build()
+	k := kustomizev1.Kustomization{}
+	k.Status.CanaryAnalysis = &kustomizev1.CanaryAnalysis{
+		Revision:  "main/1a2b3c4",
+		StartedAt: metav1.Now(),
+		Canaries:  []string{"apps/backend"},
+	}
+
+	// the analysis is tracked across reconciliations of the same revision
+	analysis, progress, err := trackCanaries(context.TODO(), c, k, "main/1a2b3c4", nil)
+	if err != nil || analysis == nil || len(progress) != 1 {
+		t.Errorf("expected the analysis to be in progress, got %+v %v %v", analysis, progress, err)
+	}
+
+	// and dropped when a new revision doesn't configure the Deployment
+	analysis, _, err = trackCanaries(context.TODO(), c, k, "main/5d6e7f8", nil)
+	if err != nil || analysis != nil {
+		t.Errorf("expected the analysis to be dropped, got %+v %v", analysis, err)
+	}
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// newChangelogEntry returns the changelog entry of the applied revision.
+func newChangelogEntry(summary *kustomizev1.ApplySummary, now time.Time) kustomizev1.ChangelogEntry {
+	return kustomizev1.ChangelogEntry{
+		Revision:   summary.Revision,
+		AppliedAt:  metav1.NewTime(now),
+		Created:    summary.Created,
+		Configured: summary.Configured,
+		Deleted:    summary.Deleted,
+	}
+}
+
+// recordChangelog prepends the entry to the Kustomization changelog, if the
+// revision differs from the last applied one or if the apply changed objects
+// e.g. when correcting drift. The oldest entries are discarded.
+func recordChangelog(kustomization *kustomizev1.Kustomization, entry kustomizev1.ChangelogEntry) {
+	changed := entry.Created+entry.Configured+entry.Deleted > 0
+	if entry.Revision == kustomization.Status.LastAppliedRevision && !changed {
+		return
+	}
+	changelog := append([]kustomizev1.ChangelogEntry{entry}, kustomization.Status.Changelog...)
+	if len(changelog) > kustomizev1.MaxChangelogEntries {
+		changelog = changelog[:kustomizev1.MaxChangelogEntries]
+	}
+	kustomization.Status.Changelog = changelog
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"testing"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestRecordChangelog(t *testing.T) {
+	kustomization := kustomizev1.Kustomization{}
+	for i := 0; i < kustomizev1.MaxChangelogEntries+2; i++ {
+		revision := fmt.Sprintf("main/%d", i)
+		recordChangelog(&kustomization, kustomizev1.ChangelogEntry{Revision: revision})
+		kustomization.Status.LastAppliedRevision = revision
+	}
+	changelog := kustomization.Status.Changelog
+	if len(changelog) != kustomizev1.MaxChangelogEntries {
+		t.Fatalf("expected %d entries, got %d", kustomizev1.MaxChangelogEntries, len(changelog))
+	}
+	if changelog[0].Revision != "main/6" || changelog[len(changelog)-1].Revision != "main/2" {
+		t.Errorf("expected the newest entries first, got %v", changelog)
+	}
+
+	// the reconciliations of the same revision are recorded only if they changed objects
+	recordChangelog(&kustomization, kustomizev1.ChangelogEntry{Revision: "main/6"})
+	if kustomization.Status.Changelog[1].Revision != "main/5" {
+		t.Errorf("expected unchanged revision to be skipped")
+	}
+	recordChangelog(&kustomization, kustomizev1.ChangelogEntry{Revision: "main/6", Configured: 1})
+	if kustomization.Status.Changelog[1].Revision != "main/6" {
+		t.Errorf("expected drift correction to be recorded")
+	}
+}
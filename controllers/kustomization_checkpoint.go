@@ -0,0 +1,110 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/cli-utils/pkg/object"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// BudgetExceededError is returned when the apply is interrupted
+// after exceeding the reconcile budget.
+type BudgetExceededError struct {
+	// Checkpoint holds the objects applied so far.
+	Checkpoint *kustomizev1.ApplyCheckpoint
+	// Total is the number of objects to be applied.
+	Total int
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("reconcile budget exceeded after applying %d/%d objects",
+		len(e.Checkpoint.Inventory.Entries), e.Total)
+}
+
+// applyCheckpoint keeps track of the objects applied within the
+// reconcile budget, and of the objects applied by previous
+// reconciliations of the same build output.
+type applyCheckpoint struct {
+	checksum string
+	deadline time.Time
+	total    int
+	applied  map[string]bool
+	entries  []kustomizev1.ResourceRef
+	progress int
+}
+
+// newApplyCheckpoint resumes from the Kustomization checkpoint if it
+// matches the checksum of the build output. A zero deadline disables
+// the budget.
+func newApplyCheckpoint(kustomization kustomizev1.Kustomization, checksum string, total int, deadline time.Time) *applyCheckpoint {
+	c := &applyCheckpoint{
+		checksum: checksum,
+		deadline: deadline,
+		total:    total,
+		applied:  make(map[string]bool),
+	}
+	if cp := kustomization.Status.Checkpoint; cp != nil && cp.Checksum == checksum {
+		for _, entry := range cp.Inventory.Entries {
+			c.applied[entry.ID] = true
+			c.entries = append(c.entries, entry)
+		}
+	}
+	return c
+}
+
+// isApplied returns true if the object was applied
+// by a previous reconciliation of the same build output.
+func (c *applyCheckpoint) isApplied(obj *unstructured.Unstructured) bool {
+	objMetadata := object.UnstructuredToObjMeta(obj)
+	return c.applied[objMetadata.String()]
+}
+
+// add records the object as applied.
+func (c *applyCheckpoint) add(obj *unstructured.Unstructured) {
+	objMetadata := object.UnstructuredToObjMeta(obj)
+	id := objMetadata.String()
+	if c.applied[id] {
+		return
+	}
+	c.applied[id] = true
+	c.entries = append(c.entries, kustomizev1.ResourceRef{
+		ID:      id,
+		Version: obj.GroupVersionKind().Version,
+	})
+	c.progress++
+}
+
+// exceeded returns an error if the reconcile budget is exhausted.
+// At least one object is applied per reconciliation to ensure
+// the apply makes progress when the budget is too small.
+func (c *applyCheckpoint) exceeded() error {
+	if c.deadline.IsZero() || c.progress == 0 || time.Now().Before(c.deadline) {
+		return nil
+	}
+	return &BudgetExceededError{
+		Checkpoint: &kustomizev1.ApplyCheckpoint{
+			Checksum:  c.checksum,
+			Inventory: kustomizev1.ResourceInventory{Entries: c.entries},
+		},
+		Total: c.total,
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestApplyCheckpoint(t *testing.T) {
+	objects, err := readObjects([]byte(`---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: test
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: first
+  namespace: test
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: second
+  namespace: test
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	kustomization := kustomizev1.Kustomization{}
+	checkpoint := newApplyCheckpoint(kustomization, "sha1", len(objects), time.Now().Add(-time.Second))
+
+	// at least one object is applied regardless of the budget
+	if err := checkpoint.exceeded(); err != nil {
+		t.Fatalf("expected no error before applying an object, got %v", err)
+	}
+	checkpoint.add(objects[0])
+
+	err = checkpoint.exceeded()
+	var budgetErr *BudgetExceededError
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("expected budget exceeded error, got %v", err)
+	}
+	if n := len(budgetErr.Checkpoint.Inventory.Entries); n != 1 || budgetErr.Total != 3 {
+		t.Errorf("expected 1/3 objects in checkpoint, got %d/%d", n, budgetErr.Total)
+	}
+
+	// resume from the checkpoint of the same build output
+	kustomization.Status.Checkpoint = budgetErr.Checkpoint
+	resumed := newApplyCheckpoint(kustomization, "sha1", len(objects), time.Time{})
+	if !resumed.isApplied(objects[0]) || resumed.isApplied(objects[1]) {
+		t.Errorf("expected only the namespace to be applied")
+	}
+	resumed.add(objects[1])
+	resumed.add(objects[2])
+	if err := resumed.exceeded(); err != nil {
+		t.Errorf("expected no error without a deadline, got %v", err)
+	}
+
+	// start over when the build output changed
+	restarted := newApplyCheckpoint(kustomization, "sha2", len(objects), time.Time{})
+	if restarted.isApplied(objects[0]) {
+		t.Errorf("expected checkpoint to be discarded for a different checksum")
+	}
+}
@@ -0,0 +1,402 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// clusterNameVar is the substitution variable set to the name
+// of the KubeConfig secret of each cluster targeted by spec.clusters.
+const clusterNameVar = "cluster_name"
+
+// maxConcurrentClusters is the maximum number of clusters
+// of spec.clusters reconciled concurrently.
+const maxConcurrentClusters = 10
+
+// clustersUnsupportedFields returns the spec fields that are set
+// and are not supported with spec.clusters.
+func clustersUnsupportedFields(spec kustomizev1.KustomizationSpec) []string {
+	var fields []string
+	if spec.Hooks != nil {
+		fields = append(fields, "hooks")
+	}
+	if spec.Rollback {
+		fields = append(fields, "rollback")
+	}
+	if spec.MaxDelta != nil {
+		fields = append(fields, "maxDelta")
+	}
+	return fields
+}
+
+// clusterTarget is a remote cluster selected by spec.clusters.
+type clusterTarget struct {
+	name       string
+	substitute map[string]string
+}
+
+// listClusterTargets returns the clusters selected by spec.clusters, sorted by the
+// name of their KubeConfig secret. When a secret is selected more than once, the
+// variables of the later targets take precedence.
+func listClusterTargets(ctx context.Context, kubeClient client.Client, kustomization kustomizev1.Kustomization) ([]clusterTarget, error) {
+	substitutes := make(map[string]map[string]string)
+	add := func(name string, vars map[string]string) {
+		if substitutes[name] == nil {
+			substitutes[name] = map[string]string{}
+		}
+		for k, v := range vars {
+			substitutes[name][k] = v
+		}
+	}
+
+	for _, target := range kustomization.Spec.Clusters {
+		if target.SecretRef != nil {
+			add(target.SecretRef.Name, target.Substitute)
+		}
+		if target.SecretSelector != nil {
+			selector, err := metav1.LabelSelectorAsSelector(target.SecretSelector)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cluster secret selector: %w", err)
+			}
+			var secrets corev1.SecretList
+			if err := kubeClient.List(ctx, &secrets, client.InNamespace(kustomization.GetNamespace()),
+				client.MatchingLabelsSelector{Selector: selector}); err != nil {
+				return nil, fmt.Errorf("unable to list the cluster secrets: %w", err)
+			}
+			for _, secret := range secrets.Items {
+				add(secret.GetName(), target.Substitute)
+			}
+		}
+	}
+
+	targets := make([]clusterTarget, 0, len(substitutes))
+	for name, vars := range substitutes {
+		targets = append(targets, clusterTarget{name: name, substitute: vars})
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].name < targets[j].name
+	})
+	return targets, nil
+}
+
+// clusterKustomization returns the Kustomization reconciled on the given cluster,
+// targeting its KubeConfig secret with its substitution variables, and holding the
+// inventory of the objects previously applied to the cluster.
+func clusterKustomization(kustomization kustomizev1.Kustomization, target clusterTarget, inventory *kustomizev1.ResourceInventory) kustomizev1.Kustomization {
+	k := *kustomization.DeepCopy()
+	k.Spec.KubeConfig = &kustomizev1.KubeConfig{
+		SecretRef: meta.LocalObjectReference{Name: target.name},
+	}
+
+	if k.Spec.PostBuild == nil {
+		k.Spec.PostBuild = &kustomizev1.PostBuild{}
+	}
+	vars := make(map[string]string, len(k.Spec.PostBuild.Substitute)+len(target.substitute)+1)
+	for key, v := range k.Spec.PostBuild.Substitute {
+		vars[key] = v
+	}
+	vars[clusterNameVar] = target.name
+	for key, v := range target.substitute {
+		vars[key] = v
+	}
+	k.Spec.PostBuild.Substitute = vars
+
+	k.Status.Inventory = inventory
+	k.Status.Snapshot = nil
+	k.Status.Checkpoint = nil
+	return k
+}
+
+// reconcileClusters applies the artifact extracted at tmpDir to each cluster
+// selected by spec.clusters, and prunes the objects of the clusters that are no
+// longer selected. The clusters are reconciled concurrently, and the Kustomization
+// is ready when all the clusters are ready. The objects applied before switching
+// to spec.clusters, recorded in status.inventory, are pruned first.
+func (r *KustomizationReconciler) reconcileClusters(
+	ctx context.Context,
+	kustomization kustomizev1.Kustomization,
+	revision string,
+	tmpDir string) (kustomizev1.Kustomization, error) {
+	log := logr.FromContext(ctx)
+
+	// the state checksum is recorded for the local cluster only
+	kustomization.Status.StateChecksum = ""
+
+	if r.readOnly || kustomization.Spec.Mode == kustomizev1.DiffOnlyMode {
+		reason, mode := kustomizev1.DiffOnlyReason, "diff-only"
+		if r.readOnly {
+			reason, mode = kustomizev1.ReadOnlyReason, "read-only"
+		}
+		kustomizev1.SetKustomizationReadiness(
+			&kustomization,
+			metav1.ConditionUnknown,
+			reason,
+			fmt.Sprintf("%s mode, revision %s not applied to the clusters", mode, revision),
+			revision,
+		)
+		return kustomization, nil
+	}
+
+	if fields := clustersUnsupportedFields(kustomization.Spec); len(fields) > 0 {
+		err := fmt.Errorf("spec.%s not supported with spec.clusters", strings.Join(fields, ", spec."))
+		return kustomizev1.KustomizationNotReady(
+			kustomization,
+			revision,
+			kustomizev1.ValidationFailedReason,
+			err.Error(),
+		), err
+	}
+
+	targets, err := listClusterTargets(ctx, r.Client, kustomization)
+	if err != nil {
+		return kustomizev1.KustomizationNotReady(
+			kustomization,
+			revision,
+			meta.ReconciliationFailedReason,
+			err.Error(),
+		), err
+	}
+
+	// prune the objects applied before switching to spec.clusters,
+	// the inventory is kept to retry if the objects can't be pruned
+	var pruneErr error
+	if kustomization.Status.Inventory != nil {
+		if pruneErr = r.prunePreviousInventory(ctx, kustomization); pruneErr != nil {
+			log.Error(pruneErr, "Pruning of the previous inventory failed")
+		} else {
+			kustomization.Status.Inventory = nil
+			kustomization.Status.Snapshot = nil
+		}
+	}
+
+	previous := make(map[string]kustomizev1.ClusterStatus, len(kustomization.Status.Clusters))
+	for _, status := range kustomization.Status.Clusters {
+		previous[status.Name] = status
+	}
+
+	var failed []string
+	statuses := make([]kustomizev1.ClusterStatus, len(targets))
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentClusters)
+	for i, target := range targets {
+		statuses[i] = previous[target.name]
+		statuses[i].Name = target.name
+		delete(previous, target.name)
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, target clusterTarget) {
+			defer func() { <-sem; wg.Done() }()
+			k := clusterKustomization(kustomization, target, statuses[i].Inventory)
+			clusterCtx := logr.NewContext(ctx, log.WithValues("cluster", target.name))
+			inventory, err := r.reconcileCluster(clusterCtx, k, revision, tmpDir)
+			if inventory != nil {
+				statuses[i].Inventory = inventory
+			}
+			errs[i] = err
+		}(i, target)
+	}
+	wg.Wait()
+
+	for i, target := range targets {
+		status := &statuses[i]
+		if err := errs[i]; err != nil {
+			log.Error(err, "Reconciliation of cluster failed", "cluster", target.name)
+			status.Ready = false
+			status.Message = err.Error()
+			failed = append(failed, fmt.Sprintf("%s: %v", target.name, err))
+		} else {
+			status.Ready = true
+			status.Message = "Applied revision: " + revision
+			status.LastAppliedRevision = revision
+		}
+	}
+
+	// prune the objects of the clusters that are no longer selected,
+	// the clusters that can't be pruned are kept to retry
+	var removed []string
+	for name := range previous {
+		removed = append(removed, name)
+	}
+	sort.Strings(removed)
+	for _, name := range removed {
+		status := previous[name]
+		if err := r.pruneCluster(ctx, kustomization, status); err != nil {
+			status.Ready = false
+			status.Message = err.Error()
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+			statuses = append(statuses, status)
+			continue
+		}
+		log.Info("Cluster removed from the targets", "cluster", name)
+	}
+	kustomization.Status.Clusters = statuses
+
+	var msgs []string
+	if pruneErr != nil {
+		msgs = append(msgs, fmt.Sprintf("failed to prune the objects applied before spec.clusters: %v", pruneErr))
+	}
+	if len(failed) > 0 {
+		msgs = append(msgs, fmt.Sprintf("reconciliation failed for %d/%d clusters: %s",
+			len(failed), len(statuses), strings.Join(failed, "; ")))
+	}
+	if len(msgs) > 0 {
+		err := errors.New(strings.Join(msgs, "; "))
+		return kustomizev1.KustomizationNotReady(
+			kustomization,
+			revision,
+			meta.ReconciliationFailedReason,
+			err.Error(),
+		), err
+	}
+
+	return kustomizev1.KustomizationReady(
+		kustomization,
+		nil,
+		revision,
+		meta.ReconciliationSucceededReason,
+		fmt.Sprintf("Applied revision: %s to %d clusters", revision, len(statuses)),
+	), nil
+}
+
+// reconcileCluster builds the artifact with the cluster variables, then applies,
+// prunes and health checks the objects on the cluster. It returns the inventory
+// of the objects applied to the cluster.
+func (r *KustomizationReconciler) reconcileCluster(
+	ctx context.Context,
+	kustomization kustomizev1.Kustomization,
+	revision string,
+	tmpDir string) (*kustomizev1.ResourceInventory, error) {
+	// build in a copy of the artifact, as the build output differs per cluster
+	clusterDir, err := ioutil.TempDir("", kustomization.GetName())
+	if err != nil {
+		return nil, fmt.Errorf("tmp dir error: %w", err)
+	}
+	defer os.RemoveAll(clusterDir)
+	if err := copyDir(tmpDir, clusterDir); err != nil {
+		return nil, fmt.Errorf("unable to copy the artifact: %w", err)
+	}
+	dirPath, err := artifactPath(clusterDir, kustomization.Spec.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	impersonation := NewKustomizeImpersonation(kustomization, r.Client, r.StatusPoller, r.discoveryOptions, r.kubeConfigOptions)
+	kubeClient, statusPoller, err := impersonation.GetClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build kube client: %w", err)
+	}
+
+	checksum, err := r.generate(ctx, kubeClient, kustomization, dirPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.build(ctx, kustomization, checksum, dirPath); err != nil {
+		return nil, err
+	}
+
+	if err := r.enforceNamespacePolicy(ctx, kubeClient.RESTMapper(), kustomization, dirPath); err != nil {
+		return nil, err
+	}
+	if err := r.enforceQuota(ctx, kustomization, dirPath); err != nil {
+		return nil, err
+	}
+	if _, err := ensureTargetNamespace(ctx, kubeClient, kustomization); err != nil {
+		return nil, fmt.Errorf("failed to create namespace '%s': %w", kustomization.Spec.TargetNamespace, err)
+	}
+	if err := r.preflight(ctx, kubeClient, kustomization, revision, dirPath); err != nil {
+		return nil, err
+	}
+	if err := r.validate(ctx, kubeClient, kustomization, dirPath); err != nil {
+		return nil, err
+	}
+
+	results, err := r.applyWithRetry(ctx, kubeClient, kustomization, revision, dirPath, checksum, time.Time{}, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	inventory, err := readInventory(kubeClient, kustomization, dirPath)
+	if err != nil {
+		return nil, err
+	}
+	setInventoryChecksums(inventory, results)
+
+	if _, err := r.prune(ctx, kubeClient, kustomization, inventory, checksum); err != nil {
+		return nil, err
+	}
+
+	if kustomization.Spec.Wait {
+		checks, err := inventoryHealthChecks(inventory)
+		if err != nil {
+			return nil, err
+		}
+		kustomization.Spec.HealthChecks = mergeHealthChecks(kustomization.Spec.HealthChecks, checks)
+	}
+	if err := r.checkHealth(ctx, kubeClient, statusPoller, kustomization, revision, results.changeSet() != ""); err != nil {
+		return inventory, err
+	}
+	return inventory, nil
+}
+
+// prunePreviousInventory deletes the objects of status.inventory, applied
+// before switching to spec.clusters, if spec.prune is enabled. The objects are
+// pruned on the cluster of spec.kubeConfig if set, otherwise on the local cluster.
+func (r *KustomizationReconciler) prunePreviousInventory(ctx context.Context, kustomization kustomizev1.Kustomization) error {
+	k := *kustomization.DeepCopy()
+	k.Spec.Clusters = nil
+	impersonation := NewKustomizeImpersonation(k, r.Client, r.StatusPoller, r.discoveryOptions, r.kubeConfigOptions)
+	kubeClient, _, err := impersonation.GetClient(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to build kube client: %w", err)
+	}
+	_, err = r.prune(ctx, kubeClient, k, nil, "")
+	return err
+}
+
+// pruneCluster deletes the objects applied to the cluster, if spec.prune is
+// enabled or if the Kustomization is being deleted with the Delete policy.
+func (r *KustomizationReconciler) pruneCluster(ctx context.Context, kustomization kustomizev1.Kustomization, status kustomizev1.ClusterStatus) error {
+	if status.Inventory == nil {
+		return nil
+	}
+	k := clusterKustomization(kustomization, clusterTarget{name: status.Name}, status.Inventory)
+	impersonation := NewKustomizeImpersonation(k, r.Client, r.StatusPoller, r.discoveryOptions, r.kubeConfigOptions)
+	kubeClient, _, err := impersonation.GetClient(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to build kube client: %w", err)
+	}
+	_, err = r.prune(ctx, kubeClient, k, nil, "")
+	return err
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestListClusterTargets(t *testing.T) {
+	secret := func(name, env string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "fleet",
+			Labels:    map[string]string{"env": env},
+		}}
+	}
+	kubeClient := fake.NewClientBuilder().WithObjects(
+		secret("prod-eu", "prod"),
+		secret("prod-us", "prod"),
+		secret("stage", "stage"),
+	).Build()
+
+	k := kustomizev1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{Name: "apps", Namespace: "fleet"},
+		Spec: kustomizev1.KustomizationSpec{
+			Clusters: []kustomizev1.ClusterTarget{
+				{
+					SecretSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+					Substitute:     map[string]string{"replicas": "3", "tier": "prod"},
+				},
+				{
+					SecretRef:  &meta.LocalObjectReference{Name: "prod-us"},
+					Substitute: map[string]string{"region": "us"},
+				},
+				{
+					SecretRef: &meta.LocalObjectReference{Name: "dev"},
+				},
+			},
+		},
+	}
+
+	targets, err := listClusterTargets(context.TODO(), kubeClient, k)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, target := range targets {
+		names = append(names, target.name)
+	}
+	if expected := "dev,prod-eu,prod-us"; strings.Join(names, ",") != expected {
+		t.Fatalf("expected clusters %s, got %v", expected, names)
+	}
+	if vars := targets[2].substitute; vars["replicas"] != "3" || vars["region"] != "us" {
+		t.Errorf("expected the variables of both targets, got %v", vars)
+	}
+	if vars := targets[1].substitute; vars["region"] != "" {
+		t.Errorf("expected the variables of the selector only, got %v", vars)
+	}
+}
+
+func TestClusterKustomization(t *testing.T) {
+	k := kustomizev1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{Name: "apps", Namespace: "fleet"},
+		Spec: kustomizev1.KustomizationSpec{
+			PostBuild: &kustomizev1.PostBuild{
+				Substitute: map[string]string{"replicas": "1", "domain": "example.com"},
+			},
+		},
+		Status: kustomizev1.KustomizationStatus{
+			Inventory:  &kustomizev1.ResourceInventory{Entries: []kustomizev1.ResourceRef{{ID: "local"}}},
+			Snapshot:   &kustomizev1.Snapshot{Checksum: "local"},
+			Checkpoint: &kustomizev1.ApplyCheckpoint{},
+		},
+	}
+	inventory := &kustomizev1.ResourceInventory{Entries: []kustomizev1.ResourceRef{{ID: "remote"}}}
+
+	ck := clusterKustomization(k, clusterTarget{name: "prod-eu", substitute: map[string]string{"replicas": "3"}}, inventory)
+
+	if ck.Spec.KubeConfig == nil || ck.Spec.KubeConfig.SecretRef.Name != "prod-eu" {
+		t.Errorf("expected the cluster KubeConfig, got %v", ck.Spec.KubeConfig)
+	}
+	vars := ck.Spec.PostBuild.Substitute
+	if vars["replicas"] != "3" || vars["domain"] != "example.com" || vars[clusterNameVar] != "prod-eu" {
+		t.Errorf("unexpected variables %v", vars)
+	}
+	if k.Spec.PostBuild.Substitute["replicas"] != "1" {
+		t.Error("expected the Kustomization variables to be unchanged")
+	}
+	if ck.Status.Inventory != inventory || ck.Status.Snapshot != nil || ck.Status.Checkpoint != nil {
+		t.Errorf("expected the cluster inventory only, got %v", ck.Status)
+	}
+}
+
+func TestReconcileClustersPrunePreviousInventory(t *testing.T) {
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "apps",
+		Name:      "backend",
+		Labels:    selectorLabels("apps", "flux-system"),
+	}}
+	k := newTestKustomization()
+	k.Spec.Prune = true
+	k.Spec.Clusters = []kustomizev1.ClusterTarget{{SecretRef: &meta.LocalObjectReference{Name: "prod"}}}
+	k.Status.Inventory = &kustomizev1.ResourceInventory{Entries: []kustomizev1.ResourceRef{
+		{ID: "apps_backend__ConfigMap", Version: "v1"},
+	}}
+	r := newTestReconciler(t, configMap, k)
+
+	// the KubeConfig secret of the cluster doesn't exist
+	ctx := logr.NewContext(context.TODO(), logr.Discard())
+	result, err := r.reconcileClusters(ctx, *k, "main/1", t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "reconciliation failed for 1/1 clusters") {
+		t.Fatalf("expected the cluster to fail, got %v", err)
+	}
+
+	if err := r.Get(context.TODO(), client.ObjectKeyFromObject(configMap), &corev1.ConfigMap{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the object of the previous inventory to be pruned, got error %v", err)
+	}
+	if result.Status.Inventory != nil {
+		t.Errorf("expected the previous inventory to be removed, got %v", result.Status.Inventory)
+	}
+	if len(result.Status.Clusters) != 1 || result.Status.Clusters[0].Ready {
+		t.Errorf("expected the status of the failed cluster, got %+v", result.Status.Clusters)
+	}
+}
+
+func TestReconcileClustersUnsupportedFields(t *testing.T) {
+	k := newTestKustomization()
+	k.Spec.Rollback = true
+	k.Spec.Hooks = &kustomizev1.Hooks{}
+	k.Spec.Clusters = []kustomizev1.ClusterTarget{{SecretRef: &meta.LocalObjectReference{Name: "prod"}}}
+	r := newTestReconciler(t, k)
+
+	ctx := logr.NewContext(context.TODO(), logr.Discard())
+	result, err := r.reconcileClusters(ctx, *k, "main/1", t.TempDir())
+	if err == nil || err.Error() != "spec.hooks, spec.rollback not supported with spec.clusters" {
+		t.Fatalf("expected the unsupported fields to be rejected, got %v", err)
+	}
+	if len(result.Status.Clusters) != 0 {
+		t.Errorf("expected no cluster to be reconciled, got %+v", result.Status.Clusters)
+	}
+}
@@ -0,0 +1,267 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// States of the commit statuses.
+const (
+	pendingCommitState = "pending"
+	successCommitState = "success"
+	failureCommitState = "failure"
+)
+
+// commitStatusTimeout bounds the update of a commit status.
+const commitStatusTimeout = 15 * time.Second
+
+// commitStatusDescriptionLength is the maximum length
+// of the description accepted by the Git providers.
+const commitStatusDescriptionLength = 140
+
+// commitStatus is the status of a commit set by a reconciliation.
+type commitStatus struct {
+	// Key identifies the Kustomization in the statuses of the commit.
+	Key         string
+	State       string
+	Description string
+}
+
+// gitRepository is a repository hosted by a Git provider.
+type gitRepository struct {
+	Scheme string
+	Host   string
+	// Path of the repository e.g. 'org/repo'.
+	Path string
+}
+
+// parseRepository parses the HTTPS, SSH or SCP-like address of a Git repository.
+// The HTTP addresses are rejected, as the credentials would be sent in cleartext.
+func parseRepository(address string) (*gitRepository, error) {
+	if !strings.Contains(address, "://") {
+		// SCP-like address e.g. 'git@github.com:org/repo.git'
+		if at := strings.Index(address, "@"); at >= 0 {
+			address = "ssh://" + strings.Replace(address[at+1:], ":", "/", 1)
+		}
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository address '%s': %w", address, err)
+	}
+	path := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	if u.Host == "" || !strings.Contains(path, "/") {
+		return nil, fmt.Errorf("invalid repository address '%s': expected the 'host/owner/repository' format", address)
+	}
+	if u.Scheme == "http" {
+		return nil, fmt.Errorf("invalid repository address '%s': the commit statuses can only be updated over HTTPS", address)
+	}
+	// the port of an SSH address is not the port of the provider API
+	scheme, host := u.Scheme, u.Host
+	if scheme != "https" {
+		scheme, host = "https", u.Hostname()
+	}
+	return &gitRepository{Scheme: scheme, Host: host, Path: path}, nil
+}
+
+// commitSHA returns the commit SHA of a GitRepository revision e.g. 'main/<sha>'.
+func commitSHA(revision string) string {
+	return revision[strings.LastIndex(revision, "/")+1:]
+}
+
+// newCommitStatusRequest returns the request that sets the status of the
+// commit on the Git provider.
+func newCommitStatusRequest(ctx context.Context, spec kustomizev1.CommitStatus, repo *gitRepository, sha string,
+	status commitStatus, credentials map[string][]byte) (*http.Request, error) {
+	var endpoint string
+	var payload map[string]string
+	header := http.Header{}
+	switch spec.Provider {
+	case kustomizev1.GitHubProvider:
+		api := fmt.Sprintf("%s://%s/api/v3", repo.Scheme, repo.Host)
+		if repo.Host == "github.com" {
+			api = "https://api.github.com"
+		}
+		endpoint = fmt.Sprintf("%s/repos/%s/statuses/%s", api, repo.Path, sha)
+		payload = map[string]string{
+			"state":       status.State,
+			"context":     status.Key,
+			"description": status.Description,
+			"target_url":  spec.URL,
+		}
+		header.Set("Authorization", "token "+string(credentials["token"]))
+	case kustomizev1.GitLabProvider:
+		state := status.State
+		if state == failureCommitState {
+			state = "failed"
+		}
+		endpoint = fmt.Sprintf("%s://%s/api/v4/projects/%s/statuses/%s",
+			repo.Scheme, repo.Host, url.PathEscape(repo.Path), sha)
+		payload = map[string]string{
+			"state":       state,
+			"name":        status.Key,
+			"description": status.Description,
+			"target_url":  spec.URL,
+		}
+		header.Set("PRIVATE-TOKEN", string(credentials["token"]))
+	case kustomizev1.BitbucketProvider:
+		states := map[string]string{
+			pendingCommitState: "INPROGRESS",
+			successCommitState: "SUCCESSFUL",
+			failureCommitState: "FAILED",
+		}
+		link := spec.URL
+		if link == "" {
+			link = fmt.Sprintf("%s://%s/%s", repo.Scheme, repo.Host, repo.Path)
+		}
+		endpoint = fmt.Sprintf("https://api.bitbucket.org/2.0/repositories/%s/commit/%s/statuses/build", repo.Path, sha)
+		payload = map[string]string{
+			"state":       states[status.State],
+			"key":         status.Key,
+			"description": status.Description,
+			"url":         link,
+		}
+		auth := fmt.Sprintf("%s:%s", credentials["username"], credentials["password"])
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)))
+	default:
+		return nil, fmt.Errorf("unsupported commit status provider '%s'", spec.Provider)
+	}
+
+	for k, v := range payload {
+		if v == "" {
+			delete(payload, k)
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// reconciledCommitStatus returns the commit status of the reconciled revision:
+// success when the Kustomization is ready, failure when the reconciliation
+// failed, and pending otherwise e.g. during a canary analysis.
+func reconciledCommitStatus(kustomization kustomizev1.Kustomization, reconcileErr error) commitStatus {
+	status := commitStatus{
+		Key:   fmt.Sprintf("kustomization/%s/%s", kustomization.GetNamespace(), kustomization.GetName()),
+		State: pendingCommitState,
+	}
+	if ready := apimeta.FindStatusCondition(kustomization.Status.Conditions, meta.ReadyCondition); ready != nil {
+		status.Description = ready.Message
+		if reconcileErr == nil && apimeta.IsStatusConditionTrue(kustomization.Status.Conditions, meta.ReadyCondition) {
+			status.State = successCommitState
+		}
+	}
+	if reconcileErr != nil {
+		status.State = failureCommitState
+		status.Description = reconcileErr.Error()
+	}
+	status.Description = trimMessage(status.Description, commitStatusDescriptionLength-3)
+	return status
+}
+
+// updateCommitStatus sets the status of the commit of the GitRepository revision
+// on the Git provider, unless it was already set by a previous reconciliation.
+// The failures are logged, as they don't affect the reconciliation.
+func (r *KustomizationReconciler) updateCommitStatus(ctx context.Context, kustomization *kustomizev1.Kustomization,
+	source sourcev1.Source, status commitStatus) {
+	spec := kustomization.Spec.CommitStatus
+	if spec == nil {
+		return
+	}
+	// the revisions of the other sources are not commits
+	repository, ok := source.(*sourcev1.GitRepository)
+	if !ok {
+		return
+	}
+	revision := source.GetArtifact().Revision
+	if last := kustomization.Status.LastCommitStatus; last != nil && last.Revision == revision && last.State == status.State {
+		return
+	}
+
+	if err := r.postCommitStatus(ctx, *kustomization, repository, status); err != nil {
+		logr.FromContext(ctx).Error(err, "unable to update the commit status", "state", status.State)
+		return
+	}
+	kustomization.Status.LastCommitStatus = &kustomizev1.CommitStatusReport{
+		Revision: revision,
+		State:    status.State,
+	}
+}
+
+func (r *KustomizationReconciler) postCommitStatus(ctx context.Context, kustomization kustomizev1.Kustomization,
+	source *sourcev1.GitRepository, status commitStatus) error {
+	spec := kustomization.Spec.CommitStatus
+	address := spec.Address
+	if address == "" {
+		address = source.Spec.URL
+	}
+	repo, err := parseRepository(address)
+	if err != nil {
+		return err
+	}
+
+	var secret corev1.Secret
+	secretName := types.NamespacedName{Namespace: kustomization.GetNamespace(), Name: spec.SecretRef.Name}
+	if err := r.Client.Get(ctx, secretName, &secret); err != nil {
+		return fmt.Errorf("unable to read the credentials from '%s': %w", secretName, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, commitStatusTimeout)
+	defer cancel()
+	req, err := newCommitStatusRequest(ctx, *spec, repo, commitSHA(source.GetArtifact().Revision), status, secret.Data)
+	if err != nil {
+		return err
+	}
+	// the update is retried at the next reconciliation, not within the reconcile worker
+	resp, err := r.commitStatusClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s commit status update failed with status %s: %s",
+			spec.Provider, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestParseRepository(t *testing.T) {
+	tests := []struct {
+		address string
+		want    *gitRepository
+	}{
+		{"https://github.com/org/repo.git", &gitRepository{Scheme: "https", Host: "github.com", Path: "org/repo"}},
+		{"ssh://git@github.com/org/repo", &gitRepository{Scheme: "https", Host: "github.com", Path: "org/repo"}},
+		{"git@gitlab.com:group/sub/repo.git", &gitRepository{Scheme: "https", Host: "gitlab.com", Path: "group/sub/repo"}},
+		{"ssh://git@gitea.example.com:2222/org/repo", &gitRepository{Scheme: "https", Host: "gitea.example.com", Path: "org/repo"}},
+		{"https://127.0.0.1:8443/org/repo", &gitRepository{Scheme: "https", Host: "127.0.0.1:8443", Path: "org/repo"}},
+		{"http://127.0.0.1:8080/org/repo", nil},
+		{"https://github.com/repo", nil},
+	}
+	for _, tt := range tests {
+		got, err := parseRepository(tt.address)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("expected error for '%s'", tt.address)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", tt.address, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expected %v for '%s', got %v", tt.want, tt.address, got)
+		}
+	}
+}
+
+func TestNewCommitStatusRequest(t *testing.T) {
+	status := commitStatus{Key: "kustomization/dev/apps", State: failureCommitState, Description: "health check failed"}
+	credentials := map[string][]byte{"token": []byte("secret"), "username": []byte("bot"), "password": []byte("secret")}
+
+	tests := []struct {
+		provider   string
+		address    string
+		wantURL    string
+		wantHeader string
+		wantState  string
+	}{
+		{
+			provider:   kustomizev1.GitHubProvider,
+			address:    "https://github.com/org/repo",
+			wantURL:    "https://api.github.com/repos/org/repo/statuses/1a2b3c",
+			wantHeader: "Authorization",
+			wantState:  "failure",
+		},
+		{
+			provider:   kustomizev1.GitLabProvider,
+			address:    "https://gitlab.com/group/sub/repo",
+			wantURL:    "https://gitlab.com/api/v4/projects/group%2Fsub%2Frepo/statuses/1a2b3c",
+			wantHeader: "PRIVATE-TOKEN",
+			wantState:  "failed",
+		},
+		{
+			provider:   kustomizev1.BitbucketProvider,
+			address:    "https://bitbucket.org/org/repo",
+			wantURL:    "https://api.bitbucket.org/2.0/repositories/org/repo/commit/1a2b3c/statuses/build",
+			wantHeader: "Authorization",
+			wantState:  "FAILED",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			repo, err := parseRepository(tt.address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			spec := kustomizev1.CommitStatus{Provider: tt.provider}
+			req, err := newCommitStatusRequest(context.TODO(), spec, repo, "1a2b3c", status, credentials)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if req.URL.String() != tt.wantURL {
+				t.Errorf("expected URL %s, got %s", tt.wantURL, req.URL.String())
+			}
+			if req.Header.Get(tt.wantHeader) == "" {
+				t.Errorf("expected the credentials in the %s header", tt.wantHeader)
+			}
+			var payload map[string]string
+			if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+				t.Fatal(err)
+			}
+			if payload["state"] != tt.wantState {
+				t.Errorf("expected state %s, got %s", tt.wantState, payload["state"])
+			}
+		})
+	}
+}
+
+func TestUpdateCommitStatus(t *testing.T) {
+	var states []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/repos/org/repo/statuses/1a2b3c" || r.Header.Get("Authorization") != "token secret" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var payload map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		states = append(states, payload["state"])
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "github-token", Namespace: "dev"},
+		Data:       map[string][]byte{"token": []byte("secret")},
+	}
+	r := &KustomizationReconciler{
+		Client:             fake.NewClientBuilder().WithObjects(secret).Build(),
+		commitStatusClient: server.Client(),
+	}
+	source := &sourcev1.GitRepository{
+		Spec: sourcev1.GitRepositorySpec{URL: server.URL + "/org/repo"},
+		Status: sourcev1.GitRepositoryStatus{
+			Artifact: &sourcev1.Artifact{Revision: "main/1a2b3c"},
+		},
+	}
+	k := kustomizev1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{Name: "apps", Namespace: "dev"},
+		Spec: kustomizev1.KustomizationSpec{
+			CommitStatus: &kustomizev1.CommitStatus{
+				Provider:  kustomizev1.GitHubProvider,
+				SecretRef: meta.LocalObjectReference{Name: "github-token"},
+			},
+		},
+	}
+
+	ctx := logr.NewContext(context.TODO(), logr.Discard())
+	meta.SetResourceCondition(&k, meta.ReadyCondition, metav1.ConditionTrue, meta.ReconciliationSucceededReason, "Applied revision: main/1a2b3c")
+	r.updateCommitStatus(ctx, &k, source, reconciledCommitStatus(k, nil))
+	// the status of the revision is updated once
+	r.updateCommitStatus(ctx, &k, source, reconciledCommitStatus(k, nil))
+	// the revisions of the other sources are not commits
+	bucket := &sourcev1.Bucket{
+		Status: sourcev1.BucketStatus{
+			Artifact: &sourcev1.Artifact{Revision: "8f3b2a"},
+		},
+	}
+	r.updateCommitStatus(ctx, &k, bucket, commitStatus{State: failureCommitState})
+
+	if want := []string{successCommitState}; !reflect.DeepEqual(states, want) {
+		t.Errorf("expected states %v, got %v", want, states)
+	}
+	if last := k.Status.LastCommitStatus; last == nil || last.Revision != "main/1a2b3c" || last.State != successCommitState {
+		t.Errorf("unexpected last commit status %v", last)
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/cli-utils/pkg/kstatus/status"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// TestKustomizationKstatus checks that generic tools can interpret
+// the conditions of a Kustomization with kstatus.
+func TestKustomizationKstatus(t *testing.T) {
+	newKustomization := func() kustomizev1.Kustomization {
+		return kustomizev1.Kustomization{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: kustomizev1.GroupVersion.String(),
+				Kind:       kustomizev1.KustomizationKind,
+			},
+			ObjectMeta: metav1.ObjectMeta{Name: "apps", Namespace: "dev", Generation: 2},
+		}
+	}
+
+	tests := []struct {
+		name string
+		k    func() kustomizev1.Kustomization
+		want status.Status
+	}{
+		{
+			name: "progressing",
+			k: func() kustomizev1.Kustomization {
+				k := newKustomization()
+				k.Status.ObservedGeneration = 2
+				return kustomizev1.KustomizationProgressing(k)
+			},
+			want: status.InProgressStatus,
+		},
+		{
+			name: "ready",
+			k: func() kustomizev1.Kustomization {
+				k := kustomizev1.KustomizationProgressing(newKustomization())
+				return kustomizev1.KustomizationReady(k, nil, "main/1", meta.ReconciliationSucceededReason, "Applied revision: main/1")
+			},
+			want: status.CurrentStatus,
+		},
+		{
+			name: "failed with retry",
+			k: func() kustomizev1.Kustomization {
+				k := kustomizev1.KustomizationProgressing(newKustomization())
+				return kustomizev1.KustomizationNotReady(k, "main/1", kustomizev1.HealthCheckFailedReason, "health check failed")
+			},
+			want: status.InProgressStatus,
+		},
+		{
+			name: "stalled",
+			k: func() kustomizev1.Kustomization {
+				k := kustomizev1.KustomizationProgressing(newKustomization())
+				return kustomizev1.KustomizationStalled(k, "main/1", kustomizev1.ArtifactNotFoundReason, "artifact not found")
+			},
+			want: status.FailedStatus,
+		},
+		{
+			name: "stalled after failures",
+			k: func() kustomizev1.Kustomization {
+				k := kustomizev1.KustomizationProgressing(newKustomization())
+				k = kustomizev1.KustomizationNotReady(k, "main/1", kustomizev1.HealthCheckFailedReason, "health check failed")
+				kustomizev1.SetKustomizationStalled(&k, kustomizev1.HealthCheckFailedReason, "reconciliation failed 5 times in a row")
+				return k
+			},
+			want: status.FailedStatus,
+		},
+		{
+			name: "spec changed",
+			k: func() kustomizev1.Kustomization {
+				k := kustomizev1.KustomizationProgressing(newKustomization())
+				k = kustomizev1.KustomizationReady(k, nil, "main/1", meta.ReconciliationSucceededReason, "Applied revision: main/1")
+				k.Generation = 3
+				return k
+			},
+			want: status.InProgressStatus,
+		},
+		{
+			name: "garbage collecting",
+			k: func() kustomizev1.Kustomization {
+				k := newKustomization()
+				k = kustomizev1.KustomizationReady(k, nil, "main/1", meta.ReconciliationSucceededReason, "Applied revision: main/1")
+				return kustomizev1.KustomizationGarbageCollecting(k, "deleting the objects")
+			},
+			want: status.InProgressStatus,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := tt.k()
+			obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := status.Compute(&unstructured.Unstructured{Object: obj})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Status != tt.want {
+				t.Errorf("expected status %s, got %s: %s", tt.want, result.Status, result.Message)
+			}
+			// the conditions are set at the second generation
+			for _, c := range k.Status.Conditions {
+				if c.ObservedGeneration != 2 {
+					t.Errorf("expected the %s condition to have the observed generation 2, got %d",
+						c.Type, c.ObservedGeneration)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+	"sigs.k8s.io/structured-merge-diff/v4/value"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// ConflictError is returned when the apply of an object conflicts
+// with the fields managed by other field managers.
+type ConflictError struct {
+	// ID is the object ID e.g. 'deployment/apps/backend'.
+	ID string
+	// Conflicts holds the conflicting fields and their managers.
+	Conflicts []metav1.StatusCause
+}
+
+func (e *ConflictError) Error() string {
+	var conflicts []string
+	for _, c := range e.Conflicts {
+		conflicts = append(conflicts, fmt.Sprintf("%s %s", c.Field, c.Message))
+	}
+	return fmt.Sprintf("apply failed: %s has fields managed by other field managers: %s",
+		e.ID, strings.Join(conflicts, ", "))
+}
+
+// serverSideApply applies the object and handles the field ownership conflicts
+// according to the conflict policy. With the 'Skip' policy, the conflicting
+// fields are removed from the object and the apply is retried, leaving these
+// fields to their managers. With the 'Fail' policy, a ConflictError listing
+// the conflicting fields and their managers is returned.
+func serverSideApply(ctx context.Context, kubeClient client.Client, obj, existing *unstructured.Unstructured, policy string, dryRun bool) error {
+	opts := []client.PatchOption{client.FieldOwner(fieldManager)}
+	if policy == "" || policy == kustomizev1.ForceConflictPolicy {
+		opts = append(opts, client.ForceOwnership)
+	}
+	if dryRun {
+		opts = append(opts, client.DryRunAll)
+	}
+
+	desired := obj.DeepCopy()
+	err := kubeClient.Patch(ctx, obj, client.Apply, opts...)
+	conflicts := applyConflicts(err)
+	if len(conflicts) == 0 {
+		return err
+	}
+	if policy != kustomizev1.SkipConflictPolicy || existing == nil {
+		return &ConflictError{ID: objectID(desired), Conflicts: conflicts}
+	}
+
+	if err := removeConflictingFields(desired, existing, conflicts); err != nil {
+		return err
+	}
+	if !dryRun {
+		logr.FromContext(ctx).V(1).Info(
+			fmt.Sprintf("apply skipped the fields of '%s' managed by other field managers", objectID(desired)),
+			"conflicts", (&ConflictError{Conflicts: conflicts}).fields())
+	}
+	if err := kubeClient.Patch(ctx, desired, client.Apply, opts...); err != nil {
+		return err
+	}
+	obj.Object = desired.Object
+	return nil
+}
+
+// fields returns the paths of the conflicting fields.
+func (e *ConflictError) fields() []string {
+	var fields []string
+	for _, c := range e.Conflicts {
+		fields = append(fields, c.Field)
+	}
+	return fields
+}
+
+// applyConflicts returns the field manager conflicts of a server-side apply error.
+func applyConflicts(err error) []metav1.StatusCause {
+	var status apierrors.APIStatus
+	if err == nil || !apierrors.IsConflict(err) || !errors.As(err, &status) || status.Status().Details == nil {
+		return nil
+	}
+	var conflicts []metav1.StatusCause
+	for _, cause := range status.Status().Details.Causes {
+		if cause.Type == metav1.CauseTypeFieldManagerConflict {
+			conflicts = append(conflicts, cause)
+		}
+	}
+	return conflicts
+}
+
+// removeConflictingFields removes the conflicting fields from the object.
+// The conflicting paths are resolved from the managed fields of the
+// in-cluster object, as the conflicts hold their string representation.
+func removeConflictingFields(obj, existing *unstructured.Unstructured, conflicts []metav1.StatusCause) error {
+	fields := make(map[string]bool, len(conflicts))
+	for _, c := range conflicts {
+		fields[c.Field] = true
+	}
+
+	var paths []fieldpath.Path
+	for _, entry := range existing.GetManagedFields() {
+		if entry.Manager == fieldManager || entry.FieldsV1 == nil {
+			continue
+		}
+		set := &fieldpath.Set{}
+		if err := set.FromJSON(bytes.NewReader(entry.FieldsV1.Raw)); err != nil {
+			return fmt.Errorf("unable to decode the managed fields of '%s': %w", objectID(existing), err)
+		}
+		set.Iterate(func(path fieldpath.Path) {
+			if fields[path.String()] {
+				paths = append(paths, path.Copy())
+			}
+		})
+	}
+
+	for _, path := range paths {
+		obj.Object = removeFieldPath(obj.Object, path).(map[string]interface{})
+	}
+	return nil
+}
+
+// removeFieldPath returns the node without the field at the given path.
+func removeFieldPath(node interface{}, path fieldpath.Path) interface{} {
+	if len(path) == 0 {
+		return node
+	}
+	pe, rest := path[0], path[1:]
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if pe.FieldName == nil {
+			return n
+		}
+		child, ok := n[*pe.FieldName]
+		if !ok {
+			return n
+		}
+		if len(rest) == 0 {
+			delete(n, *pe.FieldName)
+		} else {
+			n[*pe.FieldName] = removeFieldPath(child, rest)
+		}
+		return n
+	case []interface{}:
+		for i, item := range n {
+			if !matchPathElement(pe, i, item) {
+				continue
+			}
+			if len(rest) == 0 {
+				return append(n[:i:i], n[i+1:]...)
+			}
+			n[i] = removeFieldPath(item, rest)
+			return n
+		}
+	}
+	return node
+}
+
+// matchPathElement returns true if the list item at the given index
+// is selected by the path element.
+func matchPathElement(pe fieldpath.PathElement, index int, item interface{}) bool {
+	switch {
+	case pe.Index != nil:
+		return *pe.Index == index
+	case pe.Value != nil:
+		return value.Equals(value.NewValueInterface(item), *pe.Value)
+	case pe.Key != nil:
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for _, f := range *pe.Key {
+			v, ok := m[f.Name]
+			if !ok || !value.Equals(value.NewValueInterface(v), f.Value) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
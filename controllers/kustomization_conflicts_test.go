@@ -0,0 +1,125 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestApplyConflicts(t *testing.T) {
+	err := apierrors.NewApplyConflict([]metav1.StatusCause{
+		{
+			Type:    metav1.CauseTypeFieldManagerConflict,
+			Message: `conflict with "kubectl-edit" using apps/v1`,
+			Field:   ".spec.replicas",
+		},
+	}, "Apply failed with 1 conflict")
+
+	conflicts := applyConflicts(err)
+	if len(conflicts) != 1 || conflicts[0].Field != ".spec.replicas" {
+		t.Fatalf("expected the replicas conflict, got %v", conflicts)
+	}
+
+	msg := (&ConflictError{ID: "deployment/apps/backend", Conflicts: conflicts}).Error()
+	if !strings.Contains(msg, `.spec.replicas conflict with "kubectl-edit"`) {
+		t.Errorf("expected the conflicting manager in the error, got %s", msg)
+	}
+
+	if applyConflicts(errors.New("boom")) != nil {
+		t.Error("expected no conflicts for a generic error")
+	}
+	if applyConflicts(apierrors.NewConflict(schema.GroupResource{Resource: "deployments"}, "backend", errors.New("stale"))) != nil {
+		t.Error("expected no conflicts for a resource version conflict")
+	}
+}
+
+func TestRemoveConflictingFields(t *testing.T) {
+	objects, err := readObjects([]byte(`---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: backend
+  namespace: apps
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:v1
+      - name: sidecar
+        image: sidecar:v1
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: backend
+  namespace: apps
+  managedFields:
+  - manager: kustomize-controller
+    operation: Apply
+    apiVersion: apps/v1
+    fieldsType: FieldsV1
+    fieldsV1:
+      f:spec:
+        f:replicas: {}
+  - manager: kubectl-edit
+    operation: Update
+    apiVersion: apps/v1
+    fieldsType: FieldsV1
+    fieldsV1:
+      f:spec:
+        f:replicas: {}
+        f:template:
+          f:spec:
+            f:containers:
+              k:{"name":"sidecar"}:
+                f:image: {}
+spec:
+  replicas: 5
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	desired, existing := objects[0], objects[1]
+
+	conflicts := []metav1.StatusCause{
+		{Type: metav1.CauseTypeFieldManagerConflict, Field: ".spec.replicas"},
+		{Type: metav1.CauseTypeFieldManagerConflict, Field: `.spec.template.spec.containers[name="sidecar"].image`},
+	}
+	if err := removeConflictingFields(desired, existing, conflicts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	spec := desired.Object["spec"].(map[string]interface{})
+	if _, ok := spec["replicas"]; ok {
+		t.Error("expected replicas to be removed")
+	}
+	containers := spec["template"].(map[string]interface{})["spec"].(map[string]interface{})["containers"].([]interface{})
+	if image := containers[0].(map[string]interface{})["image"]; image != "app:v1" {
+		t.Errorf("expected the app image to be kept, got %v", image)
+	}
+	if _, ok := containers[1].(map[string]interface{})["image"]; ok {
+		t.Error("expected the sidecar image to be removed")
+	}
+}
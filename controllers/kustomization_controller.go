@@ -18,31 +18,36 @@ package controllers
 
 import (
 	"context"
+	"crypto/ed25519"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
 
-	securejoin "github.com/cyphar/filepath-securejoin"
 	"github.com/fluxcd/pkg/apis/meta"
 	"github.com/fluxcd/pkg/runtime/events"
 	"github.com/fluxcd/pkg/runtime/metrics"
 	"github.com/fluxcd/pkg/runtime/predicates"
-	"github.com/fluxcd/pkg/untar"
 	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
 	"github.com/go-logr/logr"
 	"github.com/hashicorp/go-retryablehttp"
+	"go.opentelemetry.io/otel/attribute"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	kuberecorder "k8s.io/client-go/tools/record"
 	"k8s.io/client-go/tools/reference"
 	"sigs.k8s.io/cli-utils/pkg/kstatus/polling"
@@ -52,11 +57,14 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 	"sigs.k8s.io/kustomize/api/filesys"
 
 	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+	"github.com/fluxcd/kustomize-controller/internal/discovery"
+	"github.com/fluxcd/kustomize-controller/pkg/validation"
 )
 
 // +kubebuilder:rbac:groups=kustomize.toolkit.fluxcd.io,resources=kustomizations,verbs=get;list;watch;create;update;patch;delete
@@ -65,24 +73,74 @@ import (
 // +kubebuilder:rbac:groups=source.toolkit.fluxcd.io,resources=buckets;gitrepositories,verbs=get;list;watch
 // +kubebuilder:rbac:groups=source.toolkit.fluxcd.io,resources=buckets/status;gitrepositories/status,verbs=get
 // +kubebuilder:rbac:groups="",resources=configmaps;secrets;serviceaccounts,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=create;update;patch
 // +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups="",resources=users;groups,verbs=impersonate
 
 // KustomizationReconciler reconciles a Kustomization object
 type KustomizationReconciler struct {
 	client.Client
-	httpClient            *retryablehttp.Client
-	requeueDependency     time.Duration
-	Scheme                *runtime.Scheme
-	EventRecorder         kuberecorder.EventRecorder
-	ExternalEventRecorder *events.Recorder
-	MetricsRecorder       *metrics.Recorder
-	StatusPoller          *polling.StatusPoller
+	httpClient             *retryablehttp.Client
+	commitStatusClient     *http.Client
+	requeueDependency      time.Duration
+	reconcileBudget        time.Duration
+	verboseEvents          bool
+	discoveryOptions       discovery.Options
+	kubeConfigOptions      KubeConfigOptions
+	scheduler              *namespaceScheduler
+	permissions            *permissionCache
+	readOnly               bool
+	pruneDisabledFor       []string
+	controllerNamespace    string
+	defaultServiceAccount  string
+	noCrossNamespaceRefs   bool
+	restrictToOwnNamespace bool
+	maxRetryInterval       time.Duration
+	stallAfterFailures     int64
+	driftWatcher           *driftWatcher
+	attestationKey         ed25519.PrivateKey
+	bootstrapRetry         time.Duration
+	diffEvents             bool
+	applyOptions           applyOptions
+	maxDelta               *intstr.IntOrString
+	lockWaitThreshold      time.Duration
+	artifactCache          artifactCache
+	canaryAnalysis         bool
+	Scheme                 *runtime.Scheme
+	EventRecorder          kuberecorder.EventRecorder
+	ExternalEventRecorder  *events.Recorder
+	MetricsRecorder        *metrics.Recorder
+	StatusPoller           *polling.StatusPoller
 }
 
 type KustomizationReconcilerOptions struct {
 	MaxConcurrentReconciles   int
 	HTTPRetry                 int
 	DependencyRequeueInterval time.Duration
+	ReconcileBudget           time.Duration
+	VerboseEvents             bool
+	DiscoveryOptions          discovery.Options
+	NamespaceFairness         bool
+	ReadOnly                  bool
+	PruneDisabledFor          []string
+	ControllerNamespace       string
+	DefaultServiceAccount     string
+	NoCrossNamespaceRefs      bool
+	RestrictToOwnNamespace    bool
+	InsecureKubeConfigExec    bool
+	CanaryAnalysis            bool
+	MaxRetryInterval          time.Duration
+	StallAfterFailures        int64
+	DriftDetection            bool
+	AttestationKeyFile        string
+	BootstrapRetryInterval    time.Duration
+	DiffEvents                bool
+	ApplyBatchSize            int
+	ApplyConcurrency          int
+	ApplyBatchInterval        time.Duration
+	MaxDelta                  string
+	LockWaitThreshold         time.Duration
+	ArtifactCacheDir          string
 }
 
 func (r *KustomizationReconciler) SetupWithManager(mgr ctrl.Manager, opts KustomizationReconcilerOptions) error {
@@ -98,7 +156,63 @@ func (r *KustomizationReconciler) SetupWithManager(mgr ctrl.Manager, opts Kustom
 		return fmt.Errorf("failed setting index fields: %w", err)
 	}
 
+	// Index the Kustomizations by the KubeConfig secrets they (may) point at.
+	if err := mgr.GetCache().IndexField(context.TODO(), &kustomizev1.Kustomization{}, kustomizev1.KubeConfigIndexKey,
+		r.indexByKubeConfig); err != nil {
+		return fmt.Errorf("failed setting index fields: %w", err)
+	}
+
+	// Index the Kustomizations by the Kustomizations they depend on.
+	if err := mgr.GetCache().IndexField(context.TODO(), &kustomizev1.Kustomization{}, kustomizev1.DependsOnIndexKey,
+		r.indexByDependsOn); err != nil {
+		return fmt.Errorf("failed setting index fields: %w", err)
+	}
+
 	r.requeueDependency = opts.DependencyRequeueInterval
+	r.reconcileBudget = opts.ReconcileBudget
+	r.verboseEvents = opts.VerboseEvents
+	r.discoveryOptions = opts.DiscoveryOptions
+	r.readOnly = opts.ReadOnly
+	r.pruneDisabledFor = opts.PruneDisabledFor
+	r.controllerNamespace = opts.ControllerNamespace
+	r.defaultServiceAccount = opts.DefaultServiceAccount
+	r.noCrossNamespaceRefs = opts.NoCrossNamespaceRefs
+	r.restrictToOwnNamespace = opts.RestrictToOwnNamespace
+	r.kubeConfigOptions = KubeConfigOptions{
+		InsecureExecProvider: opts.InsecureKubeConfigExec,
+		clients:              newRemoteClients(),
+		mappers:              discovery.NewMapperCache(opts.DiscoveryOptions),
+	}
+	r.maxRetryInterval = opts.MaxRetryInterval
+	r.stallAfterFailures = opts.StallAfterFailures
+	r.bootstrapRetry = opts.BootstrapRetryInterval
+	r.diffEvents = opts.DiffEvents
+	r.lockWaitThreshold = opts.LockWaitThreshold
+	r.artifactCache = artifactCache{dir: opts.ArtifactCacheDir}
+	r.permissions = newPermissionCache()
+	r.canaryAnalysis = opts.CanaryAnalysis
+	r.applyOptions = applyOptions{
+		batchSize:     opts.ApplyBatchSize,
+		concurrency:   opts.ApplyConcurrency,
+		batchInterval: opts.ApplyBatchInterval,
+	}
+	if opts.MaxDelta != "" {
+		maxDelta := intstr.Parse(opts.MaxDelta)
+		if _, err := intstr.GetScaledValueFromIntOrPercent(&maxDelta, 100, false); err != nil {
+			return fmt.Errorf("invalid max delta: %w", err)
+		}
+		r.maxDelta = &maxDelta
+	}
+	if opts.AttestationKeyFile != "" {
+		key, err := readAttestationKey(opts.AttestationKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read the attestation key: %w", err)
+		}
+		r.attestationKey = key
+	}
+	if opts.NamespaceFairness {
+		r.scheduler = newNamespaceScheduler(opts.MaxConcurrentReconciles)
+	}
 
 	// Configure the retryable http client used for fetching artifacts.
 	// By default it retries 10 times within a 3.5 minutes window.
@@ -108,34 +222,88 @@ func (r *KustomizationReconciler) SetupWithManager(mgr ctrl.Manager, opts Kustom
 	httpClient.RetryMax = opts.HTTPRetry
 	httpClient.Logger = nil
 	r.httpClient = httpClient
+	r.commitStatusClient = &http.Client{Timeout: commitStatusTimeout}
 
-	return ctrl.NewControllerManagedBy(mgr).
+	c, err := ctrl.NewControllerManagedBy(mgr).
 		For(&kustomizev1.Kustomization{}, builder.WithPredicates(
-			predicate.Or(predicate.GenerationChangedPredicate{}, predicates.ReconcileRequestedPredicate{}),
+			predicate.Or(predicate.GenerationChangedPredicate{}, predicates.ReconcileRequestedPredicate{}, ApprovalChangePredicate{}),
 		)).
 		Watches(
 			&source.Kind{Type: &sourcev1.GitRepository{}},
 			handler.EnqueueRequestsFromMapFunc(r.requestsForRevisionChangeOf(kustomizev1.GitRepositoryIndexKey)),
 			builder.WithPredicates(SourceRevisionChangePredicate{}),
 		).
+		Watches(
+			&source.Kind{Type: &kustomizev1.NamespaceConfig{}},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForNamespaceConfigChange),
+		).
 		Watches(
 			&source.Kind{Type: &sourcev1.Bucket{}},
 			handler.EnqueueRequestsFromMapFunc(r.requestsForRevisionChangeOf(kustomizev1.BucketIndexKey)),
 			builder.WithPredicates(SourceRevisionChangePredicate{}),
 		).
+		Watches(
+			&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForKubeConfigChange),
+		).
+		Watches(
+			&source.Kind{Type: &kustomizev1.Kustomization{}},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForDependencyReady),
+			builder.WithPredicates(ReadyChangePredicate{}),
+		).
 		WithOptions(controller.Options{MaxConcurrentReconciles: opts.MaxConcurrentReconciles}).
-		Complete(r)
+		Build(r)
+	if err != nil {
+		return err
+	}
+
+	// enqueue the reconciliations deferred by the namespace fairness scheduler
+	if r.scheduler != nil {
+		if err := c.Watch(r.scheduler.source(), &handler.EnqueueRequestForObject{}); err != nil {
+			return err
+		}
+	}
+
+	// watch the applied objects to correct the drift as soon as it happens
+	if opts.DriftDetection {
+		r.driftWatcher = newDriftWatcher(c, r.discoveryOptions)
+	}
+
+	// delete the cached artifacts of the Kustomizations deleted while the controller was not running
+	return mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		if !mgr.GetCache().WaitForCacheSync(ctx) {
+			return nil
+		}
+		if err := r.artifactCache.prune(ctx, mgr.GetClient()); err != nil {
+			ctrl.Log.WithName("controllers").WithName(kustomizev1.KustomizationKind).Error(err, "unable to prune the artifact cache")
+		}
+		return nil
+	}))
 }
 
 func (r *KustomizationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := logr.FromContext(ctx)
-	reconcileStart := time.Now()
 
 	var kustomization kustomizev1.Kustomization
 	if err := r.Get(ctx, req.NamespacedName, &kustomization); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.scheduler.forget(req)
+		}
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	// the deferred reconciliations are enqueued by the scheduler when a worker is released
+	if r.scheduler != nil {
+		if !r.scheduler.acquire(req) {
+			return ctrl.Result{}, nil
+		}
+		defer r.scheduler.release(req)
+	}
+	reconcileStart := time.Now()
+
+	ctx, span := startSpan(ctx, "reconcile", attribute.String("kustomization", req.String()))
+	defer span.End()
+
 	// Record suspended status metric
 	defer r.recordSuspension(ctx, kustomization)
 
@@ -148,6 +316,13 @@ func (r *KustomizationReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		}
 	}
 
+	// Merge the namespace defaults, the spec changes are not persisted
+	if err := r.applyNamespaceConfig(ctx, &kustomization); err != nil {
+		log.Error(err, "unable to read the namespace config")
+		return ctrl.Result{Requeue: true}, err
+	}
+	setDefaultServiceAccount(&kustomization, r.defaultServiceAccount)
+
 	// Examine if the object is under deletion
 	if !kustomization.ObjectMeta.DeletionTimestamp.IsZero() {
 		return r.reconcileDelete(ctx, kustomization)
@@ -159,6 +334,22 @@ func (r *KustomizationReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		return ctrl.Result{}, nil
 	}
 
+	// reject the references to other namespaces if they are disabled,
+	// when the spec changes the watcher should trigger a reconciliation
+	if r.noCrossNamespaceRefs {
+		if err := checkCrossNamespaceRefs(kustomization); err != nil {
+			kustomization = kustomizev1.KustomizationStalled(kustomization, "", kustomizev1.AccessDeniedReason, err.Error())
+			if err := r.patchStatus(ctx, req, kustomization.Status); err != nil {
+				log.Error(err, "unable to update status for access denied")
+				return ctrl.Result{Requeue: true}, err
+			}
+			r.recordReadiness(ctx, kustomization)
+			log.Error(err, "Reconciliation failed, waiting for a spec change")
+			r.event(ctx, kustomization, "", events.EventSeverityError, err.Error(), nil)
+			return ctrl.Result{}, nil
+		}
+	}
+
 	// resolve source reference
 	source, err := r.getSource(ctx, kustomization)
 	if err != nil {
@@ -192,24 +383,111 @@ func (r *KustomizationReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		return ctrl.Result{RequeueAfter: kustomization.GetRetryInterval()}, nil
 	}
 
+	// log the source revision with the messages of the reconciliation phases
+	log = log.WithValues("revision", source.GetArtifact().Revision)
+	ctx = logr.NewContext(ctx, log)
+
 	// check dependencies
+	var skippedDependencies []string
 	if len(kustomization.Spec.DependsOn) > 0 {
-		if err := r.checkDependencies(kustomization); err != nil {
-			kustomization = kustomizev1.KustomizationNotReady(
-				kustomization, source.GetArtifact().Revision, meta.DependencyNotReadyReason, err.Error())
+		now := time.Now()
+		skipped, err := r.checkDependencies(ctx, kustomization, source.GetArtifact().Revision, now)
+		if err != nil {
+			if kustomization.Status.DependencyWaitStartTime == nil {
+				start := metav1.NewTime(now)
+				kustomization.Status.DependencyWaitStartTime = &start
+			}
+
+			// we can't rely on exponential backoff because it will prolong the execution too much,
+			// instead we requeue on a fix interval.
+			requeueDependency := r.requeueDependency
+			if r.isBootstrapping(kustomization) && r.bootstrapRetry < requeueDependency {
+				requeueDependency = r.bootstrapRetry
+			}
+			if wait := dependencyWaitTime(kustomization, now); wait > 0 {
+				err = fmt.Errorf("%w, waiting for %s", err, wait.String())
+			}
+			msg := fmt.Sprintf("Dependencies do not meet ready condition, retrying in %s", requeueDependency.String())
+			reason, severity := meta.DependencyNotReadyReason, events.EventSeverityInfo
+			stalled := false
+			var timeoutErr *DependencyTimeoutError
+			if errors.As(err, &timeoutErr) {
+				reason, severity = kustomizev1.DependencyTimeoutReason, events.EventSeverityError
+				if timeoutErr.Policy == kustomizev1.FailDependencyPolicy {
+					requeueDependency = kustomization.Spec.Interval.Duration
+					stalled = true
+				}
+				msg = fmt.Sprintf("%s, retrying in %s", err.Error(), requeueDependency.String())
+			}
+
+			// emit the timeout event only once, when the condition transitions
+			ready := apimeta.FindStatusCondition(kustomization.Status.Conditions, meta.ReadyCondition)
+			notified := ready != nil && ready.Reason == kustomizev1.DependencyTimeoutReason
+			if stalled {
+				kustomization = kustomizev1.KustomizationStalled(
+					kustomization, source.GetArtifact().Revision, reason, err.Error())
+			} else {
+				kustomization = kustomizev1.KustomizationNotReady(
+					kustomization, source.GetArtifact().Revision, reason, err.Error())
+			}
 			if err := r.patchStatus(ctx, req, kustomization.Status); err != nil {
 				log.Error(err, "unable to update status for dependency not ready")
 				return ctrl.Result{Requeue: true}, err
 			}
-			// we can't rely on exponential backoff because it will prolong the execution too much,
-			// instead we requeue on a fix interval.
-			msg := fmt.Sprintf("Dependencies do not meet ready condition, retrying in %s", r.requeueDependency.String())
 			log.Info(msg)
-			r.event(ctx, kustomization, source.GetArtifact().Revision, events.EventSeverityInfo, msg, nil)
+			if reason != kustomizev1.DependencyTimeoutReason || !notified {
+				r.event(ctx, kustomization, source.GetArtifact().Revision, severity, msg, nil)
+			}
 			r.recordReadiness(ctx, kustomization)
-			return ctrl.Result{RequeueAfter: r.requeueDependency}, nil
+			return ctrl.Result{RequeueAfter: requeueDependency}, nil
+		}
+		skippedDependencies = skipped
+		if len(skipped) > 0 {
+			msg := fmt.Sprintf("Proceeding with reconciliation without the dependencies not ready after their timeout: %s",
+				strings.Join(skipped, ", "))
+			log.Info(msg)
+			if isWaitingForDependencies(kustomization) {
+				r.event(ctx, kustomization, source.GetArtifact().Revision, events.EventSeverityInfo, msg, nil)
+			}
+		} else {
+			log.Info("All dependencies are ready, proceeding with reconciliation")
+		}
+	}
+
+	// reset the dependency wait time, so that the timeout applies to the next wait,
+	// unless dependencies were skipped, to not wait again for their timeout
+	if kustomization.Status.DependencyWaitStartTime != nil && len(skippedDependencies) == 0 {
+		kustomization.Status.DependencyWaitStartTime = nil
+		if err := r.patchStatus(ctx, req, kustomization.Status); err != nil {
+			log.Error(err, "unable to update status for dependencies ready")
+			return ctrl.Result{Requeue: true}, err
 		}
-		log.Info("All dependencies are ready, proceeding with reconciliation")
+	}
+
+	// skip the reconciliation if nothing changed since the last successful apply
+	if r.isUpToDate(ctx, kustomization, source.GetArtifact().Revision) {
+		log.Info("No changes since last reconciliation", "requeueAfter", kustomization.GetDriftCheckInterval())
+		r.recordReadiness(ctx, kustomization)
+		return ctrl.Result{RequeueAfter: kustomization.GetDriftCheckInterval()}, nil
+	}
+
+	// defer the apply of a new revision pending approval or outside of the schedule windows,
+	// the changes are reported as in diff-only mode until the revision can be applied
+	var deferral *applyDeferral
+	if !r.readOnly && kustomization.Spec.Mode != kustomizev1.DiffOnlyMode {
+		d, err := deferApply(kustomization, source.GetArtifact().Revision, time.Now())
+		if err != nil {
+			kustomization = kustomizev1.KustomizationStalled(kustomization, source.GetArtifact().Revision, kustomizev1.ValidationFailedReason, err.Error())
+			if err := r.patchStatus(ctx, req, kustomization.Status); err != nil {
+				log.Error(err, "unable to update status for invalid schedule")
+				return ctrl.Result{Requeue: true}, err
+			}
+			r.recordReadiness(ctx, kustomization)
+			log.Error(err, "Reconciliation failed, waiting for a spec change")
+			r.event(ctx, kustomization, source.GetArtifact().Revision, events.EventSeverityError, err.Error(), nil)
+			return ctrl.Result{}, nil
+		}
+		deferral = d
 	}
 
 	// record reconciliation duration
@@ -229,36 +507,187 @@ func (r *KustomizationReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 	}
 	r.recordReadiness(ctx, kustomization)
 
+	// mark the commit of a new revision as pending on the Git provider
+	if deferral == nil && kustomization.Status.LastAttemptedRevision != source.GetArtifact().Revision {
+		r.updateCommitStatus(ctx, &kustomization, source, reconciledCommitStatus(kustomization, nil))
+	}
+
 	// reconcile kustomization by applying the latest revision
-	reconciledKustomization, reconcileErr := r.reconcile(ctx, *kustomization.DeepCopy(), source)
+	target := kustomization.DeepCopy()
+	if deferral != nil {
+		target.Spec.Mode = kustomizev1.DiffOnlyMode
+	}
+	reconciledKustomization, reconcileErr := r.reconcile(ctx, *target, source)
+	span.SetAttributes(attribute.String("revision", source.GetArtifact().Revision))
+	setSpanError(span, reconcileErr)
+	if reconcileErr == nil && deferral != nil {
+		msg := deferral.Message
+		if p := reconciledKustomization.Status.LastPreview; p != nil && p.Revision == source.GetArtifact().Revision {
+			msg = fmt.Sprintf("%s: %s", msg, p.Summary)
+		}
+		kustomizev1.SetKustomizationReadiness(&reconciledKustomization, metav1.ConditionUnknown,
+			deferral.Reason, msg, source.GetArtifact().Revision)
+	}
+
+	// detect if the source published a new revision during the reconciliation
+	pendingRevision := r.pendingRevision(ctx, kustomization, source.GetArtifact().Revision)
+	reconciledKustomization.Status.PendingRevision = pendingRevision
+
+	// count the consecutive failures, and mark the Kustomization as stalled
+	// when the failures threshold is reached
+	var budgetErr *BudgetExceededError
+	switch {
+	case reconcileErr == nil:
+		reconciledKustomization.Status.Failures = 0
+	case !errors.As(reconcileErr, &budgetErr):
+		failures := kustomization.Status.Failures + 1
+		reconciledKustomization.Status.Failures = failures
+		if r.stallAfterFailures > 0 && failures >= r.stallAfterFailures {
+			reason := meta.ReconciliationFailedReason
+			if ready := apimeta.FindStatusCondition(reconciledKustomization.Status.Conditions, meta.ReadyCondition); ready != nil {
+				reason = ready.Reason
+			}
+			kustomizev1.SetKustomizationStalled(&reconciledKustomization, reason,
+				fmt.Sprintf("reconciliation failed %d times in a row", failures))
+		}
+	}
+
+	// report the result of the reconciliation on the commit of the revision
+	if deferral == nil && !errors.As(reconcileErr, &budgetErr) {
+		r.updateCommitStatus(ctx, &reconciledKustomization, source,
+			reconciledCommitStatus(reconciledKustomization, reconcileErr))
+	}
+
+	// mark the Kustomization as stalled when it doesn't become ready within the
+	// progress deadline, the deferred revisions are not expected to become ready
+	if deferral != nil {
+		reconciledKustomization.Status.ProgressingSince = nil
+	} else if trackProgress(&reconciledKustomization, kustomization.Status, reconcileErr, time.Now()) {
+		log.Info("Progress deadline exceeded, marking the Kustomization as stalled")
+	}
+
 	if err := r.patchStatus(ctx, req, reconciledKustomization.Status); err != nil {
 		log.Error(err, "unable to update status after reconciliation")
 		return ctrl.Result{Requeue: true}, err
 	}
 	r.recordReadiness(ctx, reconciledKustomization)
+	duration := time.Now().Sub(reconcileStart)
+	recordOutcome(reconciledKustomization, reconcileErr, duration)
+
+	// requeue immediately to resume the apply from the checkpoint
+	if errors.As(reconcileErr, &budgetErr) {
+		log.Info("Reconciliation interrupted, resuming from checkpoint",
+			"duration", duration,
+			"applied", fmt.Sprintf("%d/%d", len(budgetErr.Checkpoint.Inventory.Entries), budgetErr.Total))
+		return ctrl.Result{Requeue: true}, nil
+	}
 
 	// broadcast the reconciliation failure and requeue at the specified retry interval
 	if reconcileErr != nil {
-		log.Error(reconcileErr, fmt.Sprintf("Reconciliation failed after %s, next try in %s",
-			time.Now().Sub(reconcileStart).String(),
-			kustomization.GetRetryInterval().String()),
-			"revision",
-			source.GetArtifact().Revision)
+		// do not retry when the artifact is permanently missing, when the source publishes
+		// a new artifact the watcher should trigger a reconciliation, the interval is kept
+		// as a safety net in case the event is missed
+		var notFound *ArtifactNotFoundError
+		var invalidPath *InvalidPathError
+		var rolledBack *RolledBackError
+		stalled := errors.As(reconcileErr, &notFound) || errors.As(reconcileErr, &invalidPath) ||
+			errors.As(reconcileErr, &rolledBack)
+		retryInterval := retryBackoff(kustomization.GetRetryInterval(), r.maxRetryInterval, reconciledKustomization.Status.Failures)
+		if r.isBootstrapping(reconciledKustomization) {
+			// retry faster until the first successful apply
+			retryInterval = retryBackoff(r.bootstrapRetry, kustomization.GetRetryInterval(), reconciledKustomization.Status.Failures)
+		}
+		if stalled {
+			msg := "Reconciliation failed, waiting for a new artifact"
+			if invalidPath != nil || rolledBack != nil {
+				msg = "Reconciliation failed, waiting for a new artifact or a spec change"
+			}
+			log.Error(reconcileErr, msg, "duration", duration)
+		} else {
+			log.Error(reconcileErr, "Reconciliation failed", "duration", duration, "retryAfter", retryInterval)
+		}
+		var metadata map[string]string
+		var denied *validation.AdmissionDeniedError
+		if errors.As(reconcileErr, &denied) {
+			metadata = map[string]string{"webhook": denied.Webhook}
+			if denied.Policy != "" {
+				metadata["policy"] = denied.Policy
+			}
+		}
 		r.event(ctx, reconciledKustomization, source.GetArtifact().Revision, events.EventSeverityError,
-			reconcileErr.Error(), nil)
-		return ctrl.Result{RequeueAfter: kustomization.GetRetryInterval()}, nil
+			reconcileErr.Error(), metadata)
+		if pendingRevision != "" {
+			log.Info("Source revision changed during reconciliation, retrying", "pendingRevision", pendingRevision)
+			return ctrl.Result{Requeue: true}, nil
+		}
+		if notFound != nil {
+			return ctrl.Result{RequeueAfter: kustomization.Spec.Interval.Duration}, nil
+		}
+		if stalled {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{RequeueAfter: retryInterval}, nil
 	}
 
-	// broadcast the reconciliation result and requeue at the specified interval
-	log.Info(fmt.Sprintf("Reconciliation finished in %s, next run in %s",
-		time.Now().Sub(reconcileStart).String(),
-		kustomization.Spec.Interval.Duration.String()),
-		"revision",
-		source.GetArtifact().Revision,
-	)
+	// watch the kinds of the applied objects, on the remote cluster if any
+	if r.driftWatcher != nil {
+		if err := r.watchDrift(ctx, reconciledKustomization); err != nil {
+			log.Error(err, "unable to watch the applied objects")
+		}
+	}
+
+	// the changes of a deferred revision are only reported, requeue at the interval,
+	// or when the next schedule window opens if sooner
+	if deferral != nil {
+		requeueAfter := deferral.RequeueAfter
+		log.Info("Reconciliation finished without applying",
+			"duration", duration,
+			"requeueAfter", requeueAfter.Round(time.Second))
+		if !reflect.DeepEqual(kustomization.Status.LastPreview, reconciledKustomization.Status.LastPreview) {
+			if ready := apimeta.FindStatusCondition(reconciledKustomization.Status.Conditions, meta.ReadyCondition); ready != nil {
+				r.event(ctx, reconciledKustomization, source.GetArtifact().Revision, events.EventSeverityInfo, ready.Message, nil)
+			}
+		}
+		return ctrl.Result{RequeueAfter: requeueAfter}, nil
+	}
+
+	// in read-only and diff-only modes the changes are only reported, skip the update event
+	if r.readOnly || kustomization.Spec.Mode == kustomizev1.DiffOnlyMode {
+		log.Info("Reconciliation finished without applying",
+			"duration", duration,
+			"requeueAfter", kustomization.Spec.Interval.Duration)
+		// report the changes when they differ from the last reported ones
+		if kustomization.Spec.Mode == kustomizev1.DiffOnlyMode &&
+			!reflect.DeepEqual(kustomization.Status.LastPreview, reconciledKustomization.Status.LastPreview) {
+			if ready := apimeta.FindStatusCondition(reconciledKustomization.Status.Conditions, meta.ReadyCondition); ready != nil {
+				r.event(ctx, reconciledKustomization, source.GetArtifact().Revision, events.EventSeverityInfo, ready.Message, nil)
+			}
+		}
+		return ctrl.Result{RequeueAfter: kustomization.Spec.Interval.Duration}, nil
+	}
+
+	// check the canary analysis in progress until it completes,
+	// the update event is issued once the analysis succeeded
+	if reconciledKustomization.Status.CanaryAnalysis != nil {
+		log.Info("Reconciliation finished, waiting for canary analysis",
+			"duration", duration,
+			"requeueAfter", canaryRequeueInterval)
+		return ctrl.Result{RequeueAfter: canaryRequeueInterval}, nil
+	}
+
+	// broadcast the reconciliation result and requeue at the specified interval,
+	// or at the drift check interval if shorter
+	log.Info("Reconciliation finished",
+		"duration", duration,
+		"requeueAfter", kustomization.GetDriftCheckInterval(),
+		"objects", summaryCounts(reconciledKustomization.Status.LastApplySummary))
 	r.event(ctx, reconciledKustomization, source.GetArtifact().Revision, events.EventSeverityInfo,
 		"Update completed", map[string]string{"commit_status": "update"})
-	return ctrl.Result{RequeueAfter: kustomization.Spec.Interval.Duration}, nil
+	if pendingRevision != "" {
+		log.Info("Source revision changed during reconciliation, requeuing", "pendingRevision", pendingRevision)
+		return ctrl.Result{Requeue: true}, nil
+	}
+	return ctrl.Result{RequeueAfter: kustomization.GetDriftCheckInterval()}, nil
 }
 
 func (r *KustomizationReconciler) reconcile(
@@ -270,6 +699,12 @@ func (r *KustomizationReconciler) reconcile(
 		kustomization.Status.SetLastHandledReconcileRequest(v)
 	}
 
+	// set the deadline for applying the objects, if any
+	var deadline time.Time
+	if r.reconcileBudget > 0 {
+		deadline = time.Now().Add(r.reconcileBudget)
+	}
+
 	// create tmp dir
 	tmpDir, err := ioutil.TempDir("", kustomization.Name)
 	if err != nil {
@@ -283,9 +718,41 @@ func (r *KustomizationReconciler) reconcile(
 	}
 	defer os.RemoveAll(tmpDir)
 
+	// keep a copy of the artifact for rollbacks, the build output
+	// is not cached as it may contain decrypted secrets
+	var archive io.Writer
+	var archivePath string
+	if kustomization.Spec.Rollback && r.artifactCache.dir != "" {
+		archiveFile, err := ioutil.TempFile("", fmt.Sprintf("%s-artifact-", kustomization.GetName()))
+		if err != nil {
+			err = fmt.Errorf("tmp file error: %w", err)
+			return kustomizev1.KustomizationNotReady(
+				kustomization,
+				source.GetArtifact().Revision,
+				sourcev1.StorageOperationFailedReason,
+				err.Error(),
+			), err
+		}
+		defer os.Remove(archiveFile.Name())
+		defer archiveFile.Close()
+		archive = archiveFile
+		archivePath = archiveFile.Name()
+	}
+
 	// download artifact and extract files
-	err = r.download(source.GetArtifact().URL, tmpDir)
+	fetchCtx, span := startSpan(ctx, "fetch", attribute.String("artifact", source.GetArtifact().URL))
+	err = r.download(fetchCtx, source.GetArtifact().URL, tmpDir, archive)
+	endSpan(span, err)
 	if err != nil {
+		var notFound *ArtifactNotFoundError
+		if errors.As(err, &notFound) {
+			return kustomizev1.KustomizationStalled(
+				kustomization,
+				source.GetArtifact().Revision,
+				kustomizev1.ArtifactNotFoundReason,
+				err.Error(),
+			), err
+		}
 		return kustomizev1.KustomizationNotReady(
 			kustomization,
 			source.GetArtifact().Revision,
@@ -294,9 +761,18 @@ func (r *KustomizationReconciler) reconcile(
 		), err
 	}
 
-	// check build path exists
-	dirPath, err := securejoin.SecureJoin(tmpDir, kustomization.Spec.Path)
+	// check build path is within the artifact and exists
+	dirPath, err := artifactPath(tmpDir, kustomization.Spec.Path)
 	if err != nil {
+		var invalidPath *InvalidPathError
+		if errors.As(err, &invalidPath) {
+			return kustomizev1.KustomizationStalled(
+				kustomization,
+				source.GetArtifact().Revision,
+				kustomizev1.InvalidPathReason,
+				err.Error(),
+			), err
+		}
 		return kustomizev1.KustomizationNotReady(
 			kustomization,
 			source.GetArtifact().Revision,
@@ -314,31 +790,73 @@ func (r *KustomizationReconciler) reconcile(
 		), err
 	}
 
+	// merge the health checks defined in the artifact, if any
+	if kustomization.Spec.HealthChecksFrom != "" {
+		checks, err := readHealthChecks(tmpDir, kustomization.Spec.HealthChecksFrom)
+		if err != nil {
+			return kustomizev1.KustomizationNotReady(
+				kustomization,
+				source.GetArtifact().Revision,
+				kustomizev1.ValidationFailedReason,
+				err.Error(),
+			), err
+		}
+		kustomization.Spec.HealthChecks = mergeHealthChecks(kustomization.Spec.HealthChecks, checks)
+	}
+
+	// fan out the reconciliation to the remote clusters, if any
+	if len(kustomization.Spec.Clusters) > 0 {
+		return r.reconcileClusters(ctx, kustomization, source.GetArtifact().Revision, tmpDir)
+	}
+
 	// create any necessary kube-clients for impersonation
-	impersonation := NewKustomizeImpersonation(kustomization, r.Client, r.StatusPoller, dirPath)
+	impersonation := NewKustomizeImpersonation(kustomization, r.Client, r.StatusPoller, r.discoveryOptions, r.kubeConfigOptions)
 	kubeClient, statusPoller, err := impersonation.GetClient(ctx)
 	if err != nil {
+		reason := meta.ReconciliationFailedReason
+		var authErr *KubeConfigAuthError
+		if errors.As(err, &authErr) {
+			reason = kustomizev1.KubeConfigAuthFailedReason
+		}
 		return kustomizev1.KustomizationNotReady(
 			kustomization,
 			source.GetArtifact().Revision,
-			meta.ReconciliationFailedReason,
+			reason,
 			err.Error(),
 		), fmt.Errorf("failed to build kube client: %w", err)
 	}
 
+	// restore the inventory from the backup, if any
+	if err := r.importInventory(ctx, kubeClient, &kustomization); err != nil {
+		return kustomizev1.KustomizationNotReady(
+			kustomization,
+			source.GetArtifact().Revision,
+			meta.ReconciliationFailedReason,
+			err.Error(),
+		), err
+	}
+
 	// generate kustomization.yaml and calculate the manifests checksum
+	ctx, buildLockWait := withBuildLockWait(ctx)
 	checksum, err := r.generate(ctx, kubeClient, kustomization, dirPath)
 	if err != nil {
+		reason := kustomizev1.BuildFailedReason
+		var missing *MissingKustomizationError
+		if errors.As(err, &missing) {
+			reason = kustomizev1.KustomizationMissingReason
+		}
 		return kustomizev1.KustomizationNotReady(
 			kustomization,
 			source.GetArtifact().Revision,
-			kustomizev1.BuildFailedReason,
+			reason,
 			err.Error(),
 		), err
 	}
 
 	// build the kustomization and generate the GC snapshot
-	snapshot, err := r.build(ctx, kustomization, checksum, dirPath)
+	buildCtx, span := startSpan(ctx, "build")
+	snapshot, err := r.build(buildCtx, kustomization, checksum, dirPath)
+	endSpan(span, err)
 	if err != nil {
 		return kustomizev1.KustomizationNotReady(
 			kustomization,
@@ -348,19 +866,204 @@ func (r *KustomizationReconciler) reconcile(
 		), err
 	}
 
+	// report the reconciliations delayed by the builds of other Kustomizations
+	if r.lockWaitThreshold > 0 && *buildLockWait > r.lockWaitThreshold {
+		msg := fmt.Sprintf("Kustomize build delayed by %s waiting for the builds of other Kustomizations",
+			buildLockWait.Round(time.Millisecond).String())
+		logr.FromContext(ctx).Info(msg)
+		r.event(ctx, kustomization, source.GetArtifact().Revision, events.EventSeverityInfo, msg, nil)
+	}
+
+	// reject the objects out of the scope of the namespace policy
+	if err := r.enforceNamespacePolicy(ctx, kubeClient.RESTMapper(), kustomization, dirPath); err != nil {
+		reason := kustomizev1.ValidationFailedReason
+		var denied *NamespaceDeniedError
+		if errors.As(err, &denied) {
+			reason = kustomizev1.AccessDeniedReason
+		}
+		return kustomizev1.KustomizationNotReady(
+			kustomization,
+			source.GetArtifact().Revision,
+			reason,
+			err.Error(),
+		), err
+	}
+
+	// reject the build output exceeding the object quota of the namespace
+	if err := r.enforceQuota(ctx, kustomization, dirPath); err != nil {
+		reason := kustomizev1.ValidationFailedReason
+		var exceeded *QuotaExceededError
+		if errors.As(err, &exceeded) {
+			reason = kustomizev1.QuotaExceededReason
+		}
+		return kustomizev1.KustomizationNotReady(
+			kustomization,
+			source.GetArtifact().Revision,
+			reason,
+			err.Error(),
+		), err
+	}
+
+	// create the target namespace, if requested
+	if !r.readOnly && kustomization.Spec.Mode != kustomizev1.DiffOnlyMode {
+		created, err := ensureTargetNamespace(ctx, kubeClient, kustomization)
+		if err != nil {
+			err = fmt.Errorf("failed to create namespace '%s': %w", kustomization.Spec.TargetNamespace, err)
+			return kustomizev1.KustomizationNotReady(
+				kustomization,
+				source.GetArtifact().Revision,
+				meta.ReconciliationFailedReason,
+				err.Error(),
+			), err
+		}
+		if created {
+			logr.FromContext(ctx).Info("Namespace created", "namespace", kustomization.Spec.TargetNamespace)
+		}
+	}
+
+	// check the permissions of the impersonated identity
+	if err := r.preflight(ctx, kubeClient, kustomization, source.GetArtifact().Revision, dirPath); err != nil {
+		reason := kustomizev1.ValidationFailedReason
+		var denied *PermissionDeniedError
+		if errors.As(err, &denied) {
+			reason = kustomizev1.PermissionDeniedReason
+		}
+		return kustomizev1.KustomizationNotReady(
+			kustomization,
+			source.GetArtifact().Revision,
+			reason,
+			err.Error(),
+		), err
+	}
+
 	// dry-run apply
-	err = r.validate(ctx, kustomization, impersonation, dirPath)
+	validateCtx, span := startSpan(ctx, "validate")
+	err = r.validate(validateCtx, kubeClient, kustomization, dirPath)
+	endSpan(span, err)
 	if err != nil {
 		return kustomizev1.KustomizationNotReady(
 			kustomization,
 			source.GetArtifact().Revision,
-			kustomizev1.ValidationFailedReason,
+			admissionReason(err, kustomizev1.ValidationFailedReason),
 			err.Error(),
 		), err
 	}
 
-	// apply
-	changeSet, err := r.applyWithRetry(ctx, kustomization, impersonation, source.GetArtifact().Revision, dirPath, 5*time.Second)
+	// record the build output and the changes to be applied
+	var changes *objectChanges
+	if kustomization.Spec.Preview || r.readOnly || kustomization.Spec.Mode == kustomizev1.DiffOnlyMode {
+		report, c, err := r.preview(ctx, kubeClient, kustomization, source.GetArtifact().Revision, dirPath)
+		if err != nil {
+			logr.FromContext(ctx).Error(err, "unable to record the preview")
+		} else {
+			kustomization.Status.LastPreview = report
+			changes = c
+		}
+	}
+
+	// report the drift without applying the changes nor pruning
+	if r.readOnly || kustomization.Spec.Mode == kustomizev1.DiffOnlyMode {
+		reason, mode := kustomizev1.DiffOnlyReason, "diff-only"
+		if r.readOnly {
+			reason, mode = kustomizev1.ReadOnlyReason, "read-only"
+		}
+		msg := fmt.Sprintf("%s mode, revision %s not applied", mode, source.GetArtifact().Revision)
+		if p := kustomization.Status.LastPreview; p != nil && p.Revision == source.GetArtifact().Revision {
+			msg = fmt.Sprintf("%s: %s", msg, p.Summary)
+		}
+		kustomizev1.SetKustomizationReadiness(
+			&kustomization,
+			metav1.ConditionUnknown,
+			reason,
+			msg,
+			source.GetArtifact().Revision,
+		)
+		return kustomization, nil
+	}
+
+	// compute the changes to be applied, if not already done by the preview
+	maxDelta := r.maxDeltaFor(kustomization)
+	if changes == nil && (r.diffEvents || maxDelta != nil) {
+		objects, err := readBuildObjects(dirPath, kustomization)
+		if err == nil {
+			changes, err = dryRunChanges(ctx, kubeClient, kustomization, objects)
+		}
+		if err != nil {
+			if maxDelta != nil {
+				err = fmt.Errorf("unable to compute the changes for the max delta check: %w", err)
+				return kustomizev1.KustomizationNotReady(
+					kustomization,
+					source.GetArtifact().Revision,
+					kustomizev1.MaxDeltaExceededReason,
+					err.Error(),
+				), err
+			}
+			logr.FromContext(ctx).Error(err, "unable to compute the changes")
+		}
+	}
+
+	// report the changes before applying them
+	if r.diffEvents && changes != nil && changes.HasChanges() {
+		r.event(ctx, kustomization, source.GetArtifact().Revision, events.EventSeverityInfo,
+			"Applying changes: "+changes.String(), nil)
+	}
+
+	// refuse to apply the revision if it changes too many objects
+	if maxDelta != nil {
+		if err := checkMaxDelta(kustomization, source.GetArtifact().Revision, changes, maxDelta); err != nil {
+			return kustomizev1.KustomizationNotReady(
+				kustomization,
+				source.GetArtifact().Revision,
+				kustomizev1.MaxDeltaExceededReason,
+				err.Error(),
+			), err
+		}
+	}
+
+	// run the pre-apply hooks of a new revision
+	runHooks := kustomization.Spec.Hooks != nil && kustomization.Status.LastAppliedRevision != source.GetArtifact().Revision
+	if runHooks {
+		if err := r.runHooks(ctx, kubeClient, kustomization, preApplyHook, kustomization.Spec.Hooks.PreApply,
+			source.GetArtifact().Revision, dirPath); err != nil {
+			return kustomizev1.KustomizationNotReady(
+				kustomization,
+				source.GetArtifact().Revision,
+				kustomizev1.HookFailedReason,
+				err.Error(),
+			), err
+		}
+	}
+
+	// apply, resuming from the checkpoint of the previous reconciliation, if any
+	applyCtx, span := startSpan(ctx, "apply")
+	results, err := r.applyWithRetry(applyCtx, kubeClient, kustomization, source.GetArtifact().Revision, dirPath, checksum, deadline, 5*time.Second)
+	endSpan(span, err)
+	if err != nil {
+		var budgetErr *BudgetExceededError
+		if errors.As(err, &budgetErr) {
+			kustomization.Status.Checkpoint = budgetErr.Checkpoint
+			return kustomizev1.KustomizationProgressing(kustomization), err
+		}
+		var partialErr *PartialApplyError
+		if errors.As(err, &partialErr) {
+			kustomization.Status.PartialApply = &kustomizev1.PartialApply{
+				Revision:   source.GetArtifact().Revision,
+				Applied:    partialErr.Applied,
+				NotApplied: partialErr.NotApplied,
+			}
+		}
+		return kustomizev1.KustomizationNotReady(
+			kustomization,
+			source.GetArtifact().Revision,
+			admissionReason(err, meta.ReconciliationFailedReason),
+			err.Error(),
+		), err
+	}
+	kustomization.Status.Checkpoint = nil
+	kustomization.Status.PartialApply = nil
+
+	// record the applied objects
+	inventory, err := readInventory(kubeClient, kustomization, dirPath)
 	if err != nil {
 		return kustomizev1.KustomizationNotReady(
 			kustomization,
@@ -369,9 +1072,19 @@ func (r *KustomizationReconciler) reconcile(
 			err.Error(),
 		), err
 	}
+	setInventoryChecksums(inventory, results)
+
+	// record the provenance of the applied objects
+	if kustomization.Spec.Attest {
+		if err := r.attest(ctx, kustomization, *source.GetArtifact(), dirPath); err != nil {
+			logr.FromContext(ctx).Error(err, "unable to record the attestation")
+		}
+	}
 
 	// prune
-	err = r.prune(ctx, kubeClient, kustomization, checksum)
+	pruneCtx, span := startSpan(ctx, "prune")
+	pruneSet, err := r.prune(pruneCtx, kubeClient, kustomization, inventory, checksum)
+	endSpan(span, err)
 	if err != nil {
 		return kustomizev1.KustomizationNotReady(
 			kustomization,
@@ -380,53 +1093,142 @@ func (r *KustomizationReconciler) reconcile(
 			err.Error(),
 		), err
 	}
+	kustomization.Status.Inventory = inventory
+	kustomization.Status.CustomResourceDefinitions = inventoryCRDs(inventory)
+
+	// wait for all the applied objects to become ready
+	if kustomization.Spec.Wait {
+		checks, err := inventoryHealthChecks(inventory)
+		if err != nil {
+			return kustomizev1.KustomizationNotReady(
+				kustomization,
+				source.GetArtifact().Revision,
+				kustomizev1.HealthCheckFailedReason,
+				err.Error(),
+			), err
+		}
+		kustomization.Spec.HealthChecks = mergeHealthChecks(kustomization.Spec.HealthChecks, checks)
+
+		// record the progress of the health assessment
+		kustomizev1.SetKustomizationCondition(&kustomization, meta.ReadyCondition, metav1.ConditionUnknown, meta.ProgressingReason,
+			fmt.Sprintf("waiting for %d objects to become ready", len(kustomization.Spec.HealthChecks)))
+		req := ctrl.Request{NamespacedName: types.NamespacedName{
+			Namespace: kustomization.GetNamespace(),
+			Name:      kustomization.GetName(),
+		}}
+		if err := r.patchStatus(ctx, req, kustomization.Status); err != nil {
+			logr.FromContext(ctx).Error(err, "unable to update status for health assessment")
+		}
+	}
 
 	// health assessment
-	err = r.checkHealth(ctx, statusPoller, kustomization, source.GetArtifact().Revision, changeSet != "")
+	healthCtx, span := startSpan(ctx, "health", attribute.Int("checks", len(kustomization.Spec.HealthChecks)))
+	err = r.checkHealth(healthCtx, kubeClient, statusPoller, kustomization, source.GetArtifact().Revision, results.changeSet() != "")
+	endSpan(span, err)
 	if err != nil {
+		// roll back to the last healthy revision, if enabled, the objects on
+		// the cluster then match the snapshot of the last applied revision
+		failedSnapshot := snapshot
+		if kustomization.Spec.Rollback && kustomization.Status.LastAppliedRevision != "" &&
+			kustomization.Status.LastAppliedRevision != source.GetArtifact().Revision {
+			kustomization, err = r.rollback(ctx, kubeClient, kustomization, source.GetArtifact().Revision, err)
+			var rolledBack *RolledBackError
+			if errors.As(err, &rolledBack) {
+				failedSnapshot = kustomization.Status.Snapshot
+			}
+		}
 		return kustomizev1.KustomizationNotReadySnapshot(
 			kustomization,
-			snapshot,
+			failedSnapshot,
 			source.GetArtifact().Revision,
 			kustomizev1.HealthCheckFailedReason,
 			err.Error(),
 		), err
 	}
 
-	return kustomizev1.KustomizationReady(
+	// run the post-apply hooks of a new revision
+	if runHooks {
+		if err := r.runHooks(ctx, kubeClient, kustomization, postApplyHook, kustomization.Spec.Hooks.PostApply,
+			source.GetArtifact().Revision, dirPath); err != nil {
+			return kustomizev1.KustomizationNotReadySnapshot(
+				kustomization,
+				snapshot,
+				source.GetArtifact().Revision,
+				kustomizev1.HookFailedReason,
+				err.Error(),
+			), err
+		}
+	}
+
+	// track the Flagger canary analysis of the configured Deployments
+	var canaryProgress []string
+	if r.canaryAnalysis {
+		analysis, progress, err := trackCanaries(ctx, kubeClient, kustomization, source.GetArtifact().Revision, results)
+		kustomization.Status.CanaryAnalysis = analysis
+		if err != nil {
+			reason := meta.ReconciliationFailedReason
+			var canaryErr *CanaryFailedError
+			if errors.As(err, &canaryErr) {
+				reason = kustomizev1.CanaryFailedReason
+			}
+			return kustomizev1.KustomizationNotReadySnapshot(
+				kustomization,
+				snapshot,
+				source.GetArtifact().Revision,
+				reason,
+				err.Error(),
+			), err
+		}
+		canaryProgress = progress
+	}
+
+	// record the cluster state of the managed objects
+	state, err := r.stateChecksum(ctx, kubeClient, kustomization, snapshot)
+	if err != nil {
+		logr.FromContext(ctx).Error(err, "unable to compute the cluster state checksum")
+	}
+
+	// record the number of objects per action and the applied revision
+	summary := newApplySummary(source.GetArtifact().Revision, results, pruneSet)
+	recordChangelog(&kustomization, newChangelogEntry(summary, time.Now()))
+
+	kustomization = kustomizev1.KustomizationReady(
 		kustomization,
 		snapshot,
 		source.GetArtifact().Revision,
 		meta.ReconciliationSucceededReason,
 		"Applied revision: "+source.GetArtifact().Revision,
-	), nil
-}
-
-func (r *KustomizationReconciler) checkDependencies(kustomization kustomizev1.Kustomization) error {
-	for _, d := range kustomization.Spec.DependsOn {
-		if d.Namespace == "" {
-			d.Namespace = kustomization.GetNamespace()
-		}
-		dName := types.NamespacedName(d)
-		var k kustomizev1.Kustomization
-		err := r.Get(context.Background(), dName, &k)
-		if err != nil {
-			return fmt.Errorf("unable to get '%s' dependency: %w", dName, err)
-		}
+	)
+	kustomization.Status.StateChecksum = state
+	kustomization.Status.LastApplySummary = summary
+	apimeta.RemoveStatusCondition(&kustomization.Status.Conditions, kustomizev1.RolledBackCondition)
 
-		if len(k.Status.Conditions) == 0 || k.Generation != k.Status.ObservedGeneration {
-			return fmt.Errorf("dependency '%s' is not ready", dName)
-		}
+	// the revision is applied, but not ready until the canary analysis completes
+	if len(canaryProgress) > 0 {
+		msg := "waiting for canary analysis: " + strings.Join(canaryProgress, ", ")
+		kustomizev1.SetKustomizationCondition(&kustomization, meta.ReadyCondition, metav1.ConditionUnknown, meta.ProgressingReason, msg)
+		kustomizev1.SetKustomizationCondition(&kustomization, meta.ReconcilingCondition, metav1.ConditionTrue, meta.ProgressingReason, msg)
+	}
 
-		if !apimeta.IsStatusConditionTrue(k.Status.Conditions, meta.ReadyCondition) {
-			return fmt.Errorf("dependency '%s' is not ready", dName)
+	// keep the artifact of the healthy revision for rollbacks
+	if archivePath != "" {
+		if err := r.artifactCache.store(kustomization, source.GetArtifact().Revision, source.GetArtifact().URL, archivePath); err != nil {
+			logr.FromContext(ctx).Error(err, "unable to store the artifact in the artifact cache")
 		}
+	} else if err := r.artifactCache.remove(kustomization); err != nil {
+		logr.FromContext(ctx).Error(err, "unable to remove the artifact from the artifact cache")
 	}
 
-	return nil
+	// back up the inventory, if enabled
+	if err := r.exportInventory(ctx, kubeClient, kustomization); err != nil {
+		logr.FromContext(ctx).Error(err, "unable to back up the inventory")
+	}
+	return kustomization, nil
 }
 
-func (r *KustomizationReconciler) download(artifactURL string, tmpDir string) error {
+// download fetches the artifact and extracts it into tmpDir, when archive
+// is not nil, the artifact is also copied to it as downloaded.
+func (r *KustomizationReconciler) download(ctx context.Context, artifactURL string, tmpDir string, archive io.Writer) error {
 	if hostname := os.Getenv("SOURCE_CONTROLLER_LOCALHOST"); hostname != "" {
 		u, err := url.Parse(artifactURL)
 		if err != nil {
@@ -441,25 +1243,51 @@ func (r *KustomizationReconciler) download(artifactURL string, tmpDir string) er
 		return fmt.Errorf("failed to create a new request: %w", err)
 	}
 
-	resp, err := r.httpClient.Do(req)
+	resp, err := r.httpClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return fmt.Errorf("failed to download artifact, error: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// check response
+	if isArtifactGone(resp.StatusCode) {
+		return &ArtifactNotFoundError{URL: artifactURL, Status: resp.Status}
+	}
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("failed to download artifact from %s, status: %s", artifactURL, resp.Status)
 	}
 
 	// extract
-	if _, err = untar.Untar(resp.Body, tmpDir); err != nil {
-		return fmt.Errorf("failed to untar artifact, error: %w", err)
+	var body io.Reader = resp.Body
+	if archive != nil {
+		body = io.TeeReader(resp.Body, archive)
+	}
+	if err = extractArtifact(body, artifactURL, tmpDir); err != nil {
+		return fmt.Errorf("failed to extract artifact, error: %w", err)
+	}
+	if archive != nil {
+		// copy the bytes left after the end of the tarball
+		if _, err := io.Copy(ioutil.Discard, body); err != nil {
+			return fmt.Errorf("failed to download artifact, error: %w", err)
+		}
 	}
 
 	return nil
 }
 
+// pendingRevision returns the artifact revision of the source if it differs
+// from the reconciled revision, or an empty string if it's unchanged.
+func (r *KustomizationReconciler) pendingRevision(ctx context.Context, kustomization kustomizev1.Kustomization, revision string) string {
+	source, err := r.getSource(ctx, kustomization)
+	if err != nil || source.GetArtifact() == nil {
+		return ""
+	}
+	if source.GetArtifact().Revision == revision {
+		return ""
+	}
+	return source.GetArtifact().Revision
+}
+
 func (r *KustomizationReconciler) getSource(ctx context.Context, kustomization kustomizev1.Kustomization) (sourcev1.Source, error) {
 	var source sourcev1.Source
 	sourceNamespace := kustomization.GetNamespace()
@@ -505,55 +1333,23 @@ func (r *KustomizationReconciler) generate(ctx context.Context, kubeClient clien
 
 func (r *KustomizationReconciler) build(ctx context.Context, kustomization kustomizev1.Kustomization, checksum, dirPath string) (*kustomizev1.Snapshot, error) {
 	timeout := kustomization.GetTimeout()
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	dec, cleanup, err := NewTempDecryptor(r.Client, kustomization)
+	m, err := buildResources(ctx, r.Client, kustomization, dirPath)
 	if err != nil {
 		return nil, err
 	}
-	defer cleanup()
-
-	// import OpenPGP keys if any
-	if err := dec.ImportKeys(ctx); err != nil {
-		return nil, err
-	}
-
 	fs := filesys.MakeFsOnDisk()
-	m, err := buildKustomization(fs, dirPath)
+
+	// set aside the hook Jobs, these are run instead of being applied
+	hooks, err := extractHookJobs(m, kustomization)
 	if err != nil {
 		return nil, fmt.Errorf("kustomize build failed: %w", err)
 	}
-
-	for _, res := range m.Resources() {
-		// check if resources are encrypted and decrypt them before generating the final YAML
-		if kustomization.Spec.Decryption != nil {
-			outRes, err := dec.Decrypt(res)
-			if err != nil {
-				return nil, fmt.Errorf("decryption failed for '%s': %w", res.GetName(), err)
-			}
-
-			if outRes != nil {
-				_, err = m.Replace(res)
-				if err != nil {
-					return nil, err
-				}
-			}
-		}
-
-		// run variable substitutions
-		if kustomization.Spec.PostBuild != nil {
-			outRes, err := substituteVariables(ctx, r.Client, kustomization, res)
-			if err != nil {
-				return nil, fmt.Errorf("var substitution failed for '%s': %w", res.GetName(), err)
-			}
-
-			if outRes != nil {
-				_, err = m.Replace(res)
-				if err != nil {
-					return nil, err
-				}
-			}
+	if hooks != nil {
+		if err := fs.WriteFile(hooksFile(dirPath, kustomization), hooks); err != nil {
+			return nil, err
 		}
 	}
 
@@ -570,178 +1366,419 @@ func (r *KustomizationReconciler) build(ctx context.Context, kustomization kusto
 	return kustomizev1.NewSnapshot(resources, checksum)
 }
 
-func (r *KustomizationReconciler) validate(ctx context.Context, kustomization kustomizev1.Kustomization, imp *KustomizeImpersonation, dirPath string) error {
-	if kustomization.Spec.Validation == "" || kustomization.Spec.Validation == "none" {
+func (r *KustomizationReconciler) validate(ctx context.Context, kubeClient client.Client, kustomization kustomizev1.Kustomization, dirPath string) error {
+	if kustomization.Spec.Validation == "none" {
 		return nil
 	}
 
-	log := logr.FromContext(ctx)
+	// default to server-side validation, skipping the kinds that are not served yet
+	mode, fallback := kustomization.Spec.Validation, false
+	if mode == "" {
+		mode, fallback = validation.ServerMode, true
+	}
+	// the atomic apply requires all the objects to pass the dry-run
+	if kustomization.Spec.Atomic {
+		mode, fallback = validation.ServerMode, false
+	}
+
 	timeout := kustomization.GetTimeout() + (time.Second * 1)
-	applyCtx, cancel := context.WithTimeout(ctx, timeout)
+	validateCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	validation := kustomization.Spec.Validation
-	if validation == "server" && kustomization.Spec.Force {
-		// Use client-side validation with force
-		validation = "client"
-		log.Info(fmt.Sprintf("Server-side validation is configured, falling-back to client-side validation since 'force' is enabled"))
+	stages, err := readStages(dirPath, kustomization)
+	if err != nil {
+		return err
 	}
 
-	cmd := fmt.Sprintf("cd %s && kubectl apply -f %s.yaml --timeout=%s --dry-run=%s --cache-dir=/tmp --force=%t",
-		dirPath, kustomization.GetUID(), kustomization.GetTimeout().String(), validation, kustomization.Spec.Force)
+	validator := validation.NewValidator(kubeClient, validation.Options{
+		Mode:      mode,
+		Force:     kustomization.Spec.Force,
+		Fallback:  fallback,
+		AllErrors: kustomization.Spec.Atomic,
+		// the dry-run honours the apply policy of the objects
+		DryRun: func(ctx context.Context, obj *unstructured.Unstructured) error {
+			opts, err := objectApplyOptions(kustomization, obj)
+			if err != nil {
+				return err
+			}
+			opts.dryRun = true
+			_, _, err = applyObject(ctx, kubeClient, obj, opts)
+			return err
+		},
+	})
+	return validator.Validate(validateCtx, stages.All())
+}
+
+// apply applies the manifests generated by the kustomize build in stages.
+// Namespaces are applied first, followed by the CRDs, then the controller
+// waits for the CRDs to be established before applying the rest of the objects.
+// Custom resources of the CRDs defined in the same build are applied last,
+// after the services backing the admission webhooks have ready endpoints.
+// Within the last two stages, the objects are applied in waves ordered
+// by their depends-on annotations.
+// When the deadline is exceeded, the apply is interrupted and the objects
+// applied so far are recorded in the checkpoint returned with the error.
+// When an atomic apply fails, the returned error is a PartialApplyError.
+func (r *KustomizationReconciler) apply(ctx context.Context, kubeClient client.Client, kustomization kustomizev1.Kustomization, dirPath, checksum string, deadline time.Time) (applyResults, error) {
+	stages, err := readStages(dirPath, kustomization)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := newApplyCheckpoint(kustomization, checksum,
+		len(stages.All()), deadline)
 
-	if kustomization.Spec.KubeConfig != nil {
-		kubeConfig, err := imp.WriteKubeConfig(ctx)
+	results, err := r.applyStages(ctx, kubeClient, kustomization, checkpoint, stages)
+	if err != nil {
+		var budgetErr *BudgetExceededError
+		if kustomization.Spec.Atomic && !errors.As(err, &budgetErr) {
+			return nil, newPartialApplyError(stages.All(), checkpoint, err)
+		}
+		return nil, err
+	}
+	return results, nil
+}
+
+// applyStages applies the stages in order, recording the applied objects in the checkpoint.
+func (r *KustomizationReconciler) applyStages(ctx context.Context, kubeClient client.Client, kustomization kustomizev1.Kustomization, checkpoint *applyCheckpoint, stages *KustomizeStages) (applyResults, error) {
+	log := logr.FromContext(ctx)
+	var results applyResults
+
+	if len(stages.Namespaces) > 0 {
+		output, err := r.applyObjects(ctx, kubeClient, kustomization, checkpoint, stages.Namespaces)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		cmd = fmt.Sprintf("%s --kubeconfig=%s", cmd, kubeConfig)
-	} else {
-		// impersonate SA
-		if kustomization.Spec.ServiceAccountName != "" {
-			saToken, err := imp.GetServiceAccountToken(ctx)
-			if err != nil {
-				return fmt.Errorf("service account impersonation failed: %w", err)
+		results = append(results, output...)
+	}
+
+	if len(stages.CRDs) > 0 {
+		output, err := r.applyObjects(ctx, kubeClient, kustomization, checkpoint, stages.CRDs)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, output...)
+
+		if err := waitForCRDs(ctx, kubeClient, stages.CRDs, kustomization.GetTimeout()); err != nil {
+			return nil, err
+		}
+		log.Info("CustomResourceDefinitions established", "count", len(stages.CRDs))
+	}
+
+	applied := append(append([]*unstructured.Unstructured{}, stages.Namespaces...), stages.CRDs...)
+	if len(stages.Objects) > 0 {
+		output, err := r.applyStage(ctx, kubeClient, kustomization, checkpoint, stages.Objects, applied)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, output...)
+	}
+
+	if len(stages.CustomResources) > 0 {
+		if webhooks := stages.Webhooks(); len(webhooks) > 0 {
+			if err := waitForWebhooks(ctx, kubeClient, webhooks, kustomization.GetTimeout()); err != nil {
+				return nil, err
 			}
+			log.Info("admission webhooks ready", "count", len(webhooks))
+		}
 
-			cmd = fmt.Sprintf("%s --token %s", cmd, saToken)
+		if kustomization.Spec.WaitForOperators {
+			if operators := findOperators(stages.Objects, stages.CRDs); len(operators) > 0 {
+				if err := waitForObjects(ctx, kubeClient, operators, kustomization.GetTimeout()); err != nil {
+					return nil, err
+				}
+				log.Info("operators ready", "count", len(operators))
+			}
+		}
+
+		applied = append(applied, stages.Objects...)
+		output, err := r.applyStage(ctx, kubeClient, kustomization, checkpoint, stages.CustomResources, applied)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, output...)
+	}
+
+	return results, nil
+}
+
+// applyStage applies the objects of a stage in waves, ordered by their
+// depends-on annotations. Before applying a wave, the controller waits
+// for the objects the wave depends on to become ready.
+func (r *KustomizationReconciler) applyStage(ctx context.Context, kubeClient client.Client, kustomization kustomizev1.Kustomization, checkpoint *applyCheckpoint, objects, applied []*unstructured.Unstructured) (applyResults, error) {
+	// the dependencies are matched against the namespaced object IDs
+	for _, obj := range objects {
+		if err := validation.SetDefaultNamespace(kubeClient.RESTMapper(), obj); err != nil {
+			return nil, fmt.Errorf("apply failed: %s %w", objectID(obj), err)
 		}
 	}
 
-	command := exec.CommandContext(applyCtx, "/bin/sh", "-c", cmd)
-	output, err := command.CombinedOutput()
+	waves, err := newApplyWaves(objects, applied)
 	if err != nil {
-		if errors.Is(applyCtx.Err(), context.DeadlineExceeded) {
-			return fmt.Errorf("validation timeout: %w", applyCtx.Err())
+		return nil, fmt.Errorf("apply failed: %w", err)
+	}
+
+	log := logr.FromContext(ctx)
+	var results applyResults
+	for _, wave := range waves {
+		if len(wave.Dependencies) > 0 {
+			if err := waitForObjects(ctx, kubeClient, wave.Dependencies, kustomization.GetTimeout()); err != nil {
+				return nil, err
+			}
+			log.Info("dependencies ready", "count", len(wave.Dependencies))
 		}
-		return fmt.Errorf("validation failed: %s", parseApplyError(output))
+
+		output, err := r.applyObjects(ctx, kubeClient, kustomization, checkpoint, wave.Objects)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, output...)
 	}
-	return nil
+	return results, nil
 }
 
-func (r *KustomizationReconciler) apply(ctx context.Context, kustomization kustomizev1.Kustomization, imp *KustomizeImpersonation, dirPath string) (string, error) {
+// objectApplyOptions returns the options for applying an object of the Kustomization,
+// the server-side dry-run uses the same options as the apply.
+func objectApplyOptions(kustomization kustomizev1.Kustomization, obj *unstructured.Unstructured) (applyObjectOptions, error) {
+	ignorePaths, err := ignoredPaths(kustomization, obj)
+	if err != nil {
+		return applyObjectOptions{}, err
+	}
+	return applyObjectOptions{
+		force:          kustomization.Spec.Force,
+		ignorePaths:    ignorePaths,
+		conflictPolicy: kustomization.Spec.ConflictPolicy,
+	}, nil
+}
+
+// applyError returns the error of a failed apply, prefixed with the object ID.
+// The conflict errors are returned as is, as they already list the object.
+func applyError(ctx context.Context, obj *unstructured.Unstructured, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("apply timeout: %w", ctx.Err())
+	}
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		return err
+	}
+	if denied := validation.ParseAdmissionDenial(err.Error()); denied != nil {
+		return fmt.Errorf("apply failed: %s %w", objectID(obj), denied)
+	}
+	return fmt.Errorf("apply failed: %s %w", objectID(obj), err)
+}
+
+// applyObjects applies the objects in batches using server-side apply,
+// and returns the list of objects that were created or configured.
+// The objects are applied in order unless the apply concurrency is
+// greater than one. The objects recorded in the checkpoint are skipped.
+func (r *KustomizationReconciler) applyObjects(ctx context.Context, kubeClient client.Client, kustomization kustomizev1.Kustomization, checkpoint *applyCheckpoint, objects []*unstructured.Unstructured) (applyResults, error) {
 	log := logr.FromContext(ctx)
 	start := time.Now()
 	timeout := kustomization.GetTimeout() + (time.Second * 1)
 	applyCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
-	fieldManager := "kustomize-controller"
 
-	cmd := fmt.Sprintf("cd %s && kubectl apply --field-manager=%s -f %s.yaml --timeout=%s --cache-dir=/tmp --force=%t",
-		dirPath, fieldManager, kustomization.GetUID(), kustomization.Spec.Interval.Duration.String(), kustomization.Spec.Force)
+	var pending []*unstructured.Unstructured
+	for _, obj := range objects {
+		if err := validation.SetDefaultNamespace(kubeClient.RESTMapper(), obj); err != nil {
+			return nil, fmt.Errorf("apply failed: %s %w", objectID(obj), err)
+		}
+		if !checkpoint.isApplied(obj) {
+			pending = append(pending, obj)
+		}
+	}
 
-	if kustomization.Spec.KubeConfig != nil {
-		kubeConfig, err := imp.WriteKubeConfig(ctx)
+	// the objects unchanged since the last apply are skipped
+	lastApplied := inventoryChecksums(kustomization.Status.Inventory)
+	var checksumsMu sync.Mutex
+	checksums := make(map[string]string)
+
+	apply := func(ctx context.Context, obj *unstructured.Unstructured) (string, error) {
+		applyOpts, err := objectApplyOptions(kustomization, obj)
 		if err != nil {
 			return "", err
 		}
-		cmd = fmt.Sprintf("%s --kubeconfig=%s", cmd, kubeConfig)
-	} else {
-		// impersonate SA
-		if kustomization.Spec.ServiceAccountName != "" {
-			saToken, err := imp.GetServiceAccountToken(ctx)
-			if err != nil {
-				return "", fmt.Errorf("service account impersonation failed: %w", err)
+		applyOpts.lastApplied = lastApplied[objectID(obj)]
+		action, checksum, err := applyObject(ctx, kubeClient, obj, applyOpts)
+		if err != nil {
+			return "", applyError(ctx, obj, err)
+		}
+		checksumsMu.Lock()
+		checksums[objectID(obj)] = checksum
+		checksumsMu.Unlock()
+		return action, nil
+	}
+
+	opts := r.applyOptionsFor(kustomization)
+	var results applyResults
+	batches := applyBatches(pending, opts.batchSize)
+	// the verification of all the batches is bounded by the Kustomization timeout
+	verifyDeadline := time.Now().Add(kustomization.GetTimeout())
+	for i, batch := range batches {
+		if i > 0 && opts.batchInterval > 0 {
+			select {
+			case <-applyCtx.Done():
+				return nil, fmt.Errorf("apply timeout: %w", applyCtx.Err())
+			case <-time.After(opts.batchInterval):
 			}
-
-			cmd = fmt.Sprintf("%s --token %s", cmd, saToken)
 		}
-	}
 
-	command := exec.CommandContext(applyCtx, "/bin/sh", "-c", cmd)
-	output, err := command.CombinedOutput()
-	if err != nil {
-		if errors.Is(applyCtx.Err(), context.DeadlineExceeded) {
-			return "", fmt.Errorf("apply timeout: %w", applyCtx.Err())
+		actions, err := applyBatch(applyCtx, checkpoint, batch, opts.concurrency, apply)
+		for _, obj := range batch {
+			action, ok := actions[objectID(obj)]
+			if !ok {
+				continue
+			}
+			results = append(results, appliedObject{ID: objectID(obj), Action: action, Checksum: checksums[objectID(obj)]})
 		}
-
-		if string(output) == "" {
-			return "", fmt.Errorf("apply failed: %w, kubectl process was killed, probably due to OOM", err)
+		if err != nil {
+			return nil, err
 		}
 
-		applyErr := parseApplyError(output)
-		if applyErr == "" {
-			applyErr = "no error output found, this may happen because of a timeout"
+		// wait for the batch to become ready before applying the next one
+		if opts.verifyBatches && i < len(batches)-1 {
+			if err := verifyBatch(applyCtx, kubeClient, batch, verifyDeadline); err != nil {
+				return nil, fmt.Errorf("batch %d/%d verification failed: %w", i+1, len(batches), err)
+			}
+			log.Info("batch ready", "batch", fmt.Sprintf("%d/%d", i+1, len(batches)), "count", len(batch))
 		}
-		return "", fmt.Errorf("apply failed: %s", applyErr)
 	}
 
-	resources := parseApplyOutput(output)
-	log.Info(
-		fmt.Sprintf("Kustomization applied in %s",
-			time.Now().Sub(start).String()),
-		"output", resources,
+	log.Info("Kustomization applied",
+		"duration", time.Now().Sub(start),
+		"objects", results.actionCounts(),
+		"output", results.output(log.V(1).Enabled()),
 	)
-
-	changeSet := ""
-	for obj, action := range resources {
-		if action != "" && action != "unchanged" {
-			changeSet += obj + " " + action + "\n"
-		}
-	}
-	return changeSet, nil
+	return results, nil
 }
 
-func (r *KustomizationReconciler) applyWithRetry(ctx context.Context, kustomization kustomizev1.Kustomization, imp *KustomizeImpersonation, revision, dirPath string, delay time.Duration) (string, error) {
+func (r *KustomizationReconciler) applyWithRetry(ctx context.Context, kubeClient client.Client, kustomization kustomizev1.Kustomization, revision, dirPath, checksum string, deadline time.Time, delay time.Duration) (applyResults, error) {
 	log := logr.FromContext(ctx)
-	changeSet, err := r.apply(ctx, kustomization, imp, dirPath)
+	results, err := r.apply(ctx, kubeClient, kustomization, dirPath, checksum, deadline)
 	if err != nil {
 		// retry apply due to CRD/CR race
 		if strings.Contains(err.Error(), "could not find the requested resource") ||
 			strings.Contains(err.Error(), "no matches for kind") {
 			log.Info("retrying apply", "error", err.Error())
-			time.Sleep(delay)
-			if changeSet, err := r.apply(ctx, kustomization, imp, dirPath); err != nil {
-				return "", err
-			} else {
-				if changeSet != "" {
-					r.event(ctx, kustomization, revision, events.EventSeverityInfo, changeSet, nil)
-				}
+			select {
+			case <-ctx.Done():
+				return nil, fmt.Errorf("apply interrupted: %w", ctx.Err())
+			case <-time.After(delay):
+			}
+			results, err = r.apply(ctx, kubeClient, kustomization, dirPath, checksum, deadline)
+			if err != nil {
+				return nil, err
+			}
+			if changeSet := results.changeSet(); changeSet != "" {
+				r.eventWithReason(ctx, kustomization, revision, events.EventSeverityInfo, appliedEventReason,
+					summarizeChangeSet(changeSet, r.verboseEvents), nil)
 			}
 		} else {
-			return "", err
+			return nil, err
 		}
 	} else {
-		if changeSet != "" && kustomization.Status.LastAppliedRevision != revision {
-			r.event(ctx, kustomization, revision, events.EventSeverityInfo, changeSet, nil)
+		if changeSet := results.changeSet(); changeSet != "" && kustomization.Status.LastAppliedRevision != revision {
+			r.eventWithReason(ctx, kustomization, revision, events.EventSeverityInfo, appliedEventReason,
+				summarizeChangeSet(changeSet, r.verboseEvents), nil)
 		}
 	}
-	return changeSet, nil
+	return results, nil
 }
 
-func (r *KustomizationReconciler) prune(ctx context.Context, kubeClient client.Client, kustomization kustomizev1.Kustomization, newChecksum string) error {
-	if !kustomization.Spec.Prune || kustomization.Status.Snapshot == nil {
-		return nil
+func (r *KustomizationReconciler) prune(ctx context.Context, kubeClient client.Client, kustomization kustomizev1.Kustomization, inventory *kustomizev1.ResourceInventory, newChecksum string) (string, error) {
+	// the deletion policy takes precedence over spec.prune for deleted Kustomizations
+	if !kustomization.Spec.Prune && kustomization.DeletionTimestamp.IsZero() {
+		return "", nil
+	}
+
+	// fallback to the label selector based garbage collection
+	// if the inventory was not recorded by the last reconciliation
+	if kustomization.Status.Inventory == nil {
+		return r.pruneSnapshot(ctx, kubeClient, kustomization, newChecksum)
+	}
+
+	stale := inventoryDiff(kustomization.Status.Inventory, inventory)
+	if len(stale) == 0 {
+		return "", nil
+	}
+
+	// the objects claimed by the inventory of other Kustomizations are not deleted
+	claims, err := r.inventoryClaims(ctx, kustomization, stale)
+	if err != nil {
+		return "", fmt.Errorf("garbage collection failed: unable to list the Kustomizations: %w", err)
+	}
+	stale, shared := excludeClaimed(stale, claims)
+
+	log := logr.FromContext(ctx)
+	gc := NewGarbageCollector(kubeClient, kustomizev1.Snapshot{}, newChecksum, r.pruneDisabledKinds(kustomization),
+		newPruneProtection(kustomization.Spec.PruneEnabledFor, r.controllerNamespace), log)
+
+	output, ok := gc.PruneInventory(ctx, kustomization.GetTimeout(),
+		stale,
+		kustomization.GetName(),
+		kustomization.GetNamespace(),
+	)
+	if shared = append(shared, gc.Shared()...); len(shared) > 0 {
+		msg := fmt.Sprintf("garbage collection skipped the objects shared with other Kustomizations: %s",
+			strings.Join(shared, ", "))
+		log.Info(msg)
+		r.eventWithReason(ctx, kustomization, newChecksum, events.EventSeverityError, pruneSkippedEventReason, msg, nil)
+	}
+	if !ok {
+		return "", fmt.Errorf("garbage collection failed: %s", output)
+	} else {
+		if output != "" {
+			log.Info("garbage collection completed", "objects", map[string]int{deletedAction: len(changeSetLines(output))},
+				"output", changeSetLines(output))
+			r.eventWithReason(ctx, kustomization, newChecksum, events.EventSeverityInfo, prunedEventReason,
+				summarizeChangeSet(output, r.verboseEvents), nil)
+		}
+		return output, nil
+	}
+}
+
+func (r *KustomizationReconciler) pruneSnapshot(ctx context.Context, kubeClient client.Client, kustomization kustomizev1.Kustomization, newChecksum string) (string, error) {
+	if kustomization.Status.Snapshot == nil {
+		return "", nil
 	}
 	if kustomization.DeletionTimestamp.IsZero() && kustomization.Status.Snapshot.Checksum == newChecksum {
-		return nil
+		return "", nil
 	}
 
 	log := logr.FromContext(ctx)
-	gc := NewGarbageCollector(kubeClient, *kustomization.Status.Snapshot, newChecksum, logr.FromContext(ctx))
+	gc := NewGarbageCollector(kubeClient, *kustomization.Status.Snapshot, newChecksum, r.pruneDisabledKinds(kustomization),
+		newPruneProtection(kustomization.Spec.PruneEnabledFor, r.controllerNamespace), log)
 
-	if output, ok := gc.Prune(kustomization.GetTimeout(),
+	if output, ok := gc.Prune(ctx, kustomization.GetTimeout(),
 		kustomization.GetName(),
 		kustomization.GetNamespace(),
 	); !ok {
-		return fmt.Errorf("garbage collection failed: %s", output)
+		return "", fmt.Errorf("garbage collection failed: %s", output)
 	} else {
 		if output != "" {
-			log.Info(fmt.Sprintf("garbage collection completed: %s", output))
-			r.event(ctx, kustomization, newChecksum, events.EventSeverityInfo, output, nil)
+			log.Info("garbage collection completed", "objects", map[string]int{deletedAction: len(changeSetLines(output))},
+				"output", changeSetLines(output))
+			r.eventWithReason(ctx, kustomization, newChecksum, events.EventSeverityInfo, prunedEventReason,
+				summarizeChangeSet(output, r.verboseEvents), nil)
 		}
+		return output, nil
 	}
-	return nil
 }
 
-func (r *KustomizationReconciler) checkHealth(ctx context.Context, statusPoller *polling.StatusPoller, kustomization kustomizev1.Kustomization, revision string, changed bool) error {
+// pruneDisabledKinds returns the kinds excluded from garbage collection
+// by the controller flags and by the Kustomization spec.
+func (r *KustomizationReconciler) pruneDisabledKinds(kustomization kustomizev1.Kustomization) []string {
+	kinds := append([]string{}, r.pruneDisabledFor...)
+	return append(kinds, kustomization.Spec.PruneDisabledFor...)
+}
+
+func (r *KustomizationReconciler) checkHealth(ctx context.Context, kubeClient client.Client, statusPoller *polling.StatusPoller, kustomization kustomizev1.Kustomization, revision string, changed bool) error {
 	if len(kustomization.Spec.HealthChecks) == 0 {
 		return nil
 	}
 
-	hc := NewHealthCheck(kustomization, statusPoller)
+	hc := NewHealthCheck(kustomization, statusPoller, kubeClient)
 
-	if err := hc.Assess(1 * time.Second); err != nil {
+	if err := hc.Assess(ctx, 1*time.Second); err != nil {
 		return err
 	}
 
@@ -749,35 +1786,79 @@ func (r *KustomizationReconciler) checkHealth(ctx context.Context, statusPoller
 	healthy := healthiness != nil && healthiness.Status == metav1.ConditionTrue
 
 	if !healthy || (kustomization.Status.LastAppliedRevision != revision && changed) {
-		r.event(ctx, kustomization, revision, events.EventSeverityInfo, "Health check passed", nil)
+		r.eventWithReason(ctx, kustomization, revision, events.EventSeverityInfo, healthCheckPassedEventReason, "Health check passed", nil)
 	}
 	return nil
 }
 
 func (r *KustomizationReconciler) reconcileDelete(ctx context.Context, kustomization kustomizev1.Kustomization) (ctrl.Result, error) {
 	log := logr.FromContext(ctx)
-	if kustomization.Spec.Prune && !kustomization.Spec.Suspend {
+	if kustomization.GetDeletionPolicy() == kustomizev1.DeletionPolicyDelete && !kustomization.Spec.Suspend &&
+		kustomization.Spec.Mode != kustomizev1.DiffOnlyMode {
+		// defer the garbage collection until the read-only mode is lifted
+		if r.readOnly {
+			log.Info("Garbage collection deferred in read-only mode", "retryAfter", kustomization.GetRetryInterval())
+			return ctrl.Result{RequeueAfter: kustomization.GetRetryInterval()}, nil
+		}
+
+		req := ctrl.Request{NamespacedName: types.NamespacedName{
+			Namespace: kustomization.GetNamespace(),
+			Name:      kustomization.GetName(),
+		}}
+
+		// record the garbage collection progress
+		msg := "garbage collection in progress"
+		if kustomization.Status.Inventory != nil {
+			msg = fmt.Sprintf("deleting %d objects", len(kustomization.Status.Inventory.Entries))
+		}
+		kustomization = kustomizev1.KustomizationGarbageCollecting(kustomization, msg)
+		if err := r.patchStatus(ctx, req, kustomization.Status); err != nil {
+			log.Error(err, "unable to update status for garbage collection")
+			return ctrl.Result{Requeue: true}, err
+		}
+
 		// create any necessary kube-clients
-		imp := NewKustomizeImpersonation(kustomization, r.Client, r.StatusPoller, "")
+		imp := NewKustomizeImpersonation(kustomization, r.Client, r.StatusPoller, r.discoveryOptions, r.kubeConfigOptions)
 		client, _, err := imp.GetClient(ctx)
 		if err != nil {
 			err = fmt.Errorf("failed to build kube client for Kustomization: %w", err)
 			log.Error(err, "Unable to prune for finalizer")
 			return ctrl.Result{}, err
 		}
-		if err := r.prune(ctx, client, kustomization, ""); err != nil {
+		_, err = r.prune(ctx, client, kustomization, nil, "")
+		// delete the objects applied to the clusters of spec.clusters, if any
+		for i := 0; err == nil && i < len(kustomization.Status.Clusters); i++ {
+			err = r.pruneCluster(ctx, kustomization, kustomization.Status.Clusters[i])
+		}
+		if err != nil {
+			kustomization = kustomizev1.KustomizationNotReady(kustomization, kustomization.Status.LastAppliedRevision,
+				kustomizev1.PruneFailedReason, err.Error())
+			if err := r.patchStatus(ctx, req, kustomization.Status); err != nil {
+				log.Error(err, "unable to update status for garbage collection failure")
+			}
 			r.event(ctx, kustomization, kustomization.Status.LastAppliedRevision, events.EventSeverityError, "pruning for deleted resource failed", nil)
 			// Return the error so we retry the failed garbage collection
 			return ctrl.Result{}, err
 		}
 	}
 
+	if err := r.artifactCache.remove(kustomization); err != nil {
+		log.Error(err, "unable to remove the artifact from the artifact cache")
+	}
+	r.permissions.forget(kustomization)
+	r.evictRemoteClients(kustomization)
+	if r.driftWatcher != nil {
+		r.driftWatcher.forget(ObjectKey(&kustomization))
+	}
+
 	// Record deleted status
 	r.recordReadiness(ctx, kustomization)
 
-	// Remove our finalizer from the list and update it
+	// Remove our finalizer from the list and patch it,
+	// the in-memory spec may contain the namespace defaults
+	patch := client.MergeFrom(kustomization.DeepCopy())
 	controllerutil.RemoveFinalizer(&kustomization, kustomizev1.KustomizationFinalizer)
-	if err := r.Update(ctx, &kustomization); err != nil {
+	if err := r.Patch(ctx, &kustomization, patch); err != nil {
 		return ctrl.Result{}, err
 	}
 
@@ -785,9 +1866,24 @@ func (r *KustomizationReconciler) reconcileDelete(ctx context.Context, kustomiza
 	return ctrl.Result{}, nil
 }
 
+// event issues an event with the reason of the Ready condition,
+// the events of the error severity are recorded as warnings.
 func (r *KustomizationReconciler) event(ctx context.Context, kustomization kustomizev1.Kustomization, revision, severity, msg string, metadata map[string]string) {
+	r.eventWithReason(ctx, kustomization, revision, severity, "", msg, metadata)
+}
+
+// eventWithReason issues an event with the given reason,
+// or with the reason of the Ready condition if empty.
+func (r *KustomizationReconciler) eventWithReason(ctx context.Context, kustomization kustomizev1.Kustomization, revision, severity, reason, msg string, metadata map[string]string) {
 	log := logr.FromContext(ctx)
-	r.EventRecorder.Event(&kustomization, "Normal", severity, msg)
+	if reason == "" {
+		reason = severity
+		if c := apimeta.FindStatusCondition(kustomization.Status.Conditions, meta.ReadyCondition); c != nil {
+			reason = c.Reason
+		}
+	}
+
+	r.EventRecorder.Event(&kustomization, eventType(severity), reason, msg)
 	objRef, err := reference.GetReference(r.Scheme, &kustomization)
 	if err != nil {
 		log.Error(err, "unable to send event")
@@ -802,12 +1898,7 @@ func (r *KustomizationReconciler) event(ctx context.Context, kustomization kusto
 			metadata["revision"] = revision
 		}
 
-		reason := severity
-		if c := apimeta.FindStatusCondition(kustomization.Status.Conditions, meta.ReadyCondition); c != nil {
-			reason = c.Reason
-		}
-
-		if err := r.ExternalEventRecorder.Eventf(*objRef, metadata, severity, reason, msg); err != nil {
+		if err := r.ExternalEventRecorder.Eventf(*objRef, metadata, severity, reason, "%s", msg); err != nil {
 			log.Error(err, "unable to send event")
 			return
 		}
@@ -815,6 +1906,7 @@ func (r *KustomizationReconciler) event(ctx context.Context, kustomization kusto
 }
 
 func (r *KustomizationReconciler) recordReadiness(ctx context.Context, kustomization kustomizev1.Kustomization) {
+	recordReady(kustomization)
 	if r.MetricsRecorder == nil {
 		return
 	}
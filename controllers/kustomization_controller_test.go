@@ -39,7 +39,6 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/fluxcd/pkg/apis/meta"
-	"github.com/fluxcd/pkg/runtime/dependency"
 	"github.com/fluxcd/pkg/testserver"
 	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
 
@@ -396,7 +395,7 @@ spec:
 						Interval:   metav1.Duration{Duration: reconciliationInterval},
 						Path:       "./",
 						Prune:      true,
-						DependsOn: []dependency.CrossNamespaceDependencyReference{
+						DependsOn: []kustomizev1.DependencyReference{
 							{
 								Name: "test-kustomization",
 							},
@@ -0,0 +1,74 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// trackProgress records the time at which the controller started to reconcile
+// the attempted revision or generation, until the Kustomization becomes ready.
+// When the progress deadline is exceeded, the Kustomization is marked as stalled
+// and trackProgress returns true.
+func trackProgress(k *kustomizev1.Kustomization, previous kustomizev1.KustomizationStatus, reconcileErr error, now time.Time) bool {
+	if apimeta.IsStatusConditionTrue(k.Status.Conditions, meta.ReadyCondition) {
+		k.Status.ProgressingSince = nil
+		return false
+	}
+
+	since := previous.ProgressingSince
+	if since == nil || previous.LastAttemptedRevision != k.Status.LastAttemptedRevision ||
+		previous.ObservedGeneration != k.Status.ObservedGeneration {
+		since = &metav1.Time{Time: now}
+	}
+	k.Status.ProgressingSince = since
+
+	deadline := k.Spec.ProgressDeadline
+	if deadline == nil || now.Sub(since.Time) < deadline.Duration {
+		return false
+	}
+
+	msg := fmt.Sprintf("not ready after %s, progress deadline of %s exceeded",
+		now.Sub(since.Time).Round(time.Second).String(), deadline.Duration.String())
+	if blockers := progressBlockers(*k, reconcileErr); blockers != "" {
+		msg = fmt.Sprintf("%s, blocked by: %s", msg, blockers)
+	}
+	kustomizev1.SetKustomizationStalled(k, kustomizev1.ProgressDeadlineExceededReason, trimMessage(msg, kustomizev1.MaxConditionMessageLength))
+	return true
+}
+
+// progressBlockers returns the objects that failed the health checks,
+// or the message of the Ready condition for the other failures.
+func progressBlockers(k kustomizev1.Kustomization, reconcileErr error) string {
+	var healthErr *HealthCheckError
+	if errors.As(reconcileErr, &healthErr) {
+		return strings.Join(healthErr.NotReady, ", ")
+	}
+	if ready := apimeta.FindStatusCondition(k.Status.Conditions, meta.ReadyCondition); ready != nil {
+		return ready.Message
+	}
+	return ""
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestTrackProgress(t *testing.T) {
+	now := time.Now()
+	start := metav1.NewTime(now.Add(-20 * time.Minute))
+	healthErr := &HealthCheckError{
+		NotReady: []string{"Deployment 'apps/backend' (status 'InProgress')"},
+		Total:    2,
+	}
+
+	newKustomization := func(revision string, ready metav1.ConditionStatus) kustomizev1.Kustomization {
+		k := kustomizev1.Kustomization{}
+		k.Spec.ProgressDeadline = &metav1.Duration{Duration: 15 * time.Minute}
+		k.Status.LastAttemptedRevision = revision
+		meta.SetResourceCondition(&k, meta.ReadyCondition, ready, meta.ProgressingReason, "waiting")
+		return k
+	}
+
+	tests := []struct {
+		name         string
+		revision     string
+		ready        metav1.ConditionStatus
+		err          error
+		wantStalled  bool
+		wantSince    *metav1.Time
+		wantBlockers string
+	}{
+		{
+			name:         "stalled after the deadline",
+			revision:     "main/1",
+			ready:        metav1.ConditionFalse,
+			err:          healthErr,
+			wantStalled:  true,
+			wantSince:    &start,
+			wantBlockers: "blocked by: Deployment 'apps/backend' (status 'InProgress')",
+		},
+		{
+			name:         "stalled with the ready message",
+			revision:     "main/1",
+			ready:        metav1.ConditionUnknown,
+			wantStalled:  true,
+			wantSince:    &start,
+			wantBlockers: "blocked by: waiting",
+		},
+		{
+			name:      "restarted by a new revision",
+			revision:  "main/2",
+			ready:     metav1.ConditionFalse,
+			err:       healthErr,
+			wantSince: &metav1.Time{Time: now},
+		},
+		{
+			name:     "cleared when ready",
+			revision: "main/1",
+			ready:    metav1.ConditionTrue,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := kustomizev1.KustomizationStatus{
+				LastAttemptedRevision: "main/1",
+				ProgressingSince:      &start,
+			}
+			k := newKustomization(tt.revision, tt.ready)
+
+			stalled := trackProgress(&k, previous, tt.err, now)
+			if stalled != tt.wantStalled {
+				t.Fatalf("expected stalled %v, got %v", tt.wantStalled, stalled)
+			}
+			if got := k.Status.ProgressingSince; (got == nil) != (tt.wantSince == nil) ||
+				(got != nil && !got.Time.Equal(tt.wantSince.Time)) {
+				t.Errorf("expected progressing since %v, got %v", tt.wantSince, got)
+			}
+
+			cond := apimeta.FindStatusCondition(k.Status.Conditions, meta.StalledCondition)
+			if !tt.wantStalled {
+				if cond != nil {
+					t.Errorf("unexpected stalled condition: %s", cond.Message)
+				}
+				return
+			}
+			if cond == nil || cond.Reason != kustomizev1.ProgressDeadlineExceededReason {
+				t.Fatalf("expected stalled condition with reason %s, got %v", kustomizev1.ProgressDeadlineExceededReason, cond)
+			}
+			if !strings.Contains(cond.Message, tt.wantBlockers) {
+				t.Errorf("expected message containing '%s', got '%s'", tt.wantBlockers, cond.Message)
+			}
+		})
+	}
+}
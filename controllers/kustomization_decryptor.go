@@ -127,7 +127,7 @@ func (kd *KustomizeDecryptor) Decrypt(res *resource.Resource) (*resource.Resourc
 
 				data, err := base64.StdEncoding.DecodeString(value)
 				if err != nil {
-					fmt.Println("Base64 Decode: %w", err)
+					return nil, fmt.Errorf("Base64 Decode of '%s': %w", key, err)
 				}
 
 				if bytes.Contains(data, []byte("sops")) && bytes.Contains(data, []byte("ENC[")) {
@@ -206,7 +206,7 @@ func (kd *KustomizeDecryptor) ImportKeys(ctx context.Context) error {
 				if err := ioutil.WriteFile(keyPath, file, os.ModePerm); err != nil {
 					return fmt.Errorf("unable to write key to storage: %w", err)
 				}
-				if err := kd.gpgImport(keyPath); err != nil {
+				if err := kd.gpgImport(ctx, keyPath); err != nil {
 					return err
 				}
 			case ".agekey":
@@ -219,12 +219,12 @@ func (kd *KustomizeDecryptor) ImportKeys(ctx context.Context) error {
 	return nil
 }
 
-func (kd *KustomizeDecryptor) gpgImport(path string) error {
+func (kd *KustomizeDecryptor) gpgImport(ctx context.Context, path string) error {
 	args := []string{"--batch", "--import", path}
 	if kd.homeDir != "" {
 		args = append([]string{"--homedir", kd.homeDir}, args...)
 	}
-	cmd := exec.Command("gpg", args...)
+	cmd := exec.CommandContext(ctx, "gpg", args...)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("gpg import error: %s", string(out))
@@ -0,0 +1,131 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestGetDeletionPolicy(t *testing.T) {
+	disabled := false
+	tests := []struct {
+		name   string
+		policy string
+		prune  bool
+		config *kustomizev1.NamespaceConfigSpec
+		want   string
+	}{
+		{name: "explicit delete", policy: kustomizev1.DeletionPolicyDelete, want: kustomizev1.DeletionPolicyDelete},
+		{name: "explicit orphan", policy: kustomizev1.DeletionPolicyOrphan, prune: true, want: kustomizev1.DeletionPolicyOrphan},
+		{name: "fallback to prune enabled", prune: true, want: kustomizev1.DeletionPolicyDelete},
+		{name: "fallback to prune disabled", want: kustomizev1.DeletionPolicyOrphan},
+		{
+			name:   "prune disabled by the namespace config",
+			prune:  true,
+			config: &kustomizev1.NamespaceConfigSpec{Prune: &disabled},
+			want:   kustomizev1.DeletionPolicyOrphan,
+		},
+		{
+			name:   "explicit delete with prune disabled by the namespace config",
+			policy: kustomizev1.DeletionPolicyDelete,
+			config: &kustomizev1.NamespaceConfigSpec{Prune: &disabled},
+			want:   kustomizev1.DeletionPolicyDelete,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := kustomizev1.Kustomization{
+				Spec: kustomizev1.KustomizationSpec{DeletionPolicy: tt.policy, Prune: tt.prune},
+			}
+			if tt.config != nil {
+				mergeNamespaceConfig(&k, *tt.config)
+			}
+			if got := k.GetDeletionPolicy(); got != tt.want {
+				t.Errorf("GetDeletionPolicy() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileDeletePrune(t *testing.T) {
+	disabled := false
+	tests := []struct {
+		name       string
+		policy     string
+		prune      bool
+		config     *kustomizev1.NamespaceConfigSpec
+		wantPruned bool
+	}{
+		{name: "delete policy", policy: kustomizev1.DeletionPolicyDelete, wantPruned: true},
+		{name: "orphan policy", policy: kustomizev1.DeletionPolicyOrphan, prune: true, wantPruned: false},
+		{name: "prune enabled", prune: true, wantPruned: true},
+		{name: "prune disabled", wantPruned: false},
+		{
+			name:       "prune disabled by the namespace config",
+			prune:      true,
+			config:     &kustomizev1.NamespaceConfigSpec{Prune: &disabled},
+			wantPruned: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Namespace: "apps",
+				Name:      "backend",
+				Labels:    selectorLabels("apps", "flux-system"),
+			}}
+			now := metav1.Now()
+			k := newTestKustomization()
+			k.DeletionTimestamp = &now
+			k.Spec.DeletionPolicy = tt.policy
+			k.Spec.Prune = tt.prune
+			k.Status.Inventory = &kustomizev1.ResourceInventory{Entries: []kustomizev1.ResourceRef{
+				{ID: "apps_backend__ConfigMap", Version: "v1"},
+			}}
+			objects := []client.Object{configMap, k}
+			if tt.config != nil {
+				objects = append(objects, &kustomizev1.NamespaceConfig{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "flux-system", Name: kustomizev1.NamespaceConfigName},
+					Spec:       *tt.config,
+				})
+			}
+			r := newTestReconciler(t, objects...)
+
+			if _, err := reconcileRequest(t, r, k); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			err := r.Get(context.TODO(), client.ObjectKeyFromObject(configMap), &corev1.ConfigMap{})
+			if pruned := apierrors.IsNotFound(err); pruned != tt.wantPruned {
+				t.Errorf("expected pruned to be %v, got error %v", tt.wantPruned, err)
+			}
+
+			// the object is deleted once the finalizer is removed
+			if err := r.Get(context.TODO(), ObjectKey(k), &kustomizev1.Kustomization{}); !apierrors.IsNotFound(err) {
+				t.Errorf("expected the finalizer to be removed, got error %v", err)
+			}
+		})
+	}
+}
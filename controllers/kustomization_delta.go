@@ -0,0 +1,86 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// allowDeltaAnnotation is the annotation used to apply a revision
+// that exceeds the max delta, its value must match the source revision.
+var allowDeltaAnnotation = fmt.Sprintf("%s/allow-delta", kustomizev1.GroupVersion.Group)
+
+// DeltaExceededError is returned when a revision modifies
+// or deletes more inventory objects than allowed.
+type DeltaExceededError struct {
+	// Revision is the source revision that was not applied.
+	Revision string
+	// Changes holds the objects that would be modified or deleted.
+	Changes *objectChanges
+	// Total is the number of objects in the inventory.
+	Total int
+	// MaxDelta is the maximum number of objects allowed to change.
+	MaxDelta int
+}
+
+func (e *DeltaExceededError) Error() string {
+	return fmt.Sprintf("revision %s modifies or deletes %d/%d objects, exceeding the max delta of %d objects (%s), "+
+		"annotate the Kustomization with %s=%s to apply it",
+		e.Revision, len(e.Changes.Configured)+len(e.Changes.Deleted), e.Total, e.MaxDelta, e.Changes.String(),
+		allowDeltaAnnotation, e.Revision)
+}
+
+// maxDeltaFor returns the max delta of the Kustomization,
+// or the controller default if not specified.
+func (r *KustomizationReconciler) maxDeltaFor(kustomization kustomizev1.Kustomization) *intstr.IntOrString {
+	if kustomization.Spec.MaxDelta != nil {
+		return kustomization.Spec.MaxDelta
+	}
+	return r.maxDelta
+}
+
+// checkMaxDelta returns an error if the changes modify or delete more objects
+// of the inventory than allowed by the max delta. The objects to be created are
+// not accounted for. The check is skipped for the first apply, and for the
+// revision set in the allow delta annotation.
+func checkMaxDelta(kustomization kustomizev1.Kustomization, revision string, changes *objectChanges, maxDelta *intstr.IntOrString) error {
+	if maxDelta == nil || kustomization.Status.Inventory == nil {
+		return nil
+	}
+	if kustomization.GetAnnotations()[allowDeltaAnnotation] == revision {
+		return nil
+	}
+
+	total := len(kustomization.Status.Inventory.Entries)
+	max, err := intstr.GetScaledValueFromIntOrPercent(maxDelta, total, false)
+	if err != nil {
+		return fmt.Errorf("invalid max delta: %w", err)
+	}
+	if len(changes.Configured)+len(changes.Deleted) <= max {
+		return nil
+	}
+	return &DeltaExceededError{
+		Revision: revision,
+		Changes:  changes,
+		Total:    total,
+		MaxDelta: max,
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestCheckMaxDelta(t *testing.T) {
+	inventory := &kustomizev1.ResourceInventory{}
+	for i := 0; i < 10; i++ {
+		inventory.Entries = append(inventory.Entries, kustomizev1.ResourceRef{ID: fmt.Sprintf("default_cm%d__ConfigMap", i), Version: "v1"})
+	}
+	changes := &objectChanges{
+		Created:    []string{"configmap/default/new"},
+		Configured: []string{"configmap/default/cm0"},
+		Deleted:    []string{"configmap/default/cm1", "configmap/default/cm2"},
+	}
+	percent := intstr.FromString("30%")
+	count := intstr.FromInt(2)
+	invalid := intstr.FromString("a lot")
+
+	tests := []struct {
+		name        string
+		inventory   *kustomizev1.ResourceInventory
+		annotations map[string]string
+		maxDelta    *intstr.IntOrString
+		exceeded    bool
+		wantErr     bool
+	}{
+		{name: "disabled", inventory: inventory},
+		{name: "first apply", maxDelta: &count},
+		{name: "within percentage", inventory: inventory, maxDelta: &percent},
+		{name: "exceeds count", inventory: inventory, maxDelta: &count, exceeded: true},
+		{
+			name:        "allowed revision",
+			inventory:   inventory,
+			annotations: map[string]string{allowDeltaAnnotation: "main/abc"},
+			maxDelta:    &count,
+		},
+		{
+			name:        "allowed other revision",
+			inventory:   inventory,
+			annotations: map[string]string{allowDeltaAnnotation: "main/def"},
+			maxDelta:    &count,
+			exceeded:    true,
+		},
+		{name: "invalid", inventory: inventory, maxDelta: &invalid, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kustomization := kustomizev1.Kustomization{}
+			kustomization.SetAnnotations(tt.annotations)
+			kustomization.Status.Inventory = tt.inventory
+
+			err := checkMaxDelta(kustomization, "main/abc", changes, tt.maxDelta)
+			var deltaErr *DeltaExceededError
+			if exceeded := errors.As(err, &deltaErr); exceeded != tt.exceeded {
+				t.Errorf("expected exceeded %v, got %v", tt.exceeded, err)
+			}
+			if tt.wantErr != (err != nil && !tt.exceeded) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if deltaErr != nil && (deltaErr.MaxDelta != 2 || deltaErr.Total != 10) {
+				t.Errorf("expected max delta 2/10, got %d/%d", deltaErr.MaxDelta, deltaErr.Total)
+			}
+		})
+	}
+}
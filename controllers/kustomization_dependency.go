@@ -0,0 +1,267 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/google/cel-go/cel"
+	celtypes "github.com/google/cel-go/common/types"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// +kubebuilder:rbac:groups=helm.toolkit.fluxcd.io,resources=helmreleases,verbs=get
+
+// dependencyAPIVersions maps the kinds of the toolkit objects
+// to the API version used when a dependency doesn't specify one.
+var dependencyAPIVersions = map[string]string{
+	"HelmRelease": "helm.toolkit.fluxcd.io/v2beta1",
+}
+
+// dependencyAPIVersion returns the API version of the dependency,
+// defaulting to the API version of the known kinds.
+func dependencyAPIVersion(d kustomizev1.DependencyReference) string {
+	if d.APIVersion != "" {
+		return d.APIVersion
+	}
+	return dependencyAPIVersions[d.Kind]
+}
+
+// DependencyTimeoutError is returned when a dependency is not ready
+// within its timeout, and its policy is not to proceed without it.
+type DependencyTimeoutError struct {
+	Timeout time.Duration
+	Policy  string
+	Err     error
+}
+
+func (e *DependencyTimeoutError) Error() string {
+	return fmt.Sprintf("dependencies not ready after %s: %v", e.Timeout.String(), e.Err)
+}
+
+func (e *DependencyTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// dependencyTimeoutExceeded reports whether the Kustomization has been waiting
+// for its dependencies for longer than the dependency timeout, if any.
+func dependencyTimeoutExceeded(kustomization kustomizev1.Kustomization, now time.Time) bool {
+	return waitExceeded(kustomization, kustomization.Spec.DependencyTimeout, now)
+}
+
+// dependencyTimeout returns the timeout of the dependency,
+// defaulting to the dependency timeout of the Kustomization.
+func dependencyTimeout(kustomization kustomizev1.Kustomization, d kustomizev1.DependencyReference) *metav1.Duration {
+	if d.Timeout != nil {
+		return d.Timeout
+	}
+	return kustomization.Spec.DependencyTimeout
+}
+
+// waitExceeded reports whether the Kustomization has been
+// waiting for its dependencies for longer than the timeout.
+func waitExceeded(kustomization kustomizev1.Kustomization, timeout *metav1.Duration, now time.Time) bool {
+	if timeout == nil || kustomization.Status.DependencyWaitStartTime == nil {
+		return false
+	}
+	deadline := kustomization.Status.DependencyWaitStartTime.Add(timeout.Duration)
+	return now.After(deadline)
+}
+
+// dependencyWaitTime returns for how long the Kustomization
+// has been waiting for its dependencies, rounded to the second.
+func dependencyWaitTime(kustomization kustomizev1.Kustomization, now time.Time) time.Duration {
+	if kustomization.Status.DependencyWaitStartTime == nil {
+		return 0
+	}
+	return now.Sub(kustomization.Status.DependencyWaitStartTime.Time).Round(time.Second)
+}
+
+// checkDependencies returns an error if a dependency is not ready, or if it's not ready
+// at the given source revision when the dependency requires a matching revision.
+// The dependencies not ready within their timeout with the Proceed policy are skipped
+// and returned, the other dependencies past their timeout return a DependencyTimeoutError.
+func (r *KustomizationReconciler) checkDependencies(ctx context.Context, kustomization kustomizev1.Kustomization, revision string, now time.Time) ([]string, error) {
+	var skipped []string
+	// the dependency objects are read with the client used to apply the
+	// manifests, a tenant can only depend on the objects it can read
+	var kubeClient client.Client
+	for _, d := range kustomization.Spec.DependsOn {
+		if d.Namespace == "" {
+			d.Namespace = kustomization.GetNamespace()
+		}
+		var err error
+		if d.IsKustomization() {
+			err = r.checkDependency(ctx, d, revision)
+		} else {
+			if kubeClient == nil {
+				imp := NewKustomizeImpersonation(kustomization, r.Client, r.StatusPoller, r.discoveryOptions, r.kubeConfigOptions)
+				if kubeClient, _, err = imp.GetClient(ctx); err != nil {
+					return skipped, fmt.Errorf("failed to build kube client: %w", err)
+				}
+			}
+			err = r.checkObjectDependency(ctx, kubeClient, d)
+		}
+		if err == nil {
+			continue
+		}
+
+		timeout := dependencyTimeout(kustomization, d)
+		if !waitExceeded(kustomization, timeout, now) {
+			return skipped, err
+		}
+		if d.OnTimeout == kustomizev1.ProceedDependencyPolicy {
+			skipped = append(skipped, d.String())
+			continue
+		}
+		policy := d.OnTimeout
+		if policy == "" {
+			policy = kustomizev1.WaitDependencyPolicy
+		}
+		return skipped, &DependencyTimeoutError{Timeout: timeout.Duration, Policy: policy, Err: err}
+	}
+	return skipped, nil
+}
+
+// checkDependency returns an error if the Kustomization dependency is not ready, or if it's
+// not ready at the given source revision when the dependency requires a matching revision.
+func (r *KustomizationReconciler) checkDependency(ctx context.Context, d kustomizev1.DependencyReference, revision string) error {
+	dName := types.NamespacedName{Namespace: d.Namespace, Name: d.Name}
+	var k kustomizev1.Kustomization
+	if err := r.Get(ctx, dName, &k); err != nil {
+		return fmt.Errorf("unable to get '%s' dependency: %w", dName, err)
+	}
+
+	if len(k.Status.Conditions) == 0 || k.Generation != k.Status.ObservedGeneration {
+		return fmt.Errorf("dependency '%s' is not ready", dName)
+	}
+
+	if !apimeta.IsStatusConditionTrue(k.Status.Conditions, meta.ReadyCondition) {
+		return fmt.Errorf("dependency '%s' is not ready", dName)
+	}
+
+	if d.MatchRevision && k.Status.LastAppliedRevision != revision {
+		return fmt.Errorf("dependency '%s' is ready at revision '%s', waiting for revision '%s'",
+			dName, k.Status.LastAppliedRevision, revision)
+	}
+	return nil
+}
+
+// checkObjectDependency returns an error if the referenced object is not ready.
+// The object is considered ready if its ready expression returns true, or else
+// if its ready condition is true and its status observed generation, when present,
+// matches its generation. The namespace is ignored for the cluster-scoped kinds.
+func (r *KustomizationReconciler) checkObjectDependency(ctx context.Context, kubeClient client.Client, d kustomizev1.DependencyReference) error {
+	apiVersion := dependencyAPIVersion(d)
+	if apiVersion == "" {
+		return fmt.Errorf("dependency '%s' must specify an apiVersion", d.String())
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(d.Kind)
+	if err := kubeClient.Get(ctx, client.ObjectKey{Namespace: d.Namespace, Name: d.Name}, obj); err != nil {
+		return fmt.Errorf("unable to get '%s' dependency: %w", d.String(), err)
+	}
+
+	if d.ReadyExpr != "" {
+		ready, err := evalReadyExpr(d.ReadyExpr, obj)
+		if err != nil {
+			return fmt.Errorf("dependency '%s' ready expression failed: %w", d.String(), err)
+		}
+		if !ready {
+			return fmt.Errorf("dependency '%s' is not ready, '%s' is false", d.String(), d.ReadyExpr)
+		}
+		return nil
+	}
+
+	if observed, found, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration"); found && observed != obj.GetGeneration() {
+		return fmt.Errorf("dependency '%s' is not ready", d.String())
+	}
+	conditionType := d.ReadyCondition
+	if conditionType == "" {
+		conditionType = meta.ReadyCondition
+	}
+	if !hasTrueCondition(obj, conditionType) {
+		return fmt.Errorf("dependency '%s' is not ready, condition '%s' is not true", d.String(), conditionType)
+	}
+	return nil
+}
+
+// compileReadyExpr compiles the ready expression of a dependency.
+func compileReadyExpr(expr string) (cel.Program, error) {
+	env, err := newObjectEnv()
+	if err != nil {
+		return nil, err
+	}
+	return compileObjectExpr(env, expr)
+}
+
+// maxReadyExprs is the maximum number of compiled ready expressions kept in
+// the cache, the cache is emptied when the limit is reached.
+const maxReadyExprs = 1000
+
+// readyExprs caches the compiled ready expressions of the dependencies,
+// as the dependencies are checked on every reconciliation.
+var readyExprs = &readyExprCache{programs: make(map[string]cel.Program)}
+
+type readyExprCache struct {
+	mu       sync.Mutex
+	programs map[string]cel.Program
+}
+
+// get returns the compiled ready expression, compiling it if it's not cached.
+// The expressions that fail to compile are not cached.
+func (c *readyExprCache) get(expr string) (cel.Program, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if program, ok := c.programs[expr]; ok {
+		return program, nil
+	}
+	program, err := compileReadyExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.programs) >= maxReadyExprs {
+		c.programs = make(map[string]cel.Program)
+	}
+	c.programs[expr] = program
+	return program, nil
+}
+
+// evalReadyExpr evaluates the ready expression of a dependency against the object.
+func evalReadyExpr(expr string, obj *unstructured.Unstructured) (bool, error) {
+	program, err := readyExprs.get(expr)
+	if err != nil {
+		return false, err
+	}
+	out, _, err := program.Eval(objectVars(obj))
+	if err != nil {
+		return false, err
+	}
+	return out == celtypes.True, nil
+}
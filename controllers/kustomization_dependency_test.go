@@ -0,0 +1,409 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/google/cel-go/cel"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestDependencyTimeoutExceeded(t *testing.T) {
+	now := time.Now()
+	start := metav1.NewTime(now.Add(-10 * time.Minute))
+
+	tests := []struct {
+		name    string
+		timeout *metav1.Duration
+		start   *metav1.Time
+		want    bool
+	}{
+		{name: "no timeout", start: &start, want: false},
+		{name: "not waiting", timeout: &metav1.Duration{Duration: time.Minute}, want: false},
+		{name: "within timeout", timeout: &metav1.Duration{Duration: time.Hour}, start: &start, want: false},
+		{name: "timeout exceeded", timeout: &metav1.Duration{Duration: 5 * time.Minute}, start: &start, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := kustomizev1.Kustomization{}
+			k.Spec.DependencyTimeout = tt.timeout
+			k.Status.DependencyWaitStartTime = tt.start
+			if got := dependencyTimeoutExceeded(k, now); got != tt.want {
+				t.Errorf("dependencyTimeoutExceeded() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckDependencies(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := kustomizev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	dependency := func(name string, generation, observed int64, ready metav1.ConditionStatus) *kustomizev1.Kustomization {
+		return &kustomizev1.Kustomization{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "infra", Generation: generation},
+			Status: kustomizev1.KustomizationStatus{
+				ObservedGeneration: observed,
+				Conditions: []metav1.Condition{
+					{Type: meta.ReadyCondition, Status: ready, Reason: meta.ReconciliationSucceededReason},
+				},
+			},
+		}
+	}
+	r := &KustomizationReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		dependency("crds", 1, 1, metav1.ConditionTrue),
+		dependency("controllers", 2, 1, metav1.ConditionTrue),
+		dependency("ingress", 1, 1, metav1.ConditionFalse),
+		&kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Name: "new", Namespace: "infra"}},
+	).Build()}
+
+	tests := []struct {
+		name      string
+		dependsOn []kustomizev1.DependencyReference
+		wantErr   bool
+	}{
+		{name: "ready", dependsOn: []kustomizev1.DependencyReference{{Name: "crds", Namespace: "infra"}}},
+		{name: "ready in the same namespace", dependsOn: []kustomizev1.DependencyReference{{Name: "crds"}}},
+		{name: "not found", dependsOn: []kustomizev1.DependencyReference{{Name: "crds", Namespace: "apps"}}, wantErr: true},
+		{name: "never reconciled", dependsOn: []kustomizev1.DependencyReference{{Name: "new"}}, wantErr: true},
+		{name: "generation not observed", dependsOn: []kustomizev1.DependencyReference{{Name: "controllers"}}, wantErr: true},
+		{name: "not ready", dependsOn: []kustomizev1.DependencyReference{{Name: "ingress"}}, wantErr: true},
+		{
+			name:      "one of many not ready",
+			dependsOn: []kustomizev1.DependencyReference{{Name: "crds"}, {Name: "ingress"}},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := kustomizev1.Kustomization{
+				ObjectMeta: metav1.ObjectMeta{Name: "apps", Namespace: "infra"},
+				Spec:       kustomizev1.KustomizationSpec{DependsOn: tt.dependsOn},
+			}
+			_, err := r.checkDependencies(context.TODO(), k, "", time.Now())
+			if tt.wantErr && err == nil {
+				t.Error("expected the dependencies not to be ready")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckObjectDependency(t *testing.T) {
+	release := &unstructured.Unstructured{}
+	release.SetAPIVersion("helm.toolkit.fluxcd.io/v2beta1")
+	release.SetKind("HelmRelease")
+	release.SetName("podinfo")
+	release.SetNamespace("apps")
+	release.SetGeneration(2)
+	release.Object["status"] = map[string]interface{}{
+		"observedGeneration": int64(2),
+		"conditions": []interface{}{
+			map[string]interface{}{"type": "Ready", "status": "True"},
+		},
+	}
+	r := &KustomizationReconciler{Client: fake.NewClientBuilder().WithObjects(release).Build()}
+
+	dep := kustomizev1.DependencyReference{
+		APIVersion: "helm.toolkit.fluxcd.io/v2beta1",
+		Kind:       "HelmRelease",
+		Name:       "podinfo",
+		Namespace:  "apps",
+	}
+	if err := r.checkObjectDependency(context.TODO(), r.Client, dep); err != nil {
+		t.Errorf("expected the dependency to be ready, got %v", err)
+	}
+
+	release.SetGeneration(3)
+	r.Client = fake.NewClientBuilder().WithObjects(release).Build()
+	if err := r.checkObjectDependency(context.TODO(), r.Client, dep); err == nil {
+		t.Error("expected error for a dependency with a stale observed generation")
+	}
+
+	release.SetGeneration(2)
+	r.Client = fake.NewClientBuilder().WithObjects(release).Build()
+	dep.APIVersion = ""
+	if err := r.checkObjectDependency(context.TODO(), r.Client, dep); err != nil {
+		t.Errorf("expected the HelmRelease API version to be defaulted, got %v", err)
+	}
+
+	dep.Kind = "Prometheus"
+	if err := r.checkObjectDependency(context.TODO(), r.Client, dep); err == nil {
+		t.Error("expected error for a dependency without apiVersion")
+	}
+}
+
+func TestCheckObjectDependencyReadiness(t *testing.T) {
+	crd := &unstructured.Unstructured{}
+	crd.SetAPIVersion("apiextensions.k8s.io/v1")
+	crd.SetKind("CustomResourceDefinition")
+	crd.SetName("certificates.cert-manager.io")
+	crd.Object["status"] = map[string]interface{}{
+		"conditions": []interface{}{
+			map[string]interface{}{"type": "Established", "status": "True"},
+		},
+	}
+	issuer := &unstructured.Unstructured{}
+	issuer.SetAPIVersion("cert-manager.io/v1")
+	issuer.SetKind("ClusterIssuer")
+	issuer.SetName("letsencrypt")
+	issuer.Object["status"] = map[string]interface{}{
+		"acme": map[string]interface{}{"uri": ""},
+	}
+	r := &KustomizationReconciler{Client: fake.NewClientBuilder().WithObjects(crd, issuer).Build()}
+
+	crdDep := kustomizev1.DependencyReference{
+		APIVersion: "apiextensions.k8s.io/v1",
+		Kind:       "CustomResourceDefinition",
+		Name:       "certificates.cert-manager.io",
+	}
+	if err := r.checkObjectDependency(context.TODO(), r.Client, crdDep); err == nil {
+		t.Error("expected error for a CRD without a Ready condition")
+	}
+	crdDep.ReadyCondition = "Established"
+	if err := r.checkObjectDependency(context.TODO(), r.Client, crdDep); err != nil {
+		t.Errorf("expected the CRD to be established, got %v", err)
+	}
+
+	issuerDep := kustomizev1.DependencyReference{
+		APIVersion: "cert-manager.io/v1",
+		Kind:       "ClusterIssuer",
+		Name:       "letsencrypt",
+		ReadyExpr:  "has(status.acme) && status.acme.uri != ''",
+	}
+	if err := r.checkObjectDependency(context.TODO(), r.Client, issuerDep); err == nil {
+		t.Error("expected error for an unregistered issuer")
+	}
+	issuerDep.ReadyExpr = "true"
+	if err := r.checkObjectDependency(context.TODO(), r.Client, issuerDep); err != nil {
+		t.Errorf("expected the issuer to exist, got %v", err)
+	}
+	issuerDep.ReadyExpr = "status.acme.uri"
+	if err := r.checkObjectDependency(context.TODO(), r.Client, issuerDep); err == nil {
+		t.Error("expected error for an expression not returning a bool")
+	}
+	issuerDep.Name = "staging"
+	issuerDep.ReadyExpr = "true"
+	if err := r.checkObjectDependency(context.TODO(), r.Client, issuerDep); err == nil {
+		t.Error("expected error for a missing issuer")
+	}
+}
+
+func TestCheckDependenciesImpersonation(t *testing.T) {
+	release := &unstructured.Unstructured{}
+	release.SetAPIVersion("helm.toolkit.fluxcd.io/v2beta1")
+	release.SetKind("HelmRelease")
+	release.SetName("podinfo")
+	release.SetNamespace("dev")
+	r := &KustomizationReconciler{Client: fake.NewClientBuilder().WithObjects(release).Build()}
+	k := kustomizev1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{Name: "apps", Namespace: "dev"},
+		Spec: kustomizev1.KustomizationSpec{
+			DependsOn: []kustomizev1.DependencyReference{
+				{Kind: "HelmRelease", Name: "podinfo", ReadyExpr: "true"},
+			},
+		},
+	}
+	if _, err := r.checkDependencies(context.TODO(), k, "", time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the object is read as the service account, not with the controller client
+	k.Spec.ServiceAccountName = "apps-reconciler"
+	_, err := r.checkDependencies(context.TODO(), k, "", time.Now())
+	if err == nil || !strings.Contains(err.Error(), "failed to build kube client") {
+		t.Errorf("expected the impersonated client to be used, got %v", err)
+	}
+}
+
+func TestReadyExprCache(t *testing.T) {
+	cache := &readyExprCache{programs: make(map[string]cel.Program)}
+	first, err := cache.get("has(status.ready)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, err := cache.get("has(status.ready)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first != second {
+		t.Error("expected the compiled expression to be cached")
+	}
+	if _, err := cache.get("status.ready +"); err == nil {
+		t.Error("expected error for an invalid expression")
+	}
+	if len(cache.programs) != 1 {
+		t.Errorf("expected the invalid expression not to be cached, got %d entries", len(cache.programs))
+	}
+}
+
+func TestReadyChangePredicate(t *testing.T) {
+	withReady := func(status metav1.ConditionStatus, reason string) *kustomizev1.Kustomization {
+		k := &kustomizev1.Kustomization{}
+		k.Status.Conditions = []metav1.Condition{{Type: meta.ReadyCondition, Status: status, Reason: reason}}
+		return k
+	}
+
+	notReady := withReady(metav1.ConditionFalse, meta.ReconciliationFailedReason)
+	ready := withReady(metav1.ConditionTrue, meta.ReconciliationSucceededReason)
+	p := ReadyChangePredicate{}
+	if !p.Update(event.UpdateEvent{ObjectOld: notReady, ObjectNew: ready}) {
+		t.Error("expected an event when the Kustomization becomes ready")
+	}
+	if p.Update(event.UpdateEvent{ObjectOld: ready, ObjectNew: ready}) {
+		t.Error("expected no event when the Kustomization stays ready")
+	}
+	applied := ready.DeepCopy()
+	applied.Status.LastAppliedRevision = "main/5d6e7f8"
+	if !p.Update(event.UpdateEvent{ObjectOld: ready, ObjectNew: applied}) {
+		t.Error("expected an event when the Kustomization applies a new revision")
+	}
+	if p.Update(event.UpdateEvent{ObjectOld: ready, ObjectNew: notReady}) {
+		t.Error("expected no event when the Kustomization becomes not ready")
+	}
+
+	if !isWaitingForDependencies(*withReady(metav1.ConditionFalse, meta.DependencyNotReadyReason)) {
+		t.Error("expected the Kustomization to be waiting for its dependencies")
+	}
+	if isWaitingForDependencies(*notReady) {
+		t.Error("expected the failed Kustomization not to be waiting for its dependencies")
+	}
+}
+
+func TestIndexByDependsOn(t *testing.T) {
+	k := &kustomizev1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{Name: "apps", Namespace: "dev"},
+		Spec: kustomizev1.KustomizationSpec{
+			DependsOn: []kustomizev1.DependencyReference{
+				{Name: "infra"},
+				{Name: "crds", Namespace: "flux-system"},
+				{APIVersion: "helm.toolkit.fluxcd.io/v2beta1", Kind: "HelmRelease", Name: "ingress"},
+			},
+		},
+	}
+	r := &KustomizationReconciler{}
+	got := r.indexByDependsOn(k)
+	want := []string{"dev/infra", "flux-system/crds"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestCheckDependenciesMatchRevision(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := kustomizev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	infra := &kustomizev1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{Name: "infra", Namespace: "dev", Generation: 1},
+		Status: kustomizev1.KustomizationStatus{
+			ObservedGeneration:  1,
+			LastAppliedRevision: "main/1a2b3c4",
+			Conditions: []metav1.Condition{
+				{Type: meta.ReadyCondition, Status: metav1.ConditionTrue, Reason: meta.ReconciliationSucceededReason},
+			},
+		},
+	}
+	r := &KustomizationReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(infra).Build()}
+	k := kustomizev1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{Name: "apps", Namespace: "dev"},
+		Spec: kustomizev1.KustomizationSpec{
+			DependsOn: []kustomizev1.DependencyReference{{Name: "infra"}},
+		},
+	}
+
+	if _, err := r.checkDependencies(context.TODO(), k, "main/5d6e7f8", time.Now()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	k.Spec.DependsOn[0].MatchRevision = true
+	if _, err := r.checkDependencies(context.TODO(), k, "main/5d6e7f8", time.Now()); err == nil {
+		t.Error("expected the dependency at another revision not to be ready")
+	}
+	if _, err := r.checkDependencies(context.TODO(), k, "main/1a2b3c4", time.Now()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCheckDependenciesTimeoutPolicy(t *testing.T) {
+	now := time.Now()
+	start := metav1.NewTime(now.Add(-10 * time.Minute))
+	r := &KustomizationReconciler{Client: fake.NewClientBuilder().Build()}
+	k := kustomizev1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{Name: "apps", Namespace: "dev"},
+		Spec: kustomizev1.KustomizationSpec{
+			DependsOn: []kustomizev1.DependencyReference{
+				{APIVersion: "cert-manager.io/v1", Kind: "ClusterIssuer", Name: "letsencrypt"},
+			},
+		},
+	}
+
+	if _, err := r.checkDependencies(context.TODO(), k, "", now); err == nil {
+		t.Fatal("expected the missing dependency not to be ready")
+	}
+
+	k.Status.DependencyWaitStartTime = &start
+	k.Spec.DependencyTimeout = &metav1.Duration{Duration: time.Hour}
+	k.Spec.DependsOn[0].Timeout = &metav1.Duration{Duration: 5 * time.Minute}
+	_, err := r.checkDependencies(context.TODO(), k, "", now)
+	var timeoutErr *DependencyTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+	if timeoutErr.Timeout != 5*time.Minute || timeoutErr.Policy != kustomizev1.WaitDependencyPolicy {
+		t.Errorf("unexpected timeout error: %+v", timeoutErr)
+	}
+
+	k.Spec.DependsOn[0].OnTimeout = kustomizev1.FailDependencyPolicy
+	if _, err := r.checkDependencies(context.TODO(), k, "", now); !errors.As(err, &timeoutErr) || timeoutErr.Policy != kustomizev1.FailDependencyPolicy {
+		t.Errorf("expected a timeout error with the Fail policy, got %v", err)
+	}
+
+	k.Spec.DependsOn[0].OnTimeout = kustomizev1.ProceedDependencyPolicy
+	skipped, err := r.checkDependencies(context.TODO(), k, "", now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"ClusterIssuer/dev/letsencrypt"}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("expected %v to be skipped, got %v", want, skipped)
+	}
+
+	k.Spec.DependsOn[0].Timeout = nil
+	if _, err := r.checkDependencies(context.TODO(), k, "", now); err == nil || errors.As(err, &timeoutErr) {
+		t.Errorf("expected the dependency within the Kustomization timeout to be waited for, got %v", err)
+	}
+
+	if got := dependencyWaitTime(k, now); got != 10*time.Minute {
+		t.Errorf("expected a wait time of 10m, got %s", got)
+	}
+}
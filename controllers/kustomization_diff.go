@@ -0,0 +1,169 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+	"github.com/fluxcd/kustomize-controller/pkg/validation"
+)
+
+// maxEventObjects is the maximum number of objects
+// listed per action in the changes summary.
+const maxEventObjects = 10
+
+// objectChanges holds the IDs of the objects grouped by the action
+// the apply of the build output would perform on them.
+type objectChanges struct {
+	Created    []string
+	Configured []string
+	Unchanged  []string
+	// Deleted holds the objects that would be garbage collected.
+	Deleted []string
+	// Diff is the unified diff between the in-cluster objects
+	// and the build output, with the Secret values masked.
+	Diff string
+}
+
+// readBuildObjects reads the objects generated by the kustomize build.
+func readBuildObjects(dirPath string, kustomization kustomizev1.Kustomization) ([]*unstructured.Unstructured, error) {
+	manifests, err := ioutil.ReadFile(filepath.Join(dirPath, fmt.Sprintf("%s.yaml", kustomization.GetUID())))
+	if err != nil {
+		return nil, err
+	}
+
+	objects, err := readObjects(manifests)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode manifests: %w", err)
+	}
+	return objects, nil
+}
+
+// dryRunChanges computes the changes the objects would make to the cluster
+// using server-side dry-run. The objects recorded in the inventory that are
+// missing from the build output are reported as deleted if pruning is enabled.
+// The changes to the ignored fields of the existing objects are not reported.
+func dryRunChanges(ctx context.Context, kubeClient client.Client, kustomization kustomizev1.Kustomization, objects []*unstructured.Unstructured) (*objectChanges, error) {
+	changes := &objectChanges{}
+	var diff strings.Builder
+	for _, obj := range objects {
+		if err := validation.SetDefaultNamespace(kubeClient.RESTMapper(), obj); err != nil {
+			return nil, err
+		}
+		id := objectID(obj)
+		existing := &unstructured.Unstructured{}
+		existing.SetGroupVersionKind(obj.GroupVersionKind())
+		err := kubeClient.Get(ctx, client.ObjectKeyFromObject(obj), existing)
+		if err != nil && !apierrors.IsNotFound(err) && !apimeta.IsNoMatchError(err) {
+			return nil, fmt.Errorf("unable to get '%s': %w", id, err)
+		}
+		if err != nil {
+			existing = nil
+		}
+
+		// the changes to the objects created only if not present are not applied
+		if existing != nil && obj.GetAnnotations()[applyPolicyAnnotation] == IfNotPresentApplyPolicy {
+			changes.Unchanged = append(changes.Unchanged, id)
+			continue
+		}
+
+		ignorePaths, err := ignoredPaths(kustomization, obj)
+		if err != nil {
+			return nil, err
+		}
+		applied := obj
+		if existing != nil && len(ignorePaths) > 0 {
+			applied = obj.DeepCopy()
+			preserveIgnoredFields(applied, existing, ignorePaths)
+		}
+
+		desired, err := dryRunApply(ctx, kubeClient, applied, existing, kustomization.Spec.ConflictPolicy)
+		if err != nil {
+			// the object can't be dry-run applied when its namespace or CRD
+			// is part of the same build, fallback to the build output
+			desired = applied
+		}
+
+		text, err := diffObjects(id, existing, desired)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case existing == nil:
+			changes.Created = append(changes.Created, id)
+		case text != "":
+			changes.Configured = append(changes.Configured, id)
+		default:
+			changes.Unchanged = append(changes.Unchanged, id)
+		}
+		diff.WriteString(text)
+	}
+	changes.Diff = diff.String()
+
+	if kustomization.Spec.Prune && kustomization.Status.Inventory != nil {
+		for _, entry := range inventoryDiff(kustomization.Status.Inventory, newInventory(objects)) {
+			obj, err := inventoryObject(entry)
+			if err != nil {
+				return nil, err
+			}
+			changes.Deleted = append(changes.Deleted, objectID(obj))
+		}
+	}
+	return changes, nil
+}
+
+// HasChanges returns true if the apply would modify the cluster.
+func (c *objectChanges) HasChanges() bool {
+	return len(c.Created) > 0 || len(c.Configured) > 0 || len(c.Deleted) > 0
+}
+
+// String returns a summary of the changes e.g.
+// '1 created, 1 configured, 0 deleted, configured: deployment/apps/backend'.
+func (c *objectChanges) String() string {
+	summary := fmt.Sprintf("%d created, %d configured, %d deleted",
+		len(c.Created), len(c.Configured), len(c.Deleted))
+	for _, action := range []struct {
+		name string
+		ids  []string
+	}{
+		{createdAction, c.Created},
+		{configuredAction, c.Configured},
+		{deletedAction, c.Deleted},
+	} {
+		if len(action.ids) == 0 {
+			continue
+		}
+		ids := action.ids
+		more := ""
+		if len(ids) > maxEventObjects {
+			more = fmt.Sprintf(" and %d more", len(ids)-maxEventObjects)
+			ids = ids[:maxEventObjects]
+		}
+		summary += fmt.Sprintf(", %s: %s%s", action.name, strings.Join(ids, ", "), more)
+	}
+	return summary
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestObjectChangesString(t *testing.T) {
+	changes := &objectChanges{
+		Created:    []string{"service/apps/backend"},
+		Configured: []string{"deployment/apps/backend"},
+		Unchanged:  []string{"namespace/apps"},
+	}
+	if !changes.HasChanges() {
+		t.Error("expected changes")
+	}
+	expected := "1 created, 1 configured, 0 deleted, created: service/apps/backend, configured: deployment/apps/backend"
+	if got := changes.String(); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	changes = &objectChanges{Unchanged: []string{"namespace/apps"}}
+	if changes.HasChanges() {
+		t.Error("expected no changes")
+	}
+
+	for i := 0; i < maxEventObjects+2; i++ {
+		changes.Deleted = append(changes.Deleted, fmt.Sprintf("configmap/apps/config-%d", i))
+	}
+	if got := changes.String(); !strings.HasSuffix(got, "configmap/apps/config-9 and 2 more") {
+		t.Errorf("expected the deleted objects to be truncated, got %q", got)
+	}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"crypto/sha1"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// appliedChecksum returns the checksum of the desired object and of the
+// resource version of its in-cluster counterpart. The garbage collection
+// checksum annotation is excluded, as it changes with any change of the
+// build output, regardless of the object.
+func appliedChecksum(obj *unstructured.Unstructured, resourceVersion string) (string, error) {
+	content := obj.DeepCopy()
+	unstructured.RemoveNestedField(content.Object, "metadata", "annotations",
+		fmt.Sprintf("%s/checksum", kustomizev1.GroupVersion.Group))
+	data, err := content.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	data = append(data, []byte("\n"+resourceVersion)...)
+	return fmt.Sprintf("%x", sha1.Sum(data)), nil
+}
+
+// inventoryChecksums returns the applied checksums recorded
+// in the inventory, indexed by object ID.
+func inventoryChecksums(inventory *kustomizev1.ResourceInventory) map[string]string {
+	checksums := make(map[string]string)
+	if inventory == nil {
+		return checksums
+	}
+	for _, entry := range inventory.Entries {
+		if entry.Checksum == "" {
+			continue
+		}
+		obj, err := inventoryObject(entry)
+		if err != nil {
+			continue
+		}
+		checksums[objectID(obj)] = entry.Checksum
+	}
+	return checksums
+}
+
+// setInventoryChecksums records the applied checksums of the
+// applied objects in the inventory entries.
+func setInventoryChecksums(inventory *kustomizev1.ResourceInventory, results applyResults) {
+	checksums := make(map[string]string, len(results))
+	for _, obj := range results {
+		checksums[obj.ID] = obj.Checksum
+	}
+	for i, entry := range inventory.Entries {
+		obj, err := inventoryObject(entry)
+		if err != nil {
+			continue
+		}
+		inventory.Entries[i].Checksum = checksums[objectID(obj)]
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// noPatchClient fails the patch requests.
+type noPatchClient struct {
+	client.Client
+}
+
+func (c *noPatchClient) Patch(context.Context, client.Object, client.Patch, ...client.PatchOption) error {
+	return errors.New("unexpected patch")
+}
+
+func TestDifferentialApply(t *testing.T) {
+	objects, err := readObjects([]byte(`---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: backend
+  namespace: apps
+  annotations:
+    kustomize.toolkit.fluxcd.io/checksum: 6ea4a2b0e5e2d0c7ba8c5fd1b2d7b7d5f1d0b4e9
+data:
+  key: value
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	obj := objects[0]
+
+	kubeClient := fake.NewClientBuilder().Build()
+	existing := obj.DeepCopy()
+	if err := kubeClient.Create(context.TODO(), existing); err != nil {
+		t.Fatal(err)
+	}
+
+	checksum, err := appliedChecksum(obj, existing.GetResourceVersion())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the checksum annotation changes with any change of the build output
+	rebuilt := obj.DeepCopy()
+	rebuilt.SetAnnotations(map[string]string{"kustomize.toolkit.fluxcd.io/checksum": "b7d5f1d0b4e96ea4a2b0e5e2d0c7ba8c5fd1b2d7"})
+	if c, _ := appliedChecksum(rebuilt, existing.GetResourceVersion()); c != checksum {
+		t.Errorf("expected the checksum annotation to be excluded")
+	}
+	changed := obj.DeepCopy()
+	changed.Object["data"] = map[string]interface{}{"key": "changed"}
+	if c, _ := appliedChecksum(changed, existing.GetResourceVersion()); c == checksum {
+		t.Errorf("expected the checksum to change with the manifest")
+	}
+	if c, _ := appliedChecksum(obj, "999"); c == checksum {
+		t.Errorf("expected the checksum to change with the resource version")
+	}
+
+	// unchanged objects are not applied
+	action, newChecksum, err := applyObject(context.TODO(), &noPatchClient{kubeClient}, rebuilt, applyObjectOptions{lastApplied: checksum})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if action != unchangedAction || newChecksum != checksum {
+		t.Errorf("expected the object to be skipped, got %s", action)
+	}
+
+	// changed objects are applied
+	if _, _, err := applyObject(context.TODO(), &noPatchClient{kubeClient}, changed, applyObjectOptions{lastApplied: checksum}); err == nil {
+		t.Errorf("expected the changed object to be applied")
+	}
+
+	// the checksums are recorded in the inventory
+	inventory := newInventory(objects)
+	setInventoryChecksums(inventory, applyResults{{ID: objectID(obj), Action: unchangedAction, Checksum: checksum}})
+	if inventory.Entries[0].Checksum != checksum {
+		t.Errorf("expected the checksum to be recorded, got %v", inventory.Entries[0])
+	}
+	if c := inventoryChecksums(inventory)[objectID(obj)]; c != checksum {
+		t.Errorf("expected the checksum to be read from the inventory, got %q", c)
+	}
+}
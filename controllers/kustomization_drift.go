@@ -0,0 +1,324 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+	"github.com/fluxcd/kustomize-controller/internal/discovery"
+)
+
+// driftWatcher watches the metadata of the objects applied by the Kustomizations,
+// and requests the reconciliation of their Kustomization when the objects are
+// modified or deleted by other field managers. The watches are added as
+// new kinds are found in the inventories, and are never removed.
+// The objects of remote clusters are watched with a cache per kubeconfig,
+// stopped when no Kustomization uses the kubeconfig anymore. The events of
+// the remote caches are sent to the controller through a single channel source,
+// so that no source is left registered when a remote cache is stopped.
+type driftWatcher struct {
+	controller       controller.Controller
+	discoveryOptions discovery.Options
+	mu               sync.Mutex
+	watched          map[schema.GroupKind]bool
+	remotes          map[string]*remoteCache
+	owners           map[types.NamespacedName]string
+	remoteEvents     chan event.GenericEvent
+}
+
+// remoteCache holds the cache of the objects of a remote cluster.
+type remoteCache struct {
+	cache   cache.Cache
+	ctx     context.Context
+	cancel  context.CancelFunc
+	watched map[schema.GroupKind]bool
+}
+
+func newDriftWatcher(c controller.Controller, discoveryOptions discovery.Options) *driftWatcher {
+	return &driftWatcher{
+		controller:       c,
+		discoveryOptions: discoveryOptions,
+		watched:          make(map[schema.GroupKind]bool),
+		remotes:          make(map[string]*remoteCache),
+		owners:           make(map[types.NamespacedName]string),
+	}
+}
+
+// watch starts watching the kinds of the inventory entries,
+// if they are not watched already.
+func (w *driftWatcher) watch(inventory *kustomizev1.ResourceInventory) error {
+	if inventory == nil {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.addWatches(w.watched, inventory, func(obj *metav1.PartialObjectMetadata) error {
+		return w.controller.Watch(
+			&source.Kind{Type: obj},
+			handler.EnqueueRequestsFromMapFunc(requestsForManagedObject),
+			driftPredicate{},
+		)
+	})
+}
+
+// watchRemote starts watching the kinds of the inventory entries on the remote
+// cluster of the given config, identified by the checksum of its kubeconfig.
+// The cache of the previous kubeconfig of the owner is stopped if unused.
+func (w *driftWatcher) watchRemote(owner types.NamespacedName, key string, restConfig *rest.Config,
+	inventory *kustomizev1.ResourceInventory) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.remoteEvents == nil {
+		events := make(chan event.GenericEvent)
+		if err := w.controller.Watch(
+			&source.Channel{Source: events},
+			handler.EnqueueRequestsFromMapFunc(requestsForManagedObject),
+		); err != nil {
+			return fmt.Errorf("failed to watch the remote clusters: %w", err)
+		}
+		w.remoteEvents = events
+	}
+
+	remote, ok := w.remotes[key]
+	if !ok {
+		mapper, err := w.discoveryOptions.NewRESTMapper(restConfig)
+		if err != nil {
+			return err
+		}
+		c, err := cache.New(restConfig, cache.Options{Mapper: mapper})
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		go c.Start(ctx)
+		remote = &remoteCache{cache: c, ctx: ctx, cancel: cancel, watched: make(map[schema.GroupKind]bool)}
+		w.remotes[key] = remote
+	}
+	w.owners[owner] = key
+	w.release()
+
+	if inventory == nil {
+		return nil
+	}
+	return w.addWatches(remote.watched, inventory, func(obj *metav1.PartialObjectMetadata) error {
+		// the informer is synced in the background as the remote cluster may be slow,
+		// the kind is watched again at the next reconciliation if the sync fails
+		go func() {
+			informer, err := remote.cache.GetInformer(remote.ctx, obj)
+			if err != nil {
+				w.mu.Lock()
+				delete(remote.watched, obj.GroupVersionKind().GroupKind())
+				w.mu.Unlock()
+				return
+			}
+			informer.AddEventHandler(remoteEventHandler{ctx: remote.ctx, events: w.remoteEvents})
+		}()
+		return nil
+	})
+}
+
+// forget stops the cache of the remote cluster watched for
+// the owner, if no other Kustomization uses it.
+func (w *driftWatcher) forget(owner types.NamespacedName) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.owners[owner]; ok {
+		delete(w.owners, owner)
+		w.release()
+	}
+}
+
+// release stops the caches of the remote clusters without owners.
+func (w *driftWatcher) release() {
+	used := make(map[string]bool, len(w.owners))
+	for _, key := range w.owners {
+		used[key] = true
+	}
+	for key, remote := range w.remotes {
+		if !used[key] {
+			remote.cancel()
+			delete(w.remotes, key)
+		}
+	}
+}
+
+// addWatches watches the kinds of the inventory entries that are not in watched.
+// Only the metadata of the objects is cached, as the drift is detected from their
+// labels, generation, resource version and managed fields.
+func (w *driftWatcher) addWatches(watched map[schema.GroupKind]bool, inventory *kustomizev1.ResourceInventory,
+	watch func(obj *metav1.PartialObjectMetadata) error) error {
+	for _, entry := range inventory.Entries {
+		obj, err := inventoryObject(entry)
+		if err != nil {
+			return err
+		}
+		gvk := obj.GroupVersionKind()
+		if watched[gvk.GroupKind()] {
+			continue
+		}
+
+		partial := &metav1.PartialObjectMetadata{}
+		partial.SetGroupVersionKind(gvk)
+		if err := watch(partial); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", gvk.String(), err)
+		}
+		watched[gvk.GroupKind()] = true
+	}
+	return nil
+}
+
+// watchDrift watches the objects applied by the Kustomization on the cluster they were
+// applied to. The objects of the clusters targeted by spec.clusters are not watched.
+func (r *KustomizationReconciler) watchDrift(ctx context.Context, kustomization kustomizev1.Kustomization) error {
+	owner := ObjectKey(&kustomization)
+	if kustomization.Spec.KubeConfig == nil || len(kustomization.Spec.Clusters) > 0 {
+		r.driftWatcher.forget(owner)
+		if len(kustomization.Spec.Clusters) > 0 {
+			return nil
+		}
+		return r.driftWatcher.watch(kustomization.Status.Inventory)
+	}
+
+	imp := NewKustomizeImpersonation(kustomization, r.Client, r.StatusPoller, r.discoveryOptions, r.kubeConfigOptions)
+	restConfig, key, err := imp.remoteConfig(ctx)
+	if err != nil {
+		return err
+	}
+	return r.driftWatcher.watchRemote(owner, key, restConfig, kustomization.Status.Inventory)
+}
+
+// requestsForManagedObject returns the reconcile request of
+// the Kustomization the object was applied from, if any.
+func requestsForManagedObject(obj client.Object) []reconcile.Request {
+	labels := obj.GetLabels()
+	name := labels[fmt.Sprintf("%s/name", kustomizev1.GroupVersion.Group)]
+	namespace := labels[fmt.Sprintf("%s/namespace", kustomizev1.GroupVersion.Group)]
+	if name == "" || namespace == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}}
+}
+
+// remoteEventHandler sends the events of a remote cache that pass
+// the drift predicate to the channel source of the controller.
+type remoteEventHandler struct {
+	ctx    context.Context
+	events chan<- event.GenericEvent
+}
+
+func (h remoteEventHandler) send(obj client.Object) {
+	select {
+	case h.events <- event.GenericEvent{Object: obj}:
+	case <-h.ctx.Done():
+	}
+}
+
+// OnAdd ignores the objects listed when the cache starts, or created by the controller.
+func (h remoteEventHandler) OnAdd(obj interface{}) {}
+
+// OnUpdate sends the changes made by other field managers.
+func (h remoteEventHandler) OnUpdate(oldObj, newObj interface{}) {
+	o, ok := oldObj.(client.Object)
+	if !ok {
+		return
+	}
+	n, ok := newObj.(client.Object)
+	if !ok {
+		return
+	}
+	if (driftPredicate{}).Update(event.UpdateEvent{ObjectOld: o, ObjectNew: n}) {
+		h.send(n)
+	}
+}
+
+// OnDelete sends the deletion of any managed object.
+func (h remoteEventHandler) OnDelete(obj interface{}) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if o, ok := obj.(client.Object); ok {
+		h.send(o)
+	}
+}
+
+// driftPredicate filters the events of the managed objects
+// that could change the state checksum of their Kustomization.
+type driftPredicate struct {
+	predicate.Funcs
+}
+
+// Create ignores the objects created by the controller.
+func (driftPredicate) Create(e event.CreateEvent) bool {
+	return false
+}
+
+// Update filters the changes made by other field managers. For objects with a
+// generation, only the spec changes are considered, as in the state checksum.
+func (driftPredicate) Update(e event.UpdateEvent) bool {
+	if e.ObjectOld == nil || e.ObjectNew == nil {
+		return false
+	}
+	if lastManager(e.ObjectNew) == fieldManager {
+		return false
+	}
+	if e.ObjectNew.GetGeneration() > 0 {
+		return e.ObjectNew.GetGeneration() != e.ObjectOld.GetGeneration()
+	}
+	return e.ObjectNew.GetResourceVersion() != e.ObjectOld.GetResourceVersion()
+}
+
+// Delete considers the deletion of any managed object as drift.
+func (driftPredicate) Delete(e event.DeleteEvent) bool {
+	return true
+}
+
+// lastManager returns the name of the field manager
+// that made the most recent change to the object.
+func lastManager(obj client.Object) string {
+	manager := ""
+	var last int64
+	for _, entry := range obj.GetManagedFields() {
+		if entry.Time == nil {
+			continue
+		}
+		if t := entry.Time.Unix(); manager == "" || t >= last {
+			manager, last = entry.Manager, t
+		}
+	}
+	return manager
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+	"github.com/fluxcd/kustomize-controller/internal/discovery"
+)
+
+// watchRecorder records the sources watched by the controller.
+type watchRecorder struct {
+	controller.Controller
+	sources []source.Source
+}
+
+func (c *watchRecorder) Watch(src source.Source, _ handler.EventHandler, _ ...predicate.Predicate) error {
+	c.sources = append(c.sources, src)
+	return nil
+}
+
+func TestDriftPredicate(t *testing.T) {
+	newObject := func(generation int64, resourceVersion, manager string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("apps/v1")
+		obj.SetKind("Deployment")
+		obj.SetGeneration(generation)
+		obj.SetResourceVersion(resourceVersion)
+		obj.SetManagedFields([]metav1.ManagedFieldsEntry{
+			{Manager: fieldManager, Time: &metav1.Time{Time: time.Now().Add(-time.Minute)}},
+			{Manager: manager, Time: &metav1.Time{Time: time.Now()}},
+		})
+		return obj
+	}
+
+	tests := []struct {
+		name string
+		old  *unstructured.Unstructured
+		new  *unstructured.Unstructured
+		want bool
+	}{
+		{name: "spec edited", old: newObject(1, "1", fieldManager), new: newObject(2, "2", "kubectl-edit"), want: true},
+		{name: "status updated", old: newObject(1, "1", fieldManager), new: newObject(1, "2", "kube-controller-manager"), want: false},
+		{name: "applied by the controller", old: newObject(1, "1", "kubectl-edit"), new: newObject(2, "2", fieldManager), want: false},
+		{name: "object without generation", old: newObject(0, "1", fieldManager), new: newObject(0, "2", "kubectl-edit"), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := event.UpdateEvent{ObjectOld: tt.old, ObjectNew: tt.new}
+			if got := (driftPredicate{}).Update(e); got != tt.want {
+				t.Errorf("Update() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestsForManagedObject(t *testing.T) {
+	obj := &unstructured.Unstructured{}
+	if reqs := requestsForManagedObject(obj); len(reqs) != 0 {
+		t.Errorf("expected no requests for an unmanaged object, got %v", reqs)
+	}
+
+	obj.SetLabels(selectorLabels("backend", "apps"))
+	reqs := requestsForManagedObject(obj)
+	if len(reqs) != 1 || reqs[0].Name != "backend" || reqs[0].Namespace != "apps" {
+		t.Errorf("expected a request for apps/backend, got %v", reqs)
+	}
+}
+
+func TestDriftWatcherForget(t *testing.T) {
+	w := newDriftWatcher(nil, discovery.Options{})
+	stopped := map[string]bool{}
+	for _, key := range []string{"prod", "stage"} {
+		key := key
+		w.remotes[key] = &remoteCache{cancel: func() { stopped[key] = true }}
+	}
+	apps := types.NamespacedName{Namespace: "fleet", Name: "apps"}
+	infra := types.NamespacedName{Namespace: "fleet", Name: "infra"}
+	preview := types.NamespacedName{Namespace: "fleet", Name: "preview"}
+	w.owners[apps] = "prod"
+	w.owners[infra] = "prod"
+	w.owners[preview] = "stage"
+
+	w.forget(preview)
+	if !stopped["stage"] || w.remotes["stage"] != nil {
+		t.Error("expected the unused cache to be stopped")
+	}
+
+	w.forget(apps)
+	if stopped["prod"] {
+		t.Error("expected the cache used by another Kustomization to be kept")
+	}
+
+	w.forget(infra)
+	if !stopped["prod"] || len(w.remotes) != 0 {
+		t.Error("expected all the caches to be stopped")
+	}
+}
+
+func TestDriftWatcherWatch(t *testing.T) {
+	c := &watchRecorder{}
+	w := newDriftWatcher(c, discovery.Options{})
+	inventory := &kustomizev1.ResourceInventory{Entries: []kustomizev1.ResourceRef{
+		{ID: "apps_backend_apps_Deployment", Version: "v1"},
+		{ID: "apps_frontend_apps_Deployment", Version: "v1"},
+		{ID: "apps_backend__ConfigMap", Version: "v1"},
+	}}
+	if err := w.watch(inventory); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.watch(inventory); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(c.sources) != 2 {
+		t.Fatalf("expected a watch per kind, got %d", len(c.sources))
+	}
+	for _, src := range c.sources {
+		kind, ok := src.(*source.Kind)
+		if !ok {
+			t.Fatalf("expected a kind source, got %T", src)
+		}
+		if _, ok := kind.Type.(*metav1.PartialObjectMetadata); !ok {
+			t.Errorf("expected the metadata of %s to be watched, got %T",
+				kind.Type.GetObjectKind().GroupVersionKind(), kind.Type)
+		}
+	}
+}
+
+func TestDriftWatcherRemoteSource(t *testing.T) {
+	c := &watchRecorder{}
+	w := newDriftWatcher(c, discovery.Options{})
+	apps := types.NamespacedName{Namespace: "fleet", Name: "apps"}
+
+	// a cache recreated for the same kubeconfig doesn't register new sources
+	for i := 0; i < 3; i++ {
+		w.remotes["prod"] = &remoteCache{cancel: func() {}, watched: map[schema.GroupKind]bool{}}
+		if err := w.watchRemote(apps, "prod", nil, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		w.forget(apps)
+		if len(w.remotes) != 0 {
+			t.Fatal("expected the unused cache to be stopped")
+		}
+	}
+
+	if len(c.sources) != 1 {
+		t.Fatalf("expected a single source for the remote clusters, got %d", len(c.sources))
+	}
+	if _, ok := c.sources[0].(*source.Channel); !ok {
+		t.Errorf("expected a channel source, got %T", c.sources[0])
+	}
+}
+
+func TestRemoteEventHandler(t *testing.T) {
+	newObject := func(generation int64, manager string) *metav1.PartialObjectMetadata {
+		obj := &metav1.PartialObjectMetadata{}
+		obj.SetGeneration(generation)
+		obj.SetManagedFields([]metav1.ManagedFieldsEntry{
+			{Manager: manager, Time: &metav1.Time{Time: time.Now()}},
+		})
+		return obj
+	}
+
+	events := make(chan event.GenericEvent, 4)
+	h := remoteEventHandler{ctx: context.TODO(), events: events}
+
+	h.OnAdd(newObject(1, fieldManager))
+	h.OnUpdate(newObject(1, fieldManager), newObject(2, fieldManager))
+	h.OnUpdate(newObject(1, fieldManager), newObject(1, "kube-controller-manager"))
+	if len(events) != 0 {
+		t.Fatalf("expected the changes of the controller and the status updates to be ignored, got %d events", len(events))
+	}
+
+	h.OnUpdate(newObject(1, fieldManager), newObject(2, "kubectl-edit"))
+	h.OnDelete(newObject(2, "kubectl-edit"))
+	h.OnDelete(toolscache.DeletedFinalStateUnknown{Key: "apps/backend", Obj: newObject(2, "kubectl-edit")})
+	if len(events) != 3 {
+		t.Errorf("expected the spec change and the deletions to be sent, got %d events", len(events))
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fluxcd/pkg/runtime/events"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// eventMaxObjects is the maximum number of objects
+// listed in the summary of a change set.
+const eventMaxObjects = 10
+
+// Reasons of the events issued by the reconciliation phases,
+// the other events have the reason of the Ready condition.
+const (
+	appliedEventReason           = "Applied"
+	prunedEventReason            = "Pruned"
+	pruneSkippedEventReason      = "PruneSkipped"
+	healthCheckPassedEventReason = "HealthCheckPassed"
+)
+
+// eventType returns the type of the Kubernetes events of the given severity.
+func eventType(severity string) string {
+	if severity == events.EventSeverityError {
+		return corev1.EventTypeWarning
+	}
+	return corev1.EventTypeNormal
+}
+
+// summarizeChangeSet returns the number of objects per action followed by
+// the first objects of the change set. The change set lines are expected to
+// start with the object ID followed by the action e.g. 'deployment/apps/backend configured'.
+// In verbose mode the change set is returned unchanged.
+func summarizeChangeSet(changeSet string, verbose bool) string {
+	if verbose {
+		return changeSet
+	}
+
+	lines := strings.Split(strings.TrimSpace(changeSet), "\n")
+	if len(lines) <= eventMaxObjects {
+		return changeSet
+	}
+
+	counts := make(map[string]int)
+	for _, line := range lines {
+		parts := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		counts[parts[1]]++
+	}
+	actions := make([]string, 0, len(counts))
+	for action := range counts {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+
+	var summary []string
+	for _, action := range actions {
+		summary = append(summary, fmt.Sprintf("%d %s", counts[action], action))
+	}
+
+	var b strings.Builder
+	b.WriteString(strings.Join(summary, ", ") + "\n")
+	for _, line := range lines[:eventMaxObjects] {
+		b.WriteString(line + "\n")
+	}
+	b.WriteString(fmt.Sprintf("and %d more\n", len(lines)-eventMaxObjects))
+	return b.String()
+}
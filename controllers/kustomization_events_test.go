@@ -0,0 +1,132 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/events"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestSummarizeChangeSet(t *testing.T) {
+	small := "deployment/apps/backend configured\n"
+	if got := summarizeChangeSet(small, false); got != small {
+		t.Errorf("expected small change set unchanged, got %q", got)
+	}
+
+	changeSet := ""
+	for i := 0; i < 12; i++ {
+		changeSet += fmt.Sprintf("configmap/apps/cm%d created\n", i)
+	}
+	for i := 0; i < 3; i++ {
+		changeSet += fmt.Sprintf("secret/apps/s%d marked for deletion\n", i)
+	}
+
+	if got := summarizeChangeSet(changeSet, true); got != changeSet {
+		t.Errorf("expected verbose mode to return the full change set")
+	}
+
+	got := summarizeChangeSet(changeSet, false)
+	lines := strings.Split(strings.TrimSpace(got), "\n")
+	if lines[0] != "12 created, 3 marked for deletion" {
+		t.Errorf("unexpected summary %q", lines[0])
+	}
+	if len(lines) != eventMaxObjects+2 {
+		t.Errorf("expected %d lines, got %d", eventMaxObjects+2, len(lines))
+	}
+	if last := lines[len(lines)-1]; last != "and 5 more" {
+		t.Errorf("unexpected last line %q", last)
+	}
+}
+
+func TestEventTypeAndReason(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := kustomizev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	recorder := record.NewFakeRecorder(10)
+	r := &KustomizationReconciler{EventRecorder: recorder, Scheme: scheme}
+
+	k := kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Name: "apps", Namespace: "dev"}}
+	meta.SetResourceCondition(&k, meta.ReadyCondition, metav1.ConditionFalse, kustomizev1.ValidationFailedReason, "invalid")
+
+	r.event(context.TODO(), k, "main/1", events.EventSeverityError, "validation failed", nil)
+	r.eventWithReason(context.TODO(), k, "main/1", events.EventSeverityInfo, prunedEventReason, "configmap/dev/test deleted", nil)
+
+	for _, want := range []string{
+		"Warning ValidationFailed validation failed",
+		"Normal Pruned configmap/dev/test deleted",
+	} {
+		if got := <-recorder.Events; got != want {
+			t.Errorf("expected event '%s', got '%s'", want, got)
+		}
+	}
+}
+
+func TestExternalEvent(t *testing.T) {
+	received := make(chan events.Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event events.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("unable to decode event: %v", err)
+		}
+		received <- event
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	scheme := runtime.NewScheme()
+	if err := kustomizev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	external, err := events.NewRecorder(server.URL, "kustomize-controller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &KustomizationReconciler{EventRecorder: record.NewFakeRecorder(10), ExternalEventRecorder: external, Scheme: scheme}
+
+	k := kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Name: "apps", Namespace: "dev"}}
+	meta.SetResourceCondition(&k, meta.ReadyCondition, metav1.ConditionFalse, kustomizev1.HealthCheckFailedReason, "failed")
+	r.event(context.TODO(), k, "main/1", events.EventSeverityError, "Health check failed, 50% objects ready", nil)
+
+	event := <-received
+	if event.InvolvedObject.Kind != kustomizev1.KustomizationKind || event.InvolvedObject.Name != "apps" ||
+		event.InvolvedObject.Namespace != "dev" {
+		t.Errorf("unexpected involved object %v", event.InvolvedObject)
+	}
+	if event.Severity != events.EventSeverityError || event.Reason != kustomizev1.HealthCheckFailedReason {
+		t.Errorf("unexpected severity '%s' and reason '%s'", event.Severity, event.Reason)
+	}
+	if event.Message != "Health check failed, 50% objects ready" {
+		t.Errorf("unexpected message '%s'", event.Message)
+	}
+	if event.Metadata["revision"] != "main/1" {
+		t.Errorf("expected the revision in the metadata, got %v", event.Metadata)
+	}
+}
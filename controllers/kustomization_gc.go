@@ -19,9 +19,11 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -30,18 +32,27 @@ import (
 )
 
 type KustomizeGarbageCollector struct {
-	snapshot    kustomizev1.Snapshot
-	newChecksum string
-	log         logr.Logger
+	snapshot      kustomizev1.Snapshot
+	newChecksum   string
+	disabledKinds map[string]bool
+	protection    *pruneProtection
+	shared        []string
+	log           logr.Logger
 	client.Client
 }
 
-func NewGarbageCollector(kubeClient client.Client, snapshot kustomizev1.Snapshot, newChecksum string, log logr.Logger) *KustomizeGarbageCollector {
+func NewGarbageCollector(kubeClient client.Client, snapshot kustomizev1.Snapshot, newChecksum string, disabledKinds []string, protection *pruneProtection, log logr.Logger) *KustomizeGarbageCollector {
+	kinds := make(map[string]bool, len(disabledKinds))
+	for _, kind := range disabledKinds {
+		kinds[kind] = true
+	}
 	return &KustomizeGarbageCollector{
-		Client:      kubeClient,
-		snapshot:    snapshot,
-		newChecksum: newChecksum,
-		log:         log,
+		Client:        kubeClient,
+		snapshot:      snapshot,
+		newChecksum:   newChecksum,
+		disabledKinds: kinds,
+		protection:    protection,
+		log:           log,
 	}
 }
 
@@ -51,11 +62,11 @@ func NewGarbageCollector(kubeClient client.Client, snapshot kustomizev1.Snapshot
 // a label selector that contains the previously applied revision.
 // The garbage collector ignores objects that are no longer present
 // on the cluster or if they are marked for deleting using Kubernetes finalizers.
-func (kgc *KustomizeGarbageCollector) Prune(timeout time.Duration, name string, namespace string) (string, bool) {
+func (kgc *KustomizeGarbageCollector) Prune(ctx context.Context, timeout time.Duration, name string, namespace string) (string, bool) {
 	changeSet := ""
 	outErr := ""
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout+time.Second)
+	ctx, cancel := context.WithTimeout(ctx, timeout+time.Second)
 	defer cancel()
 
 	for ns, gvks := range kgc.snapshot.NamespacedKinds() {
@@ -72,11 +83,11 @@ func (kgc *KustomizeGarbageCollector) Prune(timeout time.Duration, name string,
 				for _, item := range ulist.Items {
 					id := fmt.Sprintf("%s/%s/%s", item.GetKind(), item.GetNamespace(), item.GetName())
 					if kgc.shouldSkip(item) {
-						kgc.log.V(1).Info(fmt.Sprintf("gc is disabled for '%s'", id))
+						kgc.log.V(1).Info("gc is disabled", "object", id)
 						continue
 					}
 
-					if kgc.isStale(item) && item.GetDeletionTimestamp().IsZero() {
+					if kgc.isStale(item) && item.GetDeletionTimestamp().IsZero() && !kgc.isProtected(ctx, id, item) {
 						err = kgc.Delete(ctx, &item)
 						if err != nil {
 							outErr += fmt.Sprintf("delete failed for %s: %v\n", id, err)
@@ -90,7 +101,7 @@ func (kgc *KustomizeGarbageCollector) Prune(timeout time.Duration, name string,
 					}
 				}
 			} else {
-				kgc.log.V(1).Info(fmt.Sprintf("gc query failed for %s: %v", gvk.Kind, err))
+				kgc.log.V(1).Info("gc query failed", "kind", gvk.Kind, "error", err.Error())
 			}
 		}
 	}
@@ -109,11 +120,11 @@ func (kgc *KustomizeGarbageCollector) Prune(timeout time.Duration, name string,
 				id := fmt.Sprintf("%s/%s", item.GetKind(), item.GetName())
 
 				if kgc.shouldSkip(item) {
-					kgc.log.V(1).Info(fmt.Sprintf("gc is disabled for '%s'", id))
+					kgc.log.V(1).Info("gc is disabled", "object", id)
 					continue
 				}
 
-				if kgc.isStale(item) && item.GetDeletionTimestamp().IsZero() {
+				if kgc.isStale(item) && item.GetDeletionTimestamp().IsZero() && !kgc.isProtected(ctx, id, item) {
 					err = kgc.Delete(ctx, &item)
 					if err != nil {
 						outErr += fmt.Sprintf("delete failed for %s: %v\n", id, err)
@@ -127,7 +138,79 @@ func (kgc *KustomizeGarbageCollector) Prune(timeout time.Duration, name string,
 				}
 			}
 		} else {
-			kgc.log.V(1).Info(fmt.Sprintf("gc query failed for %s: %v", gvk.Kind, err))
+			kgc.log.V(1).Info("gc query failed", "kind", gvk.Kind, "error", err.Error())
+		}
+	}
+
+	if outErr != "" {
+		return outErr, false
+	}
+	return changeSet, true
+}
+
+// PruneInventory deletes the Kubernetes objects of the given stale inventory entries.
+// Namespaced objects are removed before global ones, as in CRs before CRDs.
+// The garbage collector ignores objects that are no longer present on the cluster,
+// objects that are no longer labeled as managed by the Kustomization, and objects
+// that are marked for deletion.
+func (kgc *KustomizeGarbageCollector) PruneInventory(ctx context.Context, timeout time.Duration, stale []kustomizev1.ResourceRef, name string, namespace string) (string, bool) {
+	changeSet := ""
+	outErr := ""
+
+	ctx, cancel := context.WithTimeout(ctx, timeout+time.Second)
+	defer cancel()
+
+	var objects []*unstructured.Unstructured
+	for _, entry := range stale {
+		obj, err := inventoryObject(entry)
+		if err != nil {
+			outErr += fmt.Sprintf("%v\n", err)
+			continue
+		}
+		objects = append(objects, obj)
+	}
+	sort.SliceStable(objects, func(i, j int) bool {
+		return objects[i].GetNamespace() != "" && objects[j].GetNamespace() == ""
+	})
+
+	labels := selectorLabels(name, namespace)
+	for _, obj := range objects {
+		id := objectID(obj)
+		err := kgc.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				kgc.log.V(1).Info("gc query failed", "object", id, "error", err.Error())
+			}
+			continue
+		}
+
+		if !isManagedBy(*obj, labels) {
+			if owner := labeledOwner(*obj); owner != "" {
+				kgc.shared = append(kgc.shared, fmt.Sprintf("%s (labeled by Kustomization '%s')", id, owner))
+				continue
+			}
+			kgc.log.V(1).Info("gc skipped object not managed by the Kustomization", "object", id)
+			continue
+		}
+
+		if kgc.shouldSkip(*obj) {
+			kgc.log.V(1).Info("gc is disabled", "object", id)
+			continue
+		}
+		if kgc.isProtected(ctx, id, *obj) {
+			continue
+		}
+
+		if obj.GetDeletionTimestamp().IsZero() {
+			if err := kgc.Delete(ctx, obj); err != nil {
+				outErr += fmt.Sprintf("delete failed for %s: %v\n", id, err)
+			} else {
+				if len(obj.GetFinalizers()) > 0 {
+					changeSet += fmt.Sprintf("%s marked for deletion\n", id)
+				} else {
+					changeSet += fmt.Sprintf("%s deleted\n", id)
+				}
+			}
 		}
 	}
 
@@ -137,6 +220,12 @@ func (kgc *KustomizeGarbageCollector) Prune(timeout time.Duration, name string,
 	return changeSet, true
 }
 
+// Shared returns the objects skipped by PruneInventory
+// because they are labeled as managed by another Kustomization.
+func (kgc *KustomizeGarbageCollector) Shared() []string {
+	return kgc.shared
+}
+
 // Determine staleness by checking if the annotation matches the latest checksum
 func (kgc *KustomizeGarbageCollector) isStale(obj unstructured.Unstructured) bool {
 	itemAnnotationChecksum := obj.GetAnnotations()[fmt.Sprintf("%s/checksum", kustomizev1.GroupVersion.Group)]
@@ -154,29 +243,52 @@ func (kgc *KustomizeGarbageCollector) isStale(obj unstructured.Unstructured) boo
 func (kgc *KustomizeGarbageCollector) shouldSkip(obj unstructured.Unstructured) bool {
 	key := fmt.Sprintf("%s/prune", kustomizev1.GroupVersion.Group)
 
-	return obj.GetLabels()[key] == kustomizev1.DisabledValue || obj.GetAnnotations()[key] == kustomizev1.DisabledValue
+	return kgc.disabledKinds[obj.GetKind()] ||
+		obj.GetLabels()[key] == kustomizev1.DisabledValue || obj.GetAnnotations()[key] == kustomizev1.DisabledValue
 }
 
-func (kgc *KustomizeGarbageCollector) matchingLabels(name, namespace string) client.MatchingLabels {
-	return selectorLabels(name, namespace)
+// isProtected returns true if the object is cluster-critical and must not be deleted.
+// When the protection can't be determined, the object is not deleted.
+func (kgc *KustomizeGarbageCollector) isProtected(ctx context.Context, id string, obj unstructured.Unstructured) bool {
+	if kgc.protection == nil {
+		return false
+	}
+	protected, err := kgc.protection.isProtected(ctx, kgc.Client, obj)
+	if err != nil {
+		kgc.log.Error(err, "gc skipped object, unable to determine if it is protected", "object", id)
+		return true
+	}
+	if protected {
+		kgc.log.Info("gc skipped protected object, add its kind to spec.pruneEnabledFor to allow its deletion", "object", id, "kind", obj.GetKind())
+	}
+	return protected
 }
 
-func gcLabels(name, namespace, checksum string) map[string]string {
-	return map[string]string{
-		fmt.Sprintf("%s/name", kustomizev1.GroupVersion.Group):      name,
-		fmt.Sprintf("%s/namespace", kustomizev1.GroupVersion.Group): namespace,
+// isManagedBy checks if the object has all the given labels.
+func isManagedBy(obj unstructured.Unstructured, labels map[string]string) bool {
+	for k, v := range labels {
+		if obj.GetLabels()[k] != v {
+			return false
+		}
 	}
+	return true
 }
+
+func (kgc *KustomizeGarbageCollector) matchingLabels(name, namespace string) client.MatchingLabels {
+	return selectorLabels(name, namespace)
+}
+
 func gcAnnotation(checksum string) map[string]string {
 	return map[string]string{
 		fmt.Sprintf("%s/checksum", kustomizev1.GroupVersion.Group): checksum,
 	}
 }
 
+// selectorLabels returns the labels set by the controller on all the objects
+// applied from a Kustomization, used to select the objects for garbage collection.
 func selectorLabels(name, namespace string) map[string]string {
 	return map[string]string{
 		fmt.Sprintf("%s/name", kustomizev1.GroupVersion.Group):      name,
 		fmt.Sprintf("%s/namespace", kustomizev1.GroupVersion.Group): namespace,
 	}
-
 }
@@ -0,0 +1,89 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestShouldSkip(t *testing.T) {
+	objects, err := readObjects([]byte(`---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: annotated
+  annotations:
+    kustomize.toolkit.fluxcd.io/prune: disabled
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: labeled
+  labels:
+    kustomize.toolkit.fluxcd.io/prune: disabled
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: default
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	kgc := &KustomizeGarbageCollector{}
+	for _, obj := range objects {
+		want := obj.GetName() != "default"
+		if got := kgc.shouldSkip(*obj); got != want {
+			t.Errorf("shouldSkip(%s) = %v, want %v", obj.GetName(), got, want)
+		}
+	}
+}
+
+func TestShouldSkipKind(t *testing.T) {
+	objects, err := readObjects([]byte(`---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: apps
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: data
+  namespace: apps
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  namespace: apps
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	kgc := NewGarbageCollector(nil, kustomizev1.Snapshot{}, "", []string{"Namespace", "PersistentVolumeClaim"}, nil, nil)
+	for _, obj := range objects {
+		want := obj.GetKind() != "ConfigMap"
+		if got := kgc.shouldSkip(*obj); got != want {
+			t.Errorf("shouldSkip(%s) = %v, want %v", obj.GetKind(), got, want)
+		}
+	}
+}
@@ -25,8 +25,8 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-	"sync"
 
+	"k8s.io/apimachinery/pkg/util/version"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/kustomize/api/filesys"
 	"sigs.k8s.io/kustomize/api/konfig"
@@ -43,13 +43,37 @@ import (
 const (
 	transformerFileName           = "kustomization-gc-labels.yaml"
 	transformerAnnotationFileName = "kustomization-gc-annotations.yaml"
+
+	// kustomizeVersion is the version of kustomize embedded in the controller,
+	// it must match the version of the kustomize/api module pinned in go.mod.
+	kustomizeVersion = "v4.2.0"
 )
 
+// kustomizeVersionAnnotation is the annotation of the kustomization.yaml
+// metadata used to declare the minimum kustomize version required to build it.
+var kustomizeVersionAnnotation = fmt.Sprintf("%s/kustomize-version", kustomizev1.GroupVersion.Group)
+
 type KustomizeGenerator struct {
 	kustomization kustomizev1.Kustomization
 	client.Client
 }
 
+// MissingKustomizationError is returned when the path of the Kustomization
+// contains neither a kustomization.yaml file nor Kubernetes manifests
+// a kustomization.yaml could be generated for.
+type MissingKustomizationError struct {
+	Path string
+}
+
+func (e *MissingKustomizationError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "./"
+	}
+	return fmt.Sprintf("no kustomization.yaml or Kubernetes manifests found at path '%s', "+
+		"add a kustomization.yaml or set spec.path to the directory containing the manifests", path)
+}
+
 func NewGenerator(kustomization kustomizev1.Kustomization, kubeClient client.Client) *KustomizeGenerator {
 	return &KustomizeGenerator{
 		kustomization: kustomization,
@@ -65,7 +89,7 @@ func (kg *KustomizeGenerator) WriteFile(ctx context.Context, dirPath string) (st
 		return "", err
 	}
 
-	if err := kg.generateLabelTransformer(checksum, dirPath); err != nil {
+	if err := kg.generateLabelTransformer(dirPath); err != nil {
 		return "", err
 	}
 	if err = kg.generateAnnotationTransformer(checksum, dirPath); err != nil {
@@ -220,6 +244,9 @@ func (kg *KustomizeGenerator) generateKustomization(dirPath string) error {
 	if err != nil {
 		return err
 	}
+	if len(files) == 0 {
+		return &MissingKustomizationError{Path: kg.kustomization.Spec.Path}
+	}
 
 	kfile := filepath.Join(dirPath, konfig.DefaultKustomizationFileName())
 	f, err := fs.Create(kfile)
@@ -254,8 +281,12 @@ func (kg *KustomizeGenerator) checksum(ctx context.Context, dirPath string) (str
 		return "", fmt.Errorf("kustomize create failed: %w", err)
 	}
 
+	if err := checkKustomizeVersion(dirPath); err != nil {
+		return "", err
+	}
+
 	fs := filesys.MakeFsOnDisk()
-	m, err := buildKustomization(fs, dirPath)
+	m, err := buildKustomization(ctx, fs, dirPath)
 	if err != nil {
 		return "", fmt.Errorf("kustomize build failed: %w", err)
 	}
@@ -327,11 +358,11 @@ func (kg *KustomizeGenerator) generateAnnotationTransformer(checksum, dirPath st
 	return nil
 }
 
-func (kg *KustomizeGenerator) generateLabelTransformer(checksum, dirPath string) error {
+func (kg *KustomizeGenerator) generateLabelTransformer(dirPath string) error {
+	// the labels are set regardless of spec.prune, so that
+	// the objects can be garbage collected once pruning is enabled
 	labels := selectorLabels(kg.kustomization.GetName(), kg.kustomization.GetNamespace())
 
-	labels = gcLabels(kg.kustomization.GetName(), kg.kustomization.GetNamespace(), checksum)
-
 	var lt = struct {
 		ApiVersion string `json:"apiVersion" yaml:"apiVersion"`
 		Kind       string `json:"kind" yaml:"kind"`
@@ -381,18 +412,15 @@ func adaptSelector(selector *kustomize.Selector) (output *kustypes.Selector) {
 	return
 }
 
-// TODO: remove mutex when kustomize fixes the concurrent map read/write panic
-var kustomizeBuildMutex sync.Mutex
-
 // buildKustomization wraps krusty.MakeKustomizer with the following settings:
 // - reorder the resources just before output (Namespaces and Cluster roles/role bindings first, CRDs before CRs, Webhooks last)
 // - load files from outside the kustomization.yaml root
 // - disable plugins except for the builtin ones
-func buildKustomization(fs filesys.FileSystem, dirPath string) (resmap.ResMap, error) {
+func buildKustomization(ctx context.Context, fs filesys.FileSystem, dirPath string) (resmap.ResMap, error) {
 	// temporary workaround for concurrent map read and map write bug
 	// https://github.com/kubernetes-sigs/kustomize/issues/3659
-	kustomizeBuildMutex.Lock()
-	defer kustomizeBuildMutex.Unlock()
+	unlock := lockKustomizeBuild(ctx)
+	defer unlock()
 
 	buildOptions := &krusty.Options{
 		DoLegacyResourceSort: true,
@@ -415,3 +443,40 @@ func find(source []string, value string) bool {
 
 	return false
 }
+
+// checkKustomizeVersion returns an error if the kustomization file at the
+// root of the given directory requires a kustomize version that is newer than
+// the embedded one, or that has a different major version.
+func checkKustomizeVersion(dirPath string) error {
+	var data []byte
+	for _, kfilename := range konfig.RecognizedKustomizationFileNames() {
+		b, err := ioutil.ReadFile(filepath.Join(dirPath, kfilename))
+		if err == nil {
+			data = b
+			break
+		}
+	}
+	if data == nil {
+		return nil
+	}
+
+	var kus kustypes.Kustomization
+	if err := yaml.Unmarshal(data, &kus); err != nil {
+		return err
+	}
+	if kus.MetaData == nil || kus.MetaData.Annotations[kustomizeVersionAnnotation] == "" {
+		return nil
+	}
+
+	value := kus.MetaData.Annotations[kustomizeVersionAnnotation]
+	required, err := version.ParseGeneric(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s annotation value '%s': %w", kustomizeVersionAnnotation, value, err)
+	}
+	embedded := version.MustParseSemantic(kustomizeVersion)
+	if required.Major() != embedded.Major() || !embedded.AtLeast(required) {
+		return fmt.Errorf("kustomize version mismatch: the kustomization requires %s, the controller embeds kustomize %s",
+			value, kustomizeVersion)
+	}
+	return nil
+}
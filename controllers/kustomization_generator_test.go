@@ -0,0 +1,100 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestCheckKustomizeVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		required string
+		wantErr  bool
+	}{
+		{name: "not set", required: ""},
+		{name: "older", required: "v4.1.0"},
+		{name: "minor only", required: "4.2"},
+		{name: "same", required: kustomizeVersion},
+		{name: "newer", required: "v4.3.0", wantErr: true},
+		{name: "previous major", required: "v3.8.0", wantErr: true},
+		{name: "invalid", required: "latest", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir, err := ioutil.TempDir("", "kustomize-version")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			kfile := "apiVersion: kustomize.config.k8s.io/v1beta1\nkind: Kustomization\nresources: []\n"
+			if tt.required != "" {
+				kfile += fmt.Sprintf("metadata:\n  annotations:\n    %s: %q\n", kustomizeVersionAnnotation, tt.required)
+			}
+			if err := ioutil.WriteFile(filepath.Join(tmpDir, "kustomization.yaml"), []byte(kfile), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err = checkKustomizeVersion(tmpDir)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestGenerateKustomizationMissing(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "kustomize-missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "README.md"), []byte("# apps\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	kustomization := kustomizev1.Kustomization{}
+	kustomization.Spec.Path = "./apps"
+	gen := NewGenerator(kustomization, nil)
+
+	err = gen.generateKustomization(tmpDir)
+	var missing *MissingKustomizationError
+	if !errors.As(err, &missing) || missing.Path != "./apps" {
+		t.Fatalf("expected missing kustomization error, got %v", err)
+	}
+
+	deployment := "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: backend\n"
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "deployment.yaml"), []byte(deployment), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := gen.generateKustomization(tmpDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "kustomization.yaml")); err != nil {
+		t.Errorf("expected kustomization.yaml to be generated: %v", err)
+	}
+}
@@ -19,17 +19,23 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"strings"
 	"time"
 
+	securejoin "github.com/cyphar/filepath-securejoin"
 	"github.com/fluxcd/pkg/apis/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"sigs.k8s.io/cli-utils/pkg/kstatus/polling"
 	"sigs.k8s.io/cli-utils/pkg/kstatus/polling/aggregator"
 	"sigs.k8s.io/cli-utils/pkg/kstatus/polling/collector"
 	"sigs.k8s.io/cli-utils/pkg/kstatus/polling/event"
 	"sigs.k8s.io/cli-utils/pkg/kstatus/status"
 	"sigs.k8s.io/cli-utils/pkg/object"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
 
 	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
 )
@@ -37,23 +43,82 @@ import (
 type KustomizeHealthCheck struct {
 	kustomization kustomizev1.Kustomization
 	statusPoller  *polling.StatusPoller
+	client        client.Client
 }
 
-func NewHealthCheck(kustomization kustomizev1.Kustomization, statusPoller *polling.StatusPoller) *KustomizeHealthCheck {
+func NewHealthCheck(kustomization kustomizev1.Kustomization, statusPoller *polling.StatusPoller, kubeClient client.Client) *KustomizeHealthCheck {
 	return &KustomizeHealthCheck{
 		kustomization: kustomization,
 		statusPoller:  statusPoller,
+		client:        kubeClient,
 	}
 }
 
-func (hc *KustomizeHealthCheck) Assess(pollInterval time.Duration) error {
+func (hc *KustomizeHealthCheck) Assess(ctx context.Context, pollInterval time.Duration) error {
 	objMetadata, err := hc.toObjMetadata(hc.kustomization.Spec.HealthChecks)
 	if err != nil {
 		return err
 	}
 
+	exprs, err := compileHealthExprs(hc.kustomization.Spec.HealthCheckExprs)
+	if err != nil {
+		return err
+	}
+
+	// the custom resources with health check expressions, the objects
+	// reconciled by the Flux controllers and the Jobs are assessed separately
+	var standard, custom []object.ObjMetadata
+	evaluators := make(map[schema.GroupKind]healthEvaluator)
+	for _, om := range objMetadata {
+		switch {
+		case exprs[om.GroupKind] != nil:
+			evaluators[om.GroupKind] = exprs[om.GroupKind]
+			custom = append(custom, om)
+		case isToolkitKind(om.GroupKind):
+			evaluators[om.GroupKind] = readyConditionHealth{}
+			custom = append(custom, om)
+		case om.GroupKind == jobGroupKind:
+			evaluators[om.GroupKind] = jobCompletionHealth{}
+			custom = append(custom, om)
+		default:
+			standard = append(standard, om)
+		}
+	}
+
 	timeout := hc.kustomization.GetTimeout() + (time.Second * 1)
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if len(standard) > 0 {
+		if err := hc.assessStatus(ctx, standard, pollInterval); err != nil {
+			return err
+		}
+	}
+	if len(custom) > 0 {
+		if err := hc.assessCustom(ctx, custom, evaluators, pollInterval); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HealthCheckError is returned when some of the objects
+// did not become ready within the timeout.
+type HealthCheckError struct {
+	// NotReady holds the objects that are not ready, with their status.
+	NotReady []string
+	// Total is the number of objects assessed.
+	Total int
+}
+
+func (e *HealthCheckError) Error() string {
+	return fmt.Sprintf("Health check failed for [%s], %d/%d objects ready",
+		strings.Join(e.NotReady, ", "), e.Total-len(e.NotReady), e.Total)
+}
+
+// assessStatus waits for the objects to reach the current status computed by kstatus.
+func (hc *KustomizeHealthCheck) assessStatus(ctx context.Context, objMetadata []object.ObjMetadata, pollInterval time.Duration) error {
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	opts := polling.Options{PollInterval: pollInterval, UseCache: true}
@@ -104,12 +169,66 @@ func (hc *KustomizeHealthCheck) Assess(pollInterval time.Duration) error {
 				errors = append(errors, bld.String())
 			}
 		}
-		return fmt.Errorf("Health check failed for [%s]", strings.Join(errors, ", "))
+		return &HealthCheckError{NotReady: errors, Total: len(objMetadata)}
 	}
 
 	return nil
 }
 
+// assessCustom waits for the objects to reach the current status computed
+// by the evaluator of their kind. It returns early if any of the objects failed.
+func (hc *KustomizeHealthCheck) assessCustom(ctx context.Context, objMetadata []object.ObjMetadata, evaluators map[schema.GroupKind]healthEvaluator, pollInterval time.Duration) error {
+	results := make(map[object.ObjMetadata]string)
+	failed := false
+	err := wait.PollImmediateUntil(pollInterval, func() (bool, error) {
+		ready := true
+		for _, om := range objMetadata {
+			mapping, err := hc.client.RESTMapper().RESTMapping(om.GroupKind)
+			if err != nil {
+				results[om] = fmt.Sprintf("%s: %s", hc.objMetadataToString(om), err)
+				ready = false
+				continue
+			}
+			obj := &unstructured.Unstructured{}
+			obj.SetGroupVersionKind(mapping.GroupVersionKind)
+			if err := hc.client.Get(ctx, client.ObjectKey{Namespace: om.Namespace, Name: om.Name}, obj); err != nil {
+				results[om] = fmt.Sprintf("%s: %s", hc.objMetadataToString(om), err)
+				ready = false
+				continue
+			}
+
+			result, err := evaluators[om.GroupKind].evaluate(obj)
+			msg := fmt.Sprintf("%s (status '%s')", hc.objMetadataToString(om), result)
+			if err != nil {
+				msg += fmt.Sprintf(": %s", err)
+			}
+			switch result {
+			case status.CurrentStatus:
+				delete(results, om)
+			case status.FailedStatus:
+				results[om] = msg
+				failed = true
+			default:
+				results[om] = msg
+				ready = false
+			}
+		}
+		return ready || failed, nil
+	}, ctx.Done())
+
+	if err == nil && !failed {
+		return nil
+	}
+
+	var errors []string
+	for _, om := range objMetadata {
+		if msg, ok := results[om]; ok {
+			errors = append(errors, msg)
+		}
+	}
+	return &HealthCheckError{NotReady: errors, Total: len(objMetadata)}
+}
+
 func (hc *KustomizeHealthCheck) toObjMetadata(cr []meta.NamespacedObjectKindReference) ([]object.ObjMetadata, error) {
 	oo := []object.ObjMetadata{}
 	for _, c := range cr {
@@ -137,3 +256,72 @@ func (hc *KustomizeHealthCheck) toObjMetadata(cr []meta.NamespacedObjectKindRefe
 func (hc *KustomizeHealthCheck) objMetadataToString(om object.ObjMetadata) string {
 	return fmt.Sprintf("%s '%s/%s'", om.GroupKind.Kind, om.Namespace, om.Name)
 }
+
+// readHealthChecks reads the health checks defined in the given file,
+// relative to the root of the artifact. The file must contain a list
+// of objects in the same format as spec.healthChecks.
+func readHealthChecks(root, path string) ([]meta.NamespacedObjectKindReference, error) {
+	filePath, err := securejoin.SecureJoin(root, path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read health checks from '%s': %w", path, err)
+	}
+
+	var checks []meta.NamespacedObjectKindReference
+	if err := yaml.UnmarshalStrict(data, &checks); err != nil {
+		return nil, fmt.Errorf("unable to decode health checks from '%s': %w", path, err)
+	}
+
+	for i, c := range checks {
+		if c.Kind == "" || c.Name == "" || c.Namespace == "" {
+			return nil, fmt.Errorf("invalid health check at index %d in '%s': kind, name and namespace are required", i, path)
+		}
+		if c.APIVersion != "" {
+			if _, err := schema.ParseGroupVersion(c.APIVersion); err != nil {
+				return nil, fmt.Errorf("invalid health check at index %d in '%s': %w", i, path, err)
+			}
+		}
+	}
+	return checks, nil
+}
+
+// mergeHealthChecks appends the health checks read from the artifact
+// to the ones defined in the Kustomization spec, skipping duplicates.
+func mergeHealthChecks(spec, file []meta.NamespacedObjectKindReference) []meta.NamespacedObjectKindReference {
+	merged := append([]meta.NamespacedObjectKindReference{}, spec...)
+	for _, c := range file {
+		duplicate := false
+		for _, m := range merged {
+			if m == c {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			merged = append(merged, c)
+		}
+	}
+	return merged
+}
+
+// inventoryHealthChecks returns the health checks
+// for the objects recorded in the inventory.
+func inventoryHealthChecks(inventory *kustomizev1.ResourceInventory) ([]meta.NamespacedObjectKindReference, error) {
+	var checks []meta.NamespacedObjectKindReference
+	for _, entry := range inventory.Entries {
+		obj, err := inventoryObject(entry)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, meta.NamespacedObjectKindReference{
+			APIVersion: obj.GetAPIVersion(),
+			Kind:       obj.GetKind(),
+			Name:       obj.GetName(),
+			Namespace:  obj.GetNamespace(),
+		})
+	}
+	return checks, nil
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fluxcd/pkg/apis/meta"
+)
+
+func TestReadHealthChecks(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "healthchecks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	valid := `- apiVersion: apps/v1
+  kind: Deployment
+  name: backend
+  namespace: dev
+- apiVersion: apps/v1
+  kind: Deployment
+  name: frontend
+  namespace: dev
+`
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "valid.yaml"), []byte(valid), 0644); err != nil {
+		t.Fatal(err)
+	}
+	invalid := `- apiVersion: apps/v1
+  kind: Deployment
+  name: backend
+`
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "invalid.yaml"), []byte(invalid), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	checks, err := readHealthChecks(tmpDir, "./valid.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(checks) != 2 {
+		t.Fatalf("expected 2 health checks, got %v", checks)
+	}
+
+	spec := []meta.NamespacedObjectKindReference{checks[0]}
+	merged := mergeHealthChecks(spec, checks)
+	if len(merged) != 2 || merged[1].Name != "frontend" {
+		t.Errorf("expected duplicates to be skipped, got %v", merged)
+	}
+
+	if _, err := readHealthChecks(tmpDir, "./invalid.yaml"); err == nil {
+		t.Error("expected error for health check without namespace")
+	}
+	if _, err := readHealthChecks(tmpDir, "./missing.yaml"); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestInventoryHealthChecks(t *testing.T) {
+	objects, err := readObjects([]byte(`---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: dev
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: backend
+  namespace: dev
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	checks, err := inventoryHealthChecks(newInventory(objects))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []meta.NamespacedObjectKindReference{
+		{APIVersion: "v1", Kind: "Namespace", Name: "dev"},
+		{APIVersion: "apps/v1", Kind: "Deployment", Name: "backend", Namespace: "dev"},
+	}
+	if len(checks) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, checks)
+	}
+	for i := range expected {
+		if checks[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], checks[i])
+		}
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/cli-utils/pkg/kstatus/status"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// healthExprs holds the compiled CEL expressions of a custom health check.
+type healthExprs struct {
+	current    cel.Program
+	inProgress cel.Program
+	failed     cel.Program
+}
+
+// compileHealthExprs compiles the CEL expressions of the custom health checks,
+// and returns them indexed by the group and kind of the custom resources.
+func compileHealthExprs(checks []kustomizev1.CustomHealthCheck) (map[schema.GroupKind]*healthExprs, error) {
+	if len(checks) == 0 {
+		return nil, nil
+	}
+
+	env, err := newObjectEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	compile := func(check kustomizev1.CustomHealthCheck, name, expr string) (cel.Program, error) {
+		if expr == "" {
+			return nil, nil
+		}
+		program, err := compileObjectExpr(env, expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s expression for %s: %w", name, check.Kind, err)
+		}
+		return program, nil
+	}
+
+	result := make(map[schema.GroupKind]*healthExprs)
+	for _, check := range checks {
+		gv, err := schema.ParseGroupVersion(check.APIVersion)
+		if err != nil {
+			return nil, fmt.Errorf("invalid apiVersion for %s: %w", check.Kind, err)
+		}
+		exprs := &healthExprs{}
+		if exprs.current, err = compile(check, "current", check.Current); err != nil {
+			return nil, err
+		}
+		if exprs.current == nil {
+			return nil, fmt.Errorf("missing current expression for %s", check.Kind)
+		}
+		if exprs.inProgress, err = compile(check, "inProgress", check.InProgress); err != nil {
+			return nil, err
+		}
+		if exprs.failed, err = compile(check, "failed", check.Failed); err != nil {
+			return nil, err
+		}
+		result[schema.GroupKind{Group: gv.Group, Kind: check.Kind}] = exprs
+	}
+	return result, nil
+}
+
+// newObjectEnv returns a CEL environment declaring the
+// metadata, spec and status of an object as variables.
+func newObjectEnv() (*cel.Env, error) {
+	return cel.NewEnv(cel.Declarations(
+		decls.NewVar("metadata", decls.NewMapType(decls.String, decls.Dyn)),
+		decls.NewVar("spec", decls.NewMapType(decls.String, decls.Dyn)),
+		decls.NewVar("status", decls.NewMapType(decls.String, decls.Dyn)),
+	))
+}
+
+// compileObjectExpr compiles an expression that must evaluate to a bool.
+func compileObjectExpr(env *cel.Env, expr string) (cel.Program, error) {
+	ast, issues := env.Compile(expr)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	// the fields of the objects are dynamically typed
+	if t := cel.FormatType(ast.ResultType()); t != "bool" && t != "dyn" {
+		return nil, fmt.Errorf("must evaluate to a bool, got %s", t)
+	}
+	return env.Program(ast)
+}
+
+// objectVars returns the metadata, spec and status of the object as
+// CEL variables, the missing fields are set to empty maps.
+func objectVars(obj *unstructured.Unstructured) map[string]interface{} {
+	vars := map[string]interface{}{}
+	for _, key := range []string{"metadata", "spec", "status"} {
+		value, ok := obj.Object[key].(map[string]interface{})
+		if !ok {
+			value = map[string]interface{}{}
+		}
+		vars[key] = value
+	}
+	return vars
+}
+
+// evaluate computes the status of the object. The expressions are evaluated
+// in the failed, in progress, current order, and the first one that returns
+// true determines the status. The object is in progress if none of them
+// returns true. An expression that refers to missing fields is considered
+// false, the last evaluation error is returned for reporting.
+func (h *healthExprs) evaluate(obj *unstructured.Unstructured) (status.Status, error) {
+	vars := objectVars(obj)
+	var lastErr error
+	for _, e := range []struct {
+		program cel.Program
+		status  status.Status
+	}{
+		{h.failed, status.FailedStatus},
+		{h.inProgress, status.InProgressStatus},
+		{h.current, status.CurrentStatus},
+	} {
+		if e.program == nil {
+			continue
+		}
+		out, _, err := e.program.Eval(vars)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if out == types.True {
+			return e.status, nil
+		}
+	}
+	return status.InProgressStatus, lastErr
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/cli-utils/pkg/kstatus/status"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestHealthExprs(t *testing.T) {
+	exprs, err := compileHealthExprs([]kustomizev1.CustomHealthCheck{
+		{
+			APIVersion: "example.com/v1",
+			Kind:       "Database",
+			Current:    "status.phase == 'Running'",
+			InProgress: "status.phase == 'Provisioning'",
+			Failed:     "status.phase == 'Error'",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h := exprs[schema.GroupKind{Group: "example.com", Kind: "Database"}]
+	if h == nil {
+		t.Fatalf("expected expressions for example.com/Database, got %v", exprs)
+	}
+
+	tests := []struct {
+		name     string
+		manifest string
+		want     status.Status
+		wantErr  bool
+	}{
+		{
+			name:     "running",
+			manifest: "status:\n  phase: Running\n",
+			want:     status.CurrentStatus,
+		},
+		{
+			name:     "provisioning",
+			manifest: "status:\n  phase: Provisioning\n",
+			want:     status.InProgressStatus,
+		},
+		{
+			name:     "error",
+			manifest: "status:\n  phase: Error\n",
+			want:     status.FailedStatus,
+		},
+		{
+			name:     "no status",
+			manifest: "spec: {}\n",
+			want:     status.InProgressStatus,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects, err := readObjects([]byte("apiVersion: example.com/v1\nkind: Database\nmetadata:\n  name: test\n" + tt.manifest))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := h.evaluate(objects[0])
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	invalid := []kustomizev1.CustomHealthCheck{
+		{APIVersion: "example.com/v1", Kind: "Database"},
+		{APIVersion: "example.com/v1", Kind: "Database", Current: "status.phase =="},
+		{APIVersion: "example.com/v1", Kind: "Database", Current: "'Running'"},
+	}
+	for _, check := range invalid {
+		if _, err := compileHealthExprs([]kustomizev1.CustomHealthCheck{check}); err == nil {
+			t.Errorf("expected error for %+v", check)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/cli-utils/pkg/kstatus/status"
+)
+
+// jobGroupKind is the group kind of the Jobs assessed by jobCompletionHealth.
+var jobGroupKind = schema.GroupKind{Group: batchv1.GroupName, Kind: "Job"}
+
+// jobCompletionHealth computes the status of Jobs from their Complete and
+// Failed conditions. Unlike kstatus, which considers a Job current as soon as
+// its pods are started, a Job is healthy only once it completed successfully.
+type jobCompletionHealth struct{}
+
+// evaluate returns the current status if the Job completed, the failed status
+// with the message of the Failed condition if the Job failed, and the in
+// progress status otherwise.
+func (jobCompletionHealth) evaluate(obj *unstructured.Unstructured) (status.Status, error) {
+	job := &batchv1.Job{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, job); err != nil {
+		return status.UnknownStatus, err
+	}
+
+	done, err := jobFinished(job)
+	switch {
+	case err != nil:
+		return status.FailedStatus, err
+	case done:
+		return status.CurrentStatus, nil
+	default:
+		return status.InProgressStatus, nil
+	}
+}
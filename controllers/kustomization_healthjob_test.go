@@ -0,0 +1,93 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	"sigs.k8s.io/cli-utils/pkg/kstatus/status"
+)
+
+func TestJobCompletionHealth(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		expected status.Status
+		message  string
+	}{
+		{
+			name: "complete",
+			manifest: `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+status:
+  succeeded: 1
+  conditions:
+  - type: Complete
+    status: "True"
+`,
+			expected: status.CurrentStatus,
+		},
+		{
+			name: "running",
+			manifest: `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+status:
+  active: 1
+`,
+			expected: status.InProgressStatus,
+		},
+		{
+			name: "failed",
+			manifest: `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+status:
+  failed: 3
+  conditions:
+  - type: Failed
+    status: "True"
+    reason: BackoffLimitExceeded
+    message: "Job has reached the specified backoff limit"
+`,
+			expected: status.FailedStatus,
+			message:  "BackoffLimitExceeded: Job has reached the specified backoff limit",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects, err := readObjects([]byte(tt.manifest))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			result, err := jobCompletionHealth{}.evaluate(objects[0])
+			if result != tt.expected {
+				t.Errorf("expected %s, got %s (%v)", tt.expected, result, err)
+			}
+			if tt.message != "" && (err == nil || err.Error() != tt.message) {
+				t.Errorf("expected message %q, got %v", tt.message, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/cli-utils/pkg/kstatus/status"
+)
+
+// toolkitGroupSuffix is the API group suffix of the
+// objects reconciled by the Flux controllers.
+const toolkitGroupSuffix = ".toolkit.fluxcd.io"
+
+// healthEvaluator computes the status of an object.
+type healthEvaluator interface {
+	evaluate(obj *unstructured.Unstructured) (status.Status, error)
+}
+
+// readyConditionHealth computes the status of the objects reconciled by the
+// Flux controllers, such as Kustomizations and HelmReleases, from their Ready
+// and Stalled conditions. These objects don't set the conditions assessed by
+// kstatus, which considers them current as soon as they exist.
+type readyConditionHealth struct{}
+
+// isToolkitKind returns true if the kind is reconciled by a Flux controller.
+func isToolkitKind(gk schema.GroupKind) bool {
+	return strings.HasSuffix(gk.Group, toolkitGroupSuffix)
+}
+
+// evaluate returns the current status if the object has a Ready condition
+// set to true for its latest generation, the failed status if the object is
+// stalled, and the in progress status otherwise. The message of the Ready
+// condition is returned for reporting.
+func (readyConditionHealth) evaluate(obj *unstructured.Unstructured) (status.Status, error) {
+	if hasTrueCondition(obj, meta.StalledCondition) {
+		return status.FailedStatus, fmt.Errorf("stalled: %s", conditionMessage(obj, meta.StalledCondition))
+	}
+	if observed, found, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration"); found && observed != obj.GetGeneration() {
+		return status.InProgressStatus, nil
+	}
+	if !hasTrueCondition(obj, meta.ReadyCondition) {
+		if msg := conditionMessage(obj, meta.ReadyCondition); msg != "" {
+			return status.InProgressStatus, fmt.Errorf("%s", msg)
+		}
+		return status.InProgressStatus, nil
+	}
+	return status.CurrentStatus, nil
+}
+
+// conditionMessage returns the message of the given condition type, if any.
+func conditionMessage(obj *unstructured.Unstructured, conditionType string) string {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok || cond["type"] != conditionType {
+			continue
+		}
+		msg, _ := cond["message"].(string)
+		return msg
+	}
+	return ""
+}
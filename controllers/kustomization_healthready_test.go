@@ -0,0 +1,130 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/cli-utils/pkg/kstatus/status"
+)
+
+func TestReadyConditionHealth(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		expected status.Status
+		message  string
+	}{
+		{
+			name: "ready",
+			manifest: `
+apiVersion: kustomize.toolkit.fluxcd.io/v1beta1
+kind: Kustomization
+metadata:
+  name: apps
+  generation: 2
+status:
+  observedGeneration: 2
+  conditions:
+  - type: Ready
+    status: "True"
+`,
+			expected: status.CurrentStatus,
+		},
+		{
+			name: "outdated generation",
+			manifest: `
+apiVersion: helm.toolkit.fluxcd.io/v2beta1
+kind: HelmRelease
+metadata:
+  name: redis
+  generation: 3
+status:
+  observedGeneration: 2
+  conditions:
+  - type: Ready
+    status: "True"
+`,
+			expected: status.InProgressStatus,
+		},
+		{
+			name: "not ready",
+			manifest: `
+apiVersion: kustomize.toolkit.fluxcd.io/v1beta1
+kind: Kustomization
+metadata:
+  name: apps
+status:
+  conditions:
+  - type: Ready
+    status: "False"
+    message: "Health check failed"
+`,
+			expected: status.InProgressStatus,
+			message:  "Health check failed",
+		},
+		{
+			name: "no status",
+			manifest: `
+apiVersion: kustomize.toolkit.fluxcd.io/v1beta1
+kind: Kustomization
+metadata:
+  name: apps
+`,
+			expected: status.InProgressStatus,
+		},
+		{
+			name: "stalled",
+			manifest: `
+apiVersion: kustomize.toolkit.fluxcd.io/v1beta1
+kind: Kustomization
+metadata:
+  name: apps
+status:
+  conditions:
+  - type: Stalled
+    status: "True"
+    message: "artifact not found"
+`,
+			expected: status.FailedStatus,
+			message:  "stalled: artifact not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects, err := readObjects([]byte(tt.manifest))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			result, err := readyConditionHealth{}.evaluate(objects[0])
+			if result != tt.expected {
+				t.Errorf("expected %s, got %s (%v)", tt.expected, result, err)
+			}
+			if tt.message != "" && (err == nil || err.Error() != tt.message) {
+				t.Errorf("expected message %q, got %v", tt.message, err)
+			}
+		})
+	}
+
+	if !isToolkitKind(schema.GroupKind{Group: "helm.toolkit.fluxcd.io", Kind: "HelmRelease"}) {
+		t.Error("expected HelmRelease to be a toolkit kind")
+	}
+	if isToolkitKind(schema.GroupKind{Group: "apps", Kind: "Deployment"}) {
+		t.Error("expected Deployment not to be a toolkit kind")
+	}
+}
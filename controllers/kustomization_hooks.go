@@ -0,0 +1,312 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/api/resource"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+	"github.com/fluxcd/kustomize-controller/pkg/validation"
+)
+
+// hookRevisionAnnotation records the source revision a hook Job was run for,
+// a completed Job is not run again for the same revision.
+var hookRevisionAnnotation = fmt.Sprintf("%s/hook-revision", kustomizev1.GroupVersion.Group)
+
+// Hook phases.
+const (
+	preApplyHook  = "pre-apply"
+	postApplyHook = "post-apply"
+)
+
+// HookFailedError is returned when a hook Job fails
+// or doesn't complete within the timeout.
+type HookFailedError struct {
+	Phase  string
+	Job    string
+	Reason string
+}
+
+func (e *HookFailedError) Error() string {
+	return fmt.Sprintf("%s hook %s failed: %s", e.Phase, e.Job, e.Reason)
+}
+
+// hooksFile returns the path of the file holding the hook Jobs of the build output.
+func hooksFile(dirPath string, kustomization kustomizev1.Kustomization) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%s-hooks.yaml", kustomization.GetUID()))
+}
+
+// hookReferences returns the pre-apply and post-apply hooks, in order.
+func hookReferences(kustomization kustomizev1.Kustomization) []kustomizev1.HookReference {
+	if kustomization.Spec.Hooks == nil {
+		return nil
+	}
+	var refs []kustomizev1.HookReference
+	refs = append(refs, kustomization.Spec.Hooks.PreApply...)
+	refs = append(refs, kustomization.Spec.Hooks.PostApply...)
+	return refs
+}
+
+// isHookJob returns true if the resource is the Job referenced by the hook.
+func isHookJob(ref kustomizev1.HookReference, res *resource.Resource) bool {
+	gvk := res.GetGvk()
+	return gvk.Group == batchv1.GroupName && gvk.Kind == "Job" &&
+		res.GetName() == ref.Name && (ref.Namespace == "" || res.GetNamespace() == ref.Namespace)
+}
+
+// extractHookJobs removes the Jobs referenced by the hooks from the build
+// output and returns them as a multi-doc YAML, so that they are not applied
+// with the other objects. A hook that matches no Job or more than one Job
+// is an error.
+func extractHookJobs(m resmap.ResMap, kustomization kustomizev1.Kustomization) ([]byte, error) {
+	refs := hookReferences(kustomization)
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	hooks := resmap.New()
+	for _, ref := range refs {
+		var matches []*resource.Resource
+		for _, res := range m.Resources() {
+			if isHookJob(ref, res) {
+				matches = append(matches, res)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			return nil, fmt.Errorf("hook Job '%s' not found in the build output", ref.Name)
+		case 1:
+		default:
+			return nil, fmt.Errorf("hook Job '%s' matches %d Jobs, the namespace must be specified", ref.Name, len(matches))
+		}
+
+		res := matches[0]
+		if err := m.Remove(res.CurId()); err != nil {
+			return nil, err
+		}
+		if err := hooks.Append(res); err != nil {
+			return nil, err
+		}
+	}
+	return hooks.AsYaml()
+}
+
+// readBuildAndHookObjects returns the objects of the build output along with
+// the hook Jobs set aside by the build, so that the policies enforced on the
+// build output apply to the hooks too.
+func readBuildAndHookObjects(dirPath string, kustomization kustomizev1.Kustomization) ([]*unstructured.Unstructured, error) {
+	objects, err := readBuildObjects(dirPath, kustomization)
+	if err != nil || len(hookReferences(kustomization)) == 0 {
+		return objects, err
+	}
+
+	data, err := ioutil.ReadFile(hooksFile(dirPath, kustomization))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the hook Jobs: %w", err)
+	}
+	jobs, err := readObjects(data)
+	if err != nil {
+		return nil, err
+	}
+	return append(objects, jobs...), nil
+}
+
+// readHookJobs returns the Jobs of the given hooks, in order,
+// from the file written by the build.
+func readHookJobs(dirPath string, kustomization kustomizev1.Kustomization, refs []kustomizev1.HookReference) ([]*unstructured.Unstructured, error) {
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(hooksFile(dirPath, kustomization))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the hook Jobs: %w", err)
+	}
+	objects, err := readObjects(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []*unstructured.Unstructured
+	for _, ref := range refs {
+		var job *unstructured.Unstructured
+		for _, obj := range objects {
+			if obj.GetKind() == "Job" && obj.GetName() == ref.Name &&
+				(ref.Namespace == "" || obj.GetNamespace() == ref.Namespace) {
+				job = obj
+				break
+			}
+		}
+		if job == nil {
+			return nil, fmt.Errorf("hook Job '%s' not found in the build output", ref.Name)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// runHooks runs the Jobs of the given hooks one after the other, for the given
+// revision. It stops at the first Job that fails.
+func (r *KustomizationReconciler) runHooks(ctx context.Context, kubeClient client.Client, kustomization kustomizev1.Kustomization,
+	phase string, refs []kustomizev1.HookReference, revision, dirPath string) error {
+	jobs, err := readHookJobs(dirPath, kustomization, refs)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if err := validation.SetDefaultNamespace(kubeClient.RESTMapper(), job); err != nil {
+			return err
+		}
+
+		start := time.Now()
+		ran, err := runHookJob(ctx, kubeClient, job, revision, kustomization.GetTimeout())
+		if err != nil {
+			if hookErr, ok := err.(*HookFailedError); ok {
+				hookErr.Phase = phase
+			}
+			return err
+		}
+		if ran {
+			logr.FromContext(ctx).Info("hook completed", "phase", phase, "job", objectID(job),
+				"duration", time.Now().Sub(start))
+		}
+	}
+	return nil
+}
+
+// runHookJob (re)creates the Job for the given revision and waits for it to
+// complete. A Job that has already completed for the revision is not run
+// again, the returned bool reports whether the Job was run.
+func runHookJob(ctx context.Context, kubeClient client.Client, obj *unstructured.Unstructured, revision string, timeout time.Duration) (bool, error) {
+	id := objectID(obj)
+	key := client.ObjectKeyFromObject(obj)
+
+	existing, err := getJob(ctx, kubeClient, key)
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return false, fmt.Errorf("%s query failed: %w", id, err)
+	default:
+		// never replace a Job that wasn't created by a hook
+		previous, ok := existing.GetAnnotations()[hookRevisionAnnotation]
+		if !ok {
+			return false, &HookFailedError{Job: id, Reason: "a Job with the same name, not created by a hook, already exists"}
+		}
+		if previous == revision {
+			if done, err := jobFinished(existing); done && err == nil {
+				return false, nil
+			}
+		}
+
+		// the pod template of a Job is immutable, the Job is deleted along with its pods
+		if err := kubeClient.Delete(ctx, existing, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return false, fmt.Errorf("%s deletion failed: %w", id, err)
+		}
+		err = wait.PollImmediateUntil(stagePollInterval, func() (bool, error) {
+			_, err := getJob(ctx, kubeClient, key)
+			if apierrors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		}, ctx.Done())
+		if err != nil {
+			return false, fmt.Errorf("%s deletion failed: %w", id, err)
+		}
+	}
+
+	job := obj.DeepCopy()
+	annotations := job.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[hookRevisionAnnotation] = revision
+	job.SetAnnotations(annotations)
+	if err := kubeClient.Patch(ctx, job, client.Apply, client.ForceOwnership, client.FieldOwner(fieldManager)); err != nil {
+		return false, fmt.Errorf("%s apply failed: %w", id, err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var jobErr error
+	err = wait.PollImmediateUntil(stagePollInterval, func() (bool, error) {
+		current, err := getJob(waitCtx, kubeClient, key)
+		if err != nil {
+			return false, client.IgnoreNotFound(err)
+		}
+		done, err := jobFinished(current)
+		jobErr = err
+		return done, nil
+	}, waitCtx.Done())
+	if err != nil {
+		return true, &HookFailedError{Job: id, Reason: fmt.Sprintf("timeout waiting for completion after %s", timeout)}
+	}
+	if jobErr != nil {
+		return true, &HookFailedError{Job: id, Reason: jobErr.Error()}
+	}
+	return true, nil
+}
+
+// getJob reads the Job as unstructured, the reads of unstructured objects are not
+// served from the cache of the manager, which would watch the Jobs of all the
+// namespaces, including the ones not watched with --watch-namespaces.
+func getJob(ctx context.Context, kubeClient client.Client, key client.ObjectKey) (*batchv1.Job, error) {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(batchv1.SchemeGroupVersion.WithKind("Job"))
+	if err := kubeClient.Get(ctx, key, obj); err != nil {
+		return nil, err
+	}
+	job := &batchv1.Job{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// jobFinished returns true if the Job has completed or failed,
+// the returned error holds the failure message.
+func jobFinished(job *batchv1.Job) (bool, error) {
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			return true, nil
+		case batchv1.JobFailed:
+			return true, fmt.Errorf("%s: %s", c.Reason, c.Message)
+		}
+	}
+	return false, nil
+}
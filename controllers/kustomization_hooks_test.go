@@ -0,0 +1,176 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/kustomize/api/provider"
+	"sigs.k8s.io/kustomize/api/resmap"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestHookJobs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	factory := resmap.NewFactory(provider.NewDefaultDepProvider().GetResourceFactory())
+	m, err := factory.NewResMapFromBytes([]byte(`---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: backend
+  namespace: apps
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: smoke-test
+  namespace: apps
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  namespace: apps
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kustomization := kustomizev1.Kustomization{}
+	kustomization.SetUID("test")
+	kustomization.Spec.Hooks = &kustomizev1.Hooks{
+		PreApply:  []kustomizev1.HookReference{{Name: "migrate"}},
+		PostApply: []kustomizev1.HookReference{{Name: "smoke-test", Namespace: "apps"}},
+	}
+
+	hooks, err := extractHookJobs(m, kustomization)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.Size() != 1 {
+		t.Errorf("expected the hook Jobs to be removed from the build output, got %d objects", m.Size())
+	}
+	if err := ioutil.WriteFile(hooksFile(tmpDir, kustomization), hooks, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := readHookJobs(tmpDir, kustomization, kustomization.Spec.Hooks.PreApply)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].GetName() != "migrate" {
+		t.Errorf("expected the pre-apply Job, got %v", jobs)
+	}
+
+	kustomization.Spec.Hooks.PreApply = []kustomizev1.HookReference{{Name: "missing"}}
+	if _, err := extractHookJobs(m, kustomization); err == nil {
+		t.Error("expected error for a hook not found in the build output")
+	}
+}
+
+func TestJobFinished(t *testing.T) {
+	job := &batchv1.Job{}
+	if done, err := jobFinished(job); done || err != nil {
+		t.Errorf("expected a running Job, got %v %v", done, err)
+	}
+
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	if done, err := jobFinished(job); !done || err != nil {
+		t.Errorf("expected a completed Job, got %v %v", done, err)
+	}
+
+	job.Status.Conditions = []batchv1.JobCondition{{
+		Type:    batchv1.JobFailed,
+		Status:  corev1.ConditionTrue,
+		Reason:  "BackoffLimitExceeded",
+		Message: "Job has reached the specified backoff limit",
+	}}
+	if done, err := jobFinished(job); !done || err == nil {
+		t.Errorf("expected a failed Job, got %v %v", done, err)
+	}
+}
+
+// uncachedClient fails the reads of typed objects,
+// which the manager client serves from its cache.
+type uncachedClient struct {
+	client.Client
+}
+
+func (c *uncachedClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if _, ok := obj.(*unstructured.Unstructured); !ok {
+		return fmt.Errorf("unexpected cached read of %T", obj)
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func TestRunHookJob(t *testing.T) {
+	objects, err := readObjects([]byte(`---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  namespace: apps
+  annotations:
+    kustomize.toolkit.fluxcd.io/hook-revision: main/1a2b3c
+status:
+  conditions:
+  - type: Complete
+    status: "True"
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	kubeClient := &uncachedClient{fake.NewClientBuilder().WithObjects(objects[0].DeepCopy()).Build()}
+
+	ran, err := runHookJob(context.TODO(), kubeClient, objects[0], "main/1a2b3c", time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ran {
+		t.Error("expected the completed Job not to run again for the same revision")
+	}
+
+	// a Job not created by a hook is never replaced
+	unmanaged := objects[0].DeepCopy()
+	unmanaged.SetAnnotations(nil)
+	kubeClient = &uncachedClient{fake.NewClientBuilder().WithObjects(unmanaged).Build()}
+	_, err = runHookJob(context.TODO(), kubeClient, objects[0], "main/4d5e6f", time.Second)
+	var hookErr *HookFailedError
+	if !errors.As(err, &hookErr) {
+		t.Fatalf("expected HookFailedError, got %v", err)
+	}
+	if _, err := getJob(context.TODO(), kubeClient, client.ObjectKeyFromObject(unmanaged)); err != nil {
+		t.Errorf("expected the Job not to be deleted, got %v", err)
+	}
+}
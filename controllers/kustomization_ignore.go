@@ -0,0 +1,173 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/fluxcd/pkg/apis/kustomize"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// ignoredPaths returns the field paths of the ignore rules matching the object.
+func ignoredPaths(kustomization kustomizev1.Kustomization, obj *unstructured.Unstructured) ([]string, error) {
+	var paths []string
+	for _, rule := range kustomization.Spec.IgnoreDifferences {
+		matches, err := matchSelector(rule.Target, obj)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore rule target: %w", err)
+		}
+		if !matches {
+			continue
+		}
+		for _, path := range rule.Paths {
+			if !strings.HasPrefix(path, "/") {
+				return nil, fmt.Errorf("invalid ignore rule path '%s', must be a JSON pointer e.g. '/spec/replicas'", path)
+			}
+		}
+		paths = append(paths, rule.Paths...)
+	}
+	return paths, nil
+}
+
+// matchSelector returns true if the object matches the selector, using the
+// same semantics as the kustomize patch targets: the group, version, kind,
+// namespace and name are anchored regular expressions. A nil selector matches
+// all objects.
+func matchSelector(selector *kustomize.Selector, obj *unstructured.Unstructured) (bool, error) {
+	if selector == nil {
+		return true, nil
+	}
+
+	gvk := obj.GroupVersionKind()
+	for _, field := range []struct{ expr, value string }{
+		{selector.Group, gvk.Group},
+		{selector.Version, gvk.Version},
+		{selector.Kind, gvk.Kind},
+		{selector.Namespace, obj.GetNamespace()},
+		{selector.Name, obj.GetName()},
+	} {
+		if field.expr == "" {
+			continue
+		}
+		re, err := regexp.Compile("^(?:" + field.expr + ")$")
+		if err != nil {
+			return false, err
+		}
+		if !re.MatchString(field.value) {
+			return false, nil
+		}
+	}
+
+	for _, s := range []struct {
+		expr string
+		set  labels.Set
+	}{
+		{selector.LabelSelector, obj.GetLabels()},
+		{selector.AnnotationSelector, obj.GetAnnotations()},
+	} {
+		if s.expr == "" {
+			continue
+		}
+		sel, err := labels.Parse(s.expr)
+		if err != nil {
+			return false, err
+		}
+		if !sel.Matches(s.set) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// preserveIgnoredFields sets the ignored fields of the desired object to their
+// in-cluster values, so that applying the object doesn't change them. The
+// fields missing from the in-cluster object are removed from the desired one.
+// Only the fields present in the desired object are changed, to avoid taking
+// the ownership of fields that are not part of the build output.
+func preserveIgnoredFields(desired, existing *unstructured.Unstructured, paths []string) {
+	var src interface{}
+	if existing != nil {
+		src = existing.Object
+	}
+	for _, path := range paths {
+		preserveField(desired.Object, src, splitPointer(path))
+	}
+}
+
+// removeIgnoredFields removes the ignored fields from the object.
+func removeIgnoredFields(obj *unstructured.Unstructured, paths []string) {
+	preserveIgnoredFields(obj, nil, paths)
+}
+
+func preserveField(dst, src interface{}, segments []string) {
+	if len(segments) == 0 {
+		return
+	}
+	key, rest := segments[0], segments[1:]
+	switch d := dst.(type) {
+	case map[string]interface{}:
+		dv, ok := d[key]
+		if !ok {
+			return
+		}
+		s, _ := src.(map[string]interface{})
+		sv, found := s[key]
+		if len(rest) > 0 {
+			preserveField(dv, sv, rest)
+			return
+		}
+		if found {
+			d[key] = runtime.DeepCopyJSONValue(sv)
+		} else {
+			delete(d, key)
+		}
+	case []interface{}:
+		s, _ := src.([]interface{})
+		for i := range d {
+			if key != "*" && key != strconv.Itoa(i) {
+				continue
+			}
+			var sv interface{}
+			if i < len(s) {
+				sv = s[i]
+			}
+			if len(rest) > 0 {
+				preserveField(d[i], sv, rest)
+			} else if sv != nil {
+				// the array elements can't be removed without shifting the indices
+				d[i] = runtime.DeepCopyJSONValue(sv)
+			}
+		}
+	}
+}
+
+// splitPointer returns the unescaped segments of a JSON pointer.
+func splitPointer(path string) []string {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, s := range segments {
+		segments[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(s)
+	}
+	return segments
+}
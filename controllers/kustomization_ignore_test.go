@@ -0,0 +1,171 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/fluxcd/pkg/apis/kustomize"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestIgnoredPaths(t *testing.T) {
+	objects, err := readObjects([]byte(`---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: frontend
+  namespace: apps
+  labels:
+    autoscaling: enabled
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: backend
+  namespace: apps
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	kustomization := kustomizev1.Kustomization{}
+	kustomization.Spec.IgnoreDifferences = []kustomizev1.IgnoreRule{
+		{
+			Paths:  []string{"/spec/replicas"},
+			Target: &kustomize.Selector{Kind: "Deployment", LabelSelector: "autoscaling=enabled"},
+		},
+		{
+			Paths:  []string{"/metadata/annotations/deployment.kubernetes.io~1revision"},
+			Target: &kustomize.Selector{Name: "front.*"},
+		},
+	}
+
+	paths, err := ignoredPaths(kustomization, objects[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(paths) != 2 {
+		t.Errorf("expected both rules to match, got %v", paths)
+	}
+	if paths, _ := ignoredPaths(kustomization, objects[1]); len(paths) != 0 {
+		t.Errorf("expected no rule to match, got %v", paths)
+	}
+
+	kustomization.Spec.IgnoreDifferences = []kustomizev1.IgnoreRule{{Paths: []string{"spec.replicas"}}}
+	if _, err := ignoredPaths(kustomization, objects[0]); err == nil {
+		t.Error("expected error for a path that is not a JSON pointer")
+	}
+}
+
+func TestPreserveIgnoredFields(t *testing.T) {
+	objects, err := readObjects([]byte(`---
+apiVersion: admissionregistration.k8s.io/v1
+kind: ValidatingWebhookConfiguration
+metadata:
+  name: webhook
+  annotations:
+    a/b: build
+webhooks:
+- name: first
+  clientConfig:
+    caBundle: ""
+- name: second
+  clientConfig:
+    caBundle: ""
+---
+apiVersion: admissionregistration.k8s.io/v1
+kind: ValidatingWebhookConfiguration
+metadata:
+  name: webhook
+webhooks:
+- name: first
+  clientConfig:
+    caBundle: Zmlyc3Q=
+- name: second
+  clientConfig:
+    caBundle: c2Vjb25k
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	desired, existing := objects[0], objects[1]
+
+	preserveIgnoredFields(desired, existing, []string{
+		"/webhooks/*/clientConfig/caBundle",
+		"/metadata/annotations/a~1b",
+		"/metadata/labels/missing",
+	})
+
+	webhooks := desired.Object["webhooks"].([]interface{})
+	for i, expected := range []string{"Zmlyc3Q=", "c2Vjb25k"} {
+		caBundle := webhooks[i].(map[string]interface{})["clientConfig"].(map[string]interface{})["caBundle"]
+		if caBundle != expected {
+			t.Errorf("expected in-cluster caBundle %s, got %v", expected, caBundle)
+		}
+	}
+	if _, ok := desired.GetAnnotations()["a/b"]; ok {
+		t.Errorf("expected the annotation missing from the cluster to be removed")
+	}
+	if desired.GetLabels() != nil {
+		t.Errorf("expected the fields missing from the build to be left unset")
+	}
+}
+
+func TestContentChecksum(t *testing.T) {
+	objects, err := readObjects([]byte(`---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: backend
+  namespace: apps
+spec:
+  replicas: 1
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: backend
+  namespace: apps
+  generation: 2
+spec:
+  replicas: 3
+status:
+  replicas: 3
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	paths := []string{"/spec/replicas"}
+	before, err := contentChecksum(*objects[0], paths)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	after, err := contentChecksum(*objects[1], paths)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if before != after {
+		t.Errorf("expected the checksum to ignore the replicas, status and generation")
+	}
+
+	if changed, _ := contentChecksum(*objects[1], nil); changed == before {
+		t.Errorf("expected the checksum to account for the replicas when not ignored")
+	}
+}
@@ -18,25 +18,50 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha1"
 	"fmt"
-	"io/ioutil"
+	"net/http"
+	"sort"
 	"strings"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"sigs.k8s.io/cli-utils/pkg/kstatus/polling"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 
 	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+	"github.com/fluxcd/kustomize-controller/internal/discovery"
+	"github.com/fluxcd/kustomize-controller/internal/tracing"
 )
 
+// KubeConfigOptions holds the restrictions on the kubeconfigs
+// read from the KubeConfig secrets of the Kustomizations.
+type KubeConfigOptions struct {
+	// InsecureExecProvider allows the kubeconfigs to run exec credential
+	// plugins, e.g. 'aws eks get-token', and auth provider plugins in the
+	// controller container, and to read files from the controller container.
+	InsecureExecProvider bool
+
+	// clients caches the clients built from the kubeconfigs,
+	// the clients are rebuilt at every reconciliation when nil.
+	clients *remoteClients
+
+	// mappers caches the REST mappers of the impersonated clients,
+	// the mappers are recreated at every reconciliation when nil.
+	mappers *discovery.MapperCache
+}
+
 type KustomizeImpersonation struct {
-	workdir       string
-	kustomization kustomizev1.Kustomization
-	statusPoller  *polling.StatusPoller
+	kustomization     kustomizev1.Kustomization
+	statusPoller      *polling.StatusPoller
+	discoveryOptions  discovery.Options
+	kubeConfigOptions KubeConfigOptions
 	client.Client
 }
 
@@ -44,12 +69,14 @@ func NewKustomizeImpersonation(
 	kustomization kustomizev1.Kustomization,
 	kubeClient client.Client,
 	statusPoller *polling.StatusPoller,
-	workdir string) *KustomizeImpersonation {
+	discoveryOptions discovery.Options,
+	kubeConfigOptions KubeConfigOptions) *KustomizeImpersonation {
 	return &KustomizeImpersonation{
-		workdir:       workdir,
-		kustomization: kustomization,
-		statusPoller:  statusPoller,
-		Client:        kubeClient,
+		kustomization:     kustomization,
+		statusPoller:      statusPoller,
+		discoveryOptions:  discoveryOptions,
+		kubeConfigOptions: kubeConfigOptions,
+		Client:            kubeClient,
 	}
 }
 
@@ -93,22 +120,42 @@ func (ki *KustomizeImpersonation) GetServiceAccountToken(ctx context.Context) (s
 	return token, nil
 }
 
+// setDefaultServiceAccount sets the service account to impersonate if the
+// Kustomization doesn't specify one, unless it targets a remote cluster.
+func setDefaultServiceAccount(kustomization *kustomizev1.Kustomization, name string) {
+	if kustomization.Spec.ServiceAccountName == "" && kustomization.Spec.KubeConfig == nil {
+		kustomization.Spec.ServiceAccountName = name
+	}
+}
+
 // GetClient creates a controller-runtime client for talking to a Kubernetes API server.
 // If KubeConfig is set, will use the kubeconfig bytes from the Kubernetes secret.
 // If ServiceAccountName is set, will use the cluster provided kubeconfig impersonating the SA.
 // If --kubeconfig is set, will use the kubeconfig file at that location.
 // Otherwise will assume running in cluster and use the cluster provided kubeconfig.
+// If Impersonation is set, the user and groups are impersonated on top of the above.
 func (ki *KustomizeImpersonation) GetClient(ctx context.Context) (client.Client, *polling.StatusPoller, error) {
 	if ki.kustomization.Spec.KubeConfig == nil {
 		if ki.kustomization.Spec.ServiceAccountName != "" {
 			return ki.clientForServiceAccount(ctx)
 		}
+		if ki.kustomization.Spec.Impersonation != nil {
+			return ki.clientForUser()
+		}
 
 		return ki.Client, ki.statusPoller, nil
 	}
 	return ki.clientForKubeConfig(ctx)
 }
 
+func (ki *KustomizeImpersonation) clientForUser() (client.Client, *polling.StatusPoller, error) {
+	restConfig, err := config.GetConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	return ki.clientForConfig(ki.impersonationKey("user"), restConfig)
+}
+
 func (ki *KustomizeImpersonation) clientForServiceAccount(ctx context.Context) (client.Client, *polling.StatusPoller, error) {
 	token, err := ki.GetServiceAccountToken(ctx)
 	if err != nil {
@@ -121,70 +168,203 @@ func (ki *KustomizeImpersonation) clientForServiceAccount(ctx context.Context) (
 	restConfig.BearerToken = token
 	restConfig.BearerTokenFile = "" // Clear, as it overrides BearerToken
 
-	restMapper, err := apiutil.NewDynamicRESTMapper(restConfig)
+	key := fmt.Sprintf("serviceaccount/%s/%s", ki.kustomization.GetNamespace(), ki.kustomization.Spec.ServiceAccountName)
+	return ki.clientForConfig(ki.impersonationKey(key), restConfig)
+}
+
+// clientForKubeConfig returns the client of the remote cluster, rebuilt when the KubeConfig
+// secret changed since the client was cached. The credentials are checked against the
+// remote cluster when the client is built, a KubeConfigAuthError is returned if they are
+// rejected. The cached client is evicted when the remote cluster rejects its credentials.
+func (ki *KustomizeImpersonation) clientForKubeConfig(ctx context.Context) (client.Client, *polling.StatusPoller, error) {
+	kubeConfigBytes, resourceVersion, err := ki.getKubeConfig(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	client, err := client.New(restConfig, client.Options{Mapper: restMapper})
+	clients := ki.kubeConfigOptions.clients
+	key := ki.remoteClientKey()
+	if rc, ok := clients.get(key, resourceVersion); ok {
+		return rc.client, rc.statusPoller, nil
+	}
+
+	restConfig, err := ki.parseKubeConfig(kubeConfigBytes)
 	if err != nil {
 		return nil, nil, err
 	}
+	// the client is rebuilt if the remote cluster rejects its credentials later on
+	restConfig.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &unauthorizedEviction{rt: rt, evict: func() { clients.evict(key) }}
+	})
+	authError := func(err error) error {
+		return &KubeConfigAuthError{
+			Secret: fmt.Sprintf("%s/%s", ki.kustomization.GetNamespace(), ki.kustomization.Spec.KubeConfig.SecretRef.Name),
+			Err:    err,
+		}
+	}
+	kubeClient, statusPoller, err := ki.clientForConfig("kubeconfig/"+key, restConfig)
+	if err != nil {
+		// the API discovery of the remote cluster fails first on rejected credentials
+		if apierrors.IsUnauthorized(err) {
+			return nil, nil, authError(err)
+		}
+		return nil, nil, err
+	}
 
-	statusPoller := polling.NewStatusPoller(client, restMapper)
-	return client, statusPoller, err
+	// the credentials are checked once, when the client is built
+	if err := checkRemoteAuth(ctx, kubeClient); err != nil {
+		return nil, nil, authError(err)
+	}
+	clients.set(key, remoteClient{resourceVersion: resourceVersion, client: kubeClient, statusPoller: statusPoller})
+	return kubeClient, statusPoller, nil
+}
 
+// evictClient removes the cached client of the KubeConfig secret
+// and the impersonated identity, along with its REST mapper.
+func (ki *KustomizeImpersonation) evictClient() {
+	if ki.kustomization.Spec.KubeConfig == nil {
+		return
+	}
+	key := ki.remoteClientKey()
+	ki.kubeConfigOptions.clients.evict(key)
+	ki.kubeConfigOptions.mappers.Evict("kubeconfig/" + key)
 }
 
-func (ki *KustomizeImpersonation) clientForKubeConfig(ctx context.Context) (client.Client, *polling.StatusPoller, error) {
-	kubeConfigBytes, err := ki.getKubeConfig(ctx)
-	if err != nil {
-		return nil, nil, err
+// remoteClientKey returns the cache key of the client
+// for the KubeConfig secret and the impersonated identity.
+func (ki *KustomizeImpersonation) remoteClientKey() string {
+	return ki.impersonationKey(fmt.Sprintf("%s/%s", ki.kustomization.GetNamespace(), ki.kustomization.Spec.KubeConfig.SecretRef.Name))
+}
+
+// impersonationKey appends the impersonated identity, if any, to the cache key.
+func (ki *KustomizeImpersonation) impersonationKey(key string) string {
+	if imp := ki.kustomization.Spec.Impersonation; imp != nil {
+		key = fmt.Sprintf("%s/%s/%s", key, imp.User, strings.Join(imp.Groups, ","))
 	}
+	return key
+}
 
-	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeConfigBytes)
+// remoteConfig returns the config of the remote cluster read from the KubeConfig
+// secret, impersonating the user and groups of the Kustomization if specified.
+// It also returns a checksum of the kubeconfig and the impersonated identity.
+func (ki *KustomizeImpersonation) remoteConfig(ctx context.Context) (*rest.Config, string, error) {
+	kubeConfigBytes, _, err := ki.getKubeConfig(ctx)
 	if err != nil {
-		return nil, nil, err
+		return nil, "", err
 	}
 
-	restMapper, err := apiutil.NewDynamicRESTMapper(restConfig)
+	restConfig, err := ki.parseKubeConfig(kubeConfigBytes)
 	if err != nil {
-		return nil, nil, err
+		return nil, "", err
 	}
 
-	client, err := client.New(restConfig, client.Options{Mapper: restMapper})
-	if err != nil {
-		return nil, nil, err
+	ki.impersonate(restConfig)
+	h := sha1.New()
+	h.Write(kubeConfigBytes)
+	fmt.Fprintf(h, "\n%s\n%s", restConfig.Impersonate.UserName, strings.Join(restConfig.Impersonate.Groups, ","))
+	return restConfig, fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// parseKubeConfig returns the config of the kubeconfig, rejecting the credential
+// plugins and the references to local files unless they are allowed.
+func (ki *KustomizeImpersonation) parseKubeConfig(kubeConfigBytes []byte) (*rest.Config, error) {
+	if !ki.kubeConfigOptions.InsecureExecProvider {
+		kubeConfig, err := clientcmd.Load(kubeConfigBytes)
+		if err != nil {
+			return nil, err
+		}
+		if insecure := insecureKubeConfigUse(kubeConfig); insecure != "" {
+			return nil, fmt.Errorf("KubeConfig secret '%s/%s' uses %s, which is disabled, "+
+				"it can be enabled with --insecure-kubeconfig-exec", ki.kustomization.GetNamespace(),
+				ki.kustomization.Spec.KubeConfig.SecretRef.Name, insecure)
+		}
 	}
 
-	statusPoller := polling.NewStatusPoller(client, restMapper)
+	return clientcmd.RESTConfigFromKubeConfig(kubeConfigBytes)
+}
+
+// insecureKubeConfigUse describes the first use of the controller container by the
+// kubeconfig, or returns an empty string if there is none. The exec and auth provider
+// plugins run arbitrary commands in the controller container, and the files, such as
+// the token of the controller service account, would be sent to the remote server.
+func insecureKubeConfigUse(kubeConfig *clientcmdapi.Config) string {
+	var names []string
+	for name := range kubeConfig.AuthInfos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		user := kubeConfig.AuthInfos[name]
+		switch {
+		case user.Exec != nil:
+			return fmt.Sprintf("the exec credential plugin '%s'", user.Exec.Command)
+		case user.AuthProvider != nil:
+			return fmt.Sprintf("the auth provider plugin '%s'", user.AuthProvider.Name)
+		case user.TokenFile != "":
+			return fmt.Sprintf("the token file '%s'", user.TokenFile)
+		case user.ClientCertificate != "":
+			return fmt.Sprintf("the client certificate file '%s'", user.ClientCertificate)
+		case user.ClientKey != "":
+			return fmt.Sprintf("the client key file '%s'", user.ClientKey)
+		}
+	}
 
-	return client, statusPoller, err
+	names = names[:0]
+	for name := range kubeConfig.Clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if ca := kubeConfig.Clusters[name].CertificateAuthority; ca != "" {
+			return fmt.Sprintf("the certificate authority file '%s'", ca)
+		}
+	}
+	return ""
 }
 
-func (ki *KustomizeImpersonation) WriteKubeConfig(ctx context.Context) (string, error) {
-	secretName := types.NamespacedName{
-		Namespace: ki.kustomization.GetNamespace(),
-		Name:      ki.kustomization.Spec.KubeConfig.SecretRef.Name,
+// impersonate sets the user and groups of the Kustomization, if any, on the config.
+func (ki *KustomizeImpersonation) impersonate(restConfig *rest.Config) {
+	if imp := ki.kustomization.Spec.Impersonation; imp != nil {
+		restConfig.Impersonate = rest.ImpersonationConfig{
+			UserName: imp.User,
+			Groups:   imp.Groups,
+		}
 	}
+}
 
-	kubeConfig, err := ki.getKubeConfig(ctx)
+// clientForConfig creates a client and a status poller for the given config,
+// impersonating the user and groups of the Kustomization if specified.
+// The REST mapper is reused from the previous clients of the same key and config.
+func (ki *KustomizeImpersonation) clientForConfig(key string, restConfig *rest.Config) (client.Client, *polling.StatusPoller, error) {
+	ki.impersonate(restConfig)
+	tracing.WrapConfig(restConfig)
+
+	var restMapper meta.RESTMapper
+	var err error
+	if mappers := ki.kubeConfigOptions.mappers; mappers != nil {
+		restMapper, err = mappers.RESTMapper(key, restConfig)
+	} else {
+		restMapper, err = ki.discoveryOptions.NewRESTMapper(restConfig)
+	}
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 
-	f, err := ioutil.TempFile(ki.workdir, "kubeconfig")
-	defer f.Close()
+	client, err := client.New(restConfig, client.Options{Mapper: restMapper})
 	if err != nil {
-		return "", fmt.Errorf("unable to write KubeConfig secret '%s' to storage: %w", secretName.String(), err)
-	}
-	if _, err := f.Write(kubeConfig); err != nil {
-		return "", fmt.Errorf("unable to write KubeConfig secret '%s' to storage: %w", secretName.String(), err)
+		return nil, nil, err
 	}
-	return f.Name(), nil
+
+	statusPoller := polling.NewStatusPoller(client, restMapper)
+	return client, statusPoller, nil
 }
 
-func (ki *KustomizeImpersonation) getKubeConfig(ctx context.Context) ([]byte, error) {
+// kubeConfigSecretKeys are the keys of the KubeConfig secret data
+// holding the kubeconfig file, in order of precedence.
+var kubeConfigSecretKeys = []string{"value", "value.yaml"}
+
+// getKubeConfig returns the kubeconfig of the KubeConfig secret and the resource version of the secret.
+func (ki *KustomizeImpersonation) getKubeConfig(ctx context.Context) ([]byte, string, error) {
 	secretName := types.NamespacedName{
 		Namespace: ki.kustomization.GetNamespace(),
 		Name:      ki.kustomization.Spec.KubeConfig.SecretRef.Name,
@@ -192,13 +372,14 @@ func (ki *KustomizeImpersonation) getKubeConfig(ctx context.Context) ([]byte, er
 
 	var secret corev1.Secret
 	if err := ki.Get(ctx, secretName, &secret); err != nil {
-		return nil, fmt.Errorf("unable to read KubeConfig secret '%s' error: %w", secretName.String(), err)
+		return nil, "", fmt.Errorf("unable to read KubeConfig secret '%s' error: %w", secretName.String(), err)
 	}
 
-	kubeConfig, ok := secret.Data["value"]
-	if !ok {
-		return nil, fmt.Errorf("KubeConfig secret '%s' doesn't contain a 'value' key ", secretName.String())
+	for _, key := range kubeConfigSecretKeys {
+		if kubeConfig, ok := secret.Data[key]; ok {
+			return kubeConfig, secret.GetResourceVersion(), nil
+		}
 	}
 
-	return kubeConfig, nil
+	return nil, "", fmt.Errorf("KubeConfig secret '%s' doesn't contain a 'value' or 'value.yaml' key", secretName.String())
 }
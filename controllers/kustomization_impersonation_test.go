@@ -0,0 +1,280 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+	"github.com/fluxcd/kustomize-controller/internal/discovery"
+)
+
+func TestGetKubeConfig(t *testing.T) {
+	kubeClient := fake.NewClientBuilder().WithObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "capi", Namespace: "default"},
+			Data:       map[string][]byte{"value": []byte("capi")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "yaml", Namespace: "default"},
+			Data:       map[string][]byte{"value.yaml": []byte("yaml")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "both", Namespace: "default"},
+			Data:       map[string][]byte{"value": []byte("value"), "value.yaml": []byte("yaml")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "invalid", Namespace: "default"},
+			Data:       map[string][]byte{"config": []byte("config")},
+		},
+	).Build()
+
+	tests := []struct {
+		secret   string
+		expected string
+		wantErr  bool
+	}{
+		{secret: "capi", expected: "capi"},
+		{secret: "yaml", expected: "yaml"},
+		{secret: "both", expected: "value"},
+		{secret: "invalid", wantErr: true},
+		{secret: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.secret, func(t *testing.T) {
+			k := kustomizev1.Kustomization{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+				Spec: kustomizev1.KustomizationSpec{
+					KubeConfig: &kustomizev1.KubeConfig{
+						SecretRef: meta.LocalObjectReference{Name: tt.secret},
+					},
+				},
+			}
+			imp := NewKustomizeImpersonation(k, kubeClient, nil, discovery.Options{}, KubeConfigOptions{})
+			kubeConfig, _, err := imp.getKubeConfig(context.TODO())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(kubeConfig) != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, string(kubeConfig))
+			}
+		})
+	}
+}
+
+func TestClientForConfigImpersonation(t *testing.T) {
+	var mu sync.Mutex
+	var user string
+	var groups []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		user = r.Header.Get("Impersonate-User")
+		groups = r.Header.Values("Impersonate-Group")
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api":
+			w.Write([]byte(`{"kind":"APIVersions","versions":["v1"]}`))
+		case r.URL.Path == "/apis":
+			w.Write([]byte(`{"kind":"APIGroupList","groups":[]}`))
+		case strings.HasPrefix(r.URL.Path, "/api/v1"):
+			w.Write([]byte(`{"kind":"APIResourceList","groupVersion":"v1","resources":[]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	k := kustomizev1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: kustomizev1.KustomizationSpec{
+			Impersonation: &kustomizev1.Impersonation{
+				User:   "gitops@example.com",
+				Groups: []string{"dev", "ops"},
+			},
+		},
+	}
+	imp := NewKustomizeImpersonation(k, nil, nil, discovery.Options{}, KubeConfigOptions{})
+	if _, _, err := imp.clientForConfig("user", &rest.Config{Host: server.URL}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if user != "gitops@example.com" {
+		t.Errorf("expected the user to be impersonated, got %q", user)
+	}
+	if len(groups) != 2 || groups[0] != "dev" || groups[1] != "ops" {
+		t.Errorf("expected the groups to be impersonated, got %v", groups)
+	}
+}
+
+func TestSetDefaultServiceAccount(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     kustomizev1.KustomizationSpec
+		expected string
+	}{
+		{
+			name:     "default",
+			spec:     kustomizev1.KustomizationSpec{},
+			expected: "tenant",
+		},
+		{
+			name:     "spec",
+			spec:     kustomizev1.KustomizationSpec{ServiceAccountName: "app"},
+			expected: "app",
+		},
+		{
+			name: "remote cluster",
+			spec: kustomizev1.KustomizationSpec{
+				KubeConfig: &kustomizev1.KubeConfig{SecretRef: meta.LocalObjectReference{Name: "kubeconfig"}},
+			},
+			expected: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := kustomizev1.Kustomization{Spec: tt.spec}
+			setDefaultServiceAccount(&k, "tenant")
+			if k.Spec.ServiceAccountName != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, k.Spec.ServiceAccountName)
+			}
+		})
+	}
+}
+
+func TestClientForKubeConfigExec(t *testing.T) {
+	kubeConfig := `apiVersion: v1
+kind: Config
+clusters:
+- name: eks
+  cluster:
+    server: https://eks.example.com
+contexts:
+- name: eks
+  context:
+    cluster: eks
+    user: eks
+current-context: eks
+users:
+- name: eks
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1alpha1
+      command: aws
+      args: ["eks", "get-token", "--cluster-name", "eks"]
+`
+	kubeClient := fake.NewClientBuilder().WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "eks", Namespace: "default"},
+		Data:       map[string][]byte{"value": []byte(kubeConfig)},
+	}).Build()
+	k := kustomizev1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: kustomizev1.KustomizationSpec{
+			KubeConfig: &kustomizev1.KubeConfig{SecretRef: meta.LocalObjectReference{Name: "eks"}},
+		},
+	}
+
+	imp := NewKustomizeImpersonation(k, kubeClient, nil, discovery.Options{}, KubeConfigOptions{})
+	_, _, err := imp.GetClient(context.TODO())
+	if err == nil || !strings.Contains(err.Error(), "--insecure-kubeconfig-exec") {
+		t.Errorf("expected the exec plugin to be rejected, got %v", err)
+	}
+}
+
+func TestParseKubeConfig(t *testing.T) {
+	kubeConfig := func(user, cluster string) []byte {
+		return []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: remote
+  cluster:
+    server: https://remote.example.com
+%s
+contexts:
+- name: remote
+  context:
+    cluster: remote
+    user: remote
+current-context: remote
+users:
+- name: remote
+  user:
+%s
+`, cluster, user))
+	}
+
+	tests := []struct {
+		name       string
+		kubeConfig []byte
+		want       string
+	}{
+		{name: "token", kubeConfig: kubeConfig("    token: secret", "")},
+		{name: "exec", kubeConfig: kubeConfig("    exec:\n      apiVersion: client.authentication.k8s.io/v1alpha1\n      command: aws", ""), want: "exec credential plugin 'aws'"},
+		{name: "auth provider", kubeConfig: kubeConfig("    auth-provider:\n      name: gcp\n      config:\n        cmd-path: /bin/sh", ""), want: "auth provider plugin 'gcp'"},
+		{name: "token file", kubeConfig: kubeConfig("    tokenFile: /var/run/secrets/kubernetes.io/serviceaccount/token", ""), want: "token file"},
+		{name: "client certificate", kubeConfig: kubeConfig("    client-certificate: /etc/tls/tls.crt", ""), want: "client certificate file"},
+		{name: "client key", kubeConfig: kubeConfig("    client-key: /etc/tls/tls.key", ""), want: "client key file"},
+		{name: "certificate authority", kubeConfig: kubeConfig("    token: secret", "    certificate-authority: /etc/tls/ca.crt"), want: "certificate authority file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := kustomizev1.Kustomization{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+				Spec: kustomizev1.KustomizationSpec{
+					KubeConfig: &kustomizev1.KubeConfig{SecretRef: meta.LocalObjectReference{Name: "remote"}},
+				},
+			}
+			imp := NewKustomizeImpersonation(k, nil, nil, discovery.Options{}, KubeConfigOptions{})
+			_, err := imp.parseKubeConfig(tt.kubeConfig)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected an error about the %s, got %v", tt.want, err)
+			}
+
+			imp = NewKustomizeImpersonation(k, nil, nil, discovery.Options{}, KubeConfigOptions{InsecureExecProvider: true})
+			if _, err := imp.parseKubeConfig(tt.kubeConfig); err != nil && strings.Contains(err.Error(), "--insecure-kubeconfig-exec") {
+				t.Errorf("expected the kubeconfig to be allowed, got %v", err)
+			}
+		})
+	}
+}
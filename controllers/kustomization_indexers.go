@@ -20,6 +20,11 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/fluxcd/pkg/apis/meta"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
@@ -70,6 +75,162 @@ func (r *KustomizationReconciler) requestsForRevisionChangeOf(indexKey string) f
 	}
 }
 
+// requestsForNamespaceConfigChange enqueues the Kustomizations in the namespace
+// of the changed NamespaceConfig, or in the namespace it's named after for the
+// policy configs of the controller namespace.
+func (r *KustomizationReconciler) requestsForNamespaceConfigChange(obj client.Object) []reconcile.Request {
+	var namespaces []string
+	if obj.GetName() == kustomizev1.NamespaceConfigName {
+		namespaces = append(namespaces, obj.GetNamespace())
+	}
+	if r.controllerNamespace != "" && obj.GetNamespace() == r.controllerNamespace &&
+		(len(namespaces) == 0 || namespaces[0] != obj.GetName()) {
+		namespaces = append(namespaces, obj.GetName())
+	}
+
+	ctx := context.Background()
+	var reqs []reconcile.Request
+	for _, namespace := range namespaces {
+		var list kustomizev1.KustomizationList
+		if err := r.List(ctx, &list, client.InNamespace(namespace)); err != nil {
+			return nil
+		}
+		for _, k := range list.Items {
+			reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: k.Namespace, Name: k.Name}})
+		}
+	}
+	return reqs
+}
+
+// requestsForKubeConfigChange enqueues the Kustomizations that use the changed
+// secret as KubeConfig, e.g. when Cluster API creates or rotates the kubeconfig
+// of a workload cluster. The secrets selected by labels in spec.clusters can't be
+// indexed, the Kustomizations of the secret namespace are matched against them instead.
+// The remote clients cached for the secret are evicted.
+func (r *KustomizationReconciler) requestsForKubeConfigChange(obj client.Object) []reconcile.Request {
+	for _, key := range r.kubeConfigOptions.clients.evictSecret(obj.GetNamespace(), obj.GetName()) {
+		r.kubeConfigOptions.mappers.Evict("kubeconfig/" + key)
+	}
+
+	ctx := context.Background()
+	var list kustomizev1.KustomizationList
+	if err := r.List(ctx, &list, client.MatchingFields{
+		kustomizev1.KubeConfigIndexKey: ObjectKey(obj).String(),
+	}); err != nil {
+		return nil
+	}
+
+	var selecting kustomizev1.KustomizationList
+	if err := r.List(ctx, &selecting, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+	for _, k := range selecting.Items {
+		if selectsSecret(k, obj) {
+			list.Items = append(list.Items, k)
+		}
+	}
+
+	seen := make(map[types.NamespacedName]bool)
+	var reqs []reconcile.Request
+	for _, k := range list.Items {
+		key := types.NamespacedName{Namespace: k.Namespace, Name: k.Name}
+		if !seen[key] {
+			seen[key] = true
+			reqs = append(reqs, reconcile.Request{NamespacedName: key})
+		}
+	}
+	return reqs
+}
+
+// selectsSecret returns true if the secret matches the secretSelector
+// of one of the spec.clusters entries of the Kustomization.
+func selectsSecret(k kustomizev1.Kustomization, secret client.Object) bool {
+	for _, target := range k.Spec.Clusters {
+		if target.SecretSelector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(target.SecretSelector)
+		if err != nil {
+			continue
+		}
+		if selector.Matches(labels.Set(secret.GetLabels())) {
+			return true
+		}
+	}
+	return false
+}
+
+// indexByKubeConfig indexes the Kustomizations by the namespaced names of their KubeConfig
+// secrets, including the secrets referenced by name in spec.clusters. The secrets selected
+// by spec.clusters[].secretSelector are not indexed, see requestsForKubeConfigChange.
+func (r *KustomizationReconciler) indexByKubeConfig(o client.Object) []string {
+	k, ok := o.(*kustomizev1.Kustomization)
+	if !ok {
+		panic(fmt.Sprintf("Expected a Kustomization, got %T", o))
+	}
+
+	var keys []string
+	if k.Spec.KubeConfig != nil {
+		keys = append(keys, fmt.Sprintf("%s/%s", k.GetNamespace(), k.Spec.KubeConfig.SecretRef.Name))
+	}
+	for _, target := range k.Spec.Clusters {
+		if target.SecretRef != nil {
+			keys = append(keys, fmt.Sprintf("%s/%s", k.GetNamespace(), target.SecretRef.Name))
+		}
+	}
+	return keys
+}
+
+// requestsForDependencyReady enqueues the Kustomizations waiting for the
+// Kustomization that became ready or applied a new revision, instead of
+// letting them wait for the --requeue-dependency interval.
+func (r *KustomizationReconciler) requestsForDependencyReady(obj client.Object) []reconcile.Request {
+	ctx := context.Background()
+	var list kustomizev1.KustomizationList
+	if err := r.List(ctx, &list, client.MatchingFields{
+		kustomizev1.DependsOnIndexKey: ObjectKey(obj).String(),
+	}); err != nil {
+		return nil
+	}
+	var reqs []reconcile.Request
+	for _, d := range list.Items {
+		if isWaitingForDependencies(d) {
+			reqs = append(reqs, reconcile.Request{NamespacedName: ObjectKey(&d)})
+		}
+	}
+	return reqs
+}
+
+// isWaitingForDependencies returns true if the Kustomization
+// is not ready because of its dependencies.
+func isWaitingForDependencies(k kustomizev1.Kustomization) bool {
+	ready := apimeta.FindStatusCondition(k.Status.Conditions, meta.ReadyCondition)
+	return ready != nil && ready.Status == metav1.ConditionFalse &&
+		(ready.Reason == meta.DependencyNotReadyReason || ready.Reason == kustomizev1.DependencyTimeoutReason)
+}
+
+// indexByDependsOn indexes the Kustomizations by the namespaced names
+// of the Kustomizations they depend on.
+func (r *KustomizationReconciler) indexByDependsOn(o client.Object) []string {
+	k, ok := o.(*kustomizev1.Kustomization)
+	if !ok {
+		panic(fmt.Sprintf("Expected a Kustomization, got %T", o))
+	}
+
+	var keys []string
+	for _, d := range k.Spec.DependsOn {
+		if !d.IsKustomization() {
+			continue
+		}
+		namespace := k.GetNamespace()
+		if d.Namespace != "" {
+			namespace = d.Namespace
+		}
+		keys = append(keys, fmt.Sprintf("%s/%s", namespace, d.Name))
+	}
+	return keys
+}
+
 func (r *KustomizationReconciler) indexBy(kind string) func(o client.Object) []string {
 	return func(o client.Object) []string {
 		k, ok := o.(*kustomizev1.Kustomization)
@@ -0,0 +1,205 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// kubeConfigIndexClient lists the Kustomizations matching the KubeConfig index,
+// which isn't supported by the fake client.
+type kubeConfigIndexClient struct {
+	client.Client
+	index func(client.Object) []string
+}
+
+func (c kubeConfigIndexClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	var key string
+	var rest []client.ListOption
+	for _, opt := range opts {
+		if fields, ok := opt.(client.MatchingFields); ok {
+			key = fields[kustomizev1.KubeConfigIndexKey]
+			continue
+		}
+		rest = append(rest, opt)
+	}
+	if err := c.Client.List(ctx, list, rest...); err != nil || key == "" {
+		return err
+	}
+
+	kustomizations := list.(*kustomizev1.KustomizationList)
+	var items []kustomizev1.Kustomization
+	for _, k := range kustomizations.Items {
+		for _, indexed := range c.index(&k) {
+			if indexed == key {
+				items = append(items, k)
+				break
+			}
+		}
+	}
+	kustomizations.Items = items
+	return nil
+}
+
+func TestIndexByKubeConfig(t *testing.T) {
+	k := &kustomizev1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{Name: "apps", Namespace: "fleet"},
+		Spec: kustomizev1.KustomizationSpec{
+			KubeConfig: &kustomizev1.KubeConfig{SecretRef: meta.LocalObjectReference{Name: "hub-kubeconfig"}},
+			Clusters: []kustomizev1.ClusterTarget{
+				{SecretRef: &meta.LocalObjectReference{Name: "staging-kubeconfig"}},
+				{SecretSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "production"}}},
+			},
+		},
+	}
+
+	got := (&KustomizationReconciler{}).indexByKubeConfig(k)
+	want := []string{"fleet/hub-kubeconfig", "fleet/staging-kubeconfig"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("indexByKubeConfig() = %v, want %v", got, want)
+	}
+}
+
+func TestRequestsForKubeConfigChange(t *testing.T) {
+	kustomization := func(name string, spec kustomizev1.KustomizationSpec) *kustomizev1.Kustomization {
+		return &kustomizev1.Kustomization{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "fleet"},
+			Spec:       spec,
+		}
+	}
+	production := &metav1.LabelSelector{MatchLabels: map[string]string{"env": "production"}}
+
+	r := newTestReconciler(t,
+		kustomization("hub", kustomizev1.KustomizationSpec{
+			KubeConfig: &kustomizev1.KubeConfig{SecretRef: meta.LocalObjectReference{Name: "hub-kubeconfig"}},
+		}),
+		kustomization("staging", kustomizev1.KustomizationSpec{
+			Clusters: []kustomizev1.ClusterTarget{{SecretRef: &meta.LocalObjectReference{Name: "staging-kubeconfig"}}},
+		}),
+		kustomization("production", kustomizev1.KustomizationSpec{
+			Clusters: []kustomizev1.ClusterTarget{{SecretSelector: production}},
+		}),
+		kustomization("all", kustomizev1.KustomizationSpec{
+			Clusters: []kustomizev1.ClusterTarget{
+				{SecretRef: &meta.LocalObjectReference{Name: "prod-eu-kubeconfig"}},
+				{SecretSelector: production},
+			},
+		}),
+		kustomization("local", kustomizev1.KustomizationSpec{}),
+	)
+	r.Client = kubeConfigIndexClient{Client: r.Client, index: r.indexByKubeConfig}
+
+	tests := []struct {
+		name   string
+		secret *corev1.Secret
+		want   []string
+	}{
+		{
+			name:   "kubeConfig secret",
+			secret: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "hub-kubeconfig", Namespace: "fleet"}},
+			want:   []string{"hub"},
+		},
+		{
+			name:   "clusters secretRef",
+			secret: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "staging-kubeconfig", Namespace: "fleet"}},
+			want:   []string{"staging"},
+		},
+		{
+			name: "clusters secretSelector",
+			secret: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+				Name: "prod-us-kubeconfig", Namespace: "fleet", Labels: map[string]string{"env": "production"},
+			}},
+			want: []string{"all", "production"},
+		},
+		{
+			name: "clusters secretRef and secretSelector",
+			secret: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+				Name: "prod-eu-kubeconfig", Namespace: "fleet", Labels: map[string]string{"env": "production"},
+			}},
+			want: []string{"all", "production"},
+		},
+		{
+			name: "secret of another namespace",
+			secret: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+				Name: "hub-kubeconfig", Namespace: "apps", Labels: map[string]string{"env": "production"},
+			}},
+		},
+		{
+			name:   "unused secret",
+			secret: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "sops-gpg", Namespace: "fleet"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, req := range r.requestsForKubeConfigChange(tt.secret) {
+				if req.Namespace != "fleet" {
+					t.Errorf("unexpected request %s", req)
+				}
+				got = append(got, req.Name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("requestsForKubeConfigChange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestsForNamespaceConfigChange(t *testing.T) {
+	r := newTestReconciler(t,
+		&kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Name: "apps", Namespace: "team1"}},
+		&kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Name: "infra", Namespace: "flux-system"}},
+	)
+	r.controllerNamespace = "flux-system"
+
+	tests := []struct {
+		name   string
+		config metav1.ObjectMeta
+		want   []string
+	}{
+		{name: "namespace config", config: metav1.ObjectMeta{Name: "default", Namespace: "team1"}, want: []string{"team1/apps"}},
+		{name: "policy config", config: metav1.ObjectMeta{Name: "team1", Namespace: "flux-system"}, want: []string{"team1/apps"}},
+		{
+			name:   "controller namespace config",
+			config: metav1.ObjectMeta{Name: "default", Namespace: "flux-system"},
+			want:   []string{"flux-system/infra"},
+		},
+		{name: "other config", config: metav1.ObjectMeta{Name: "team1", Namespace: "team2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, req := range r.requestsForNamespaceConfigChange(&kustomizev1.NamespaceConfig{ObjectMeta: tt.config}) {
+				got = append(got, req.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("requestsForNamespaceConfigChange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/cli-utils/pkg/object"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+	"github.com/fluxcd/kustomize-controller/pkg/validation"
+)
+
+// newInventory returns the inventory of the given objects,
+// sorted by ID for a stable status representation.
+func newInventory(objects []*unstructured.Unstructured) *kustomizev1.ResourceInventory {
+	inventory := &kustomizev1.ResourceInventory{
+		Entries: []kustomizev1.ResourceRef{},
+	}
+	seen := make(map[string]bool)
+	for _, obj := range objects {
+		objMetadata := object.UnstructuredToObjMeta(obj)
+		id := objMetadata.String()
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		inventory.Entries = append(inventory.Entries, kustomizev1.ResourceRef{
+			ID:      id,
+			Version: obj.GroupVersionKind().Version,
+		})
+	}
+	sort.Slice(inventory.Entries, func(i, j int) bool {
+		return inventory.Entries[i].ID < inventory.Entries[j].ID
+	})
+	return inventory
+}
+
+// readInventory returns the inventory of the objects
+// generated by the kustomize build.
+func readInventory(kubeClient client.Client, kustomization kustomizev1.Kustomization, dirPath string) (*kustomizev1.ResourceInventory, error) {
+	stages, err := readStages(dirPath, kustomization)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := stages.All()
+	for _, obj := range objects {
+		if err := validation.SetDefaultNamespace(kubeClient.RESTMapper(), obj); err != nil {
+			return nil, fmt.Errorf("failed to compute the inventory: %w", err)
+		}
+	}
+	return newInventory(objects), nil
+}
+
+// inventoryDiff returns the entries of the old inventory
+// that are missing from the new one.
+func inventoryDiff(old, new *kustomizev1.ResourceInventory) []kustomizev1.ResourceRef {
+	var diff []kustomizev1.ResourceRef
+	if old == nil {
+		return diff
+	}
+
+	ids := make(map[string]bool)
+	if new != nil {
+		for _, entry := range new.Entries {
+			ids[entry.ID] = true
+		}
+	}
+
+	for _, entry := range old.Entries {
+		if !ids[entry.ID] {
+			diff = append(diff, entry)
+		}
+	}
+	return diff
+}
+
+// inventoryObject returns an unstructured object
+// identifying the Kubernetes object of the inventory entry.
+func inventoryObject(entry kustomizev1.ResourceRef) (*unstructured.Unstructured, error) {
+	objMetadata, err := object.ParseObjMetadata(entry.ID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid inventory entry '%s': %w", entry.ID, err)
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(schema.GroupVersionKind{
+		Group:   objMetadata.GroupKind.Group,
+		Kind:    objMetadata.GroupKind.Kind,
+		Version: entry.Version,
+	})
+	obj.SetNamespace(objMetadata.Namespace)
+	obj.SetName(objMetadata.Name)
+	return obj, nil
+}
+
+// inventoryCRDs returns the names of the CustomResourceDefinitions
+// found in the inventory.
+func inventoryCRDs(inventory *kustomizev1.ResourceInventory) []string {
+	var names []string
+	for _, entry := range inventory.Entries {
+		objMetadata, err := object.ParseObjMetadata(entry.ID)
+		if err != nil {
+			continue
+		}
+		if objMetadata.GroupKind.Kind == crdKind {
+			names = append(names, objMetadata.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+)
+
+func TestInventoryDiff(t *testing.T) {
+	oldObjects, err := readObjects([]byte(`---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: test
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: backend
+  namespace: test
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: backend
+  namespace: test
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	newObjects, err := readObjects([]byte(`---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: test
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: backend
+  namespace: test
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	oldInventory := newInventory(oldObjects)
+	if len(oldInventory.Entries) != 3 {
+		t.Fatalf("expected 3 entries, got %v", oldInventory.Entries)
+	}
+	if id := oldInventory.Entries[0].ID; id != "_test__Namespace" {
+		t.Errorf("expected entries sorted by ID, got %s first", id)
+	}
+
+	stale := inventoryDiff(oldInventory, newInventory(newObjects))
+	if len(stale) != 1 {
+		t.Fatalf("expected one stale entry, got %v", stale)
+	}
+
+	obj, err := inventoryObject(stale[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if obj.GetKind() != "ConfigMap" || obj.GetAPIVersion() != "v1" ||
+		obj.GetNamespace() != "test" || obj.GetName() != "backend" {
+		t.Errorf("unexpected object %v", obj)
+	}
+
+	if stale := inventoryDiff(nil, newInventory(newObjects)); len(stale) != 0 {
+		t.Errorf("expected no stale entries without a previous inventory, got %v", stale)
+	}
+}
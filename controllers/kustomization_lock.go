@@ -0,0 +1,66 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var buildLockWaitSeconds = prometheus.NewHistogram(
+	prometheus.HistogramOpts{
+		Name:    "gotk_kustomize_build_lock_wait_seconds",
+		Help:    "The time a reconciliation waited for the kustomize build lock held by other reconciliations.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+	},
+)
+
+func init() {
+	metrics.Registry.MustRegister(buildLockWaitSeconds)
+}
+
+// TODO: remove mutex when kustomize fixes the concurrent map read/write panic
+var kustomizeBuildMutex sync.Mutex
+
+// buildLockWaitKey is the context key of the build lock wait time.
+type buildLockWaitKey struct{}
+
+// withBuildLockWait returns a context in which the time spent waiting
+// for the kustomize build lock is accumulated in the returned duration.
+func withBuildLockWait(ctx context.Context) (context.Context, *time.Duration) {
+	wait := new(time.Duration)
+	return context.WithValue(ctx, buildLockWaitKey{}, wait), wait
+}
+
+// lockKustomizeBuild acquires the kustomize build lock and records the wait
+// time in the metrics and in the context, if set with withBuildLockWait.
+// It returns the function releasing the lock.
+func lockKustomizeBuild(ctx context.Context) func() {
+	start := time.Now()
+	kustomizeBuildMutex.Lock()
+	wait := time.Since(start)
+
+	buildLockWaitSeconds.Observe(wait.Seconds())
+	if total, ok := ctx.Value(buildLockWaitKey{}).(*time.Duration); ok {
+		*total += wait
+	}
+	return kustomizeBuildMutex.Unlock
+}
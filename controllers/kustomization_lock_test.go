@@ -0,0 +1,49 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLockKustomizeBuild(t *testing.T) {
+	ctx, wait := withBuildLockWait(context.TODO())
+
+	unlock := lockKustomizeBuild(ctx)
+	unlock()
+	if *wait > 100*time.Millisecond {
+		t.Fatalf("expected no contention, waited %s", *wait)
+	}
+
+	// another reconciliation holds the lock
+	holder := lockKustomizeBuild(context.TODO())
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		holder()
+	}()
+
+	unlock = lockKustomizeBuild(ctx)
+	unlock()
+	if *wait < 150*time.Millisecond {
+		t.Errorf("expected the wait time to be recorded, got %s", *wait)
+	}
+
+	// the wait time is not recorded without a tracking context
+	lockKustomizeBuild(context.TODO())()
+}
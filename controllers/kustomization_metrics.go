@@ -0,0 +1,102 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/prometheus/client_golang/prometheus"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// Results of a reconciliation recorded in the outcome metrics.
+const (
+	successResult = "success"
+	failureResult = "failure"
+)
+
+var (
+	reconcileOutcomeSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gotk_kustomization_reconcile_outcome_seconds",
+			Help:    "The duration of the Kustomization reconciliations by result.",
+			Buckets: []float64{1, 2.5, 5, 10, 15, 30, 60, 120, 300, 600, 1800},
+		},
+		[]string{"name", "namespace", "result"},
+	)
+	reconcileOutcomeTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gotk_kustomization_reconcile_outcome_total",
+			Help: "The number of Kustomization reconciliations by result and Ready condition reason.",
+		},
+		[]string{"name", "namespace", "result", "reason"},
+	)
+	readyGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gotk_kustomization_ready",
+			Help: "The status of the Kustomization Ready condition, 1 for True, 0 for False and -1 for Unknown.",
+		},
+		[]string{"name", "namespace"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(reconcileOutcomeSeconds, reconcileOutcomeTotal, readyGauge)
+}
+
+// recordReady sets the readiness gauge of the Kustomization to the status of its
+// Ready condition, the gauge of a deleted Kustomization is removed.
+func recordReady(kustomization kustomizev1.Kustomization) {
+	name, namespace := kustomization.GetName(), kustomization.GetNamespace()
+	if !kustomization.DeletionTimestamp.IsZero() {
+		readyGauge.DeleteLabelValues(name, namespace)
+		return
+	}
+
+	value := -1.0
+	if ready := apimeta.FindStatusCondition(kustomization.Status.Conditions, meta.ReadyCondition); ready != nil {
+		switch ready.Status {
+		case metav1.ConditionTrue:
+			value = 1
+		case metav1.ConditionFalse:
+			value = 0
+		}
+	}
+	readyGauge.WithLabelValues(name, namespace).Set(value)
+}
+
+// recordOutcome records the duration and the result of a reconciliation,
+// the reason is taken from the Ready condition of the reconciled Kustomization.
+func recordOutcome(kustomization kustomizev1.Kustomization, reconcileErr error, duration time.Duration) {
+	result := successResult
+	if reconcileErr != nil {
+		result = failureResult
+	}
+	reason := ""
+	if ready := apimeta.FindStatusCondition(kustomization.Status.Conditions, meta.ReadyCondition); ready != nil {
+		reason = ready.Reason
+	}
+
+	name, namespace := kustomization.GetName(), kustomization.GetNamespace()
+	reconcileOutcomeSeconds.WithLabelValues(name, namespace, result).Observe(duration.Seconds())
+	reconcileOutcomeTotal.WithLabelValues(name, namespace, result, reason).Inc()
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestRecordOutcome(t *testing.T) {
+	k := kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Name: "metrics", Namespace: "dev"}}
+
+	meta.SetResourceCondition(&k, meta.ReadyCondition, metav1.ConditionTrue, meta.ReconciliationSucceededReason, "applied")
+	recordOutcome(k, nil, 2*time.Second)
+	recordOutcome(k, nil, 40*time.Second)
+
+	meta.SetResourceCondition(&k, meta.ReadyCondition, metav1.ConditionFalse, kustomizev1.HealthCheckFailedReason, "failed")
+	recordOutcome(k, errors.New("health check failed"), time.Minute)
+
+	if n := testutil.ToFloat64(reconcileOutcomeTotal.WithLabelValues("metrics", "dev", successResult, meta.ReconciliationSucceededReason)); n != 2 {
+		t.Errorf("expected 2 successful reconciliations, got %v", n)
+	}
+	if n := testutil.ToFloat64(reconcileOutcomeTotal.WithLabelValues("metrics", "dev", failureResult, kustomizev1.HealthCheckFailedReason)); n != 1 {
+		t.Errorf("expected 1 failed reconciliation, got %v", n)
+	}
+	if n := testutil.CollectAndCount(reconcileOutcomeSeconds, "gotk_kustomization_reconcile_outcome_seconds"); n < 2 {
+		t.Errorf("expected a histogram per result, got %d", n)
+	}
+}
+
+func TestRecordReady(t *testing.T) {
+	k := kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Name: "ready", Namespace: "dev"}}
+
+	tests := []struct {
+		status metav1.ConditionStatus
+		want   float64
+	}{
+		{metav1.ConditionUnknown, -1},
+		{metav1.ConditionFalse, 0},
+		{metav1.ConditionTrue, 1},
+	}
+	for _, tt := range tests {
+		meta.SetResourceCondition(&k, meta.ReadyCondition, tt.status, meta.ProgressingReason, "")
+		recordReady(k)
+		if got := testutil.ToFloat64(readyGauge.WithLabelValues("ready", "dev")); got != tt.want {
+			t.Errorf("expected %v for status %s, got %v", tt.want, tt.status, got)
+		}
+	}
+
+	now := metav1.Now()
+	k.DeletionTimestamp = &now
+	recordReady(k)
+	if readyGauge.DeleteLabelValues("ready", "dev") {
+		t.Errorf("expected the gauge of the deleted Kustomization to be removed")
+	}
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// ensureTargetNamespace creates the target namespace of the Kustomization
+// if it doesn't exist, labeled with the name and namespace of the Kustomization.
+// The namespace is annotated to be skipped by the garbage collection, as it's not
+// part of the build output. It returns true if the namespace was created.
+func ensureTargetNamespace(ctx context.Context, kubeClient client.Client, kustomization kustomizev1.Kustomization) (bool, error) {
+	name := kustomization.Spec.TargetNamespace
+	if !kustomization.Spec.CreateNamespace || name == "" {
+		return false, nil
+	}
+
+	var namespace corev1.Namespace
+	err := kubeClient.Get(ctx, client.ObjectKey{Name: name}, &namespace)
+	if err == nil || !apierrors.IsNotFound(err) {
+		return false, err
+	}
+
+	namespace = corev1.Namespace{}
+	namespace.SetName(name)
+	namespace.SetLabels(selectorLabels(kustomization.GetName(), kustomization.GetNamespace()))
+	namespace.SetAnnotations(map[string]string{
+		fmt.Sprintf("%s/prune", kustomizev1.GroupVersion.Group): kustomizev1.DisabledValue,
+	})
+	if err := kubeClient.Create(ctx, &namespace); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestEnsureTargetNamespace(t *testing.T) {
+	kubeClient := fake.NewClientBuilder().Build()
+
+	kustomization := kustomizev1.Kustomization{}
+	kustomization.SetName("apps")
+	kustomization.SetNamespace("flux-system")
+	kustomization.Spec.TargetNamespace = "apps"
+
+	created, err := ensureTargetNamespace(context.TODO(), kubeClient, kustomization)
+	if err != nil || created {
+		t.Fatalf("expected the namespace not to be created when disabled, got %v %v", created, err)
+	}
+
+	kustomization.Spec.CreateNamespace = true
+	created, err = ensureTargetNamespace(context.TODO(), kubeClient, kustomization)
+	if err != nil || !created {
+		t.Fatalf("expected the namespace to be created, got %v %v", created, err)
+	}
+
+	var namespace corev1.Namespace
+	if err := kubeClient.Get(context.TODO(), client.ObjectKey{Name: "apps"}, &namespace); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for k, v := range selectorLabels("apps", "flux-system") {
+		if namespace.GetLabels()[k] != v {
+			t.Errorf("expected label %s=%s, got %v", k, v, namespace.GetLabels())
+		}
+	}
+	if v := namespace.GetAnnotations()["kustomize.toolkit.fluxcd.io/prune"]; v != kustomizev1.DisabledValue {
+		t.Errorf("expected the namespace to be excluded from pruning, got %q", v)
+	}
+
+	created, err = ensureTargetNamespace(context.TODO(), kubeClient, kustomization)
+	if err != nil || created {
+		t.Errorf("expected the existing namespace to be left unchanged, got %v %v", created, err)
+	}
+}
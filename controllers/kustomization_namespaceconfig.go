@@ -0,0 +1,108 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// +kubebuilder:rbac:groups=kustomize.toolkit.fluxcd.io,resources=namespaceconfigs,verbs=get;list;watch
+
+// getNamespaceConfig returns the spec of the NamespaceConfig of the namespace,
+// or an empty spec if the namespace has none.
+func (r *KustomizationReconciler) getNamespaceConfig(ctx context.Context, namespace string) (kustomizev1.NamespaceConfigSpec, error) {
+	var config kustomizev1.NamespaceConfig
+	key := types.NamespacedName{Namespace: namespace, Name: kustomizev1.NamespaceConfigName}
+	if err := r.Get(ctx, key, &config); err != nil {
+		if apierrors.IsNotFound(err) || apimeta.IsNoMatchError(err) {
+			return kustomizev1.NamespaceConfigSpec{}, nil
+		}
+		return kustomizev1.NamespaceConfigSpec{}, err
+	}
+	return config.Spec, nil
+}
+
+// getPolicyConfig returns the spec of the NamespaceConfig named after the namespace
+// in the controller namespace, or an empty spec if there is none. It holds the policies
+// enforced on the tenants of the namespace, which are not read from the NamespaceConfig
+// of their own namespace, as the tenants may be able to modify it.
+func (r *KustomizationReconciler) getPolicyConfig(ctx context.Context, namespace string) (kustomizev1.NamespaceConfigSpec, error) {
+	if r.controllerNamespace == "" {
+		return kustomizev1.NamespaceConfigSpec{}, nil
+	}
+	var config kustomizev1.NamespaceConfig
+	key := types.NamespacedName{Namespace: r.controllerNamespace, Name: namespace}
+	if err := r.Get(ctx, key, &config); err != nil {
+		if apierrors.IsNotFound(err) || apimeta.IsNoMatchError(err) {
+			return kustomizev1.NamespaceConfigSpec{}, nil
+		}
+		return kustomizev1.NamespaceConfigSpec{}, err
+	}
+	return config.Spec, nil
+}
+
+// applyNamespaceConfig merges the settings of the NamespaceConfig
+// of the Kustomization namespace, if any, into the Kustomization spec.
+func (r *KustomizationReconciler) applyNamespaceConfig(ctx context.Context, kustomization *kustomizev1.Kustomization) error {
+	config, err := r.getNamespaceConfig(ctx, kustomization.GetNamespace())
+	if err != nil {
+		return err
+	}
+	mergeNamespaceConfig(kustomization, config)
+	return nil
+}
+
+// mergeNamespaceConfig sets the defaults and enforces the bounds
+// defined in the NamespaceConfig on the Kustomization spec.
+func mergeNamespaceConfig(kustomization *kustomizev1.Kustomization, config kustomizev1.NamespaceConfigSpec) {
+	spec := &kustomization.Spec
+
+	if spec.ServiceAccountName == "" {
+		spec.ServiceAccountName = config.ServiceAccountName
+	}
+
+	if config.MinInterval != nil && spec.Interval.Duration < config.MinInterval.Duration {
+		spec.Interval = *config.MinInterval
+	}
+	if config.MaxInterval != nil && spec.Interval.Duration > config.MaxInterval.Duration {
+		spec.Interval = *config.MaxInterval
+	}
+
+	if config.Prune != nil {
+		spec.Prune = *config.Prune
+	}
+
+	if len(config.Substitute) > 0 {
+		if spec.PostBuild == nil {
+			spec.PostBuild = &kustomizev1.PostBuild{}
+		}
+		vars := make(map[string]string, len(config.Substitute)+len(spec.PostBuild.Substitute))
+		for k, v := range config.Substitute {
+			vars[k] = v
+		}
+		for k, v := range spec.PostBuild.Substitute {
+			vars[k] = v
+		}
+		spec.PostBuild.Substitute = vars
+	}
+}
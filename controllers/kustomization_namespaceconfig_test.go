@@ -0,0 +1,80 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestMergeNamespaceConfig(t *testing.T) {
+	prune := false
+	config := kustomizev1.NamespaceConfigSpec{
+		ServiceAccountName: "tenant",
+		MinInterval:        &metav1.Duration{Duration: 5 * time.Minute},
+		MaxInterval:        &metav1.Duration{Duration: time.Hour},
+		Prune:              &prune,
+		Substitute: map[string]string{
+			"cluster": "staging",
+			"region":  "eu-central-1",
+		},
+	}
+
+	k := kustomizev1.Kustomization{
+		Spec: kustomizev1.KustomizationSpec{
+			Interval: metav1.Duration{Duration: time.Minute},
+			Prune:    true,
+		},
+	}
+	mergeNamespaceConfig(&k, config)
+	if k.Spec.ServiceAccountName != "tenant" {
+		t.Errorf("expected default service account, got %q", k.Spec.ServiceAccountName)
+	}
+	if k.Spec.Interval.Duration != 5*time.Minute {
+		t.Errorf("expected interval raised to the lower bound, got %s", k.Spec.Interval.Duration)
+	}
+	if k.Spec.Prune {
+		t.Error("expected prune to be overridden")
+	}
+	if k.Spec.PostBuild == nil || k.Spec.PostBuild.Substitute["cluster"] != "staging" {
+		t.Errorf("expected default substitution vars, got %v", k.Spec.PostBuild)
+	}
+
+	k = kustomizev1.Kustomization{
+		Spec: kustomizev1.KustomizationSpec{
+			ServiceAccountName: "app",
+			Interval:           metav1.Duration{Duration: 2 * time.Hour},
+			PostBuild: &kustomizev1.PostBuild{
+				Substitute: map[string]string{"cluster": "prod"},
+			},
+		},
+	}
+	mergeNamespaceConfig(&k, config)
+	if k.Spec.ServiceAccountName != "app" {
+		t.Errorf("expected service account to be kept, got %q", k.Spec.ServiceAccountName)
+	}
+	if k.Spec.Interval.Duration != time.Hour {
+		t.Errorf("expected interval lowered to the upper bound, got %s", k.Spec.Interval.Duration)
+	}
+	if vars := k.Spec.PostBuild.Substitute; vars["cluster"] != "prod" || vars["region"] != "eu-central-1" {
+		t.Errorf("expected Kustomization vars to take precedence, got %v", vars)
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// NamespaceDeniedError is returned when the build output contains objects
+// in namespaces not allowed by the namespace policy, or objects the tenants
+// restricted by the policy can't apply.
+type NamespaceDeniedError struct {
+	Objects []string
+}
+
+func (e *NamespaceDeniedError) Error() string {
+	return fmt.Sprintf("objects in namespaces not allowed by the namespace policy: %s", strings.Join(e.Objects, ", "))
+}
+
+// namespacePolicy restricts the namespaces a Kustomization can apply objects to.
+// A nil allowed list allows all the namespaces.
+type namespacePolicy struct {
+	allowed []string
+	denied  []string
+}
+
+// allows returns true if the namespace matches an allowed pattern and no denied one.
+func (p namespacePolicy) allows(namespace string) bool {
+	if matchNamespace(p.denied, namespace) {
+		return false
+	}
+	return p.allowed == nil || matchNamespace(p.allowed, namespace)
+}
+
+// matchNamespace returns true if the namespace matches one of the shell patterns.
+func matchNamespace(patterns []string, namespace string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// newNamespacePolicy returns the policy defined in the policy config of the namespace.
+// When the config doesn't allow any namespace, the Kustomizations are confined to their
+// own namespace if restrict is true.
+func newNamespacePolicy(namespace string, config kustomizev1.NamespaceConfigSpec, restrict bool) namespacePolicy {
+	policy := namespacePolicy{
+		allowed: config.AllowedNamespaces,
+		denied:  config.DeniedNamespaces,
+	}
+	if policy.allowed == nil && restrict {
+		policy.allowed = []string{namespace}
+	}
+	return policy
+}
+
+// namespacePolicy returns the namespace policy of the Kustomization namespace.
+func (r *KustomizationReconciler) namespacePolicy(ctx context.Context, kustomization kustomizev1.Kustomization) (namespacePolicy, error) {
+	config, err := r.getPolicyConfig(ctx, kustomization.GetNamespace())
+	if err != nil {
+		return namespacePolicy{}, err
+	}
+	return newNamespacePolicy(kustomization.GetNamespace(), config, r.restrictToOwnNamespace), nil
+}
+
+// checkNamespaces returns a NamespaceDeniedError listing the objects, and the
+// target namespace, out of the scope of the policy. The namespaced objects that
+// don't specify a namespace are checked against 'default', the custom resources
+// of the CRDs defined in the same build are checked only if they specify one.
+// The NamespaceConfigs are always denied, and the cluster-scoped objects other
+// than namespaces are denied when the policy restricts the allowed namespaces.
+func checkNamespaces(mapper apimeta.RESTMapper, policy namespacePolicy, kustomization kustomizev1.Kustomization, objects []*unstructured.Unstructured) error {
+	var denied []string
+	if ns := kustomization.Spec.TargetNamespace; ns != "" && !policy.allows(ns) {
+		denied = append(denied, fmt.Sprintf("namespace/%s", ns))
+	}
+	for _, obj := range objects {
+		gvk := obj.GroupVersionKind()
+		if gvk.Group == kustomizev1.GroupVersion.Group && gvk.Kind == kustomizev1.NamespaceConfigKind {
+			denied = append(denied, objectID(obj))
+			continue
+		}
+
+		namespace := obj.GetNamespace()
+		switch {
+		case gvk.Kind == "Namespace" && gvk.Group == "":
+			namespace = obj.GetName()
+		case namespace == "":
+			mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+			if err != nil {
+				continue
+			}
+			if mapping.Scope.Name() != apimeta.RESTScopeNameNamespace {
+				if policy.allowed != nil {
+					denied = append(denied, objectID(obj))
+				}
+				continue
+			}
+			namespace = "default"
+		}
+		if !policy.allows(namespace) {
+			denied = append(denied, objectID(obj))
+		}
+	}
+	if len(denied) > 0 {
+		return &NamespaceDeniedError{Objects: denied}
+	}
+	return nil
+}
+
+// enforceNamespacePolicy checks that the build output, including the hook Jobs,
+// is in the scope of the namespace policy of the Kustomization.
+func (r *KustomizationReconciler) enforceNamespacePolicy(ctx context.Context, mapper apimeta.RESTMapper, kustomization kustomizev1.Kustomization, dirPath string) error {
+	policy, err := r.namespacePolicy(ctx, kustomization)
+	if err != nil {
+		return err
+	}
+	if policy.allowed == nil && policy.denied == nil {
+		return nil
+	}
+
+	objects, err := readBuildAndHookObjects(dirPath, kustomization)
+	if err != nil {
+		return err
+	}
+	return checkNamespaces(mapper, policy, kustomization, objects)
+}
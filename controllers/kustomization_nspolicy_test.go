@@ -0,0 +1,196 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestNamespacePolicyAllows(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    kustomizev1.NamespaceConfigSpec
+		restrict  bool
+		namespace string
+		want      bool
+	}{
+		{name: "no policy", namespace: "kube-system", want: true},
+		{name: "own namespace", restrict: true, namespace: "team1", want: true},
+		{name: "other namespace", restrict: true, namespace: "team2", want: false},
+		{
+			name:      "allowed pattern",
+			config:    kustomizev1.NamespaceConfigSpec{AllowedNamespaces: []string{"team1-*"}},
+			restrict:  true,
+			namespace: "team1-apps",
+			want:      true,
+		},
+		{
+			name:      "allowed list replaces own namespace",
+			config:    kustomizev1.NamespaceConfigSpec{AllowedNamespaces: []string{"team1-*"}},
+			restrict:  true,
+			namespace: "team1",
+			want:      false,
+		},
+		{
+			name: "denied takes precedence",
+			config: kustomizev1.NamespaceConfigSpec{
+				AllowedNamespaces: []string{"*"},
+				DeniedNamespaces:  []string{"kube-*"},
+			},
+			namespace: "kube-system",
+			want:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newNamespacePolicy("team1", tt.config, tt.restrict)
+			if got := policy.allows(tt.namespace); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCheckNamespaces(t *testing.T) {
+	k := kustomizev1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{Name: "apps", Namespace: "team1"},
+		Spec:       kustomizev1.KustomizationSpec{TargetNamespace: "team1-apps"},
+	}
+	objects := []*unstructured.Unstructured{
+		preflightObject("apps/v1", "Deployment", "team1", "frontend"),
+		preflightObject("v1", "ConfigMap", "", "settings"),
+		preflightObject("v1", "Namespace", "", "team2"),
+		preflightObject("example.com/v1", "Widget", "", "widget"),
+		preflightObject("rbac.authorization.k8s.io/v1", "ClusterRole", "", "admin"),
+		preflightObject("kustomize.toolkit.fluxcd.io/v1beta1", "NamespaceConfig", "team1", "default"),
+	}
+
+	policy := newNamespacePolicy("team1", kustomizev1.NamespaceConfigSpec{}, true)
+	err := checkNamespaces(preflightMapper(), policy, k, objects)
+	var denied *NamespaceDeniedError
+	if !errors.As(err, &denied) {
+		t.Fatalf("expected NamespaceDeniedError, got %v", err)
+	}
+	want := []string{"namespace/team1-apps", "configmap/settings", "namespace/team2",
+		"clusterrole/admin", "namespaceconfig/team1/default"}
+	if !reflect.DeepEqual(denied.Objects, want) {
+		t.Errorf("expected %v, got %v", want, denied.Objects)
+	}
+
+	policy = newNamespacePolicy("team1", kustomizev1.NamespaceConfigSpec{
+		AllowedNamespaces: []string{"team1", "team1-*", "team2", "default"},
+	}, true)
+	err = checkNamespaces(preflightMapper(), policy, k, objects)
+	if !errors.As(err, &denied) {
+		t.Fatalf("expected NamespaceDeniedError, got %v", err)
+	}
+	want = []string{"clusterrole/admin", "namespaceconfig/team1/default"}
+	if !reflect.DeepEqual(denied.Objects, want) {
+		t.Errorf("expected %v, got %v", want, denied.Objects)
+	}
+
+	policy = newNamespacePolicy("team1", kustomizev1.NamespaceConfigSpec{DeniedNamespaces: []string{"kube-*"}}, false)
+	err = checkNamespaces(preflightMapper(), policy, k, objects)
+	if !errors.As(err, &denied) {
+		t.Fatalf("expected NamespaceDeniedError, got %v", err)
+	}
+	want = []string{"namespaceconfig/team1/default"}
+	if !reflect.DeepEqual(denied.Objects, want) {
+		t.Errorf("expected %v, got %v", want, denied.Objects)
+	}
+}
+
+func TestEnforceNamespacePolicy(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := kustomizev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	// the config of the tenant namespace can't widen the policy
+	tenantConfig := &kustomizev1.NamespaceConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: kustomizev1.NamespaceConfigName, Namespace: "team1"},
+		Spec:       kustomizev1.NamespaceConfigSpec{AllowedNamespaces: []string{"*"}},
+	}
+	policyConfig := &kustomizev1.NamespaceConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "team1", Namespace: "flux-system"},
+		Spec:       kustomizev1.NamespaceConfigSpec{AllowedNamespaces: []string{"team1", "team1-*"}},
+	}
+
+	k := kustomizev1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{Name: "apps", Namespace: "team1", UID: "uid"},
+		Spec: kustomizev1.KustomizationSpec{
+			Hooks: &kustomizev1.Hooks{PreApply: []kustomizev1.HookReference{{Name: "migrate"}}},
+		},
+	}
+	dir := t.TempDir()
+	manifests := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+  namespace: team1-apps
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "uid.yaml"), []byte(manifests), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hooks := `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  namespace: kube-system
+`
+	if err := ioutil.WriteFile(hooksFile(dir, k), []byte(hooks), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		objects []client.Object
+		want    []string
+	}{
+		{name: "own namespace", objects: []client.Object{tenantConfig}, want: []string{"configmap/team1-apps/settings", "job/kube-system/migrate"}},
+		{name: "policy config", objects: []client.Object{tenantConfig, policyConfig}, want: []string{"job/kube-system/migrate"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &KustomizationReconciler{
+				Client:                 fake.NewClientBuilder().WithScheme(scheme).WithObjects(tt.objects...).Build(),
+				controllerNamespace:    "flux-system",
+				restrictToOwnNamespace: true,
+			}
+			err := r.enforceNamespacePolicy(context.TODO(), preflightMapper(), k, dir)
+			var denied *NamespaceDeniedError
+			if !errors.As(err, &denied) {
+				t.Fatalf("expected NamespaceDeniedError, got %v", err)
+			}
+			if !reflect.DeepEqual(denied.Objects, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, denied.Objects)
+			}
+		})
+	}
+}
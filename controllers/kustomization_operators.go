@@ -0,0 +1,124 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const rbacGroup = "rbac.authorization.k8s.io"
+
+// findOperators returns the workloads that manage the custom resources of
+// the given CRDs. A Deployment, StatefulSet or DaemonSet is considered an
+// operator if its service account is bound, in the same build, to a Role or
+// ClusterRole granting access to the API group of one of the CRDs.
+func findOperators(objects, crds []*unstructured.Unstructured) []*unstructured.Unstructured {
+	groups := make(map[string]bool)
+	for _, crd := range crds {
+		group, _, _ := unstructured.NestedString(crd.Object, "spec", "group")
+		groups[group] = true
+	}
+
+	// the roles granting access to the custom resources
+	roles := make(map[string]bool)
+	for _, obj := range objects {
+		if obj.GroupVersionKind().Group != rbacGroup || (obj.GetKind() != "Role" && obj.GetKind() != "ClusterRole") {
+			continue
+		}
+		if grantsGroups(obj, groups) {
+			roles[roleKey(obj.GetKind(), obj.GetNamespace(), obj.GetName())] = true
+		}
+	}
+	if len(roles) == 0 {
+		return nil
+	}
+
+	// the service accounts bound to these roles
+	accounts := make(map[string]bool)
+	for _, obj := range objects {
+		if obj.GroupVersionKind().Group != rbacGroup || (obj.GetKind() != "RoleBinding" && obj.GetKind() != "ClusterRoleBinding") {
+			continue
+		}
+		kind, _, _ := unstructured.NestedString(obj.Object, "roleRef", "kind")
+		name, _, _ := unstructured.NestedString(obj.Object, "roleRef", "name")
+		if !roles[roleKey(kind, obj.GetNamespace(), name)] {
+			continue
+		}
+		subjects, _, _ := unstructured.NestedSlice(obj.Object, "subjects")
+		for _, s := range subjects {
+			subject, ok := s.(map[string]interface{})
+			if !ok || subject["kind"] != "ServiceAccount" {
+				continue
+			}
+			namespace, _ := subject["namespace"].(string)
+			if namespace == "" {
+				namespace = obj.GetNamespace()
+			}
+			name, _ := subject["name"].(string)
+			accounts[namespace+"/"+name] = true
+		}
+	}
+
+	// the workloads running with these service accounts
+	var operators []*unstructured.Unstructured
+	for _, obj := range objects {
+		if obj.GroupVersionKind().Group != "apps" {
+			continue
+		}
+		switch obj.GetKind() {
+		case "Deployment", "StatefulSet", "DaemonSet":
+		default:
+			continue
+		}
+		account, _, _ := unstructured.NestedString(obj.Object, "spec", "template", "spec", "serviceAccountName")
+		if account == "" {
+			account = "default"
+		}
+		if accounts[obj.GetNamespace()+"/"+account] {
+			operators = append(operators, obj)
+		}
+	}
+	return operators
+}
+
+// grantsGroups returns true if one of the rules of the role
+// refers to one of the given API groups.
+func grantsGroups(role *unstructured.Unstructured, groups map[string]bool) bool {
+	rules, _, _ := unstructured.NestedSlice(role.Object, "rules")
+	for _, r := range rules {
+		rule, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		apiGroups, _, _ := unstructured.NestedStringSlice(rule, "apiGroups")
+		for _, group := range apiGroups {
+			if group == "*" || groups[group] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// roleKey returns the key of a role, the namespace of
+// the ClusterRoles and of their bindings is ignored.
+func roleKey(kind, namespace, name string) string {
+	if kind == "ClusterRole" {
+		return kind + "/" + name
+	}
+	return kind + "/" + namespace + "/" + name
+}
@@ -0,0 +1,270 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// applyVerbs are the verbs required to server-side apply an object.
+var applyVerbs = []string{"get", "create", "patch"}
+
+// pruneVerbs are the verbs required to garbage collect the objects.
+var pruneVerbs = []string{"list", "delete"}
+
+// maxConcurrentReviews is the maximum number of
+// SelfSubjectAccessReviews sent concurrently.
+const maxConcurrentReviews = 10
+
+// PermissionDeniedError is returned when the identity the Kustomization
+// is reconciled with can't apply some of the objects of the build output.
+type PermissionDeniedError struct {
+	Missing []string
+}
+
+func (e *PermissionDeniedError) Error() string {
+	return fmt.Sprintf("missing permissions to apply the objects: %s", strings.Join(e.Missing, "; "))
+}
+
+// resourceAccess is the access to a resource in a namespace,
+// or at the cluster scope if the namespace is empty.
+type resourceAccess struct {
+	group     string
+	resource  string
+	namespace string
+}
+
+func (a resourceAccess) String() string {
+	name := a.resource
+	if a.group != "" {
+		name = fmt.Sprintf("%s.%s", a.resource, a.group)
+	}
+	if a.namespace == "" {
+		return fmt.Sprintf("%s at the cluster scope", name)
+	}
+	return fmt.Sprintf("%s in namespace '%s'", name, a.namespace)
+}
+
+// applyAccesses returns the resources and namespaces of the objects, sorted.
+// The namespaced objects that don't specify a namespace are applied in 'default'.
+// The kinds unknown to the mapper, such as the custom resources of CRDs
+// defined in the same build, are skipped.
+func applyAccesses(mapper meta.RESTMapper, objects []*unstructured.Unstructured) []resourceAccess {
+	seen := make(map[resourceAccess]bool)
+	var accesses []resourceAccess
+	for _, obj := range objects {
+		gvk := obj.GroupVersionKind()
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			continue
+		}
+		access := resourceAccess{group: gvk.Group, resource: mapping.Resource.Resource}
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			access.namespace = obj.GetNamespace()
+			if access.namespace == "" {
+				access.namespace = "default"
+			}
+		}
+		if !seen[access] {
+			seen[access] = true
+			accesses = append(accesses, access)
+		}
+	}
+	sort.Slice(accesses, func(i, j int) bool {
+		return accesses[i].String() < accesses[j].String()
+	})
+	return accesses
+}
+
+// checkPermissions reviews with SelfSubjectAccessReviews that the identity
+// of the client is allowed the verbs on the resources of the objects, and returns
+// a PermissionDeniedError listing the denied verbs per resource and namespace.
+// The reviews are sent concurrently.
+func checkPermissions(ctx context.Context, kubeClient client.Client, mapper meta.RESTMapper, objects []*unstructured.Unstructured, verbs []string) error {
+	accesses := applyAccesses(mapper, objects)
+	denied := make([][]bool, len(accesses))
+	errs := make([]error, len(accesses)*len(verbs))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentReviews)
+	for i, access := range accesses {
+		denied[i] = make([]bool, len(verbs))
+		for j, verb := range verbs {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i, j int, access resourceAccess, verb string) {
+				defer func() { <-sem; wg.Done() }()
+				review := &authorizationv1.SelfSubjectAccessReview{
+					Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+						ResourceAttributes: &authorizationv1.ResourceAttributes{
+							Namespace: access.namespace,
+							Verb:      verb,
+							Group:     access.group,
+							Resource:  access.resource,
+						},
+					},
+				}
+				if err := kubeClient.Create(ctx, review); err != nil {
+					errs[i*len(verbs)+j] = fmt.Errorf("access review of %s failed: %w", access.String(), err)
+					return
+				}
+				denied[i][j] = !review.Status.Allowed
+			}(i, j, access, verb)
+		}
+	}
+	wg.Wait()
+
+	var missing []string
+	for i, access := range accesses {
+		var deniedVerbs []string
+		for j, verb := range verbs {
+			if err := errs[i*len(verbs)+j]; err != nil {
+				return err
+			}
+			if denied[i][j] {
+				deniedVerbs = append(deniedVerbs, verb)
+			}
+		}
+		if len(deniedVerbs) > 0 {
+			missing = append(missing, fmt.Sprintf("%s %s", strings.Join(deniedVerbs, ","), access.String()))
+		}
+	}
+	if len(missing) > 0 {
+		return &PermissionDeniedError{Missing: missing}
+	}
+	return nil
+}
+
+// preflightVerbs returns the verbs the identity of the Kustomization
+// needs on the resources of the objects it applies.
+func preflightVerbs(kustomization kustomizev1.Kustomization) []string {
+	if !kustomization.Spec.Prune {
+		return applyVerbs
+	}
+	return append(append([]string{}, applyVerbs...), pruneVerbs...)
+}
+
+// permissionCache records the preflight checks that passed per Kustomization
+// and target cluster, so that the access reviews are only sent again when
+// the revision, the identity or the reviewed resources change.
+// The denied permissions are not recorded, to detect the RBAC fixes
+// at the next reconciliation. The methods of a nil cache are no-ops.
+type permissionCache struct {
+	mu       sync.Mutex
+	reviewed map[client.ObjectKey]map[string]string
+}
+
+func newPermissionCache() *permissionCache {
+	return &permissionCache{reviewed: make(map[client.ObjectKey]map[string]string)}
+}
+
+// passed returns true if the preflight check with the given checksum passed.
+func (c *permissionCache) passed(kustomization kustomizev1.Kustomization, checksum string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reviewed[ObjectKey(&kustomization)][targetCluster(kustomization)] == checksum
+}
+
+func (c *permissionCache) set(kustomization kustomizev1.Kustomization, checksum string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := ObjectKey(&kustomization)
+	if c.reviewed[key] == nil {
+		c.reviewed[key] = make(map[string]string)
+	}
+	c.reviewed[key][targetCluster(kustomization)] = checksum
+}
+
+// forget removes the checks of the Kustomization, for all the target clusters.
+func (c *permissionCache) forget(kustomization kustomizev1.Kustomization) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.reviewed, ObjectKey(&kustomization))
+}
+
+// preflightChecksum returns the checksum of the inputs of the preflight check:
+// the revision, the identity the Kustomization is reconciled with, and the
+// resources and verbs to review.
+func preflightChecksum(kustomization kustomizev1.Kustomization, revision string, accesses []resourceAccess, verbs []string) (string, error) {
+	identity, err := json.Marshal(struct {
+		ServiceAccountName string
+		Impersonation      interface{}
+		KubeConfig         interface{}
+	}{kustomization.Spec.ServiceAccountName, kustomization.Spec.Impersonation, kustomization.Spec.KubeConfig})
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n", revision, identity, strings.Join(verbs, ","))
+	for _, access := range accesses {
+		fmt.Fprintf(h, "%s\n", access.String())
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// preflight checks that the identity the Kustomization is reconciled with can apply
+// the build output and the hook Jobs, and prune them when spec.prune is enabled.
+// The check is skipped when the controller's own identity is used, or when it
+// passed for the same revision, identity and resources.
+func (r *KustomizationReconciler) preflight(ctx context.Context, kubeClient client.Client, kustomization kustomizev1.Kustomization, revision, dirPath string) error {
+	if kustomization.Spec.ServiceAccountName == "" && kustomization.Spec.Impersonation == nil &&
+		kustomization.Spec.KubeConfig == nil {
+		return nil
+	}
+
+	objects, err := readBuildAndHookObjects(dirPath, kustomization)
+	if err != nil {
+		return err
+	}
+
+	mapper := kubeClient.RESTMapper()
+	verbs := preflightVerbs(kustomization)
+	checksum, err := preflightChecksum(kustomization, revision, applyAccesses(mapper, objects), verbs)
+	if err != nil {
+		return err
+	}
+	if r.permissions.passed(kustomization, checksum) {
+		return nil
+	}
+
+	if err := checkPermissions(ctx, kubeClient, mapper, objects, verbs); err != nil {
+		return err
+	}
+	r.permissions.set(kustomization, checksum)
+	return nil
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// reviewClient answers the SelfSubjectAccessReviews with the allowed function.
+type reviewClient struct {
+	client.Client
+	allowed func(attrs *authorizationv1.ResourceAttributes) bool
+	reviews int32
+}
+
+func (c *reviewClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	review, ok := obj.(*authorizationv1.SelfSubjectAccessReview)
+	if !ok {
+		return errors.New("unexpected object")
+	}
+	atomic.AddInt32(&c.reviews, 1)
+	review.Status.Allowed = c.allowed(review.Spec.ResourceAttributes)
+	return nil
+}
+
+func (c *reviewClient) RESTMapper() meta.RESTMapper {
+	return preflightMapper()
+}
+
+func preflightMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	mapper.Add(schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"}, meta.RESTScopeRoot)
+	return mapper
+}
+
+func preflightObject(apiVersion, kind, namespace, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return obj
+}
+
+func TestApplyAccesses(t *testing.T) {
+	objects := []*unstructured.Unstructured{
+		preflightObject("apps/v1", "Deployment", "dev", "frontend"),
+		preflightObject("apps/v1", "Deployment", "dev", "backend"),
+		preflightObject("v1", "ConfigMap", "", "settings"),
+		preflightObject("v1", "Namespace", "", "dev"),
+		preflightObject("example.com/v1", "Widget", "dev", "widget"),
+	}
+
+	var got []string
+	for _, access := range applyAccesses(preflightMapper(), objects) {
+		got = append(got, access.String())
+	}
+	want := []string{
+		"configmaps in namespace 'default'",
+		"deployments.apps in namespace 'dev'",
+		"namespaces at the cluster scope",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestCheckPermissions(t *testing.T) {
+	objects := []*unstructured.Unstructured{
+		preflightObject("apps/v1", "Deployment", "dev", "frontend"),
+		preflightObject("v1", "ConfigMap", "dev", "settings"),
+		preflightObject("v1", "Namespace", "", "dev"),
+	}
+
+	kubeClient := &reviewClient{allowed: func(attrs *authorizationv1.ResourceAttributes) bool {
+		switch attrs.Resource {
+		case "namespaces":
+			return false
+		case "deployments":
+			return attrs.Verb == "get"
+		}
+		return true
+	}}
+
+	err := checkPermissions(context.TODO(), kubeClient, preflightMapper(), objects, applyVerbs)
+	var denied *PermissionDeniedError
+	if !errors.As(err, &denied) {
+		t.Fatalf("expected PermissionDeniedError, got %v", err)
+	}
+	want := []string{
+		"create,patch deployments.apps in namespace 'dev'",
+		"get,create,patch namespaces at the cluster scope",
+	}
+	if !reflect.DeepEqual(denied.Missing, want) {
+		t.Errorf("expected %v, got %v", want, denied.Missing)
+	}
+
+	kubeClient.allowed = func(*authorizationv1.ResourceAttributes) bool { return true }
+	if err := checkPermissions(context.TODO(), kubeClient, preflightMapper(), objects, applyVerbs); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCheckPermissionsPrune(t *testing.T) {
+	objects := []*unstructured.Unstructured{
+		preflightObject("v1", "ConfigMap", "dev", "settings"),
+	}
+	kubeClient := &reviewClient{allowed: func(attrs *authorizationv1.ResourceAttributes) bool {
+		return attrs.Verb != "delete"
+	}}
+
+	kustomization := kustomizev1.Kustomization{}
+	if err := checkPermissions(context.TODO(), kubeClient, preflightMapper(), objects, preflightVerbs(kustomization)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	kustomization.Spec.Prune = true
+	err := checkPermissions(context.TODO(), kubeClient, preflightMapper(), objects, preflightVerbs(kustomization))
+	var denied *PermissionDeniedError
+	if !errors.As(err, &denied) {
+		t.Fatalf("expected PermissionDeniedError, got %v", err)
+	}
+	want := []string{"delete configmaps in namespace 'dev'"}
+	if !reflect.DeepEqual(denied.Missing, want) {
+		t.Errorf("expected %v, got %v", want, denied.Missing)
+	}
+}
+
+func TestPreflightCache(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "preflight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	kustomization := kustomizev1.Kustomization{}
+	kustomization.SetNamespace("dev")
+	kustomization.SetName("apps")
+	kustomization.SetUID("uid")
+	kustomization.Spec.ServiceAccountName = "deployer"
+	manifests := []byte(`---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+  namespace: dev
+`)
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("%s.yaml", kustomization.GetUID())), manifests, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	allowed := false
+	kubeClient := &reviewClient{allowed: func(*authorizationv1.ResourceAttributes) bool { return allowed }}
+	r := &KustomizationReconciler{permissions: newPermissionCache()}
+	preflight := func(revision string) (int32, error) {
+		atomic.StoreInt32(&kubeClient.reviews, 0)
+		err := r.preflight(context.TODO(), kubeClient, kustomization, revision, tmpDir)
+		return atomic.LoadInt32(&kubeClient.reviews), err
+	}
+
+	// the denied permissions are reviewed again
+	if _, err := preflight("main/1"); err == nil {
+		t.Fatal("expected the permissions to be denied")
+	}
+	allowed = true
+	if n, err := preflight("main/1"); err != nil || n != int32(len(applyVerbs)) {
+		t.Fatalf("expected %d reviews, got %d, %v", len(applyVerbs), n, err)
+	}
+
+	// the checks that passed are cached per revision and identity
+	if n, err := preflight("main/1"); err != nil || n != 0 {
+		t.Errorf("expected the check to be cached, got %d reviews, %v", n, err)
+	}
+	if n, _ := preflight("main/2"); n == 0 {
+		t.Error("expected a new revision to be reviewed")
+	}
+	kustomization.Spec.ServiceAccountName = "admin"
+	if n, _ := preflight("main/2"); n == 0 {
+		t.Error("expected a new identity to be reviewed")
+	}
+
+	r.permissions.forget(kustomization)
+	if n, _ := preflight("main/2"); n == 0 {
+		t.Error("expected the forgotten check to be reviewed")
+	}
+}
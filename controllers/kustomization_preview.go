@@ -0,0 +1,240 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pmezard/go-difflib/difflib"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	kyaml "sigs.k8s.io/yaml"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+const (
+	previewSuffix       = "-preview"
+	previewRevisionKey  = "revision"
+	previewManifestsKey = "manifests.yaml"
+	previewDiffKey      = "diff"
+	previewMask         = "***"
+	previewMaskBefore   = "*** (before)"
+	previewMaskAfter    = "*** (after)"
+
+	// previewMaxSize is the maximum size of each ConfigMap entry,
+	// chosen to keep the ConfigMap under the 1MiB limit.
+	previewMaxSize = 400 * 1024
+)
+
+// preview computes the changes the build output would make to the cluster
+// using server-side dry-run, and records the build output along with
+// the diff in a ConfigMap owned by the Kustomization.
+// The values of the Secrets are masked in both the build output and the diff.
+func (r *KustomizationReconciler) preview(ctx context.Context, kubeClient client.Client, kustomization kustomizev1.Kustomization, revision, dirPath string) (*kustomizev1.PreviewReport, *objectChanges, error) {
+	objects, err := readBuildObjects(dirPath, kustomization)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var build strings.Builder
+	for _, obj := range objects {
+		text, err := previewYAML(maskSecret(obj, nil, ""))
+		if err != nil {
+			return nil, nil, err
+		}
+		build.WriteString("---\n" + text)
+	}
+
+	changes, err := dryRunChanges(ctx, kubeClient, kustomization, objects)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cm := &corev1.ConfigMap{}
+	cm.SetName(kustomization.GetName() + previewSuffix)
+	cm.SetNamespace(kustomization.GetNamespace())
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, cm, func() error {
+		cm.SetLabels(previewLabels(kustomization.GetName(), kustomization.GetNamespace()))
+		cm.Data = map[string]string{
+			previewRevisionKey:  revision,
+			previewManifestsKey: truncate(build.String(), previewMaxSize),
+			previewDiffKey:      truncate(changes.Diff, previewMaxSize),
+		}
+		return controllerutil.SetControllerReference(&kustomization, cm, r.Scheme)
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to write preview ConfigMap '%s/%s': %w", cm.GetNamespace(), cm.GetName(), err)
+	}
+
+	return &kustomizev1.PreviewReport{
+		Revision:      revision,
+		ConfigMapName: cm.GetName(),
+		Summary: fmt.Sprintf("%d created, %d configured, %d unchanged",
+			len(changes.Created), len(changes.Configured), len(changes.Unchanged)),
+	}, changes, nil
+}
+
+// dryRunApply returns the object as it would be persisted
+// by the API server after applying it with the given conflict policy.
+func dryRunApply(ctx context.Context, kubeClient client.Client, obj, existing *unstructured.Unstructured, conflictPolicy string) (*unstructured.Unstructured, error) {
+	dryRunObj := obj.DeepCopy()
+	err := serverSideApply(ctx, kubeClient, dryRunObj, existing, conflictPolicy, true)
+	return dryRunObj, err
+}
+
+// diffObjects returns the unified diff between the YAML representation
+// of the in-cluster object and the desired one, ignoring the fields
+// managed by the API server.
+func diffObjects(id string, existing, desired *unstructured.Unstructured) (string, error) {
+	from, err := previewYAML(maskSecret(existing, desired, previewMaskBefore))
+	if err != nil {
+		return "", err
+	}
+	to, err := previewYAML(maskSecret(desired, existing, previewMaskAfter))
+	if err != nil {
+		return "", err
+	}
+	if from == to {
+		return "", nil
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(from),
+		B:        difflib.SplitLines(to),
+		FromFile: "cluster/" + id,
+		ToFile:   "build/" + id,
+		Context:  3,
+	})
+}
+
+func previewYAML(obj *unstructured.Unstructured) (string, error) {
+	if obj == nil {
+		return "", nil
+	}
+	o := obj.DeepCopy()
+	for _, field := range [][]string{
+		{"status"},
+		{"metadata", "managedFields"},
+		{"metadata", "resourceVersion"},
+		{"metadata", "generation"},
+		{"metadata", "uid"},
+		{"metadata", "creationTimestamp"},
+		{"metadata", "selfLink"},
+		{"metadata", "annotations", "kubectl.kubernetes.io/last-applied-configuration"},
+	} {
+		unstructured.RemoveNestedField(o.Object, field...)
+	}
+	if len(o.GetAnnotations()) == 0 {
+		unstructured.RemoveNestedField(o.Object, "metadata", "annotations")
+	}
+	data, err := kyaml.Marshal(o.Object)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal '%s': %w", objectID(obj), err)
+	}
+	return string(data), nil
+}
+
+// previewLabels returns the labels of the preview ConfigMap. These differ from
+// the selector labels, so that the ConfigMap is not mistaken for an applied
+// object by the garbage collection, the state checksum or the drift detection.
+func previewLabels(name, namespace string) map[string]string {
+	return map[string]string{
+		fmt.Sprintf("%s/preview-name", kustomizev1.GroupVersion.Group):      name,
+		fmt.Sprintf("%s/preview-namespace", kustomizev1.GroupVersion.Group): namespace,
+	}
+}
+
+// maskSecret returns a copy of the Secret with its values replaced by
+// a placeholder. The values that differ from the other version of the
+// Secret are replaced with the changed placeholder instead.
+// Other kinds are returned as is.
+func maskSecret(obj, other *unstructured.Unstructured, changed string) *unstructured.Unstructured {
+	if obj == nil || obj.GetKind() != "Secret" || obj.GroupVersionKind().Group != "" {
+		return obj
+	}
+	o := obj.DeepCopy()
+	var otherValues map[string]string
+	if other != nil {
+		otherValues = secretValues(other)
+	}
+	for _, field := range []string{"data", "stringData"} {
+		data, ok, _ := unstructured.NestedMap(o.Object, field)
+		if !ok {
+			continue
+		}
+		for k, v := range data {
+			data[k] = previewMask
+			if other == nil {
+				continue
+			}
+			value, ok := secretValue(field, v)
+			if otherValue, found := otherValues[k]; !ok || !found || otherValue != value {
+				data[k] = changed
+			}
+		}
+		_ = unstructured.SetNestedMap(o.Object, data, field)
+	}
+	return o
+}
+
+// secretValues returns the decoded values of the Secret,
+// the stringData values take precedence over the data ones.
+func secretValues(obj *unstructured.Unstructured) map[string]string {
+	values := map[string]string{}
+	for _, field := range []string{"data", "stringData"} {
+		data, _, _ := unstructured.NestedMap(obj.Object, field)
+		for k, v := range data {
+			if value, ok := secretValue(field, v); ok {
+				values[k] = value
+			}
+		}
+	}
+	return values
+}
+
+// secretValue returns the decoded value of a Secret entry of the given field.
+func secretValue(field string, v interface{}) (string, bool) {
+	value, ok := v.(string)
+	if !ok {
+		return "", false
+	}
+	if field == "stringData" {
+		return value, true
+	}
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", false
+	}
+	return string(decoded), true
+}
+
+// truncate cuts the string to at most size bytes, on a rune boundary.
+func truncate(s string, size int) string {
+	if len(s) <= size {
+		return s
+	}
+	for size > 0 && !utf8.RuneStart(s[size]) {
+		size--
+	}
+	return s[:size] + "\n... truncated\n"
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDiffObjects(t *testing.T) {
+	newSecret := func(value string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata": map[string]interface{}{
+				"name":            "test",
+				"namespace":       "default",
+				"resourceVersion": "1",
+			},
+			"data": map[string]interface{}{
+				"password": value,
+				"username": "YWRtaW4=",
+			},
+		}}
+		return obj
+	}
+
+	existing := newSecret("c2VjcmV0")
+	diff, err := diffObjects("secret/default/test", existing, newSecret("c2VjcmV0"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff != "" {
+		t.Errorf("expected no diff for unchanged object, got:\n%s", diff)
+	}
+
+	diff, err = diffObjects("secret/default/test", existing, newSecret("bmV3"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(diff, "c2VjcmV0") || strings.Contains(diff, "bmV3") {
+		t.Errorf("expected secret values to be masked, got:\n%s", diff)
+	}
+	for _, line := range []string{"-  password: '*** (before)'", "+  password: '*** (after)'"} {
+		if !strings.Contains(diff, line) {
+			t.Errorf("expected diff to contain %q, got:\n%s", line, diff)
+		}
+	}
+	if !strings.Contains(diff, "   username: '***'") {
+		t.Errorf("expected unchanged values to be masked in the diff context, got:\n%s", diff)
+	}
+}
+
+func TestMaskSecretStringData(t *testing.T) {
+	existing := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"data": map[string]interface{}{
+			"password": "c2VjcmV0",
+			"username": "YWRtaW4=",
+		},
+	}}
+	desired := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"stringData": map[string]interface{}{
+			"password": "secret",
+			"username": "root",
+		},
+	}}
+
+	masked := maskSecret(desired, existing, previewMaskAfter)
+	data, _, _ := unstructured.NestedStringMap(masked.Object, "stringData")
+	if data["password"] != previewMask {
+		t.Errorf("expected the unchanged value to be masked, got %q", data["password"])
+	}
+	if data["username"] != previewMaskAfter {
+		t.Errorf("expected the changed value to be masked as changed, got %q", data["username"])
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	s := "abcéé"
+	if got := truncate(s, len(s)); got != s {
+		t.Errorf("expected the string not to be truncated, got %q", got)
+	}
+	// the cut falls in the middle of the second two-byte rune
+	got := truncate(s, 6)
+	if !utf8.ValidString(got) || !strings.HasPrefix(got, "abcé\n") {
+		t.Errorf("expected the string to be cut on a rune boundary, got %q", got)
+	}
+}
+
+func TestPreviewLabels(t *testing.T) {
+	labels := previewLabels("apps", "flux-system")
+	for k := range selectorLabels("apps", "flux-system") {
+		if _, ok := labels[k]; ok {
+			t.Errorf("expected the preview labels not to contain the selector label %s", k)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// protectedKinds are the kinds of the cluster-critical objects whose deletion
+// cascades to the objects they contain or hold, these are never garbage collected
+// unless the kind is listed in spec.pruneEnabledFor.
+var protectedKinds = []string{"Namespace", "CustomResourceDefinition", "PersistentVolume"}
+
+// pruneProtection determines the objects that must not be garbage collected,
+// the cluster-critical kinds and the RBAC objects granting the controller its permissions.
+type pruneProtection struct {
+	kinds               map[string]bool
+	rbacKinds           map[string]bool
+	controllerNamespace string
+	controllerRoles     map[string]bool
+}
+
+// newPruneProtection returns the protection of the cluster-critical kinds and
+// of the RBAC objects of the controller, except for the enabled kinds. The RBAC
+// objects are not protected when the namespace of the controller is not known.
+func newPruneProtection(enabledKinds []string, controllerNamespace string) *pruneProtection {
+	enabled := make(map[string]bool, len(enabledKinds))
+	for _, kind := range enabledKinds {
+		enabled[kind] = true
+	}
+
+	p := &pruneProtection{
+		kinds:               make(map[string]bool),
+		rbacKinds:           make(map[string]bool),
+		controllerNamespace: controllerNamespace,
+	}
+	for _, kind := range protectedKinds {
+		if !enabled[kind] {
+			p.kinds[kind] = true
+		}
+	}
+	for _, kind := range []string{"ServiceAccount", "Role", "RoleBinding", "ClusterRole", "ClusterRoleBinding"} {
+		if !enabled[kind] && controllerNamespace != "" {
+			p.rbacKinds[kind] = true
+		}
+	}
+	return p
+}
+
+// isProtected returns true if the object is of a protected kind, or if it is
+// part of the RBAC of the controller: the service accounts, roles and role bindings
+// of the controller namespace, the cluster role bindings granting permissions to
+// the service accounts of the controller namespace and the cluster roles they reference.
+func (p *pruneProtection) isProtected(ctx context.Context, kubeClient client.Client, obj unstructured.Unstructured) (bool, error) {
+	kind := obj.GetKind()
+	if p.kinds[kind] {
+		return true, nil
+	}
+	if !p.rbacKinds[kind] {
+		return false, nil
+	}
+
+	switch kind {
+	case "ServiceAccount", "Role", "RoleBinding":
+		return obj.GetNamespace() == p.controllerNamespace, nil
+	case "ClusterRoleBinding":
+		binding := &rbacv1.ClusterRoleBinding{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, binding); err != nil {
+			return false, err
+		}
+		return p.bindsController(binding.Subjects), nil
+	case "ClusterRole":
+		if p.controllerRoles == nil {
+			roles, err := p.listControllerRoles(ctx, kubeClient)
+			if err != nil {
+				return false, err
+			}
+			p.controllerRoles = roles
+		}
+		return p.controllerRoles[obj.GetName()], nil
+	}
+	return false, nil
+}
+
+// bindsController returns true if the subjects include
+// a service account of the controller namespace.
+func (p *pruneProtection) bindsController(subjects []rbacv1.Subject) bool {
+	for _, subject := range subjects {
+		if subject.Kind == rbacv1.ServiceAccountKind && subject.Namespace == p.controllerNamespace {
+			return true
+		}
+	}
+	return false
+}
+
+// listControllerRoles returns the names of the cluster roles bound
+// to the service accounts of the controller namespace.
+func (p *pruneProtection) listControllerRoles(ctx context.Context, kubeClient client.Client) (map[string]bool, error) {
+	roles := make(map[string]bool)
+
+	var clusterBindings rbacv1.ClusterRoleBindingList
+	if err := kubeClient.List(ctx, &clusterBindings); err != nil {
+		return nil, err
+	}
+	for _, binding := range clusterBindings.Items {
+		if binding.RoleRef.Kind == "ClusterRole" && p.bindsController(binding.Subjects) {
+			roles[binding.RoleRef.Name] = true
+		}
+	}
+
+	var bindings rbacv1.RoleBindingList
+	if err := kubeClient.List(ctx, &bindings, client.InNamespace(p.controllerNamespace)); err != nil {
+		return nil, err
+	}
+	for _, binding := range bindings.Items {
+		if binding.RoleRef.Kind == "ClusterRole" {
+			roles[binding.RoleRef.Name] = true
+		}
+	}
+	return roles, nil
+}
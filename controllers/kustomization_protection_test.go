@@ -0,0 +1,120 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPruneProtection(t *testing.T) {
+	objects, err := readObjects([]byte(`---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: apps
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: databases.example.com
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  namespace: apps
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: kustomize-controller
+  namespace: flux-system
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: backend
+  namespace: apps
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: cluster-reconciler
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: cluster-admin
+subjects:
+  - kind: ServiceAccount
+    name: kustomize-controller
+    namespace: flux-system
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: crd-controller
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: backend
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	kubeClient := fake.NewClientBuilder().WithObjects(&rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "crd-controller"},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "crd-controller"},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: "kustomize-controller", Namespace: "flux-system"}},
+	}).Build()
+
+	protected := map[string]bool{
+		"namespace/apps": true,
+		"customresourcedefinition/databases.example.com":  true,
+		"serviceaccount/flux-system/kustomize-controller": true,
+		"clusterrolebinding/cluster-reconciler":           true,
+		"clusterrole/crd-controller":                      true,
+	}
+	p := newPruneProtection(nil, "flux-system")
+	for _, obj := range objects {
+		got, err := p.isProtected(context.TODO(), kubeClient, *obj)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want := protected[objectID(obj)]; got != want {
+			t.Errorf("isProtected(%s) = %v, want %v", objectID(obj), got, want)
+		}
+	}
+
+	p = newPruneProtection([]string{"Namespace", "ClusterRole"}, "flux-system")
+	for _, obj := range objects {
+		got, err := p.isProtected(context.TODO(), kubeClient, *obj)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := protected[objectID(obj)] && obj.GetKind() != "Namespace" && obj.GetKind() != "ClusterRole"
+		if got != want {
+			t.Errorf("isProtected(%s) = %v, want %v with the kind enabled", objectID(obj), got, want)
+		}
+	}
+}
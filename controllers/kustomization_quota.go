@@ -0,0 +1,116 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// QuotaExceededError is returned when the build output
+// exceeds the object quota of the NamespaceConfig.
+type QuotaExceededError struct {
+	Violations []string
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("object quota exceeded: %s", strings.Join(e.Violations, "; "))
+}
+
+// checkQuota returns a QuotaExceededError listing the limits of the quota exceeded by
+// the objects. The namespaceObjects is the number of objects managed by the other
+// Kustomizations of the namespace.
+func checkQuota(quota kustomizev1.ObjectQuota, objects []*unstructured.Unstructured, namespaceObjects int) error {
+	var violations []string
+	if max := quota.MaxObjects; max != nil && len(objects) > int(*max) {
+		violations = append(violations, fmt.Sprintf("%d objects, the limit is %d", len(objects), *max))
+	}
+	if max := quota.MaxNamespaceObjects; max != nil && namespaceObjects+len(objects) > int(*max) {
+		violations = append(violations, fmt.Sprintf("%d objects in the namespace, the limit is %d",
+			namespaceObjects+len(objects), *max))
+	}
+
+	kinds := make(map[string]int)
+	for _, obj := range objects {
+		kinds[obj.GetKind()]++
+	}
+	var names []string
+	for kind := range quota.Kinds {
+		names = append(names, kind)
+	}
+	sort.Strings(names)
+	for _, kind := range names {
+		if max := quota.Kinds[kind]; kinds[kind] > int(max) {
+			violations = append(violations, fmt.Sprintf("%d %s objects, the limit is %d", kinds[kind], kind, max))
+		}
+	}
+
+	if len(violations) > 0 {
+		return &QuotaExceededError{Violations: violations}
+	}
+	return nil
+}
+
+// inventorySize returns the number of objects in the
+// inventories of the Kustomization, for all its clusters.
+func inventorySize(kustomization kustomizev1.Kustomization) int {
+	size := 0
+	if inv := kustomization.Status.Inventory; inv != nil {
+		size += len(inv.Entries)
+	}
+	for _, cluster := range kustomization.Status.Clusters {
+		if cluster.Inventory != nil {
+			size += len(cluster.Inventory.Entries)
+		}
+	}
+	return size
+}
+
+// enforceQuota checks that the build output and the hook Jobs are within the object
+// quota of the NamespaceConfig named after the Kustomization namespace in the controller namespace.
+func (r *KustomizationReconciler) enforceQuota(ctx context.Context, kustomization kustomizev1.Kustomization, dirPath string) error {
+	config, err := r.getPolicyConfig(ctx, kustomization.GetNamespace())
+	if err != nil || config.Quota == nil {
+		return err
+	}
+
+	namespaceObjects := 0
+	if config.Quota.MaxNamespaceObjects != nil {
+		var list kustomizev1.KustomizationList
+		if err := r.List(ctx, &list, client.InNamespace(kustomization.GetNamespace())); err != nil {
+			return err
+		}
+		for _, k := range list.Items {
+			if k.GetName() != kustomization.GetName() {
+				namespaceObjects += inventorySize(k)
+			}
+		}
+	}
+
+	objects, err := readBuildAndHookObjects(dirPath, kustomization)
+	if err != nil {
+		return err
+	}
+	return checkQuota(*config.Quota, objects, namespaceObjects)
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestCheckQuota(t *testing.T) {
+	objects := []*unstructured.Unstructured{
+		preflightObject("apps/v1", "Deployment", "dev", "frontend"),
+		preflightObject("apps/v1", "Deployment", "dev", "backend"),
+		preflightObject("v1", "ConfigMap", "dev", "settings"),
+	}
+	max := func(n int32) *int32 { return &n }
+
+	if err := checkQuota(kustomizev1.ObjectQuota{MaxObjects: max(3)}, objects, 0); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	quota := kustomizev1.ObjectQuota{
+		MaxObjects:          max(2),
+		MaxNamespaceObjects: max(10),
+		Kinds:               map[string]int32{"Deployment": 1, "ConfigMap": 1},
+	}
+	err := checkQuota(quota, objects, 8)
+	var exceeded *QuotaExceededError
+	if !errors.As(err, &exceeded) {
+		t.Fatalf("expected QuotaExceededError, got %v", err)
+	}
+	want := []string{
+		"3 objects, the limit is 2",
+		"11 objects in the namespace, the limit is 10",
+		"2 Deployment objects, the limit is 1",
+	}
+	if !reflect.DeepEqual(exceeded.Violations, want) {
+		t.Errorf("expected %v, got %v", want, exceeded.Violations)
+	}
+}
+
+func TestEnforceQuota(t *testing.T) {
+	max := int32(3)
+	config := &kustomizev1.NamespaceConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "flux-system"},
+		Spec: kustomizev1.NamespaceConfigSpec{
+			Quota: &kustomizev1.ObjectQuota{MaxNamespaceObjects: &max},
+		},
+	}
+	// the quota of the tenant's own config is ignored
+	tenantMax := int32(100)
+	tenantConfig := &kustomizev1.NamespaceConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: kustomizev1.NamespaceConfigName, Namespace: "dev"},
+		Spec: kustomizev1.NamespaceConfigSpec{
+			Quota: &kustomizev1.ObjectQuota{MaxNamespaceObjects: &tenantMax},
+		},
+	}
+	other := &kustomizev1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{Name: "infra", Namespace: "dev"},
+		Status: kustomizev1.KustomizationStatus{
+			Inventory: &kustomizev1.ResourceInventory{Entries: []kustomizev1.ResourceRef{
+				{ID: "dev_a_apps_Deployment", Version: "v1"},
+				{ID: "dev_b_apps_Deployment", Version: "v1"},
+			}},
+		},
+	}
+	scheme := runtime.NewScheme()
+	if err := kustomizev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	r := &KustomizationReconciler{
+		Client:              fake.NewClientBuilder().WithScheme(scheme).WithObjects(config, tenantConfig, other).Build(),
+		controllerNamespace: "flux-system",
+	}
+
+	k := kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Name: "apps", Namespace: "dev", UID: "uid"}}
+	dir, err := ioutil.TempDir("", "quota")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	manifests := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a
+  namespace: dev
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: b
+  namespace: dev
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "uid.yaml"), []byte(manifests), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.enforceQuota(context.TODO(), k, dir)
+	var exceeded *QuotaExceededError
+	if !errors.As(err, &exceeded) {
+		t.Fatalf("expected QuotaExceededError, got %v", err)
+	}
+
+	max = 4
+	config.Spec.Quota.MaxNamespaceObjects = &max
+	r.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(config, tenantConfig, other).Build()
+	if err := r.enforceQuota(context.TODO(), k, dir); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,449 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+	"github.com/go-logr/logr"
+	"github.com/hashicorp/go-retryablehttp"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// newTestReconciler returns a reconciler backed by a fake client
+// holding the given objects.
+func newTestReconciler(t *testing.T, objects ...client.Object) *KustomizationReconciler {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	for _, add := range []func(*runtime.Scheme) error{
+		clientgoscheme.AddToScheme,
+		kustomizev1.AddToScheme,
+		sourcev1.AddToScheme,
+	} {
+		if err := add(scheme); err != nil {
+			t.Fatal(err)
+		}
+	}
+	httpClient := retryablehttp.NewClient()
+	httpClient.RetryMax = 0
+	httpClient.Logger = nil
+	return &KustomizationReconciler{
+		Client:        fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		httpClient:    httpClient,
+		Scheme:        scheme,
+		EventRecorder: record.NewFakeRecorder(100),
+	}
+}
+
+// serveArtifact serves the files as a tarball artifact, and returns its URL.
+func serveArtifact(t *testing.T, files map[string]string) string {
+	t.Helper()
+	var tgz bytes.Buffer
+	gw := gzip.NewWriter(&tgz)
+	tw := tar.NewWriter(gw)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(tgz.Bytes())
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/artifact.tar.gz"
+}
+
+// newTestKustomization returns a Kustomization of the GitRepository
+// returned by newTestRepository, with the finalizer registered.
+func newTestKustomization() *kustomizev1.Kustomization {
+	return &kustomizev1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:  "flux-system",
+			Name:       "apps",
+			Generation: 1,
+			Finalizers: []string{kustomizev1.KustomizationFinalizer},
+		},
+		Spec: kustomizev1.KustomizationSpec{
+			Interval:  metav1.Duration{Duration: time.Hour},
+			SourceRef: kustomizev1.CrossNamespaceSourceReference{Kind: sourcev1.GitRepositoryKind, Name: "apps"},
+			Path:      "./",
+		},
+	}
+}
+
+// newTestRepository returns a GitRepository with an artifact of the given revision.
+func newTestRepository(revision string) *sourcev1.GitRepository {
+	return &sourcev1.GitRepository{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "flux-system", Name: "apps"},
+		Status: sourcev1.GitRepositoryStatus{
+			Artifact: &sourcev1.Artifact{Revision: revision},
+		},
+	}
+}
+
+// setUpToDate records the status of a successful reconciliation of the revision,
+// with the state checksum of the Kustomization objects on the cluster.
+func setUpToDate(t *testing.T, r *KustomizationReconciler, k *kustomizev1.Kustomization, revision string) {
+	t.Helper()
+	snapshot := &kustomizev1.Snapshot{Checksum: "checksum", Entries: []kustomizev1.SnapshotEntry{}}
+	*k = kustomizev1.KustomizationReady(*k, snapshot, revision, meta.ReconciliationSucceededReason, "Applied")
+	k.Status.ObservedGeneration = k.Generation
+	checksum, err := r.stateChecksum(context.TODO(), r.Client, *k, snapshot)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	k.Status.StateChecksum = checksum
+}
+
+// reconcileRequest runs the reconciliation of the Kustomization.
+func reconcileRequest(t *testing.T, r *KustomizationReconciler, k *kustomizev1.Kustomization) (ctrl.Result, error) {
+	t.Helper()
+	ctx := logr.NewContext(context.TODO(), logr.Discard())
+	return r.Reconcile(ctx, ctrl.Request{NamespacedName: ObjectKey(k)})
+}
+
+func TestReconcileDriftCheckRequeue(t *testing.T) {
+	tests := []struct {
+		name       string
+		driftCheck *metav1.Duration
+		want       time.Duration
+	}{
+		{name: "no drift check interval", want: time.Hour},
+		{name: "shorter drift check interval", driftCheck: &metav1.Duration{Duration: 5 * time.Minute}, want: 5 * time.Minute},
+		{name: "drift check interval equal to the interval", driftCheck: &metav1.Duration{Duration: time.Hour}, want: time.Hour},
+		{name: "longer drift check interval", driftCheck: &metav1.Duration{Duration: 2 * time.Hour}, want: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newTestKustomization()
+			k.Spec.DriftCheckInterval = tt.driftCheck
+			r := newTestReconciler(t, newTestRepository("main/1a2b3c"))
+			setUpToDate(t, r, k, "main/1a2b3c")
+			if err := r.Create(context.TODO(), k); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := k.GetDriftCheckInterval(); got != tt.want {
+				t.Errorf("GetDriftCheckInterval() = %s, want %s", got, tt.want)
+			}
+
+			result, err := reconcileRequest(t, r, k)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.RequeueAfter != tt.want {
+				t.Errorf("expected a requeue after %s, got %s", tt.want, result.RequeueAfter)
+			}
+		})
+	}
+}
+
+func TestReconcileDiffOnly(t *testing.T) {
+	artifact := func(value string) string {
+		return serveArtifact(t, map[string]string{
+			"kustomization.yaml": "resources:\n- configmap.yaml\n",
+			"configmap.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: backend\n  namespace: apps\n" +
+				"data:\n  key: " + value + "\n",
+		})
+	}
+
+	stale := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "apps",
+		Name:      "stale",
+		Labels:    selectorLabels("apps", "flux-system"),
+	}}
+	repository := newTestRepository("main/1a2b3c")
+	repository.Status.Artifact.URL = artifact("v1")
+	k := newTestKustomization()
+	k.Spec.Mode = kustomizev1.DiffOnlyMode
+	k.Spec.Prune = true
+	k.Spec.Validation = "none"
+	k.Status.Inventory = &kustomizev1.ResourceInventory{Entries: []kustomizev1.ResourceRef{
+		{ID: "apps_stale__ConfigMap", Version: "v1"},
+	}}
+	r := newTestReconciler(t, repository, k, stale)
+	recorder := r.EventRecorder.(*record.FakeRecorder)
+
+	reconcileDiffOnly := func() (kustomizev1.Kustomization, []string) {
+		t.Helper()
+		if _, err := reconcileRequest(t, r, k); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var reconciled kustomizev1.Kustomization
+		if err := r.Get(context.TODO(), ObjectKey(k), &reconciled); err != nil {
+			t.Fatal(err)
+		}
+		var reported []string
+		for len(recorder.Events) > 0 {
+			if event := <-recorder.Events; strings.Contains(event, "diff-only mode") {
+				reported = append(reported, event)
+			}
+		}
+		return reconciled, reported
+	}
+
+	reconciled, reported := reconcileDiffOnly()
+	if reconciled.Status.LastPreview == nil || reconciled.Status.LastPreview.Revision != "main/1a2b3c" {
+		t.Fatalf("expected the preview of the revision, got %v", reconciled.Status.LastPreview)
+	}
+	if len(reported) != 1 {
+		t.Errorf("expected the changes to be reported, got %v", reported)
+	}
+	if reconciled.Status.StateChecksum != "" || reconciled.Status.LastAppliedRevision != "" {
+		t.Errorf("expected no state checksum nor applied revision, got '%s' and '%s'",
+			reconciled.Status.StateChecksum, reconciled.Status.LastAppliedRevision)
+	}
+	if err := r.Get(context.TODO(), client.ObjectKey{Namespace: "apps", Name: "backend"}, &corev1.ConfigMap{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the ConfigMap not to be applied, got error %v", err)
+	}
+	if err := r.Get(context.TODO(), client.ObjectKeyFromObject(stale), &corev1.ConfigMap{}); err != nil {
+		t.Errorf("expected the stale ConfigMap not to be pruned, got error %v", err)
+	}
+
+	// the same changes are not reported again
+	if _, reported := reconcileDiffOnly(); len(reported) != 0 {
+		t.Errorf("expected the unchanged preview not to be reported, got %v", reported)
+	}
+
+	// the changes of a new revision are reported
+	repository.Status.Artifact.Revision = "main/4d5e6f"
+	repository.Status.Artifact.URL = artifact("v2")
+	if err := r.Status().Update(context.TODO(), repository); err != nil {
+		t.Fatal(err)
+	}
+	reconciled, reported = reconcileDiffOnly()
+	if reconciled.Status.LastPreview == nil || reconciled.Status.LastPreview.Revision != "main/4d5e6f" {
+		t.Fatalf("expected the preview of the new revision, got %v", reconciled.Status.LastPreview)
+	}
+	if len(reported) != 1 {
+		t.Errorf("expected the new changes to be reported, got %v", reported)
+	}
+	if reconciled.Status.StateChecksum != "" {
+		t.Errorf("expected no state checksum, got '%s'", reconciled.Status.StateChecksum)
+	}
+}
+
+func TestReconcileReadOnly(t *testing.T) {
+	stale := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "apps",
+		Name:      "stale",
+		Labels:    selectorLabels("apps", "flux-system"),
+	}}
+	repository := newTestRepository("main/1a2b3c")
+	repository.Status.Artifact.URL = serveArtifact(t, map[string]string{
+		"kustomization.yaml": "resources:\n- configmap.yaml\n",
+		"configmap.yaml":     "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: backend\n  namespace: apps\n",
+	})
+	k := newTestKustomization()
+	k.Spec.Prune = true
+	k.Spec.Validation = "none"
+	k.Status.Inventory = &kustomizev1.ResourceInventory{Entries: []kustomizev1.ResourceRef{
+		{ID: "apps_stale__ConfigMap", Version: "v1"},
+	}}
+	r := newTestReconciler(t, repository, k, stale)
+	r.readOnly = true
+
+	result, err := reconcileRequest(t, r, k)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.RequeueAfter != k.Spec.Interval.Duration {
+		t.Errorf("expected a requeue at the interval, got %s", result.RequeueAfter)
+	}
+
+	var reconciled kustomizev1.Kustomization
+	if err := r.Get(context.TODO(), ObjectKey(k), &reconciled); err != nil {
+		t.Fatal(err)
+	}
+	ready := apimeta.FindStatusCondition(reconciled.Status.Conditions, meta.ReadyCondition)
+	if ready == nil || ready.Status != metav1.ConditionUnknown || ready.Reason != kustomizev1.ReadOnlyReason {
+		t.Errorf("expected the read-only readiness, got %v", ready)
+	}
+	if reconciled.Status.LastPreview == nil {
+		t.Error("expected the changes to be previewed")
+	}
+	if err := r.Get(context.TODO(), client.ObjectKey{Namespace: "apps", Name: "backend"}, &corev1.ConfigMap{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the ConfigMap not to be applied, got error %v", err)
+	}
+	if err := r.Get(context.TODO(), client.ObjectKeyFromObject(stale), &corev1.ConfigMap{}); err != nil {
+		t.Errorf("expected the stale ConfigMap not to be pruned, got error %v", err)
+	}
+}
+
+func TestReconcileDeleteReadOnly(t *testing.T) {
+	managed := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "apps",
+		Name:      "backend",
+		Labels:    selectorLabels("apps", "flux-system"),
+	}}
+	now := metav1.Now()
+	k := newTestKustomization()
+	k.DeletionTimestamp = &now
+	k.Spec.DeletionPolicy = kustomizev1.DeletionPolicyDelete
+	k.Spec.RetryInterval = &metav1.Duration{Duration: time.Minute}
+	k.Status.Inventory = &kustomizev1.ResourceInventory{Entries: []kustomizev1.ResourceRef{
+		{ID: "apps_backend__ConfigMap", Version: "v1"},
+	}}
+	r := newTestReconciler(t, k, managed)
+	r.readOnly = true
+
+	result, err := reconcileRequest(t, r, k)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.RequeueAfter != time.Minute {
+		t.Errorf("expected the garbage collection to be retried at the retry interval, got %s", result.RequeueAfter)
+	}
+	if err := r.Get(context.TODO(), client.ObjectKeyFromObject(managed), &corev1.ConfigMap{}); err != nil {
+		t.Errorf("expected the garbage collection to be deferred, got error %v", err)
+	}
+	var deferred kustomizev1.Kustomization
+	if err := r.Get(context.TODO(), ObjectKey(k), &deferred); err != nil {
+		t.Fatalf("expected the finalizer to be kept, got error %v", err)
+	}
+	if len(deferred.GetFinalizers()) != 1 {
+		t.Errorf("expected the finalizer to be kept, got %v", deferred.GetFinalizers())
+	}
+}
+
+func TestReconcileClustersReadOnly(t *testing.T) {
+	k := newTestKustomization()
+	k.Spec.Clusters = []kustomizev1.ClusterTarget{{SecretRef: &meta.LocalObjectReference{Name: "missing"}}}
+	k.Status.StateChecksum = "checksum"
+	r := newTestReconciler(t)
+	r.readOnly = true
+
+	ctx := logr.NewContext(context.TODO(), logr.Discard())
+	reconciled, err := r.reconcileClusters(ctx, *k, "main/1a2b3c", t.TempDir())
+	if err != nil {
+		t.Fatalf("expected the clusters not to be listed, got error %v", err)
+	}
+	ready := apimeta.FindStatusCondition(reconciled.Status.Conditions, meta.ReadyCondition)
+	if ready == nil || ready.Status != metav1.ConditionUnknown || ready.Reason != kustomizev1.ReadOnlyReason {
+		t.Errorf("expected the read-only readiness, got %v", ready)
+	}
+	if reconciled.Status.StateChecksum != "" {
+		t.Errorf("expected no state checksum, got '%s'", reconciled.Status.StateChecksum)
+	}
+	if len(reconciled.Status.Clusters) != 0 {
+		t.Errorf("expected no cluster statuses, got %v", reconciled.Status.Clusters)
+	}
+}
+
+func TestReconcilePendingRevision(t *testing.T) {
+	repository := newTestRepository("main/1a2b3c")
+	k := newTestKustomization()
+	r := newTestReconciler(t, repository, k)
+
+	// the source publishes a new revision while the artifact is downloaded
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var current sourcev1.GitRepository
+		if err := r.Get(context.TODO(), client.ObjectKeyFromObject(repository), &current); err != nil {
+			t.Error(err)
+		}
+		current.Status.Artifact.Revision = "main/4d5e6f"
+		if err := r.Status().Update(context.TODO(), &current); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	repository.Status.Artifact.URL = server.URL + "/artifact.tar.gz"
+	if err := r.Status().Update(context.TODO(), repository); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := r.pendingRevision(context.TODO(), *k, "main/1a2b3c"); got != "" {
+		t.Errorf("expected no pending revision, got '%s'", got)
+	}
+
+	result, err := reconcileRequest(t, r, k)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Requeue || result.RequeueAfter != 0 {
+		t.Errorf("expected an immediate requeue, got %+v", result)
+	}
+
+	var reconciled kustomizev1.Kustomization
+	if err := r.Get(context.TODO(), ObjectKey(k), &reconciled); err != nil {
+		t.Fatal(err)
+	}
+	if reconciled.Status.PendingRevision != "main/4d5e6f" {
+		t.Errorf("expected the pending revision to be recorded, got '%s'", reconciled.Status.PendingRevision)
+	}
+	if reconciled.Status.LastAttemptedRevision != "main/1a2b3c" {
+		t.Errorf("expected the attempted revision to be recorded, got '%s'", reconciled.Status.LastAttemptedRevision)
+	}
+}
+
+func TestReconcileArtifactNotFound(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	repository := newTestRepository("main/1a2b3c")
+	repository.Status.Artifact.URL = server.URL + "/artifact.tar.gz"
+	k := newTestKustomization()
+	k.Spec.RetryInterval = &metav1.Duration{Duration: time.Minute}
+	r := newTestReconciler(t, repository, k)
+
+	result, err := reconcileRequest(t, r, k)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.RequeueAfter != k.Spec.Interval.Duration {
+		t.Errorf("expected a requeue after %s, got %s", k.Spec.Interval.Duration, result.RequeueAfter)
+	}
+
+	if err := r.Get(context.TODO(), ObjectKey(k), k); err != nil {
+		t.Fatal(err)
+	}
+	if !apimeta.IsStatusConditionTrue(k.Status.Conditions, meta.StalledCondition) {
+		t.Errorf("expected the Kustomization to be stalled, got %v", k.Status.Conditions)
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/cli-utils/pkg/kstatus/polling"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// KubeConfigAuthError is returned when the remote cluster rejects the
+// credentials of the KubeConfig secret, usually because a short-lived
+// token expired and the secret wasn't refreshed.
+type KubeConfigAuthError struct {
+	Secret string
+	Err    error
+}
+
+func (e *KubeConfigAuthError) Error() string {
+	return fmt.Sprintf("the remote cluster rejected the credentials of KubeConfig secret '%s', they may have expired: %v",
+		e.Secret, e.Err)
+}
+
+func (e *KubeConfigAuthError) Unwrap() error {
+	return e.Err
+}
+
+// checkRemoteAuth returns the error of the remote cluster if it rejects the
+// credentials of the client. The SelfSubjectAccessReviews are allowed to all
+// the authenticated users, the other errors are left to the reconciliation.
+func checkRemoteAuth(ctx context.Context, kubeClient client.Client) error {
+	review := &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "get", Resource: "namespaces"},
+		},
+	}
+	if err := kubeClient.Create(ctx, review); apierrors.IsUnauthorized(err) {
+		return err
+	}
+	return nil
+}
+
+// remoteClient is a client of a remote cluster built from a KubeConfig secret.
+type remoteClient struct {
+	resourceVersion string
+	client          client.Client
+	statusPoller    *polling.StatusPoller
+}
+
+// remoteClients caches the clients of the remote clusters by KubeConfig secret
+// and impersonated identity, saving the API discovery of the remote cluster at
+// every reconciliation. A client is rebuilt when the resource version of the
+// secret changes, or after the remote cluster rejected its credentials.
+// The clients are evicted when their secret changes or is deleted, and when
+// their Kustomization is deleted. The methods of a nil cache are no-ops.
+type remoteClients struct {
+	mu      sync.Mutex
+	clients map[string]remoteClient
+}
+
+func newRemoteClients() *remoteClients {
+	return &remoteClients{clients: make(map[string]remoteClient)}
+}
+
+// get returns the client cached for the key, if it was built
+// from the given resource version of the secret.
+func (c *remoteClients) get(key, resourceVersion string) (remoteClient, bool) {
+	if c == nil {
+		return remoteClient{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rc, ok := c.clients[key]
+	if !ok || rc.resourceVersion != resourceVersion {
+		return remoteClient{}, false
+	}
+	return rc, true
+}
+
+func (c *remoteClients) set(key string, rc remoteClient) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clients[key] = rc
+}
+
+func (c *remoteClients) evict(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.clients, key)
+}
+
+// evictSecret removes the clients of the KubeConfig secret, for all the
+// impersonated identities, and returns their keys.
+func (c *remoteClients) evictSecret(namespace, name string) []string {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	secret := fmt.Sprintf("%s/%s", namespace, name)
+	var keys []string
+	for key := range c.clients {
+		if key == secret || strings.HasPrefix(key, secret+"/") {
+			keys = append(keys, key)
+			delete(c.clients, key)
+		}
+	}
+	return keys
+}
+
+// evictRemoteClients removes the cached clients of the remote clusters the
+// Kustomization was reconciled on, with spec.kubeConfig or spec.clusters.
+func (r *KustomizationReconciler) evictRemoteClients(kustomization kustomizev1.Kustomization) {
+	targets := []kustomizev1.Kustomization{kustomization}
+	for _, status := range kustomization.Status.Clusters {
+		targets = append(targets, clusterKustomization(kustomization, clusterTarget{name: status.Name}, nil))
+	}
+	for _, k := range targets {
+		NewKustomizeImpersonation(k, r.Client, r.StatusPoller, r.discoveryOptions, r.kubeConfigOptions).evictClient()
+	}
+}
+
+// unauthorizedEviction evicts the cached client when the
+// remote cluster rejects the credentials of its requests.
+type unauthorizedEviction struct {
+	rt    http.RoundTripper
+	evict func()
+}
+
+func (t *unauthorizedEviction) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.rt.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		t.evict()
+	}
+	return resp, err
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+	"github.com/fluxcd/kustomize-controller/internal/discovery"
+)
+
+// remoteCluster serves the API discovery and the SelfSubjectAccessReviews,
+// rejecting the requests if unauthorized is set.
+type remoteCluster struct {
+	mu           sync.Mutex
+	discoveries  int
+	reviews      int
+	unauthorized bool
+}
+
+func (c *remoteCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.unauthorized {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Unauthorized","code":401}`))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case "/api":
+		c.discoveries++
+		w.Write([]byte(`{"kind":"APIVersions","versions":["v1"]}`))
+	case "/apis":
+		w.Write([]byte(`{"kind":"APIGroupList","groups":[{"name":"authorization.k8s.io",` +
+			`"versions":[{"groupVersion":"authorization.k8s.io/v1","version":"v1"}],` +
+			`"preferredVersion":{"groupVersion":"authorization.k8s.io/v1","version":"v1"}}]}`))
+	case "/api/v1":
+		w.Write([]byte(`{"kind":"APIResourceList","groupVersion":"v1","resources":[]}`))
+	case "/apis/authorization.k8s.io/v1":
+		w.Write([]byte(`{"kind":"APIResourceList","groupVersion":"authorization.k8s.io/v1","resources":[` +
+			`{"name":"selfsubjectaccessreviews","namespaced":false,"kind":"SelfSubjectAccessReview","verbs":["create"]}]}`))
+	case "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews":
+		c.reviews++
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"kind":"SelfSubjectAccessReview","apiVersion":"authorization.k8s.io/v1","status":{"allowed":true}}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestClientForKubeConfigCache(t *testing.T) {
+	cluster := &remoteCluster{}
+	server := httptest.NewServer(cluster)
+	defer server.Close()
+
+	kubeConfig := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: prod
+  cluster:
+    server: %s
+contexts:
+- name: prod
+  context:
+    cluster: prod
+    user: prod
+current-context: prod
+users:
+- name: prod
+  user:
+    token: short-lived
+`, server.URL)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "prod", Namespace: "default"},
+		Data:       map[string][]byte{"value": []byte(kubeConfig)},
+	}
+	kubeClient := fake.NewClientBuilder().WithObjects(secret).Build()
+	k := kustomizev1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: kustomizev1.KustomizationSpec{
+			KubeConfig: &kustomizev1.KubeConfig{SecretRef: meta.LocalObjectReference{Name: "prod"}},
+		},
+	}
+	opts := KubeConfigOptions{clients: newRemoteClients()}
+	var remoteClient client.Client
+	getClient := func() error {
+		imp := NewKustomizeImpersonation(k, kubeClient, nil, discovery.Options{}, opts)
+		c, _, err := imp.GetClient(context.TODO())
+		remoteClient = c
+		return err
+	}
+	discoveries := func() int {
+		cluster.mu.Lock()
+		defer cluster.mu.Unlock()
+		return cluster.discoveries
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := getClient(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := discoveries(); n != 1 {
+		t.Errorf("expected the client to be reused, got %d discoveries", n)
+	}
+	cluster.mu.Lock()
+	reviews := cluster.reviews
+	cluster.mu.Unlock()
+	if reviews != 1 {
+		t.Errorf("expected the credentials to be checked once, got %d reviews", reviews)
+	}
+
+	// a refreshed token updates the resource version of the secret
+	secret.Data["value.yaml"] = []byte("refreshed")
+	if err := kubeClient.Update(context.TODO(), secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := getClient(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := discoveries(); n != 2 {
+		t.Errorf("expected the client to be rebuilt, got %d discoveries", n)
+	}
+
+	// the client is evicted when the remote cluster rejects its credentials
+	cluster.mu.Lock()
+	cluster.unauthorized = true
+	cluster.mu.Unlock()
+	review := &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "get", Resource: "namespaces"},
+		},
+	}
+	if err := remoteClient.Create(context.TODO(), review); !apierrors.IsUnauthorized(err) {
+		t.Fatalf("expected the request to be rejected, got %v", err)
+	}
+	if _, ok := opts.clients.get("default/prod", secret.GetResourceVersion()); ok {
+		t.Error("expected the client to be evicted")
+	}
+
+	err := getClient()
+	var authErr *KubeConfigAuthError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("expected KubeConfigAuthError, got %v", err)
+	}
+	if authErr.Secret != "default/prod" {
+		t.Errorf("expected the secret to be reported, got %q", authErr.Secret)
+	}
+	if _, ok := opts.clients.get("default/prod", secret.GetResourceVersion()); ok {
+		t.Error("expected the rejected client not to be cached")
+	}
+}
+
+func TestRemoteClientsEviction(t *testing.T) {
+	clients := newRemoteClients()
+	for _, key := range []string{"default/prod", "default/prod/admin/", "default/production", "other/prod"} {
+		clients.set(key, remoteClient{resourceVersion: "1"})
+	}
+
+	r := newTestReconciler(t)
+	r.kubeConfigOptions = KubeConfigOptions{clients: clients}
+	r.requestsForKubeConfigChange(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "prod", Namespace: "default"}})
+	for key, evicted := range map[string]bool{
+		"default/prod":        true,
+		"default/prod/admin/": true,
+		"default/production":  false,
+		"other/prod":          false,
+	} {
+		if _, ok := clients.get(key, "1"); ok == evicted {
+			t.Errorf("expected the eviction of %s to be %v", key, evicted)
+		}
+	}
+
+	// the clients of the deleted Kustomizations are evicted
+	k := kustomizev1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: kustomizev1.KustomizationSpec{
+			Clusters: []kustomizev1.ClusterTarget{{SecretRef: &meta.LocalObjectReference{Name: "production"}}},
+		},
+		Status: kustomizev1.KustomizationStatus{
+			Clusters: []kustomizev1.ClusterStatus{{Name: "production"}},
+		},
+	}
+	r.evictRemoteClients(k)
+	if _, ok := clients.get("default/production", "1"); ok {
+		t.Error("expected the client of the cluster to be evicted")
+	}
+	if _, ok := clients.get("other/prod", "1"); !ok {
+		t.Error("expected the client of the other namespace to be kept")
+	}
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/kustomize/api/filesys"
+	"sigs.k8s.io/kustomize/api/resmap"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// buildResources runs kustomize build on the generated kustomization.yaml
+// of dirPath, then decrypts the resources and runs the variable substitutions.
+// The Secrets and ConfigMaps referenced by the Kustomization are read with the given client.
+func buildResources(ctx context.Context, kubeClient client.Client, kustomization kustomizev1.Kustomization, dirPath string) (resmap.ResMap, error) {
+	dec, cleanup, err := NewTempDecryptor(kubeClient, kustomization)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	// import OpenPGP keys if any
+	if err := dec.ImportKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	fs := filesys.MakeFsOnDisk()
+	m, err := buildKustomization(ctx, fs, dirPath)
+	if err != nil {
+		return nil, fmt.Errorf("kustomize build failed: %w", err)
+	}
+
+	// check if resources are encrypted and decrypt them before generating the final YAML
+	if kustomization.Spec.Decryption != nil {
+		decryptCtx, span := startSpan(ctx, "decrypt")
+		err := decryptResources(decryptCtx, dec, m)
+		endSpan(span, err)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, res := range m.Resources() {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("kustomize build interrupted: %w", err)
+		}
+
+		// run variable substitutions
+		if kustomization.Spec.PostBuild != nil {
+			outRes, err := substituteVariables(ctx, kubeClient, kustomization, res)
+			if err != nil {
+				return nil, fmt.Errorf("var substitution failed for '%s': %w", res.GetName(), err)
+			}
+
+			if outRes != nil {
+				_, err = m.Replace(res)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return m, nil
+}
+
+// decryptResources replaces the encrypted resources of m with their decrypted content.
+func decryptResources(ctx context.Context, dec *KustomizeDecryptor, m resmap.ResMap) error {
+	for _, res := range m.Resources() {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("kustomize build interrupted: %w", err)
+		}
+
+		outRes, err := dec.Decrypt(res)
+		if err != nil {
+			return fmt.Errorf("decryption failed for '%s': %w", res.GetName(), err)
+		}
+
+		if outRes != nil {
+			if _, err := m.Replace(res); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Render returns the multi-doc YAML of the objects the controller would apply
+// for the Kustomization, given an artifact extracted at root. The spec path,
+// patches, images, target namespace, decryption and variable substitutions
+// are applied as in a reconciliation, the hook Jobs are included in the output.
+// The Secrets and ConfigMaps referenced by the Kustomization are read with the
+// given client. The root directory is copied before the kustomization.yaml is
+// generated, so that the fixtures are left unchanged.
+func Render(ctx context.Context, kubeClient client.Client, kustomization kustomizev1.Kustomization, root string) ([]byte, error) {
+	tmpDir, err := ioutil.TempDir("", kustomization.GetName())
+	if err != nil {
+		return nil, fmt.Errorf("tmp dir error: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := copyDir(root, tmpDir); err != nil {
+		return nil, fmt.Errorf("unable to copy %s: %w", root, err)
+	}
+
+	dirPath, err := artifactPath(tmpDir, kustomization.Spec.Path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(dirPath); err != nil {
+		return nil, fmt.Errorf("kustomization path not found: %w", err)
+	}
+
+	if _, err := NewGenerator(kustomization, kubeClient).WriteFile(ctx, dirPath); err != nil {
+		return nil, err
+	}
+
+	m, err := buildResources(ctx, kubeClient, kustomization, dirPath)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := m.AsYaml()
+	if err != nil {
+		return nil, fmt.Errorf("kustomize build failed: %w", err)
+	}
+	return resources, nil
+}
+
+// copyDir copies the directories, regular files and symlinks of src into dst.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			return os.MkdirAll(target, mode.Perm()|0700)
+		case mode&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case mode.IsRegular():
+			in, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0600)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, in); err != nil {
+				out.Close()
+				return err
+			}
+			return out.Close()
+		default:
+			return nil
+		}
+	})
+}
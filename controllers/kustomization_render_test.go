@@ -0,0 +1,79 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestRender(t *testing.T) {
+	root, err := ioutil.TempDir("", "render")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	if err := os.MkdirAll(filepath.Join(root, "apps"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	manifest := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: backend\ndata:\n  env: ${env}\n"
+	if err := ioutil.WriteFile(filepath.Join(root, "apps", "configmap.yaml"), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	kustomization := kustomizev1.Kustomization{}
+	kustomization.SetName("backend")
+	kustomization.SetNamespace("flux-system")
+	kustomization.Spec.Path = "./apps"
+	kustomization.Spec.TargetNamespace = "apps"
+	kustomization.Spec.PostBuild = &kustomizev1.PostBuild{Substitute: map[string]string{"env": "dev"}}
+
+	out, err := Render(context.TODO(), fake.NewClientBuilder().Build(), kustomization, root)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{"namespace: apps", "env: dev", "kustomize.toolkit.fluxcd.io/name: backend"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("expected %q in the rendered output, got:\n%s", want, out)
+		}
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(root, "apps"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected the fixture directory to be left unchanged, got %d files", len(files))
+	}
+
+	kustomization.Spec.Path = "../apps"
+	_, err = Render(context.TODO(), fake.NewClientBuilder().Build(), kustomization, root)
+	var invalidPath *InvalidPathError
+	if !errors.As(err, &invalidPath) {
+		t.Errorf("expected an invalid path error, got %v", err)
+	}
+}
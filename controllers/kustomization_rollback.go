@@ -0,0 +1,257 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+const (
+	artifactCacheRevisionFile = "revision"
+	artifactCacheURLFile      = "url"
+	artifactCacheArchiveFile  = "artifact"
+)
+
+// RolledBackError is returned when the health checks of a new revision failed
+// and the last healthy revision was applied again. Retrying the reconciliation
+// is pointless until the source publishes a new revision or the spec changes.
+type RolledBackError struct {
+	Revision string
+	Err      error
+}
+
+func (e *RolledBackError) Error() string {
+	return fmt.Sprintf("%s, rolled back to revision %s", e.Err, e.Revision)
+}
+
+func (e *RolledBackError) Unwrap() error {
+	return e.Err
+}
+
+// artifactCache keeps on disk the artifact of the last healthy revision
+// of the Kustomizations with rollback enabled. The artifact of each Kustomization
+// is stored as downloaded, in a directory named after its UID. The build output
+// is not cached, as it may contain decrypted secrets, the artifact is built
+// again on rollback. The cache is disabled when the directory is not set.
+type artifactCache struct {
+	dir string
+}
+
+func (c artifactCache) path(kustomization kustomizev1.Kustomization) string {
+	return filepath.Join(c.dir, string(kustomization.GetUID()))
+}
+
+// store replaces the cached artifact of the Kustomization with the one
+// of the given revision, downloaded from artifactURL to archivePath.
+func (c artifactCache) store(kustomization kustomizev1.Kustomization, revision, artifactURL, archivePath string) error {
+	if c.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return err
+	}
+	tmpDir, err := ioutil.TempDir(c.dir, "store-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := copyFile(archivePath, filepath.Join(tmpDir, artifactCacheArchiveFile)); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, artifactCacheURLFile), []byte(artifactURL), 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, artifactCacheRevisionFile), []byte(revision), 0600); err != nil {
+		return err
+	}
+
+	// swap the directories so that a partial write never replaces a valid entry
+	if err := os.RemoveAll(c.path(kustomization)); err != nil {
+		return err
+	}
+	return os.Rename(tmpDir, c.path(kustomization))
+}
+
+// load extracts the cached artifact of the Kustomization into dir and returns
+// its revision, or an empty revision if nothing is cached.
+func (c artifactCache) load(kustomization kustomizev1.Kustomization, dir string) (string, error) {
+	if c.dir == "" {
+		return "", nil
+	}
+	entryPath := c.path(kustomization)
+	revision, err := ioutil.ReadFile(filepath.Join(entryPath, artifactCacheRevisionFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	artifactURL, err := ioutil.ReadFile(filepath.Join(entryPath, artifactCacheURLFile))
+	if err != nil {
+		return "", err
+	}
+	archive, err := os.Open(filepath.Join(entryPath, artifactCacheArchiveFile))
+	if err != nil {
+		return "", err
+	}
+	defer archive.Close()
+	if err := extractArtifact(archive, string(artifactURL), dir); err != nil {
+		return "", fmt.Errorf("failed to extract the cached artifact: %w", err)
+	}
+	return strings.TrimSpace(string(revision)), nil
+}
+
+// remove deletes the cached artifact of the Kustomization, if any.
+func (c artifactCache) remove(kustomization kustomizev1.Kustomization) error {
+	if c.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(c.path(kustomization))
+}
+
+// prune deletes the cached artifacts of the Kustomizations that no longer exist,
+// e.g. when they were deleted while the controller was not running.
+func (c artifactCache) prune(ctx context.Context, reader client.Reader) error {
+	if c.dir == "" {
+		return nil
+	}
+	entries, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var list kustomizev1.KustomizationList
+	if err := reader.List(ctx, &list); err != nil {
+		return err
+	}
+	uids := make(map[string]bool, len(list.Items))
+	for _, k := range list.Items {
+		uids[string(k.GetUID())] = true
+	}
+	for _, entry := range entries {
+		if !uids[entry.Name()] {
+			if err := os.RemoveAll(filepath.Join(c.dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copyFile copies the file at src to dst, readable only by the controller.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// rollback builds and applies the cached artifact of the last applied revision, after
+// the health checks of the given revision failed, and prunes the objects added
+// by the failed revision. The RolledBack condition records the outcome. It returns
+// a RolledBackError wrapping the health check error if the rollback succeeded,
+// and the health check error otherwise.
+func (r *KustomizationReconciler) rollback(ctx context.Context, kubeClient client.Client, kustomization kustomizev1.Kustomization,
+	revision string, healthErr error) (kustomizev1.Kustomization, error) {
+	log := logr.FromContext(ctx)
+	lastRevision := kustomization.Status.LastAppliedRevision
+
+	fail := func(msg string) (kustomizev1.Kustomization, error) {
+		log.Info(msg)
+		kustomizev1.SetKustomizationCondition(&kustomization, kustomizev1.RolledBackCondition, metav1.ConditionFalse,
+			kustomizev1.RollbackFailedReason, msg)
+		return kustomization, healthErr
+	}
+
+	tmpDir, err := ioutil.TempDir("", kustomization.GetName())
+	if err != nil {
+		return fail(fmt.Sprintf("rollback to revision %s failed: %s", lastRevision, err))
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cachedRevision, err := r.artifactCache.load(kustomization, tmpDir)
+	if err != nil {
+		return fail(fmt.Sprintf("rollback to revision %s failed: %s", lastRevision, err))
+	}
+	if cachedRevision == "" || cachedRevision != lastRevision {
+		return fail(fmt.Sprintf("rollback to revision %s not possible, the artifact is not in the artifact cache", lastRevision))
+	}
+
+	// build the cached artifact again, with the current spec
+	dirPath, err := artifactPath(tmpDir, kustomization.Spec.Path)
+	if err != nil {
+		return fail(fmt.Sprintf("rollback to revision %s failed: %s", lastRevision, err))
+	}
+	checksum, err := r.generate(ctx, kubeClient, kustomization, dirPath)
+	if err != nil {
+		return fail(fmt.Sprintf("rollback to revision %s failed: %s", lastRevision, err))
+	}
+	if _, err := r.build(ctx, kustomization, checksum, dirPath); err != nil {
+		return fail(fmt.Sprintf("rollback to revision %s failed: %s", lastRevision, err))
+	}
+
+	results, err := r.apply(ctx, kubeClient, kustomization, dirPath, "", time.Time{})
+	if err != nil {
+		return fail(fmt.Sprintf("rollback to revision %s failed: %s", lastRevision, err))
+	}
+	inventory, err := readInventory(kubeClient, kustomization, dirPath)
+	if err != nil {
+		return fail(fmt.Sprintf("rollback to revision %s failed: %s", lastRevision, err))
+	}
+	setInventoryChecksums(inventory, results)
+
+	// delete the objects added by the failed revision
+	if kustomization.Status.Inventory != nil {
+		if _, err := r.prune(ctx, kubeClient, kustomization, inventory, lastRevision); err != nil {
+			return fail(fmt.Sprintf("rollback to revision %s failed: %s", lastRevision, err))
+		}
+	}
+	kustomization.Status.Inventory = inventory
+	kustomization.Status.CustomResourceDefinitions = inventoryCRDs(inventory)
+
+	msg := fmt.Sprintf("Rolled back to revision %s after the health checks of revision %s failed", lastRevision, revision)
+	log.Info(msg)
+	kustomizev1.SetKustomizationCondition(&kustomization, kustomizev1.RolledBackCondition, metav1.ConditionTrue,
+		kustomizev1.RollbackSucceededReason, msg)
+	return kustomization, &RolledBackError{Revision: lastRevision, Err: healthErr}
+}
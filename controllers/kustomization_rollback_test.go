@@ -0,0 +1,160 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestArtifactCache(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "artifact-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	kustomization := kustomizev1.Kustomization{}
+	kustomization.SetUID("test")
+
+	artifactURL := serveArtifact(t, map[string]string{
+		"apps/configmap.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: backend\n  namespace: apps\n",
+	})
+	archive, err := os.Create(filepath.Join(tmpDir, "artifact.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	r := newTestReconciler(t)
+	if err := r.download(context.TODO(), artifactURL, filepath.Join(tmpDir, "build"), archive); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cache := artifactCache{dir: filepath.Join(tmpDir, "cache")}
+	if revision, err := cache.load(kustomization, filepath.Join(tmpDir, "empty")); err != nil || revision != "" {
+		t.Fatalf("expected an empty cache, got %q %v", revision, err)
+	}
+
+	for _, revision := range []string{"main/1", "main/2"} {
+		if err := cache.store(kustomization, revision, artifactURL, archive.Name()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	dirPath := filepath.Join(tmpDir, "restored")
+	revision, err := cache.load(kustomization, dirPath)
+	if err != nil || revision != "main/2" {
+		t.Fatalf("expected the last stored revision, got %q %v", revision, err)
+	}
+	if _, err := os.Stat(filepath.Join(dirPath, "apps", "configmap.yaml")); err != nil {
+		t.Errorf("expected the cached artifact to be extracted, got %v", err)
+	}
+
+	// only the artifact is cached, never the build output
+	files, err := ioutil.ReadDir(cache.path(kustomization))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	if strings.Join(names, ",") != "artifact,revision,url" {
+		t.Errorf("expected only the artifact to be cached, got %v", names)
+	}
+
+	if err := cache.remove(kustomization); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if revision, _ := cache.load(kustomization, filepath.Join(tmpDir, "removed")); revision != "" {
+		t.Errorf("expected the cache entry to be removed, got %q", revision)
+	}
+}
+
+func TestArtifactCachePrune(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "artifact-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	archivePath := filepath.Join(tmpDir, "artifact.tar.gz")
+	if err := ioutil.WriteFile(archivePath, []byte("artifact"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	existing := newTestKustomization()
+	existing.SetUID("existing")
+	deleted := newTestKustomization()
+	deleted.SetUID("deleted")
+
+	cache := artifactCache{dir: filepath.Join(tmpDir, "cache")}
+	for _, k := range []*kustomizev1.Kustomization{existing, deleted} {
+		if err := cache.store(*k, "main/1", "http://source/artifact.tar.gz", archivePath); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	r := newTestReconciler(t, existing)
+	if err := cache.prune(context.TODO(), r.Client); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(cache.path(*existing)); err != nil {
+		t.Errorf("expected the entry of the existing Kustomization to be kept, got %v", err)
+	}
+	if _, err := os.Stat(cache.path(*deleted)); !os.IsNotExist(err) {
+		t.Errorf("expected the entry of the deleted Kustomization to be removed, got %v", err)
+	}
+}
+
+func TestRollbackNotCached(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "artifact-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	r := &KustomizationReconciler{artifactCache: artifactCache{dir: tmpDir}}
+	kustomization := kustomizev1.Kustomization{}
+	kustomization.SetUID("test")
+	kustomization.Status.LastAppliedRevision = "main/1"
+
+	healthErr := errors.New("Health check failed")
+	kustomization, err = r.rollback(logr.NewContext(context.TODO(), logr.Discard()), nil, kustomization, "main/2", healthErr)
+	if err != healthErr {
+		t.Errorf("expected the health check error, got %v", err)
+	}
+	cond := apimeta.FindStatusCondition(kustomization.Status.Conditions, kustomizev1.RolledBackCondition)
+	if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != kustomizev1.RollbackFailedReason {
+		t.Errorf("expected the RolledBack condition to record the failure, got %v", cond)
+	}
+
+	rolledBack := &RolledBackError{Revision: "main/1", Err: healthErr}
+	if !errors.Is(rolledBack, healthErr) {
+		t.Error("expected the rollback error to wrap the health check error")
+	}
+}
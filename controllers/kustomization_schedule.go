@@ -0,0 +1,84 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// applyWindows are the parsed time windows of a Kustomization schedule.
+type applyWindows struct {
+	location  *time.Location
+	schedules []cron.Schedule
+	durations []time.Duration
+}
+
+// parseSchedule returns the time windows of the schedule,
+// or an error if a cron expression or the time zone is invalid.
+func parseSchedule(schedule kustomizev1.Schedule) (*applyWindows, error) {
+	location := time.UTC
+	if schedule.TimeZone != "" {
+		loc, err := time.LoadLocation(schedule.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule time zone '%s': %w", schedule.TimeZone, err)
+		}
+		location = loc
+	}
+
+	w := &applyWindows{location: location}
+	for _, window := range schedule.Windows {
+		s, err := cron.ParseStandard(window.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule cron expression '%s': %w", window.Cron, err)
+		}
+		if window.Duration.Duration <= 0 {
+			return nil, fmt.Errorf("invalid schedule window duration '%s': must be greater than zero", window.Duration.Duration.String())
+		}
+		w.schedules = append(w.schedules, s)
+		w.durations = append(w.durations, window.Duration.Duration)
+	}
+	return w, nil
+}
+
+// isOpen returns true if a window is open at the given time,
+// i.e. if a window opened less than its duration ago.
+func (w *applyWindows) isOpen(now time.Time) bool {
+	now = now.In(w.location)
+	for i, s := range w.schedules {
+		if !s.Next(now.Add(-w.durations[i])).After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextOpen returns the time at which the next window opens.
+func (w *applyWindows) nextOpen(now time.Time) time.Time {
+	now = now.In(w.location)
+	var next time.Time
+	for _, s := range w.schedules {
+		if t := s.Next(now); !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	return next
+}
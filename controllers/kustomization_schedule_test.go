@@ -0,0 +1,95 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestApplyWindows(t *testing.T) {
+	windows, err := parseSchedule(kustomizev1.Schedule{
+		TimeZone: "Europe/Paris",
+		Windows: []kustomizev1.ScheduleWindow{
+			{Cron: "0 22 * * 1-5", Duration: metav1.Duration{Duration: 2 * time.Hour}},
+			{Cron: "0 10 * * 6", Duration: metav1.Duration{Duration: 30 * time.Minute}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		open     bool
+		nextOpen time.Time
+	}{
+		{
+			name:     "weekday afternoon",
+			now:      time.Date(2021, 6, 9, 15, 0, 0, 0, paris),
+			nextOpen: time.Date(2021, 6, 9, 22, 0, 0, 0, paris),
+		},
+		{name: "weekday window", now: time.Date(2021, 6, 9, 23, 30, 0, 0, paris), open: true},
+		{name: "window opening", now: time.Date(2021, 6, 9, 22, 0, 0, 0, paris), open: true},
+		{
+			name:     "window closing",
+			now:      time.Date(2021, 6, 10, 0, 0, 0, 0, paris),
+			nextOpen: time.Date(2021, 6, 10, 22, 0, 0, 0, paris),
+		},
+		{name: "saturday window in UTC", now: time.Date(2021, 6, 12, 8, 15, 0, 0, time.UTC), open: true},
+		{
+			name:     "saturday afternoon",
+			now:      time.Date(2021, 6, 12, 15, 0, 0, 0, paris),
+			nextOpen: time.Date(2021, 6, 14, 22, 0, 0, 0, paris),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := windows.isOpen(tt.now); got != tt.open {
+				t.Errorf("isOpen() = %v, want %v", got, tt.open)
+			}
+			if tt.open {
+				return
+			}
+			if got := windows.nextOpen(tt.now); !got.Equal(tt.nextOpen) {
+				t.Errorf("nextOpen() = %s, want %s", got, tt.nextOpen)
+			}
+		})
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	window := kustomizev1.ScheduleWindow{Cron: "0 22 * * *", Duration: metav1.Duration{Duration: time.Hour}}
+	for name, schedule := range map[string]kustomizev1.Schedule{
+		"time zone": {TimeZone: "Mars/Olympus", Windows: []kustomizev1.ScheduleWindow{window}},
+		"cron":      {Windows: []kustomizev1.ScheduleWindow{{Cron: "0 25 * * *", Duration: window.Duration}}},
+		"duration":  {Windows: []kustomizev1.ScheduleWindow{{Cron: window.Cron}}},
+	} {
+		if _, err := parseSchedule(schedule); err == nil {
+			t.Errorf("expected error for an invalid %s", name)
+		}
+	}
+}
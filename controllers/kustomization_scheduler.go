@@ -0,0 +1,195 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+var queueWaitSeconds = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "gotk_reconcile_queue_wait_seconds",
+		Help:    "The time a reconciliation waited in the namespace fairness queue for a worker.",
+		Buckets: prometheus.ExponentialBuckets(0.5, 2, 10),
+	},
+	[]string{"namespace"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(queueWaitSeconds)
+}
+
+// namespaceScheduler shares the reconcile workers between the namespaces,
+// so that a namespace with many Kustomizations can't take all the workers.
+// Each namespace with running or queued reconciliations gets an equal
+// share of the workers, and at least one worker.
+// The requests exceeding the share of their namespace are queued, and sent
+// back to the work queue of the controller in order when workers are released.
+type namespaceScheduler struct {
+	mu      sync.Mutex
+	workers int
+	running map[string]int
+	waiting map[ctrl.Request]*waitingRequest
+	// queues holds the requests of each namespace in the order they were queued
+	queues map[string][]ctrl.Request
+	// dispatched receives the requests that can start
+	dispatched chan event.GenericEvent
+}
+
+// waitingRequest records when a request was queued, and whether
+// it was sent back to the work queue of the controller.
+type waitingRequest struct {
+	since      time.Time
+	dispatched bool
+}
+
+func newNamespaceScheduler(workers int) *namespaceScheduler {
+	return &namespaceScheduler{
+		workers:    workers,
+		running:    make(map[string]int),
+		waiting:    make(map[ctrl.Request]*waitingRequest),
+		queues:     make(map[string][]ctrl.Request),
+		dispatched: make(chan event.GenericEvent, workers),
+	}
+}
+
+// source returns the source of the requests dispatched by the scheduler.
+func (s *namespaceScheduler) source() source.Source {
+	return &source.Channel{Source: s.dispatched}
+}
+
+// acquire returns true if the reconciliation of the request can start,
+// and false if the namespace of the request has used its share of workers.
+// The requests that can't start are queued until a worker is released,
+// they must not be requeued by the caller.
+func (s *namespaceScheduler) acquire(req ctrl.Request) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.running[req.Namespace] >= s.share(req.Namespace) {
+		w, ok := s.waiting[req]
+		if !ok {
+			w = &waitingRequest{since: now}
+			s.waiting[req] = w
+			s.queues[req.Namespace] = append(s.queues[req.Namespace], req)
+		} else if w.dispatched {
+			w.dispatched = false
+			s.queues[req.Namespace] = append(s.queues[req.Namespace], req)
+		}
+		return false
+	}
+
+	var wait time.Duration
+	if w, ok := s.waiting[req]; ok {
+		wait = now.Sub(w.since)
+		delete(s.waiting, req)
+	}
+	queueWaitSeconds.WithLabelValues(req.Namespace).Observe(wait.Seconds())
+	s.running[req.Namespace]++
+	return true
+}
+
+// release frees the worker acquired for the request, and dispatches
+// the queued requests of the namespaces that have free workers.
+func (s *namespaceScheduler) release(req ctrl.Request) {
+	s.mu.Lock()
+	s.running[req.Namespace]--
+	if s.running[req.Namespace] <= 0 {
+		delete(s.running, req.Namespace)
+	}
+	dispatched := s.dispatch()
+	s.mu.Unlock()
+
+	for _, r := range dispatched {
+		obj := &kustomizev1.Kustomization{}
+		obj.SetNamespace(r.Namespace)
+		obj.SetName(r.Name)
+		s.dispatched <- event.GenericEvent{Object: obj}
+	}
+}
+
+// forget removes the request from the queue,
+// it must be called when the Kustomization is deleted.
+func (s *namespaceScheduler) forget(req ctrl.Request) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.waiting, req)
+}
+
+// dispatch returns the queued requests that can start, in the order they were queued.
+func (s *namespaceScheduler) dispatch() []ctrl.Request {
+	var namespaces []string
+	for ns := range s.queues {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	var dispatched []ctrl.Request
+	for _, ns := range namespaces {
+		free := s.share(ns) - s.running[ns]
+		queue := s.queues[ns]
+		for len(queue) > 0 && free > 0 {
+			req := queue[0]
+			queue = queue[1:]
+			// the requests forgotten or started since they were queued are skipped
+			if w, ok := s.waiting[req]; ok && !w.dispatched {
+				w.dispatched = true
+				dispatched = append(dispatched, req)
+				free--
+			}
+		}
+		if len(queue) == 0 {
+			delete(s.queues, ns)
+		} else {
+			s.queues[ns] = queue
+		}
+	}
+	return dispatched
+}
+
+// share returns the number of workers a namespace can use, based on the
+// number of namespaces that have running or queued reconciliations.
+func (s *namespaceScheduler) share(namespace string) int {
+	namespaces := map[string]bool{namespace: true}
+	for ns := range s.running {
+		namespaces[ns] = true
+	}
+	for req := range s.waiting {
+		namespaces[req.Namespace] = true
+	}
+
+	share := s.workers / len(namespaces)
+	if share < 1 {
+		share = 1
+	}
+	return share
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestNamespaceScheduler(t *testing.T) {
+	request := func(namespace, name string) ctrl.Request {
+		return ctrl.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}
+	}
+
+	s := newNamespaceScheduler(4)
+
+	// a single namespace can use all the workers
+	for _, name := range []string{"a", "b", "c"} {
+		if !s.acquire(request("busy", name)) {
+			t.Fatalf("expected busy/%s to start", name)
+		}
+	}
+
+	// with two namespaces, each one gets half of the workers
+	if !s.acquire(request("other", "a")) {
+		t.Fatal("expected other/a to start")
+	}
+	if s.acquire(request("busy", "d")) {
+		t.Fatal("expected busy/d to be deferred")
+	}
+	if _, ok := s.waiting[request("busy", "d")]; !ok {
+		t.Fatal("expected busy/d to be waiting")
+	}
+
+	s.release(request("busy", "a"))
+	s.release(request("busy", "b"))
+	if dispatched := receiveDispatched(s); len(dispatched) != 1 || dispatched[0] != request("busy", "d") {
+		t.Fatalf("expected busy/d to be dispatched, got %v", dispatched)
+	}
+	if !s.acquire(request("busy", "d")) {
+		t.Fatal("expected busy/d to start after busy released its workers")
+	}
+	if len(s.waiting) != 0 {
+		t.Errorf("expected no waiting requests, got %v", s.waiting)
+	}
+
+	s.release(request("busy", "c"))
+	s.release(request("busy", "d"))
+	s.release(request("other", "a"))
+	if len(s.running) != 0 {
+		t.Errorf("expected no running requests, got %v", s.running)
+	}
+}
+
+func TestNamespaceSchedulerDispatch(t *testing.T) {
+	request := func(namespace, name string) ctrl.Request {
+		return ctrl.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}
+	}
+
+	s := newNamespaceScheduler(2)
+	if !s.acquire(request("busy", "a")) || !s.acquire(request("other", "a")) {
+		t.Fatal("expected busy/a and other/a to start")
+	}
+	for _, name := range []string{"b", "c", "d"} {
+		if s.acquire(request("busy", name)) {
+			t.Fatalf("expected busy/%s to be queued", name)
+		}
+	}
+	// a request deferred again keeps its place in the queue
+	if s.acquire(request("busy", "b")) {
+		t.Fatal("expected busy/b to be queued")
+	}
+
+	// the Kustomization deleted while queued is not dispatched
+	s.forget(request("busy", "c"))
+
+	s.release(request("busy", "a"))
+	if dispatched := receiveDispatched(s); !reflect.DeepEqual(dispatched, []ctrl.Request{request("busy", "b")}) {
+		t.Fatalf("expected busy/b to be dispatched, got %v", dispatched)
+	}
+	if !s.acquire(request("busy", "b")) {
+		t.Fatal("expected busy/b to start")
+	}
+
+	// the other namespace leaving frees a worker for busy
+	s.release(request("other", "a"))
+	if dispatched := receiveDispatched(s); !reflect.DeepEqual(dispatched, []ctrl.Request{request("busy", "d")}) {
+		t.Fatalf("expected busy/d to be dispatched, got %v", dispatched)
+	}
+	if !s.acquire(request("busy", "d")) {
+		t.Fatal("expected busy/d to start")
+	}
+
+	s.release(request("busy", "b"))
+	s.release(request("busy", "d"))
+	if len(s.waiting) != 0 || len(s.queues) != 0 || len(s.running) != 0 {
+		t.Errorf("expected the scheduler to be empty, got waiting %v, queues %v, running %v", s.waiting, s.queues, s.running)
+	}
+	if dispatched := receiveDispatched(s); len(dispatched) != 0 {
+		t.Errorf("expected no dispatched requests, got %v", dispatched)
+	}
+}
+
+// receiveDispatched returns the requests dispatched by the scheduler.
+func receiveDispatched(s *namespaceScheduler) []ctrl.Request {
+	var requests []ctrl.Request
+	for {
+		select {
+		case e := <-s.dispatched:
+			requests = append(requests, ctrl.Request{NamespacedName: types.NamespacedName{
+				Namespace: e.Object.GetNamespace(),
+				Name:      e.Object.GetName(),
+			}})
+		default:
+			return requests
+		}
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// inventoryClaims returns the stale objects that are recorded in the inventory
+// of other Kustomizations targeting the same cluster, mapped to the
+// 'namespace/name' of the first Kustomization claiming them.
+func (r *KustomizationReconciler) inventoryClaims(ctx context.Context, kustomization kustomizev1.Kustomization, stale []kustomizev1.ResourceRef) (map[string]string, error) {
+	ids := make(map[string]bool, len(stale))
+	for _, entry := range stale {
+		ids[entry.ID] = true
+	}
+
+	var list kustomizev1.KustomizationList
+	if err := r.List(ctx, &list); err != nil {
+		return nil, err
+	}
+
+	cluster := targetCluster(kustomization)
+	claims := make(map[string]string)
+	for _, k := range list.Items {
+		if k.GetNamespace() == kustomization.GetNamespace() && k.GetName() == kustomization.GetName() {
+			continue
+		}
+		inventory := clusterInventories(k)[cluster]
+		if inventory == nil {
+			continue
+		}
+		for _, entry := range inventory.Entries {
+			if _, claimed := claims[entry.ID]; ids[entry.ID] && !claimed {
+				claims[entry.ID] = fmt.Sprintf("%s/%s", k.GetNamespace(), k.GetName())
+			}
+		}
+	}
+	return claims, nil
+}
+
+// targetCluster returns the key of the cluster the Kustomization applies its objects to,
+// an empty string for the local cluster, and 'namespace/name' of the kubeconfig secret for
+// the remote clusters. The prune of a cluster of spec.clusters sets spec.kubeConfig.
+func targetCluster(kustomization kustomizev1.Kustomization) string {
+	if kustomization.Spec.KubeConfig == nil {
+		return ""
+	}
+	return remoteClusterKey(kustomization, kustomization.Spec.KubeConfig.SecretRef.Name)
+}
+
+func remoteClusterKey(kustomization kustomizev1.Kustomization, secretName string) string {
+	return fmt.Sprintf("%s/%s", kustomization.GetNamespace(), secretName)
+}
+
+// clusterInventories returns the inventories of the Kustomization mapped to
+// the key of the cluster they were applied to. The Kustomizations with
+// spec.clusters have one inventory per cluster recorded in status.clusters,
+// as spec.clusters takes precedence over spec.kubeConfig.
+func clusterInventories(kustomization kustomizev1.Kustomization) map[string]*kustomizev1.ResourceInventory {
+	inventories := make(map[string]*kustomizev1.ResourceInventory)
+	if len(kustomization.Spec.Clusters) > 0 {
+		for _, status := range kustomization.Status.Clusters {
+			if status.Inventory != nil {
+				inventories[remoteClusterKey(kustomization, status.Name)] = status.Inventory
+			}
+		}
+		return inventories
+	}
+	if kustomization.Status.Inventory != nil {
+		inventories[targetCluster(kustomization)] = kustomization.Status.Inventory
+	}
+	return inventories
+}
+
+// excludeClaimed splits the stale objects between the objects that can be
+// pruned and the objects claimed by other Kustomizations.
+func excludeClaimed(stale []kustomizev1.ResourceRef, claims map[string]string) ([]kustomizev1.ResourceRef, []string) {
+	var prunable []kustomizev1.ResourceRef
+	var shared []string
+	for _, entry := range stale {
+		owner, claimed := claims[entry.ID]
+		if !claimed {
+			prunable = append(prunable, entry)
+			continue
+		}
+		id := entry.ID
+		if obj, err := inventoryObject(entry); err == nil {
+			id = objectID(obj)
+		}
+		shared = append(shared, fmt.Sprintf("%s (in the inventory of Kustomization '%s')", id, owner))
+	}
+	return prunable, shared
+}
+
+// labeledOwner returns the 'namespace/name' of the Kustomization
+// set in the garbage collection labels of the object, if any.
+func labeledOwner(obj unstructured.Unstructured) string {
+	labels := obj.GetLabels()
+	name := labels[fmt.Sprintf("%s/name", kustomizev1.GroupVersion.Group)]
+	namespace := labels[fmt.Sprintf("%s/namespace", kustomizev1.GroupVersion.Group)]
+	if name == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestInventoryClaims(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := kustomizev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	newKustomization := func(name string, kubeConfig *kustomizev1.KubeConfig, ids ...string) *kustomizev1.Kustomization {
+		k := &kustomizev1.Kustomization{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "dev"},
+			Spec:       kustomizev1.KustomizationSpec{KubeConfig: kubeConfig},
+		}
+		k.Status.Inventory = &kustomizev1.ResourceInventory{}
+		for _, id := range ids {
+			k.Status.Inventory.Entries = append(k.Status.Inventory.Entries, kustomizev1.ResourceRef{ID: id, Version: "v1"})
+		}
+		return k
+	}
+	remote := &kustomizev1.KubeConfig{SecretRef: meta.LocalObjectReference{Name: "staging"}}
+
+	// the inventory of the clusters of spec.clusters are recorded in status.clusters,
+	// a stale status.inventory is ignored as spec.clusters takes precedence
+	fleet := newKustomization("fleet", nil, "dev_c__ConfigMap")
+	fleet.Spec.Clusters = []kustomizev1.ClusterTarget{{SecretRef: &meta.LocalObjectReference{Name: "staging"}}}
+	fleet.Status.Clusters = []kustomizev1.ClusterStatus{{
+		Name: "staging",
+		Inventory: &kustomizev1.ResourceInventory{Entries: []kustomizev1.ResourceRef{
+			{ID: "dev_c__ConfigMap", Version: "v1"},
+			{ID: "dev_e__ConfigMap", Version: "v1"},
+		}},
+	}}
+
+	apps := newKustomization("apps", nil, "dev_a__ConfigMap", "dev_b__ConfigMap", "dev_c__ConfigMap")
+	r := &KustomizationReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		apps,
+		fleet,
+		newKustomization("infra", nil, "dev_a__ConfigMap", "dev_d__ConfigMap"),
+		newKustomization("staging", remote, "dev_b__ConfigMap"),
+	).Build()}
+
+	stale := apps.Status.Inventory.Entries
+	claims, err := r.inventoryClaims(context.TODO(), *apps, stale)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := map[string]string{"dev_a__ConfigMap": "dev/infra"}; !reflect.DeepEqual(claims, want) {
+		t.Errorf("expected claims %v, got %v", want, claims)
+	}
+
+	prunable, shared := excludeClaimed(stale, claims)
+	if len(prunable) != 2 || prunable[0].ID != "dev_b__ConfigMap" || prunable[1].ID != "dev_c__ConfigMap" {
+		t.Errorf("expected the unclaimed objects to be prunable, got %v", prunable)
+	}
+	if want := []string{"configmap/dev/a (in the inventory of Kustomization 'dev/infra')"}; !reflect.DeepEqual(shared, want) {
+		t.Errorf("expected shared %v, got %v", want, shared)
+	}
+
+	// on the staging cluster, the objects are claimed by the remote Kustomizations
+	stagingApps := newKustomization("apps", remote, "dev_b__ConfigMap", "dev_c__ConfigMap", "dev_e__ConfigMap")
+	claims, err = r.inventoryClaims(context.TODO(), *stagingApps, stagingApps.Status.Inventory.Entries)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]string{
+		"dev_b__ConfigMap": "dev/staging",
+		"dev_c__ConfigMap": "dev/fleet",
+		"dev_e__ConfigMap": "dev/fleet",
+	}
+	if !reflect.DeepEqual(claims, want) {
+		t.Errorf("expected claims %v, got %v", want, claims)
+	}
+}
@@ -0,0 +1,318 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+const (
+	crdKind               = "CustomResourceDefinition"
+	namespaceKind         = "Namespace"
+	validatingWebhookKind = "ValidatingWebhookConfiguration"
+	mutatingWebhookKind   = "MutatingWebhookConfiguration"
+	stagePollInterval     = 2 * time.Second
+)
+
+// KustomizeStages holds the Kubernetes objects generated by a
+// kustomize build, grouped in the order they must be applied.
+type KustomizeStages struct {
+	// Namespaces holds the Namespaces, applied first.
+	Namespaces []*unstructured.Unstructured
+	// CRDs holds the CustomResourceDefinitions, applied after
+	// the Namespaces.
+	CRDs []*unstructured.Unstructured
+	// Objects holds the objects that don't depend on the CRDs
+	// defined in the same build, including webhook configurations.
+	Objects []*unstructured.Unstructured
+	// CustomResources holds the objects of the kinds defined by
+	// the CRDs, applied after the CRDs are established and
+	// the admission webhooks are ready.
+	CustomResources []*unstructured.Unstructured
+}
+
+// NewKustomizeStages splits the multi-doc YAML manifests into apply stages,
+// while preserving the order of the objects within each stage.
+func NewKustomizeStages(manifests []byte) (*KustomizeStages, error) {
+	objects, err := readObjects(manifests)
+	if err != nil {
+		return nil, err
+	}
+
+	stages := &KustomizeStages{}
+	definedKinds := make(map[string]bool)
+	for _, obj := range objects {
+		if obj.GetKind() == crdKind {
+			stages.CRDs = append(stages.CRDs, obj)
+			group, _, _ := unstructured.NestedString(obj.Object, "spec", "group")
+			kind, _, _ := unstructured.NestedString(obj.Object, "spec", "names", "kind")
+			definedKinds[group+"/"+kind] = true
+		}
+	}
+
+	for _, obj := range objects {
+		if obj.GetKind() == crdKind {
+			continue
+		}
+		gvk := obj.GroupVersionKind()
+		if isNamespace(obj) {
+			stages.Namespaces = append(stages.Namespaces, obj)
+		} else if definedKinds[gvk.Group+"/"+gvk.Kind] {
+			stages.CustomResources = append(stages.CustomResources, obj)
+		} else {
+			stages.Objects = append(stages.Objects, obj)
+		}
+	}
+
+	return stages, nil
+}
+
+// readObjects decodes the multi-doc YAML manifests into a list of
+// unstructured objects, while preserving their order.
+func readObjects(manifests []byte) ([]*unstructured.Unstructured, error) {
+	var objects []*unstructured.Unstructured
+	reader := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(manifests), 2048)
+	for {
+		obj := &unstructured.Unstructured{}
+		err := reader.Decode(obj)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if obj.IsList() {
+			err := obj.EachListItem(func(item runtime.Object) error {
+				objects = append(objects, item.(*unstructured.Unstructured))
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if len(obj.Object) > 0 {
+			objects = append(objects, obj)
+		}
+	}
+	return objects, nil
+}
+
+// HasStages returns true if the build contains CRDs,
+// and the objects must be applied in multiple stages.
+func (ks *KustomizeStages) HasStages() bool {
+	return len(ks.CRDs) > 0
+}
+
+// NamespaceNames returns the names of the namespaces
+// found in the namespaces stage.
+func (ks *KustomizeStages) NamespaceNames() []string {
+	var namespaces []string
+	for _, obj := range ks.Namespaces {
+		namespaces = append(namespaces, obj.GetName())
+	}
+	return namespaces
+}
+
+// All returns the objects of all stages in the order they are applied.
+func (ks *KustomizeStages) All() []*unstructured.Unstructured {
+	var objects []*unstructured.Unstructured
+	objects = append(objects, ks.Namespaces...)
+	objects = append(objects, ks.CRDs...)
+	objects = append(objects, ks.Objects...)
+	return append(objects, ks.CustomResources...)
+}
+
+// isNamespace returns true if the object is a core Namespace.
+func isNamespace(obj *unstructured.Unstructured) bool {
+	return obj.GetKind() == namespaceKind && obj.GroupVersionKind().Group == ""
+}
+
+// Webhooks returns the admission webhook configurations
+// found in the objects stage.
+func (ks *KustomizeStages) Webhooks() []*unstructured.Unstructured {
+	var webhooks []*unstructured.Unstructured
+	for _, obj := range ks.Objects {
+		if obj.GetKind() == validatingWebhookKind || obj.GetKind() == mutatingWebhookKind {
+			webhooks = append(webhooks, obj)
+		}
+	}
+	return webhooks
+}
+
+// readStages reads the manifests generated by the kustomize build
+// and splits them into apply stages.
+func readStages(dirPath string, kustomization kustomizev1.Kustomization) (*KustomizeStages, error) {
+	manifests, err := ioutil.ReadFile(filepath.Join(dirPath, fmt.Sprintf("%s.yaml", kustomization.GetUID())))
+	if err != nil {
+		return nil, err
+	}
+
+	stages, err := NewKustomizeStages(manifests)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode manifests: %w", err)
+	}
+	return stages, nil
+}
+
+// waitForCRDs blocks until all the given CustomResourceDefinitions
+// are established on the cluster, or the timeout expires.
+func waitForCRDs(ctx context.Context, kubeClient client.Client, crds []*unstructured.Unstructured, timeout time.Duration) error {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pending := ""
+	err := wait.PollImmediateUntil(stagePollInterval, func() (bool, error) {
+		for _, crd := range crds {
+			existing := &unstructured.Unstructured{}
+			existing.SetGroupVersionKind(crd.GroupVersionKind())
+			if err := kubeClient.Get(waitCtx, client.ObjectKey{Name: crd.GetName()}, existing); err != nil {
+				if apierrors.IsNotFound(err) {
+					pending = crd.GetName()
+					return false, nil
+				}
+				return false, err
+			}
+			if !hasTrueCondition(existing, "Established") {
+				pending = crd.GetName()
+				return false, nil
+			}
+		}
+		return true, nil
+	}, waitCtx.Done())
+	if err != nil {
+		return fmt.Errorf("CustomResourceDefinition '%s' is not established: %w", pending, err)
+	}
+	return nil
+}
+
+// waitForWebhooks blocks until the services backing the given admission
+// webhook configurations have at least one ready endpoint,
+// or the timeout expires.
+func waitForWebhooks(ctx context.Context, kubeClient client.Client, webhooks []*unstructured.Unstructured, timeout time.Duration) error {
+	var services []client.ObjectKey
+	for _, wh := range webhooks {
+		entries, _, _ := unstructured.NestedSlice(wh.Object, "webhooks")
+		for _, entry := range entries {
+			e, ok := entry.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _, _ := unstructured.NestedString(e, "clientConfig", "service", "name")
+			namespace, _, _ := unstructured.NestedString(e, "clientConfig", "service", "namespace")
+			if name == "" {
+				continue
+			}
+			key := client.ObjectKey{Namespace: namespace, Name: name}
+			if !containsObjectKey(services, key) {
+				services = append(services, key)
+			}
+		}
+	}
+
+	if len(services) == 0 {
+		return nil
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pending := ""
+	err := wait.PollImmediateUntil(stagePollInterval, func() (bool, error) {
+		for _, svc := range services {
+			endpoints := &unstructured.Unstructured{}
+			endpoints.SetAPIVersion("v1")
+			endpoints.SetKind("Endpoints")
+			if err := kubeClient.Get(waitCtx, svc, endpoints); err != nil {
+				if apierrors.IsNotFound(err) {
+					pending = svc.String()
+					return false, nil
+				}
+				return false, err
+			}
+			if !hasReadyAddress(endpoints) {
+				pending = svc.String()
+				return false, nil
+			}
+		}
+		return true, nil
+	}, waitCtx.Done())
+	if err != nil {
+		return fmt.Errorf("webhook service '%s' has no ready endpoints: %w", pending, err)
+	}
+	return nil
+}
+
+func hasTrueCondition(obj *unstructured.Unstructured, conditionType string) bool {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if cond["type"] == conditionType && cond["status"] == "True" {
+			return true
+		}
+	}
+	return false
+}
+
+func hasReadyAddress(endpoints *unstructured.Unstructured) bool {
+	subsets, _, _ := unstructured.NestedSlice(endpoints.Object, "subsets")
+	for _, s := range subsets {
+		subset, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if addresses, ok := subset["addresses"].([]interface{}); ok && len(addresses) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func containsObjectKey(keys []client.ObjectKey, key client.ObjectKey) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func objectID(obj *unstructured.Unstructured) string {
+	id := strings.ToLower(obj.GetKind())
+	if obj.GetNamespace() != "" {
+		return fmt.Sprintf("%s/%s/%s", id, obj.GetNamespace(), obj.GetName())
+	}
+	return fmt.Sprintf("%s/%s", id, obj.GetName())
+}
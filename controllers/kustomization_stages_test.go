@@ -0,0 +1,89 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+)
+
+func TestNewKustomizeStages(t *testing.T) {
+	manifests := []byte(`---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: test
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: certificates.cert-manager.io
+spec:
+  group: cert-manager.io
+  names:
+    kind: Certificate
+---
+apiVersion: cert-manager.io/v1
+kind: Certificate
+metadata:
+  name: test
+  namespace: test
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: webhook
+  namespace: test
+---
+apiVersion: admissionregistration.k8s.io/v1
+kind: ValidatingWebhookConfiguration
+metadata:
+  name: webhook
+webhooks:
+- name: webhook.cert-manager.io
+  clientConfig:
+    service:
+      name: webhook
+      namespace: test
+`)
+
+	stages, err := NewKustomizeStages(manifests)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !stages.HasStages() {
+		t.Errorf("expected stages for a build containing CRDs")
+	}
+	if len(stages.CRDs) != 1 || stages.CRDs[0].GetName() != "certificates.cert-manager.io" {
+		t.Errorf("expected one CRD, got %v", stages.CRDs)
+	}
+	if len(stages.Namespaces) != 1 || stages.Namespaces[0].GetName() != "test" {
+		t.Errorf("expected one namespace, got %v", stages.Namespaces)
+	}
+	if len(stages.Objects) != 2 {
+		t.Errorf("expected 2 objects, got %v", len(stages.Objects))
+	}
+	if len(stages.CustomResources) != 1 || stages.CustomResources[0].GetKind() != "Certificate" {
+		t.Errorf("expected one custom resource, got %v", stages.CustomResources)
+	}
+	if webhooks := stages.Webhooks(); len(webhooks) != 1 {
+		t.Errorf("expected one webhook, got %v", len(webhooks))
+	}
+	if all := stages.All(); len(all) != 5 || all[0].GetKind() != "Namespace" || all[1].GetKind() != "CustomResourceDefinition" {
+		t.Errorf("expected namespaces and CRDs to be applied first, got %v", all)
+	}
+}
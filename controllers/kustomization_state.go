@@ -0,0 +1,207 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/cli-utils/pkg/kstatus/status"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// stateChecksum computes a checksum of the in-cluster state of the objects
+// managed by the Kustomization, of the ConfigMaps and Secrets referenced
+// in the post build substitutions, and of the settings of the NamespaceConfig
+// and of the controller defaults merged into the Kustomization spec.
+// For objects with a generation, the checksum accounts for spec changes only,
+// for the other objects it accounts for any change of their resource version.
+// For objects with ignored fields, the checksum accounts for the changes
+// of their content, excluding the status and the ignored fields.
+// When the Kustomization assesses the health of its objects, the checksum
+// accounts for the kstatus result of the managed objects, so that an object
+// becoming unhealthy after a successful reconciliation is detected.
+func (r *KustomizationReconciler) stateChecksum(ctx context.Context, kubeClient client.Client, kustomization kustomizev1.Kustomization, snapshot *kustomizev1.Snapshot) (string, error) {
+	if snapshot == nil {
+		return "", nil
+	}
+
+	var entries []string
+	withHealth := assessesHealth(kustomization)
+	listOptions := client.MatchingLabels(selectorLabels(kustomization.GetName(), kustomization.GetNamespace()))
+	list := func(gvk schema.GroupVersionKind, opts ...client.ListOption) error {
+		ulist := &unstructured.UnstructuredList{}
+		ulist.SetGroupVersionKind(schema.GroupVersionKind{
+			Group:   gvk.Group,
+			Kind:    gvk.Kind + "List",
+			Version: gvk.Version,
+		})
+		if err := kubeClient.List(ctx, ulist, opts...); err != nil {
+			return fmt.Errorf("failed to list %s: %w", gvk.Kind, err)
+		}
+		for _, item := range ulist.Items {
+			version := item.GetResourceVersion()
+			if item.GetGeneration() > 0 {
+				version = fmt.Sprintf("%d", item.GetGeneration())
+			}
+			ignorePaths, err := ignoredPaths(kustomization, &item)
+			if err != nil {
+				return err
+			}
+			if len(ignorePaths) > 0 {
+				if version, err = contentChecksum(item, ignorePaths); err != nil {
+					return err
+				}
+			}
+			if withHealth {
+				health := status.UnknownStatus
+				if res, err := status.Compute(&item); err == nil {
+					health = res.Status
+				}
+				version = fmt.Sprintf("%s/%s", version, health)
+			}
+			entries = append(entries, fmt.Sprintf("%s/%s/%s/%s/%s",
+				gvk.String(), item.GetNamespace(), item.GetName(), item.GetUID(), version))
+		}
+		return nil
+	}
+
+	for ns, gvks := range snapshot.NamespacedKinds() {
+		for _, gvk := range gvks {
+			if err := list(gvk, client.InNamespace(ns), listOptions); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	for _, gvk := range snapshot.NonNamespacedKinds() {
+		if err := list(gvk, listOptions); err != nil {
+			return "", err
+		}
+	}
+
+	if kustomization.Spec.PostBuild != nil {
+		for _, reference := range kustomization.Spec.PostBuild.SubstituteFrom {
+			namespacedName := types.NamespacedName{Namespace: kustomization.Namespace, Name: reference.Name}
+			var obj client.Object
+			switch reference.Kind {
+			case "ConfigMap":
+				obj = &corev1.ConfigMap{}
+			case "Secret":
+				obj = &corev1.Secret{}
+			default:
+				continue
+			}
+			if err := r.Client.Get(ctx, namespacedName, obj); err != nil {
+				return "", fmt.Errorf("unable to get '%s/%s': %w", reference.Kind, reference.Name, err)
+			}
+			entries = append(entries, fmt.Sprintf("%s/%s/%s", reference.Kind, reference.Name, obj.GetResourceVersion()))
+		}
+	}
+
+	// the namespace config changes are not persisted in the Kustomization spec,
+	// hence they don't result in a new generation
+	config, err := r.getNamespaceConfig(ctx, kustomization.GetNamespace())
+	if err != nil {
+		return "", fmt.Errorf("unable to read the namespace config: %w", err)
+	}
+	policy, err := r.getPolicyConfig(ctx, kustomization.GetNamespace())
+	if err != nil {
+		return "", fmt.Errorf("unable to read the namespace policy config: %w", err)
+	}
+	data, err := json.Marshal([]kustomizev1.NamespaceConfigSpec{config, policy})
+	if err != nil {
+		return "", err
+	}
+	entries = append(entries, fmt.Sprintf("%s/%x/%s", kustomizev1.NamespaceConfigKind, sha1.Sum(data), r.defaultServiceAccount))
+
+	sort.Strings(entries)
+	return fmt.Sprintf("%x", sha1.Sum([]byte(strings.Join(entries, "\n")))), nil
+}
+
+// assessesHealth returns true if the Kustomization waits for its objects
+// or defines health checks, inline or in the source artifact.
+func assessesHealth(kustomization kustomizev1.Kustomization) bool {
+	return kustomization.Spec.Wait ||
+		kustomization.Spec.HealthChecksFrom != "" ||
+		len(kustomization.Spec.HealthChecks) > 0
+}
+
+// contentChecksum returns the checksum of the object labels, annotations
+// and content, excluding the status and the given fields.
+func contentChecksum(obj unstructured.Unstructured, ignorePaths []string) (string, error) {
+	content := obj.DeepCopy()
+	removeIgnoredFields(content, ignorePaths)
+	delete(content.Object, "status")
+	content.Object["metadata"] = map[string]interface{}{
+		"labels":      content.GetLabels(),
+		"annotations": content.GetAnnotations(),
+	}
+	data, err := content.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha1.Sum(data)), nil
+}
+
+// isUpToDate returns true if the last reconciliation succeeded for the given
+// source revision and generation, no reconciliation was requested since, and
+// the cluster state of the managed objects matches the recorded checksum.
+// The health of the managed objects is part of the checksum, hence an
+// object becoming unhealthy triggers a full reconciliation with its
+// health assessment.
+func (r *KustomizationReconciler) isUpToDate(ctx context.Context, kustomization kustomizev1.Kustomization, revision string) bool {
+	if kustomization.Status.StateChecksum == "" ||
+		kustomization.Status.LastAppliedRevision != revision ||
+		kustomization.Status.ObservedGeneration != kustomization.Generation {
+		return false
+	}
+
+	if !apimeta.IsStatusConditionTrue(kustomization.Status.Conditions, meta.ReadyCondition) {
+		return false
+	}
+
+	if v, ok := meta.ReconcileAnnotationValue(kustomization.GetAnnotations()); ok &&
+		v != kustomization.Status.GetLastHandledReconcileRequest() {
+		return false
+	}
+
+	imp := NewKustomizeImpersonation(kustomization, r.Client, r.StatusPoller, r.discoveryOptions, r.kubeConfigOptions)
+	kubeClient, _, err := imp.GetClient(ctx)
+	if err != nil {
+		return false
+	}
+
+	checksum, err := r.stateChecksum(ctx, kubeClient, kustomization, kustomization.Status.Snapshot)
+	if err != nil {
+		return false
+	}
+
+	return checksum == kustomization.Status.StateChecksum
+}
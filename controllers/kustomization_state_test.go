@@ -0,0 +1,258 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+const stateManifests = `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: backend
+  namespace: apps
+  generation: 1
+  labels:
+    kustomize.toolkit.fluxcd.io/name: backend
+    kustomize.toolkit.fluxcd.io/namespace: flux-system
+spec:
+  replicas: 1
+status:
+  observedGeneration: 1
+  replicas: 1
+  updatedReplicas: 1
+  readyReplicas: 1
+  availableReplicas: 1
+  conditions:
+  - type: Available
+    status: "True"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: backend
+  namespace: apps
+  labels:
+    kustomize.toolkit.fluxcd.io/name: backend
+    kustomize.toolkit.fluxcd.io/namespace: flux-system
+data:
+  key: value
+`
+
+func TestIsUpToDate(t *testing.T) {
+	tests := []struct {
+		name   string
+		wait   bool
+		mutate func(t *testing.T, r *KustomizationReconciler, k *kustomizev1.Kustomization)
+		want   bool
+	}{
+		{
+			name: "no changes",
+			want: true,
+		},
+		{
+			name: "new source revision",
+			mutate: func(t *testing.T, r *KustomizationReconciler, k *kustomizev1.Kustomization) {
+				k.Status.LastAppliedRevision = "main/previous"
+			},
+			want: false,
+		},
+		{
+			name: "new generation",
+			mutate: func(t *testing.T, r *KustomizationReconciler, k *kustomizev1.Kustomization) {
+				k.Generation = 2
+			},
+			want: false,
+		},
+		{
+			name: "reconcile requested",
+			mutate: func(t *testing.T, r *KustomizationReconciler, k *kustomizev1.Kustomization) {
+				k.SetAnnotations(map[string]string{meta.ReconcileRequestAnnotation: time.Now().String()})
+			},
+			want: false,
+		},
+		{
+			name: "not ready",
+			mutate: func(t *testing.T, r *KustomizationReconciler, k *kustomizev1.Kustomization) {
+				apimeta.SetStatusCondition(&k.Status.Conditions, metav1.Condition{
+					Type:   meta.ReadyCondition,
+					Status: metav1.ConditionFalse,
+					Reason: meta.ReconciliationFailedReason,
+				})
+			},
+			want: false,
+		},
+		{
+			name: "spec change of an object with a generation",
+			mutate: func(t *testing.T, r *KustomizationReconciler, k *kustomizev1.Kustomization) {
+				updateObject(t, r.Client, "Deployment", func(obj *unstructured.Unstructured) {
+					obj.SetGeneration(2)
+					_ = unstructured.SetNestedField(obj.Object, int64(2), "spec", "replicas")
+				})
+			},
+			want: false,
+		},
+		{
+			name: "status change of an object with a generation",
+			mutate: func(t *testing.T, r *KustomizationReconciler, k *kustomizev1.Kustomization) {
+				updateObject(t, r.Client, "Deployment", func(obj *unstructured.Unstructured) {
+					_ = unstructured.SetNestedField(obj.Object, int64(0), "status", "readyReplicas")
+				})
+			},
+			want: true,
+		},
+		{
+			name: "change of an object without a generation",
+			mutate: func(t *testing.T, r *KustomizationReconciler, k *kustomizev1.Kustomization) {
+				updateObject(t, r.Client, "ConfigMap", func(obj *unstructured.Unstructured) {
+					_ = unstructured.SetNestedField(obj.Object, "changed", "data", "key")
+				})
+			},
+			want: false,
+		},
+		{
+			name: "change of a substitution Secret",
+			mutate: func(t *testing.T, r *KustomizationReconciler, k *kustomizev1.Kustomization) {
+				secret := &corev1.Secret{}
+				if err := r.Get(context.TODO(), client.ObjectKey{Namespace: "flux-system", Name: "vars"}, secret); err != nil {
+					t.Fatal(err)
+				}
+				secret.StringData = map[string]string{"cluster": "prod"}
+				if err := r.Update(context.TODO(), secret); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: false,
+		},
+		{
+			name: "change of the namespace config",
+			mutate: func(t *testing.T, r *KustomizationReconciler, k *kustomizev1.Kustomization) {
+				prune := false
+				config := &kustomizev1.NamespaceConfig{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "flux-system", Name: kustomizev1.NamespaceConfigName},
+					Spec:       kustomizev1.NamespaceConfigSpec{Prune: &prune},
+				}
+				if err := r.Create(context.TODO(), config); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: false,
+		},
+		{
+			name: "change of the default service account",
+			mutate: func(t *testing.T, r *KustomizationReconciler, k *kustomizev1.Kustomization) {
+				r.defaultServiceAccount = "tenant"
+			},
+			want: false,
+		},
+		{
+			name: "object becoming unhealthy with wait",
+			wait: true,
+			mutate: func(t *testing.T, r *KustomizationReconciler, k *kustomizev1.Kustomization) {
+				updateObject(t, r.Client, "Deployment", func(obj *unstructured.Unstructured) {
+					_ = unstructured.SetNestedField(obj.Object, int64(0), "status", "readyReplicas")
+					_ = unstructured.SetNestedField(obj.Object, int64(0), "status", "availableReplicas")
+				})
+			},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects, err := readObjects([]byte(stateManifests))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// the managed objects are kept unstructured, as listed by stateChecksum
+			scheme := runtime.NewScheme()
+			scheme.AddKnownTypes(corev1.SchemeGroupVersion, &corev1.Secret{})
+			if err := kustomizev1.AddToScheme(scheme); err != nil {
+				t.Fatal(err)
+			}
+			kubeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "flux-system", Name: "vars"},
+				StringData: map[string]string{"cluster": "staging"},
+			}).Build()
+			for _, obj := range objects {
+				if err := kubeClient.Create(context.TODO(), obj); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			snapshot, err := kustomizev1.NewSnapshot([]byte(stateManifests), "checksum")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			k := kustomizev1.Kustomization{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "flux-system", Name: "backend", Generation: 1},
+				Spec: kustomizev1.KustomizationSpec{
+					Wait: tt.wait,
+					PostBuild: &kustomizev1.PostBuild{
+						SubstituteFrom: []kustomizev1.SubstituteReference{{Kind: "Secret", Name: "vars"}},
+					},
+				},
+			}
+			k = kustomizev1.KustomizationReady(k, snapshot, "main/1a2b3c", meta.ReconciliationSucceededReason, "Applied")
+			k.Status.ObservedGeneration = 1
+
+			r := &KustomizationReconciler{Client: kubeClient}
+			k.Status.StateChecksum, err = r.stateChecksum(context.TODO(), kubeClient, k, snapshot)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if tt.mutate != nil {
+				tt.mutate(t, r, &k)
+			}
+			if got := r.isUpToDate(context.TODO(), k, "main/1a2b3c"); got != tt.want {
+				t.Errorf("isUpToDate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func updateObject(t *testing.T, c client.Client, kind string, mutate func(obj *unstructured.Unstructured)) {
+	t.Helper()
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	if kind == "Deployment" {
+		obj.SetAPIVersion("apps/v1")
+	}
+	obj.SetKind(kind)
+	if err := c.Get(context.TODO(), client.ObjectKey{Namespace: "apps", Name: "backend"}, obj); err != nil {
+		t.Fatal(err)
+	}
+	mutate(obj)
+	if err := c.Update(context.TODO(), obj); err != nil {
+		t.Fatal(err)
+	}
+}
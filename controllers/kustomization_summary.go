@@ -0,0 +1,137 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// appliedObject records the action performed on an applied object.
+type appliedObject struct {
+	// ID is the object ID e.g. 'deployment/apps/backend'.
+	ID string
+	// Action is the action performed on the object e.g. 'configured'.
+	Action string
+	// Checksum is the applied checksum of the object, recorded
+	// in the inventory to skip the next apply if nothing changed.
+	Checksum string
+}
+
+// applyResults holds the applied objects in the apply order.
+type applyResults []appliedObject
+
+// changeSet returns the objects that were created, configured or replaced,
+// one per line, with the object ID followed by the action.
+func (a applyResults) changeSet() string {
+	changeSet := ""
+	for _, obj := range a {
+		if obj.Action != unchangedAction && obj.Action != skippedAction {
+			changeSet += obj.ID + " " + obj.Action + "\n"
+		}
+	}
+	return changeSet
+}
+
+// output returns the action performed on each object, keyed by object ID.
+// The unchanged and skipped objects are included only when verbose.
+func (a applyResults) output(verbose bool) map[string]string {
+	output := make(map[string]string, len(a))
+	for _, obj := range a {
+		if verbose || (obj.Action != unchangedAction && obj.Action != skippedAction) {
+			output[obj.ID] = obj.Action
+		}
+	}
+	return output
+}
+
+// actionCounts returns the number of objects per action.
+func (a applyResults) actionCounts() map[string]int {
+	counts := make(map[string]int)
+	for _, obj := range a {
+		counts[obj.Action]++
+	}
+	return counts
+}
+
+// summaryCounts returns the number of objects per action of the apply summary.
+func summaryCounts(summary *kustomizev1.ApplySummary) map[string]int {
+	if summary == nil {
+		return nil
+	}
+	return map[string]int{
+		createdAction:    summary.Created,
+		configuredAction: summary.Configured,
+		unchangedAction:  summary.Unchanged,
+		deletedAction:    summary.Deleted,
+	}
+}
+
+// changeSetLines returns the lines of a change set, one per object.
+func changeSetLines(changeSet string) []string {
+	if strings.TrimSpace(changeSet) == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSpace(changeSet), "\n")
+}
+
+// newApplySummary counts the objects per action and per kind, from the
+// apply results and the garbage collection change set. The change set lines
+// are expected to start with the object ID followed by the action.
+func newApplySummary(revision string, applied applyResults, pruned string) *kustomizev1.ApplySummary {
+	summary := &kustomizev1.ApplySummary{Revision: revision}
+	kinds := make(map[string]*kustomizev1.KindSummary)
+	kindSummary := func(id string) *kustomizev1.KindSummary {
+		kind := strings.ToLower(strings.SplitN(id, "/", 2)[0])
+		if _, ok := kinds[kind]; !ok {
+			kinds[kind] = &kustomizev1.KindSummary{Kind: kind}
+		}
+		return kinds[kind]
+	}
+
+	for _, obj := range applied {
+		switch obj.Action {
+		case createdAction:
+			summary.Created++
+			kindSummary(obj.ID).Created++
+		case configuredAction, replacedAction:
+			summary.Configured++
+			kindSummary(obj.ID).Configured++
+		default:
+			summary.Unchanged++
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSpace(pruned), "\n") {
+		if id := strings.SplitN(strings.TrimSpace(line), " ", 2)[0]; id != "" {
+			summary.Deleted++
+			kindSummary(id).Deleted++
+		}
+	}
+
+	for _, k := range kinds {
+		summary.Kinds = append(summary.Kinds, *k)
+	}
+	sort.Slice(summary.Kinds, func(i, j int) bool {
+		return summary.Kinds[i].Kind < summary.Kinds[j].Kind
+	})
+	summary.Summary = fmt.Sprintf("%d created, %d configured, %d unchanged, %d deleted",
+		summary.Created, summary.Configured, summary.Unchanged, summary.Deleted)
+	return summary
+}
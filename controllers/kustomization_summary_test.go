@@ -0,0 +1,104 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestNewApplySummary(t *testing.T) {
+	results := applyResults{
+		{ID: "namespace//apps", Action: createdAction},
+		{ID: "deployment/apps/frontend", Action: configuredAction},
+		{ID: "deployment/apps/backend", Action: replacedAction},
+		{ID: "service/apps/backend", Action: unchangedAction},
+		{ID: "secret/apps/token", Action: skippedAction},
+	}
+	pruned := `configmap/apps/legacy deleted
+ConfigMap/apps/old marked for deletion
+`
+
+	expected := "namespace//apps created\ndeployment/apps/frontend configured\ndeployment/apps/backend replaced\n"
+	if changeSet := results.changeSet(); changeSet != expected {
+		t.Errorf("expected change set %q, got %q", expected, changeSet)
+	}
+
+	summary := newApplySummary("main/abc", results, pruned)
+	if summary.Created != 1 || summary.Configured != 2 || summary.Unchanged != 2 || summary.Deleted != 2 {
+		t.Errorf("unexpected counts %+v", summary)
+	}
+	if summary.Summary != "1 created, 2 configured, 2 unchanged, 2 deleted" {
+		t.Errorf("unexpected summary %s", summary.Summary)
+	}
+	kinds := []kustomizev1.KindSummary{
+		{Kind: "configmap", Deleted: 2},
+		{Kind: "deployment", Configured: 2},
+		{Kind: "namespace", Created: 1},
+	}
+	if len(summary.Kinds) != len(kinds) {
+		t.Fatalf("expected %v, got %v", kinds, summary.Kinds)
+	}
+	for i := range kinds {
+		if summary.Kinds[i] != kinds[i] {
+			t.Errorf("expected %v, got %v", kinds[i], summary.Kinds[i])
+		}
+	}
+
+	entry := newChangelogEntry(summary, time.Now())
+	if entry.Revision != "main/abc" || entry.Created != 1 || entry.Configured != 2 || entry.Deleted != 2 {
+		t.Errorf("unexpected changelog entry %+v", entry)
+	}
+}
+
+func TestApplyResultsOutput(t *testing.T) {
+	results := applyResults{
+		{ID: "namespace//apps", Action: createdAction},
+		{ID: "deployment/apps/frontend", Action: configuredAction},
+		{ID: "service/apps/backend", Action: unchangedAction},
+		{ID: "secret/apps/token", Action: skippedAction},
+	}
+
+	changed := map[string]string{
+		"namespace//apps":          createdAction,
+		"deployment/apps/frontend": configuredAction,
+	}
+	if output := results.output(false); !reflect.DeepEqual(output, changed) {
+		t.Errorf("expected output %v, got %v", changed, output)
+	}
+	if output := results.output(true); len(output) != len(results) {
+		t.Errorf("expected all the objects in the verbose output, got %v", output)
+	}
+
+	counts := map[string]int{createdAction: 1, configuredAction: 1, unchangedAction: 1, skippedAction: 1}
+	if c := results.actionCounts(); !reflect.DeepEqual(c, counts) {
+		t.Errorf("expected counts %v, got %v", counts, c)
+	}
+}
+
+func TestChangeSetLines(t *testing.T) {
+	if lines := changeSetLines(""); lines != nil {
+		t.Errorf("expected no lines for an empty change set, got %v", lines)
+	}
+	expected := []string{"configmap/apps/legacy deleted", "ConfigMap/apps/old marked for deletion"}
+	if lines := changeSetLines("configmap/apps/legacy deleted\nConfigMap/apps/old marked for deletion\n"); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected lines %v, got %v", expected, lines)
+	}
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer records the spans of the reconciliations, the spans are discarded
+// unless a tracer provider is registered at startup.
+var tracer = otel.Tracer("github.com/fluxcd/kustomize-controller")
+
+// startSpan starts the span of a reconciliation phase, as a child
+// of the span of the reconciliation held by ctx.
+func startSpan(ctx context.Context, phase string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, phase, trace.WithAttributes(attrs...))
+}
+
+// endSpan records the error of the phase, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	setSpanError(span, err)
+	span.End()
+}
+
+// setSpanError marks the span as failed with the given error, if any.
+func setSpanError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestPhaseSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	ctx, root := startSpan(context.TODO(), "reconcile")
+	_, build := startSpan(ctx, "build")
+	endSpan(build, nil)
+	_, apply := startSpan(ctx, "apply")
+	endSpan(apply, errors.New("apply failed"))
+	root.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+	for _, span := range spans[:2] {
+		if span.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("expected span %s to be a child of the reconcile span", span.Name())
+		}
+	}
+	if status := spans[0].Status(); status.Code != codes.Unset {
+		t.Errorf("expected unset status for the build span, got %v", status)
+	}
+	if status := spans[1].Status(); status.Code != codes.Error || status.Description != "apply failed" {
+		t.Errorf("expected error status for the apply span, got %v", status)
+	}
+	if events := spans[1].Events(); len(events) != 1 || events[0].Name != "exception" {
+		t.Errorf("expected the error to be recorded on the apply span, got %v", events)
+	}
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/cli-utils/pkg/kstatus/status"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// dependsOnAnnotation is the annotation used to order the apply of the
+// objects of the same build, its value is a comma separated list of
+// object references in the format '<kind>/<namespace>/<name>', or
+// '<kind>/<name>' for cluster-scoped objects.
+var dependsOnAnnotation = fmt.Sprintf("%s/depends-on", kustomizev1.GroupVersion.Group)
+
+// applyWave holds the objects of a stage that are applied together,
+// after their dependencies are ready.
+type applyWave struct {
+	// Objects holds the objects of the wave, in the build order.
+	Objects []*unstructured.Unstructured
+	// Dependencies holds the objects the wave depends on.
+	Dependencies []*unstructured.Unstructured
+}
+
+// newApplyWaves splits the objects of a stage into waves based on their
+// depends-on annotations. An object is applied in the wave following the
+// waves of its dependencies from the same stage. The dependencies can also
+// refer to the objects of the previous stages, given as applied.
+// The dependencies on objects that are not part of the build, or on objects
+// of the later stages, and the circular dependencies are rejected.
+func newApplyWaves(objects, applied []*unstructured.Unstructured) ([]applyWave, error) {
+	index := make(map[string]int)
+	for i, obj := range objects {
+		index[objectID(obj)] = i
+	}
+	previous := make(map[string]*unstructured.Unstructured)
+	for _, obj := range applied {
+		previous[objectID(obj)] = obj
+	}
+
+	deps := make([][]string, len(objects))
+	for i, obj := range objects {
+		for _, ref := range dependsOn(obj) {
+			_, inStage := index[ref]
+			if !inStage && previous[ref] == nil {
+				return nil, fmt.Errorf("%s depends on '%s' which is not part of the build or is applied in a later stage",
+					objectID(obj), ref)
+			}
+			deps[i] = append(deps[i], ref)
+		}
+	}
+
+	// the wave of an object is the length of its longest dependency chain
+	levels := make([]int, len(objects))
+	visiting := make([]bool, len(objects))
+	visited := make([]bool, len(objects))
+	var visit func(i int) error
+	visit = func(i int) error {
+		if visited[i] {
+			return nil
+		}
+		if visiting[i] {
+			return fmt.Errorf("circular dependency detected for %s", objectID(objects[i]))
+		}
+		visiting[i] = true
+		for _, ref := range deps[i] {
+			j, inStage := index[ref]
+			if !inStage {
+				continue
+			}
+			if err := visit(j); err != nil {
+				return err
+			}
+			if levels[j]+1 > levels[i] {
+				levels[i] = levels[j] + 1
+			}
+		}
+		visiting[i] = false
+		visited[i] = true
+		return nil
+	}
+
+	total := 0
+	for i := range objects {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+		if levels[i]+1 > total {
+			total = levels[i] + 1
+		}
+	}
+
+	waves := make([]applyWave, total)
+	seen := make([]map[string]bool, total)
+	for i, obj := range objects {
+		wave := &waves[levels[i]]
+		wave.Objects = append(wave.Objects, obj)
+		if seen[levels[i]] == nil {
+			seen[levels[i]] = make(map[string]bool)
+		}
+		for _, ref := range deps[i] {
+			if seen[levels[i]][ref] {
+				continue
+			}
+			seen[levels[i]][ref] = true
+			if j, inStage := index[ref]; inStage {
+				wave.Dependencies = append(wave.Dependencies, objects[j])
+			} else {
+				wave.Dependencies = append(wave.Dependencies, previous[ref])
+			}
+		}
+	}
+	return waves, nil
+}
+
+// dependsOn returns the object references of the depends-on annotation,
+// with the kinds converted to lowercase to match the object IDs.
+func dependsOn(obj *unstructured.Unstructured) []string {
+	value := obj.GetAnnotations()[dependsOnAnnotation]
+	if value == "" {
+		return nil
+	}
+	var refs []string
+	for _, ref := range strings.Split(value, ",") {
+		ref = strings.TrimSpace(ref)
+		if ref == "" {
+			continue
+		}
+		parts := strings.SplitN(ref, "/", 2)
+		parts[0] = strings.ToLower(parts[0])
+		refs = append(refs, strings.Join(parts, "/"))
+	}
+	return refs
+}
+
+// waitForObjects blocks until all the given objects are ready,
+// or the timeout expires.
+func waitForObjects(ctx context.Context, kubeClient client.Client, objects []*unstructured.Unstructured, timeout time.Duration) error {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if pending, err := pollObjects(waitCtx, kubeClient, objects); err != nil {
+		return fmt.Errorf("dependency %s is not ready: %w", pending, err)
+	}
+	return nil
+}
+
+// pollObjects blocks until all the given objects are ready according to
+// kstatus, and the Jobs completed, or the context is done. It returns
+// the ID of the first object that is not ready along with the error.
+func pollObjects(ctx context.Context, kubeClient client.Client, objects []*unstructured.Unstructured) (string, error) {
+	pending := ""
+	err := wait.PollImmediateUntil(stagePollInterval, func() (bool, error) {
+		for _, obj := range objects {
+			pending = objectID(obj)
+			existing := &unstructured.Unstructured{}
+			existing.SetGroupVersionKind(obj.GroupVersionKind())
+			if err := kubeClient.Get(ctx, client.ObjectKeyFromObject(obj), existing); err != nil {
+				return false, err
+			}
+			result, err := readiness(existing)
+			if err != nil {
+				return false, err
+			}
+			if result != status.CurrentStatus {
+				pending = fmt.Sprintf("%s (status '%s')", pending, result)
+				return false, nil
+			}
+		}
+		return true, nil
+	}, ctx.Done())
+	return pending, err
+}
+
+// readiness returns the kstatus of the object, and the
+// completion status assessed by jobCompletionHealth for the Jobs.
+func readiness(obj *unstructured.Unstructured) (status.Status, error) {
+	if obj.GroupVersionKind().GroupKind() == jobGroupKind {
+		return jobCompletionHealth{}.evaluate(obj)
+	}
+	res, err := status.Compute(obj)
+	if err != nil {
+		return status.UnknownStatus, err
+	}
+	return res.Status, nil
+}
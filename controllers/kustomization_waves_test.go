@@ -0,0 +1,107 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestNewApplyWaves(t *testing.T) {
+	objects, err := readObjects([]byte(`---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: test
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migration
+  namespace: test
+  annotations:
+    kustomize.toolkit.fluxcd.io/depends-on: StatefulSet/test/db, Namespace/test
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+  namespace: test
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: test
+  annotations:
+    kustomize.toolkit.fluxcd.io/depends-on: Job/test/migration
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+  namespace: test
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	waves, err := newApplyWaves(objects[1:], objects[:1])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(waves) != 3 {
+		t.Fatalf("expected 3 waves, got %v", len(waves))
+	}
+
+	expected := []struct {
+		objects      []string
+		dependencies []string
+	}{
+		{objects: []string{"statefulset/test/db", "service/test/app"}},
+		{objects: []string{"job/test/migration"}, dependencies: []string{"statefulset/test/db", "namespace/test"}},
+		{objects: []string{"deployment/test/app"}, dependencies: []string{"job/test/migration"}},
+	}
+	for i, e := range expected {
+		if got := objectIDs(waves[i].Objects); strings.Join(got, ",") != strings.Join(e.objects, ",") {
+			t.Errorf("wave %d: expected objects %v, got %v", i, e.objects, got)
+		}
+		if got := objectIDs(waves[i].Dependencies); strings.Join(got, ",") != strings.Join(e.dependencies, ",") {
+			t.Errorf("wave %d: expected dependencies %v, got %v", i, e.dependencies, got)
+		}
+	}
+
+	// the objects of the previous stages must be passed as applied
+	if _, err := newApplyWaves(objects[1:], nil); err == nil {
+		t.Error("expected error for a dependency that is not part of the stage")
+	}
+
+	objects[2].SetAnnotations(map[string]string{dependsOnAnnotation: "Deployment/test/app"})
+	if _, err := newApplyWaves(objects[1:], objects[:1]); err == nil || !strings.Contains(err.Error(), "circular") {
+		t.Errorf("expected circular dependency error, got %v", err)
+	}
+}
+
+func objectIDs(objects []*unstructured.Unstructured) []string {
+	var ids []string
+	for _, obj := range objects {
+		ids = append(ids, objectID(obj))
+	}
+	return ids
+}
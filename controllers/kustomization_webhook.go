@@ -0,0 +1,213 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+	admissionv1 "k8s.io/api/admission/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+	"github.com/fluxcd/kustomize-controller/pkg/validation"
+)
+
+// KustomizationValidatorPath is the path the validating webhook is served at.
+const KustomizationValidatorPath = "/validate-kustomize-toolkit-fluxcd-io-v1beta1-kustomization"
+
+// +kubebuilder:webhook:path=/validate-kustomize-toolkit-fluxcd-io-v1beta1-kustomization,mutating=false,failurePolicy=fail,sideEffects=None,groups=kustomize.toolkit.fluxcd.io,resources=kustomizations,verbs=create;update,versions=v1beta1,name=vkustomization.kustomize.toolkit.fluxcd.io,admissionReviewVersions={v1,v1beta1}
+
+// KustomizationValidator rejects the Kustomizations with specs that
+// can't be reconciled, at admission time instead of at the first reconciliation.
+type KustomizationValidator struct{}
+
+// SetupWebhookWithManager registers the validating webhook with the webhook server of the manager.
+func (v *KustomizationValidator) SetupWebhookWithManager(mgr ctrl.Manager) {
+	mgr.GetWebhookServer().Register(KustomizationValidatorPath, &webhook.Admission{Handler: v})
+}
+
+// Handle decodes the Kustomization of the admission request and validates its spec.
+// The Kustomizations under deletion and the updates that don't change the spec,
+// such as the finalizer changes of the controller, are allowed, so that the objects
+// created before a validation rule was introduced can still be finalized.
+func (v *KustomizationValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var kustomization kustomizev1.Kustomization
+	if err := json.Unmarshal(req.Object.Raw, &kustomization); err != nil {
+		return admission.Denied(fmt.Sprintf("invalid Kustomization: %v", err))
+	}
+	if kustomization.DeletionTimestamp != nil {
+		return admission.Allowed("")
+	}
+	if req.Operation == admissionv1.Update && len(req.OldObject.Raw) > 0 {
+		var old kustomizev1.Kustomization
+		if err := json.Unmarshal(req.OldObject.Raw, &old); err == nil &&
+			apiequality.Semantic.DeepEqual(old.Spec, kustomization.Spec) {
+			return admission.Allowed("")
+		}
+	}
+	if errs := validateKustomization(kustomization); len(errs) > 0 {
+		return admission.Denied(errs.ToAggregate().Error())
+	}
+	return admission.Allowed("")
+}
+
+// validateKustomization returns the errors of the spec fields that are invalid,
+// or that conflict with each other.
+func validateKustomization(kustomization kustomizev1.Kustomization) field.ErrorList {
+	var errs field.ErrorList
+	spec := kustomization.Spec
+	specPath := field.NewPath("spec")
+
+	if spec.Interval.Duration <= 0 {
+		errs = append(errs, field.Invalid(specPath.Child("interval"), spec.Interval.Duration.String(), "must be greater than zero"))
+	}
+	for _, d := range []struct {
+		name     string
+		duration *metav1.Duration
+	}{
+		{"retryInterval", spec.RetryInterval},
+		{"timeout", spec.Timeout},
+		{"dependencyTimeout", spec.DependencyTimeout},
+		{"progressDeadline", spec.ProgressDeadline},
+		{"driftCheckInterval", spec.DriftCheckInterval},
+	} {
+		if d.duration != nil && d.duration.Duration <= 0 {
+			errs = append(errs, field.Invalid(specPath.Child(d.name), d.duration.Duration.String(), "must be greater than zero"))
+		}
+	}
+	if d := spec.DriftCheckInterval; d != nil && d.Duration >= spec.Interval.Duration && spec.Interval.Duration > 0 {
+		errs = append(errs, field.Invalid(specPath.Child("driftCheckInterval"), d.Duration.String(), "must be shorter than the interval"))
+	}
+
+	sourcePath := specPath.Child("sourceRef")
+	switch spec.SourceRef.Kind {
+	case sourcev1.GitRepositoryKind, sourcev1.BucketKind:
+	default:
+		errs = append(errs, field.NotSupported(sourcePath.Child("kind"), spec.SourceRef.Kind,
+			[]string{sourcev1.GitRepositoryKind, sourcev1.BucketKind}))
+	}
+	if spec.SourceRef.Name == "" {
+		errs = append(errs, field.Required(sourcePath.Child("name"), ""))
+	}
+
+	for i, dep := range spec.DependsOn {
+		depPath := specPath.Child("dependsOn").Index(i)
+		if !dep.IsKustomization() && dependencyAPIVersion(dep) == "" {
+			errs = append(errs, field.Required(depPath.Child("apiVersion"), "required for the dependencies of kind "+dep.Kind))
+		}
+		if !dep.IsKustomization() && dep.MatchRevision {
+			errs = append(errs, field.Forbidden(depPath.Child("matchRevision"), "only applies to the dependencies of kind Kustomization"))
+		}
+		if dep.IsKustomization() && (dep.ReadyCondition != "" || dep.ReadyExpr != "") {
+			errs = append(errs, field.Forbidden(depPath, "readyCondition and readyExpr don't apply to the dependencies of kind Kustomization"))
+		}
+		if dep.ReadyExpr != "" {
+			if _, err := compileReadyExpr(dep.ReadyExpr); err != nil {
+				errs = append(errs, field.Invalid(depPath.Child("readyExpr"), dep.ReadyExpr, err.Error()))
+			}
+		}
+		if dep.Timeout != nil && dep.Timeout.Duration <= 0 {
+			errs = append(errs, field.Invalid(depPath.Child("timeout"), dep.Timeout.Duration.String(), "must be greater than zero"))
+		}
+		if dep.OnTimeout != "" && dep.Timeout == nil && spec.DependencyTimeout == nil {
+			errs = append(errs, field.Required(depPath.Child("timeout"), "required when onTimeout is set, unless spec.dependencyTimeout is set"))
+		}
+		namespace := dep.Namespace
+		if namespace == "" {
+			namespace = kustomization.GetNamespace()
+		}
+		if dep.IsKustomization() && dep.Name == kustomization.GetName() && namespace == kustomization.GetNamespace() {
+			errs = append(errs, field.Invalid(depPath, dep.String(), "a Kustomization can't depend on itself"))
+		}
+	}
+
+	if spec.CreateNamespace && spec.TargetNamespace == "" {
+		errs = append(errs, field.Required(specPath.Child("targetNamespace"), "required when createNamespace is enabled"))
+	}
+	if spec.KubeConfig != nil && len(spec.Clusters) > 0 {
+		errs = append(errs, field.Forbidden(specPath.Child("kubeConfig"), "conflicts with spec.clusters"))
+	}
+	if len(spec.Clusters) > 0 {
+		for _, name := range clustersUnsupportedFields(spec) {
+			errs = append(errs, field.Forbidden(specPath.Child(name), "not supported with spec.clusters"))
+		}
+	}
+	for i, cluster := range spec.Clusters {
+		clusterPath := specPath.Child("clusters").Index(i)
+		switch {
+		case cluster.SecretRef == nil && cluster.SecretSelector == nil:
+			errs = append(errs, field.Required(clusterPath, "one of secretRef or secretSelector must be set"))
+		case cluster.SecretRef != nil && cluster.SecretSelector != nil:
+			errs = append(errs, field.Forbidden(clusterPath, "secretRef and secretSelector are mutually exclusive"))
+		case cluster.SecretSelector != nil && len(cluster.SecretSelector.MatchLabels) == 0 &&
+			len(cluster.SecretSelector.MatchExpressions) == 0:
+			errs = append(errs, field.Invalid(clusterPath.Child("secretSelector"), "{}", "selects all the secrets in the namespace"))
+		}
+		if _, err := metav1.LabelSelectorAsSelector(cluster.SecretSelector); err != nil {
+			errs = append(errs, field.Invalid(clusterPath.Child("secretSelector"), cluster.SecretSelector, err.Error()))
+		}
+	}
+
+	if spec.Schedule != nil {
+		schedulePath := specPath.Child("schedule")
+		if len(spec.Schedule.Windows) == 0 {
+			errs = append(errs, field.Required(schedulePath.Child("windows"), ""))
+		}
+		if _, err := parseSchedule(*spec.Schedule); err != nil {
+			errs = append(errs, field.Invalid(schedulePath, spec.Schedule, err.Error()))
+		}
+	}
+	if cs := spec.CommitStatus; cs != nil {
+		commitStatusPath := specPath.Child("commitStatus")
+		if spec.SourceRef.Kind != sourcev1.GitRepositoryKind {
+			errs = append(errs, field.Forbidden(commitStatusPath, "only applies to the GitRepository sources"))
+		}
+		if cs.Address != "" {
+			if _, err := parseRepository(cs.Address); err != nil {
+				errs = append(errs, field.Invalid(commitStatusPath.Child("address"), cs.Address, err.Error()))
+			}
+		}
+	}
+	if spec.Atomic && spec.Validation != "" && spec.Validation != validation.ServerMode {
+		errs = append(errs, field.Invalid(specPath.Child("validation"), spec.Validation, "must be 'server' when atomic is enabled"))
+	}
+	if spec.Mode == kustomizev1.DiffOnlyMode && spec.Rollback {
+		errs = append(errs, field.Forbidden(specPath.Child("rollback"), "can't be enabled in DiffOnly mode"))
+	}
+	for _, kind := range spec.PruneEnabledFor {
+		for _, disabled := range spec.PruneDisabledFor {
+			if kind == disabled {
+				errs = append(errs, field.Invalid(specPath.Child("pruneEnabledFor"), kind, "conflicts with spec.pruneDisabledFor"))
+			}
+		}
+	}
+	if spec.MaxDelta != nil {
+		if _, err := intstr.GetScaledValueFromIntOrPercent(spec.MaxDelta, 100, false); err != nil {
+			errs = append(errs, field.Invalid(specPath.Child("maxDelta"), spec.MaxDelta.String(), err.Error()))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestValidateKustomization(t *testing.T) {
+	valid := func() kustomizev1.Kustomization {
+		return kustomizev1.Kustomization{
+			ObjectMeta: metav1.ObjectMeta{Name: "apps", Namespace: "dev"},
+			Spec: kustomizev1.KustomizationSpec{
+				Interval:  metav1.Duration{Duration: 5 * time.Minute},
+				SourceRef: kustomizev1.CrossNamespaceSourceReference{Kind: "GitRepository", Name: "apps"},
+			},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		mutate  func(k *kustomizev1.Kustomization)
+		wantErr string
+	}{
+		{name: "valid", mutate: func(k *kustomizev1.Kustomization) {}},
+		{
+			name:    "zero interval",
+			mutate:  func(k *kustomizev1.Kustomization) { k.Spec.Interval.Duration = 0 },
+			wantErr: "spec.interval",
+		},
+		{
+			name:    "negative timeout",
+			mutate:  func(k *kustomizev1.Kustomization) { k.Spec.Timeout = &metav1.Duration{Duration: -time.Second} },
+			wantErr: "spec.timeout",
+		},
+		{
+			name: "drift check longer than interval",
+			mutate: func(k *kustomizev1.Kustomization) {
+				k.Spec.DriftCheckInterval = &metav1.Duration{Duration: 10 * time.Minute}
+			},
+			wantErr: "spec.driftCheckInterval",
+		},
+		{
+			name:    "unknown source kind",
+			mutate:  func(k *kustomizev1.Kustomization) { k.Spec.SourceRef.Kind = "HelmRepository" },
+			wantErr: "spec.sourceRef.kind",
+		},
+		{
+			name: "ready expression on a Kustomization dependency",
+			mutate: func(k *kustomizev1.Kustomization) {
+				k.Spec.DependsOn = []kustomizev1.DependencyReference{{Name: "infra", ReadyExpr: "true"}}
+			},
+			wantErr: "spec.dependsOn[0]",
+		},
+		{
+			name: "invalid ready expression",
+			mutate: func(k *kustomizev1.Kustomization) {
+				k.Spec.DependsOn = []kustomizev1.DependencyReference{
+					{APIVersion: "cert-manager.io/v1", Kind: "ClusterIssuer", Name: "letsencrypt", ReadyExpr: "status.acme ==="},
+				}
+			},
+			wantErr: "spec.dependsOn[0].readyExpr",
+		},
+		{
+			name: "dependency timeout policy without timeout",
+			mutate: func(k *kustomizev1.Kustomization) {
+				k.Spec.DependsOn = []kustomizev1.DependencyReference{{Name: "infra", OnTimeout: kustomizev1.ProceedDependencyPolicy}}
+			},
+			wantErr: "spec.dependsOn[0].timeout",
+		},
+		{
+			name: "invalid schedule cron",
+			mutate: func(k *kustomizev1.Kustomization) {
+				k.Spec.Schedule = &kustomizev1.Schedule{Windows: []kustomizev1.ScheduleWindow{
+					{Cron: "0 22 * *", Duration: metav1.Duration{Duration: time.Hour}},
+				}}
+			},
+			wantErr: "spec.schedule",
+		},
+		{
+			name: "commit status for a bucket",
+			mutate: func(k *kustomizev1.Kustomization) {
+				k.Spec.SourceRef.Kind = "Bucket"
+				k.Spec.CommitStatus = &kustomizev1.CommitStatus{Provider: kustomizev1.GitHubProvider}
+			},
+			wantErr: "spec.commitStatus",
+		},
+		{
+			name: "invalid commit status address",
+			mutate: func(k *kustomizev1.Kustomization) {
+				k.Spec.CommitStatus = &kustomizev1.CommitStatus{Provider: kustomizev1.GitHubProvider, Address: "https://github.com/repo"}
+			},
+			wantErr: "spec.commitStatus.address",
+		},
+		{
+			name: "atomic without server validation",
+			mutate: func(k *kustomizev1.Kustomization) {
+				k.Spec.Atomic = true
+				k.Spec.Validation = "client"
+			},
+			wantErr: "spec.validation",
+		},
+		{
+			name: "self dependency",
+			mutate: func(k *kustomizev1.Kustomization) {
+				k.Spec.DependsOn = []kustomizev1.DependencyReference{{Name: "apps"}}
+			},
+			wantErr: "spec.dependsOn[0]",
+		},
+		{
+			name:    "create namespace without target",
+			mutate:  func(k *kustomizev1.Kustomization) { k.Spec.CreateNamespace = true },
+			wantErr: "spec.targetNamespace",
+		},
+		{
+			name: "selector matching all secrets",
+			mutate: func(k *kustomizev1.Kustomization) {
+				k.Spec.Clusters = []kustomizev1.ClusterTarget{{SecretSelector: &metav1.LabelSelector{}}}
+			},
+			wantErr: "spec.clusters[0].secretSelector",
+		},
+		{
+			name: "kubeconfig and clusters",
+			mutate: func(k *kustomizev1.Kustomization) {
+				k.Spec.KubeConfig = &kustomizev1.KubeConfig{SecretRef: meta.LocalObjectReference{Name: "prod"}}
+				k.Spec.Clusters = []kustomizev1.ClusterTarget{{SecretRef: &meta.LocalObjectReference{Name: "prod"}}}
+			},
+			wantErr: "spec.kubeConfig",
+		},
+		{
+			name: "hooks and clusters",
+			mutate: func(k *kustomizev1.Kustomization) {
+				k.Spec.Hooks = &kustomizev1.Hooks{}
+				k.Spec.Clusters = []kustomizev1.ClusterTarget{{SecretRef: &meta.LocalObjectReference{Name: "prod"}}}
+			},
+			wantErr: "spec.hooks",
+		},
+		{
+			name: "rollback and clusters",
+			mutate: func(k *kustomizev1.Kustomization) {
+				k.Spec.Rollback = true
+				k.Spec.Clusters = []kustomizev1.ClusterTarget{{SecretRef: &meta.LocalObjectReference{Name: "prod"}}}
+			},
+			wantErr: "spec.rollback",
+		},
+		{
+			name: "prune enabled and disabled",
+			mutate: func(k *kustomizev1.Kustomization) {
+				k.Spec.PruneEnabledFor = []string{"Namespace"}
+				k.Spec.PruneDisabledFor = []string{"Namespace"}
+			},
+			wantErr: "spec.pruneEnabledFor",
+		},
+		{
+			name: "invalid max delta",
+			mutate: func(k *kustomizev1.Kustomization) {
+				maxDelta := intstr.FromString("ten")
+				k.Spec.MaxDelta = &maxDelta
+			},
+			wantErr: "spec.maxDelta",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := valid()
+			tt.mutate(&k)
+			errs := validateKustomization(k)
+			if tt.wantErr == "" {
+				if len(errs) > 0 {
+					t.Fatalf("unexpected errors: %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || errs[0].Field != tt.wantErr {
+				t.Errorf("expected an error for %s, got %v", tt.wantErr, errs)
+			}
+		})
+	}
+}
+
+func TestKustomizationValidatorHandle(t *testing.T) {
+	v := &KustomizationValidator{}
+	request := func(raw string) admission.Request {
+		return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Object: runtime.RawExtension{Raw: []byte(raw)},
+		}}
+	}
+
+	resp := v.Handle(context.TODO(), request(`{"spec":{"interval":"5x","sourceRef":{"kind":"GitRepository","name":"apps"}}}`))
+	if resp.Allowed || !strings.Contains(string(resp.Result.Reason), `duration "5x"`) {
+		t.Errorf("expected the invalid duration to be denied, got %+v", resp.Result)
+	}
+
+	resp = v.Handle(context.TODO(), request(`{"spec":{"interval":"5m","sourceRef":{"kind":"Bucket","name":"apps"}}}`))
+	if !resp.Allowed {
+		t.Errorf("expected the Kustomization to be allowed, got %+v", resp.Result)
+	}
+
+	// the Kustomizations under deletion can be finalized
+	invalid := `{"metadata":{%s"finalizers":[%s]},"spec":{"interval":"0s","sourceRef":{"kind":"GitRepository","name":"apps"}}}`
+	resp = v.Handle(context.TODO(), request(fmt.Sprintf(invalid, `"deletionTimestamp":"2021-06-01T00:00:00Z",`, "")))
+	if !resp.Allowed {
+		t.Errorf("expected the Kustomization under deletion to be allowed, got %+v", resp.Result)
+	}
+
+	// the updates that don't change the spec are allowed
+	update := request(fmt.Sprintf(invalid, "", `"finalizers.fluxcd.io"`))
+	update.Operation = admissionv1.Update
+	update.OldObject = runtime.RawExtension{Raw: []byte(fmt.Sprintf(invalid, "", ""))}
+	resp = v.Handle(context.TODO(), update)
+	if !resp.Allowed {
+		t.Errorf("expected the finalizer update to be allowed, got %+v", resp.Result)
+	}
+
+	update.OldObject = runtime.RawExtension{Raw: []byte(`{"spec":{"interval":"5m","sourceRef":{"kind":"GitRepository","name":"apps"}}}`)}
+	resp = v.Handle(context.TODO(), update)
+	if resp.Allowed {
+		t.Errorf("expected the invalid spec change to be denied")
+	}
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// +kubebuilder:rbac:groups=kustomize.toolkit.fluxcd.io,resources=kustomizationstatusreports,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=kustomize.toolkit.fluxcd.io,resources=kustomizationstatusreports/status,verbs=get;update;patch
+
+// reportMessageLength is the maximum length of the
+// condition messages recorded in the status reports.
+const reportMessageLength = 1024
+
+// reportMaxEntries is the maximum number of Kustomizations listed in a
+// status report, chosen to keep the report under the object size limit.
+const reportMaxEntries = 250
+
+// KustomizationStatusReportReconciler periodically aggregates the status
+// of the Kustomizations in a KustomizationStatusReport per namespace.
+type KustomizationStatusReportReconciler struct {
+	client.Client
+	interval time.Duration
+}
+
+type KustomizationStatusReportReconcilerOptions struct {
+	Interval time.Duration
+}
+
+func (r *KustomizationStatusReportReconciler) SetupWithManager(mgr ctrl.Manager, opts KustomizationStatusReportReconcilerOptions) error {
+	if opts.Interval <= 0 {
+		return fmt.Errorf("invalid status report interval '%s'", opts.Interval)
+	}
+	r.interval = opts.Interval
+	return mgr.Add(manager.RunnableFunc(r.run))
+}
+
+func (r *KustomizationStatusReportReconciler) run(ctx context.Context) error {
+	log := ctrl.Log.WithName("controllers").WithName(kustomizev1.KustomizationStatusReportKind)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		if err := r.reconcile(ctx); err != nil {
+			log.Error(err, "unable to update the status reports")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *KustomizationStatusReportReconciler) reconcile(ctx context.Context) error {
+	var list kustomizev1.KustomizationList
+	if err := r.List(ctx, &list); err != nil {
+		return fmt.Errorf("unable to list Kustomizations: %w", err)
+	}
+
+	namespaces := make(map[string][]kustomizev1.Kustomization)
+	for _, k := range list.Items {
+		namespaces[k.Namespace] = append(namespaces[k.Namespace], k)
+	}
+
+	// a failing namespace doesn't prevent the update of the others
+	var errs []error
+	for namespace, kustomizations := range namespaces {
+		if err := r.updateReport(ctx, namespace, kustomizations); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// remove the reports from the namespaces without Kustomizations
+	var reports kustomizev1.KustomizationStatusReportList
+	if err := r.List(ctx, &reports); err != nil {
+		errs = append(errs, fmt.Errorf("unable to list KustomizationStatusReports: %w", err))
+		return kerrors.NewAggregate(errs)
+	}
+	for _, report := range reports.Items {
+		if _, ok := namespaces[report.Namespace]; ok || report.Name != kustomizev1.KustomizationStatusReportName {
+			continue
+		}
+		if err := r.Delete(ctx, &report); client.IgnoreNotFound(err) != nil {
+			errs = append(errs, fmt.Errorf("unable to delete KustomizationStatusReport '%s/%s': %w", report.Namespace, report.Name, err))
+		}
+	}
+
+	return kerrors.NewAggregate(errs)
+}
+
+func (r *KustomizationStatusReportReconciler) updateReport(ctx context.Context, namespace string, kustomizations []kustomizev1.Kustomization) error {
+	report := &kustomizev1.KustomizationStatusReport{}
+	key := types.NamespacedName{Namespace: namespace, Name: kustomizev1.KustomizationStatusReportName}
+	if err := r.Get(ctx, key, report); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		report.SetName(key.Name)
+		report.SetNamespace(key.Namespace)
+		if err := r.Create(ctx, report); err != nil {
+			return fmt.Errorf("unable to create KustomizationStatusReport '%s': %w", key.String(), err)
+		}
+	}
+
+	report.Status = newStatusReport(kustomizations, report.Status.Kustomizations, metav1.Now())
+	if err := r.Status().Update(ctx, report); err != nil {
+		return fmt.Errorf("unable to update KustomizationStatusReport '%s': %w", key.String(), err)
+	}
+	return nil
+}
+
+// newStatusReport summarizes the status of the given Kustomizations.
+// The last failure of each Kustomization is carried over from the
+// previous report, unless the Kustomization is currently failing.
+// At most reportMaxEntries Kustomizations are listed, the ones
+// that are not ready first, while the counters cover all of them.
+func newStatusReport(kustomizations []kustomizev1.Kustomization, previous []kustomizev1.KustomizationStatusSummary, now metav1.Time) kustomizev1.KustomizationStatusReportStatus {
+	failures := make(map[string]*kustomizev1.KustomizationFailure)
+	for _, summary := range previous {
+		failures[summary.Name] = summary.LastFailure
+	}
+
+	status := kustomizev1.KustomizationStatusReportStatus{
+		LastUpdateTime: &now,
+		Total:          len(kustomizations),
+	}
+
+	for _, k := range kustomizations {
+		summary := kustomizev1.KustomizationStatusSummary{
+			Name:                  k.Name,
+			Ready:                 metav1.ConditionUnknown,
+			Suspended:             k.Spec.Suspend,
+			LastAppliedRevision:   k.Status.LastAppliedRevision,
+			LastAttemptedRevision: k.Status.LastAttemptedRevision,
+			LastFailure:           failures[k.Name],
+		}
+
+		if c := apimeta.FindStatusCondition(k.Status.Conditions, meta.ReadyCondition); c != nil {
+			summary.Ready = c.Status
+			summary.Reason = c.Reason
+			summary.Message = trimMessage(c.Message, reportMessageLength)
+			if c.Status == metav1.ConditionFalse {
+				summary.LastFailure = &kustomizev1.KustomizationFailure{
+					Time:     c.LastTransitionTime,
+					Reason:   c.Reason,
+					Message:  summary.Message,
+					Revision: k.Status.LastAttemptedRevision,
+				}
+			}
+		}
+
+		switch summary.Ready {
+		case metav1.ConditionTrue:
+			status.Ready++
+		case metav1.ConditionFalse:
+			status.Failing++
+		}
+		if summary.Suspended {
+			status.Suspended++
+		}
+
+		status.Kustomizations = append(status.Kustomizations, summary)
+	}
+
+	if len(status.Kustomizations) > reportMaxEntries {
+		sort.SliceStable(status.Kustomizations, func(i, j int) bool {
+			return status.Kustomizations[i].Ready != metav1.ConditionTrue &&
+				status.Kustomizations[j].Ready == metav1.ConditionTrue
+		})
+		status.Kustomizations = status.Kustomizations[:reportMaxEntries]
+	}
+
+	sort.Slice(status.Kustomizations, func(i, j int) bool {
+		return status.Kustomizations[i].Name < status.Kustomizations[j].Name
+	})
+	return status
+}
+
+func trimMessage(msg string, limit int) string {
+	if len(msg) <= limit {
+		return msg
+	}
+	return msg[:limit] + "..."
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+func TestNewStatusReport(t *testing.T) {
+	newKustomization := func(name string, status metav1.ConditionStatus, reason string) kustomizev1.Kustomization {
+		k := kustomizev1.Kustomization{}
+		k.Name = name
+		k.Status.LastAttemptedRevision = "main/" + name
+		k.Status.Conditions = []metav1.Condition{{
+			Type:    meta.ReadyCondition,
+			Status:  status,
+			Reason:  reason,
+			Message: reason,
+		}}
+		return k
+	}
+
+	previousFailure := &kustomizev1.KustomizationFailure{Reason: kustomizev1.HealthCheckFailedReason}
+	previous := []kustomizev1.KustomizationStatusSummary{
+		{Name: "backend", LastFailure: previousFailure},
+	}
+
+	suspended := newKustomization("cache", metav1.ConditionTrue, meta.ReconciliationSucceededReason)
+	suspended.Spec.Suspend = true
+
+	status := newStatusReport([]kustomizev1.Kustomization{
+		newKustomization("frontend", metav1.ConditionFalse, kustomizev1.ValidationFailedReason),
+		newKustomization("backend", metav1.ConditionTrue, meta.ReconciliationSucceededReason),
+		suspended,
+	}, previous, metav1.Now())
+
+	if status.Total != 3 || status.Ready != 2 || status.Failing != 1 || status.Suspended != 1 {
+		t.Errorf("unexpected counters %+v", status)
+	}
+
+	names := []string{"backend", "cache", "frontend"}
+	for i, summary := range status.Kustomizations {
+		if summary.Name != names[i] {
+			t.Errorf("expected %s at index %d, got %s", names[i], i, summary.Name)
+		}
+	}
+
+	if f := status.Kustomizations[0].LastFailure; f == nil || f.Reason != kustomizev1.HealthCheckFailedReason {
+		t.Errorf("expected the previous failure to be retained, got %v", f)
+	}
+	if f := status.Kustomizations[1].LastFailure; f != nil {
+		t.Errorf("expected no failure, got %v", f)
+	}
+	if f := status.Kustomizations[2].LastFailure; f == nil || f.Reason != kustomizev1.ValidationFailedReason || f.Revision != "main/frontend" {
+		t.Errorf("expected the current failure to be recorded, got %v", f)
+	}
+}
+
+func TestNewStatusReportMaxEntries(t *testing.T) {
+	var kustomizations []kustomizev1.Kustomization
+	for i := 0; i < reportMaxEntries+10; i++ {
+		k := kustomizev1.Kustomization{}
+		k.Name = fmt.Sprintf("app-%03d", i)
+		status := metav1.ConditionTrue
+		if i >= reportMaxEntries {
+			status = metav1.ConditionFalse
+		}
+		k.Status.Conditions = []metav1.Condition{{Type: meta.ReadyCondition, Status: status}}
+		kustomizations = append(kustomizations, k)
+	}
+
+	status := newStatusReport(kustomizations, nil, metav1.Now())
+	if status.Total != reportMaxEntries+10 || status.Failing != 10 {
+		t.Errorf("expected the counters to cover all the Kustomizations, got %+v", status)
+	}
+	if len(status.Kustomizations) != reportMaxEntries {
+		t.Fatalf("expected %d entries, got %d", reportMaxEntries, len(status.Kustomizations))
+	}
+	failing := 0
+	for i, summary := range status.Kustomizations {
+		if summary.Ready == metav1.ConditionFalse {
+			failing++
+		}
+		if i > 0 && status.Kustomizations[i-1].Name >= summary.Name {
+			t.Errorf("expected the entries to be sorted by name, got %s before %s", status.Kustomizations[i-1].Name, summary.Name)
+		}
+	}
+	if failing != 10 {
+		t.Errorf("expected the failing Kustomizations to be listed, got %d", failing)
+	}
+}
+
+// failingCreateClient fails the creation of the objects in the given namespace.
+type failingCreateClient struct {
+	client.Client
+	namespace string
+}
+
+func (c *failingCreateClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if obj.GetNamespace() == c.namespace {
+		return errors.New("create failed")
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func TestStatusReportReconcileErrors(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := kustomizev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	var objects []client.Object
+	for _, namespace := range []string{"apps", "broken", "infra"} {
+		objects = append(objects, &kustomizev1.Kustomization{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: namespace},
+		})
+	}
+	r := &KustomizationStatusReportReconciler{Client: &failingCreateClient{
+		Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		namespace: "broken",
+	}}
+
+	err := r.reconcile(context.TODO())
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("expected the error of the broken namespace, got %v", err)
+	}
+	for _, namespace := range []string{"apps", "infra"} {
+		report := &kustomizev1.KustomizationStatusReport{}
+		key := types.NamespacedName{Namespace: namespace, Name: kustomizev1.KustomizationStatusReportName}
+		if err := r.Get(context.TODO(), key, report); err != nil {
+			t.Errorf("expected the report of %s to be updated, got %v", namespace, err)
+			continue
+		}
+		if report.Status.Total != 1 {
+			t.Errorf("expected the report of %s to count 1 Kustomization, got %d", namespace, report.Status.Total)
+		}
+	}
+}
@@ -19,58 +19,8 @@ package controllers
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"strings"
 )
 
-// parseApplyOutput extracts the objects and the action
-// performed by kubectl e.g.:
-// service/backend created
-// service/frontend configured
-// service/database unchanged
-func parseApplyOutput(in []byte) map[string]string {
-	result := make(map[string]string)
-	input := strings.Split(string(in), "\n")
-	if len(input) == 0 {
-		return result
-	}
-	var parts []string
-	for _, str := range input {
-		if str != "" {
-			parts = append(parts, str)
-		}
-	}
-	for _, str := range parts {
-		kv := strings.Split(str, " ")
-		if len(kv) > 1 {
-			result[kv[0]] = kv[1]
-		}
-	}
-	return result
-}
-
-// parseApplyError extracts the errors from the kubectl
-// apply output by removing the successfully applied objects
-func parseApplyError(in []byte) string {
-	errors := ""
-	lines := strings.Split(string(in), "\n")
-	for _, line := range lines {
-		if line != "" &&
-			!strings.HasSuffix(line, "created") &&
-			!strings.HasSuffix(line, "created (dry run)") &&
-			!strings.HasSuffix(line, "created (server dry run)") &&
-			!strings.HasSuffix(line, "configured") &&
-			!strings.HasSuffix(line, "configured (dry run)") &&
-			!strings.HasSuffix(line, "configured (server dry run)") &&
-			!strings.HasSuffix(line, "unchanged") &&
-			!strings.HasSuffix(line, "unchanged (dry run)") &&
-			!strings.HasSuffix(line, "unchanged (server dry run)") {
-			errors += line + "\n"
-		}
-	}
-
-	return errors
-}
-
 func containsString(slice []string, s string) bool {
 	for _, item := range slice {
 		if item == s {
@@ -0,0 +1,108 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/rest"
+)
+
+// MapperCache caches the REST mappers of the controller's Kubernetes clients,
+// so that the API discovery is not performed at every reconciliation.
+// The mappers are indexed by a key identifying the client, and are created
+// again when the config of the client changes, e.g. when a token is rotated.
+type MapperCache struct {
+	options Options
+	mu      sync.Mutex
+	mappers map[string]cachedMapper
+}
+
+type cachedMapper struct {
+	checksum string
+	mapper   meta.RESTMapper
+}
+
+// NewMapperCache returns an empty cache of the REST mappers
+// created with the given Options.
+func NewMapperCache(options Options) *MapperCache {
+	return &MapperCache{
+		options: options,
+		mappers: make(map[string]cachedMapper),
+	}
+}
+
+// RESTMapper returns the REST mapper cached for the key if it was created for
+// the same config, or else creates a REST mapper for the config and caches it.
+// When the cache is nil, a new REST mapper is returned at every call.
+func (c *MapperCache) RESTMapper(key string, cfg *rest.Config) (meta.RESTMapper, error) {
+	if c == nil {
+		return Options{}.NewRESTMapper(cfg)
+	}
+	checksum := configChecksum(cfg)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, ok := c.mappers[key]; ok && cached.checksum == checksum {
+		return cached.mapper, nil
+	}
+	mapper, err := c.options.NewRESTMapper(cfg)
+	if err != nil {
+		return nil, err
+	}
+	c.mappers[key] = cachedMapper{checksum: checksum, mapper: mapper}
+	return mapper, nil
+}
+
+// Evict removes the REST mapper cached for the key, if any.
+func (c *MapperCache) Evict(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.mappers, key)
+}
+
+// configChecksum returns a checksum of the server and the credentials of the config.
+func configChecksum(cfg *rest.Config) string {
+	h := sha256.New()
+	for _, value := range []string{
+		cfg.Host,
+		cfg.APIPath,
+		cfg.BearerToken,
+		cfg.BearerTokenFile,
+		cfg.Username,
+		cfg.Password,
+		cfg.Impersonate.UserName,
+		strings.Join(cfg.Impersonate.Groups, ","),
+		cfg.TLSClientConfig.ServerName,
+		cfg.TLSClientConfig.CertFile,
+		cfg.TLSClientConfig.KeyFile,
+		cfg.TLSClientConfig.CAFile,
+		string(cfg.TLSClientConfig.CertData),
+		string(cfg.TLSClientConfig.KeyData),
+		string(cfg.TLSClientConfig.CAData),
+	} {
+		fmt.Fprintf(h, "%d:%s\n", len(value), value)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
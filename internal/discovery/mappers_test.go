@@ -0,0 +1,164 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+)
+
+// discoveryServer serves the discovery of the core group, and counts the discovery requests.
+func discoveryServer(t *testing.T) (*httptest.Server, func() int) {
+	t.Helper()
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api":
+			mu.Lock()
+			requests++
+			mu.Unlock()
+			w.Write([]byte(`{"kind":"APIVersions","versions":["v1"]}`))
+		case r.URL.Path == "/apis":
+			w.Write([]byte(`{"kind":"APIGroupList","groups":[]}`))
+		case strings.HasPrefix(r.URL.Path, "/api/v1"):
+			w.Write([]byte(`{"kind":"APIResourceList","groupVersion":"v1","resources":[` +
+				`{"name":"configmaps","namespaced":true,"kind":"ConfigMap","verbs":["get"]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func TestMapperCache(t *testing.T) {
+	server, requests := discoveryServer(t)
+	cache := NewMapperCache(Options{RefreshInterval: time.Second, Burst: 1})
+
+	cfg := &rest.Config{Host: server.URL, BearerToken: "token"}
+	first, err := cache.RESTMapper("serviceaccount/apps/reconciler", cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := first.RESTMapping(schema.GroupKind{Kind: "ConfigMap"}, "v1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	discoveries := requests()
+
+	// the mapper is reused for the same key and config
+	second, err := cache.RESTMapper("serviceaccount/apps/reconciler", &rest.Config{Host: server.URL, BearerToken: "token"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if second != first {
+		t.Error("expected the mapper to be reused")
+	}
+	if requests() != discoveries {
+		t.Errorf("expected no discovery, got %d requests", requests()-discoveries)
+	}
+
+	// a mapper is created for another key
+	other, err := cache.RESTMapper("serviceaccount/dev/reconciler", cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if other == first {
+		t.Error("expected a mapper per key")
+	}
+	if len(cache.mappers) != 2 {
+		t.Errorf("expected 2 cached mappers, got %d", len(cache.mappers))
+	}
+}
+
+func TestMapperCacheInvalidation(t *testing.T) {
+	server, _ := discoveryServer(t)
+	cache := NewMapperCache(Options{})
+	key := "serviceaccount/apps/reconciler"
+
+	first, err := cache.RESTMapper(key, &rest.Config{Host: server.URL, BearerToken: "token"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the mapper is replaced when the credentials change
+	rotated, err := cache.RESTMapper(key, &rest.Config{Host: server.URL, BearerToken: "rotated"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rotated == first {
+		t.Error("expected a new mapper for the rotated token")
+	}
+	if len(cache.mappers) != 1 {
+		t.Errorf("expected the mapper to be replaced, got %d cached mappers", len(cache.mappers))
+	}
+
+	// the mapper is recreated after being evicted
+	cache.Evict(key)
+	if len(cache.mappers) != 0 {
+		t.Errorf("expected the mapper to be evicted, got %d cached mappers", len(cache.mappers))
+	}
+	recreated, err := cache.RESTMapper(key, &rest.Config{Host: server.URL, BearerToken: "rotated"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if recreated == rotated {
+		t.Error("expected a new mapper after the eviction")
+	}
+}
+
+func TestMapperCacheNil(t *testing.T) {
+	server, requests := discoveryServer(t)
+	var cache *MapperCache
+	cfg := &rest.Config{Host: server.URL}
+	for i := 0; i < 2; i++ {
+		if _, err := cache.RESTMapper("user", cfg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if requests() != 2 {
+		t.Errorf("expected a discovery per mapper without a cache, got %d", requests())
+	}
+	cache.Evict("user")
+}
+
+func TestConfigChecksum(t *testing.T) {
+	base := &rest.Config{Host: "https://cluster", BearerToken: "token"}
+	impersonated := &rest.Config{Host: "https://cluster", BearerToken: "token",
+		Impersonate: rest.ImpersonationConfig{UserName: "dev"}}
+	other := &rest.Config{Host: "https://other", BearerToken: "token"}
+	if configChecksum(base) != configChecksum(&rest.Config{Host: "https://cluster", BearerToken: "token"}) {
+		t.Error("expected the same checksum for the same config")
+	}
+	for _, cfg := range []*rest.Config{impersonated, other} {
+		if configChecksum(cfg) == configChecksum(base) {
+			t.Errorf("expected a different checksum for %s %s", cfg.Host, cfg.Impersonate.UserName)
+		}
+	}
+}
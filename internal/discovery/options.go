@@ -0,0 +1,65 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+const (
+	flagRefreshInterval = "discovery-refresh-interval"
+	flagBurst           = "discovery-burst"
+)
+
+// Options contains the configuration of the API discovery performed by
+// the REST mappers of the controller's Kubernetes clients.
+type Options struct {
+	// RefreshInterval is the minimum interval between two discovery refreshes.
+	// A refresh happens when a client encounters a kind unknown to its
+	// REST mapper, e.g. after a CRD was installed on the cluster.
+	RefreshInterval time.Duration
+
+	// Burst is the maximum number of discovery refreshes that can happen
+	// in a row, before being throttled to one per RefreshInterval.
+	Burst int
+}
+
+// BindFlags will parse the given pflag.FlagSet for discovery option flags
+// and set the Options accordingly.
+func (o *Options) BindFlags(fs *pflag.FlagSet) {
+	fs.DurationVar(&o.RefreshInterval, flagRefreshInterval, 200*time.Millisecond,
+		"The minimum interval between API discovery refreshes, triggered when an unknown kind is encountered.")
+	fs.IntVar(&o.Burst, flagBurst, 5,
+		"The maximum number of API discovery refreshes allowed in a row, before being throttled by the refresh interval.")
+}
+
+// NewRESTMapper returns a dynamic REST mapper for the given config, that
+// refreshes its discovery information at the rate defined by the Options.
+// When the Options are not set, the controller-runtime defaults are used.
+func (o Options) NewRESTMapper(cfg *rest.Config) (meta.RESTMapper, error) {
+	if o.RefreshInterval <= 0 || o.Burst <= 0 {
+		return apiutil.NewDynamicRESTMapper(cfg)
+	}
+	limiter := rate.NewLimiter(rate.Every(o.RefreshInterval), o.Burst)
+	return apiutil.NewDynamicRESTMapper(cfg, apiutil.WithLimiter(limiter))
+}
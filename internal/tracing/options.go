@@ -0,0 +1,106 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/pflag"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	flagEndpoint    = "otlp-endpoint"
+	flagInsecure    = "otlp-insecure"
+	flagSampleRatio = "tracing-sample-ratio"
+)
+
+// Options contains the configuration of the OpenTelemetry tracing
+// of the reconciliations.
+type Options struct {
+	// Endpoint is the address of the OTLP gRPC receiver to which the spans
+	// are exported, e.g. 'otel-collector.monitoring:4317'.
+	// Tracing is disabled when empty.
+	Endpoint string
+
+	// Insecure disables the TLS of the connection to the OTLP receiver.
+	Insecure bool
+
+	// SampleRatio is the fraction of the reconciliations that are traced.
+	SampleRatio float64
+}
+
+// BindFlags will parse the given pflag.FlagSet for tracing option flags
+// and set the Options accordingly.
+func (o *Options) BindFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Endpoint, flagEndpoint, "",
+		"The address of the OTLP gRPC receiver to which the traces of the reconciliations are exported, tracing is disabled when empty.")
+	fs.BoolVar(&o.Insecure, flagInsecure, false,
+		"Disable the TLS of the connection to the OTLP receiver.")
+	fs.Float64Var(&o.SampleRatio, flagSampleRatio, 1,
+		"The fraction of the reconciliations that are traced, between 0 and 1.")
+}
+
+// Setup registers the global tracer provider exporting the spans to the
+// OTLP receiver, and the W3C trace context propagator used to correlate the
+// spans with the Kubernetes API server's. The returned function flushes the
+// pending spans and must be called before exiting. When the Options have no
+// endpoint, the spans are discarded.
+func (o Options) Setup(ctx context.Context, serviceName string) (func(context.Context) error, error) {
+	if o.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	if o.SampleRatio < 0 || o.SampleRatio > 1 {
+		return nil, fmt.Errorf("invalid --%s %v, must be between 0 and 1", flagSampleRatio, o.SampleRatio)
+	}
+
+	exporterOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(o.Endpoint)}
+	if o.Insecure {
+		exporterOpts = append(exporterOpts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, exporterOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the OTLP exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(o.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown, nil
+}
+
+// WrapConfig instruments the transport of the given config, so that the
+// requests to the Kubernetes API are recorded as spans of the reconciliation
+// phase that issued them.
+func WrapConfig(cfg *rest.Config) {
+	cfg.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return otelhttp.NewTransport(rt)
+	})
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watch
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"sort"
+
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	flagNamespaces        = "watch-namespaces"
+	flagNamespaceSelector = "watch-namespace-selector"
+	flagLabelSelector     = "watch-label-selector"
+)
+
+// Options contains the configuration of the namespaces and
+// of the objects watched by the controller.
+type Options struct {
+	// Namespaces restricts the watch to the given namespaces.
+	Namespaces []string
+
+	// NamespaceSelector restricts the watch to the namespaces matching
+	// the label selector. The namespaces are selected at startup.
+	NamespaceSelector string
+
+	// LabelSelector restricts the watch of the reconciled objects
+	// to the ones matching the label selector.
+	LabelSelector string
+}
+
+// BindFlags will parse the given pflag.FlagSet for watch option flags
+// and set the Options accordingly.
+func (o *Options) BindFlags(fs *pflag.FlagSet) {
+	fs.StringSliceVar(&o.Namespaces, flagNamespaces, nil,
+		"Watch only the given comma-separated namespaces, allowing the controller to run with namespace-scoped RBAC.")
+	fs.StringVar(&o.NamespaceSelector, flagNamespaceSelector, "",
+		"Watch only the namespaces matching this label selector, e.g. 'team=team1'. The namespaces are selected at startup.")
+	fs.StringVar(&o.LabelSelector, flagLabelSelector, "",
+		"Watch only the Kustomizations matching this label selector, e.g. 'sharding.fluxcd.io/key=shard1', to split the Kustomizations between controller instances.")
+}
+
+// Validate returns an error when the namespaces are restricted together with
+// --watch-all-namespaces=false, which restricts the controller to its runtime namespace.
+func (o Options) Validate(watchAllNamespaces bool) error {
+	if !watchAllNamespaces && (len(o.Namespaces) > 0 || o.NamespaceSelector != "") {
+		return fmt.Errorf("--%s and --%s can't be used with --watch-all-namespaces=false",
+			flagNamespaces, flagNamespaceSelector)
+	}
+	return nil
+}
+
+// ResolveNamespaces returns the sorted union of the Namespaces and of the
+// namespaces matching the NamespaceSelector. An empty list means all namespaces.
+func (o Options) ResolveNamespaces(ctx context.Context, cfg *rest.Config) ([]string, error) {
+	set := make(map[string]bool)
+	for _, ns := range o.Namespaces {
+		set[ns] = true
+	}
+
+	if o.NamespaceSelector != "" {
+		if _, err := labels.Parse(o.NamespaceSelector); err != nil {
+			return nil, fmt.Errorf("invalid namespace selector: %w", err)
+		}
+		clientset, err := kubernetes.NewForConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		list, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: o.NamespaceSelector})
+		if err != nil {
+			return nil, fmt.Errorf("unable to list the namespaces matching '%s': %w", o.NamespaceSelector, err)
+		}
+		if len(list.Items) == 0 {
+			return nil, fmt.Errorf("no namespace matches '%s'", o.NamespaceSelector)
+		}
+		for _, ns := range list.Items {
+			set[ns.GetName()] = true
+		}
+	}
+
+	namespaces := make([]string, 0, len(set))
+	for ns := range set {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+// NewCache returns a cache constructor restricted to the namespaces,
+// and to the objects of the type of obj matching the LabelSelector.
+func (o Options) NewCache(namespaces []string, obj client.Object) (cache.NewCacheFunc, error) {
+	var selectors cache.SelectorsByObject
+	if o.LabelSelector != "" {
+		selector, err := labels.Parse(o.LabelSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label selector: %w", err)
+		}
+		selectors = cache.SelectorsByObject{obj: {Label: selector}}
+	}
+
+	return func(cfg *rest.Config, opts cache.Options) (cache.Cache, error) {
+		opts.SelectorsByObject = selectors
+		switch len(namespaces) {
+		case 0:
+			return cache.New(cfg, opts)
+		case 1:
+			opts.Namespace = namespaces[0]
+			return cache.New(cfg, opts)
+		default:
+			return cache.MultiNamespacedCacheBuilder(namespaces)(cfg, opts)
+		}
+	}, nil
+}
+
+// LeaderElectionID returns the id suffixed with a hash of the LabelSelector,
+// so that the controller instances watching different shards elect their own leader.
+func (o Options) LeaderElectionID(id string) string {
+	if o.LabelSelector == "" {
+		return id
+	}
+	sum := sha1.Sum([]byte(o.LabelSelector))
+	return fmt.Sprintf("%s-%x", id, sum[:8])
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+)
+
+// namespacesServer serves the namespaces matching the label selector of the list requests.
+func namespacesServer(t *testing.T, matching map[string][]string) *rest.Config {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/v1/namespaces" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		list := corev1.NamespaceList{TypeMeta: metav1.TypeMeta{Kind: "NamespaceList", APIVersion: "v1"}}
+		for _, name := range matching[req.URL.Query().Get("labelSelector")] {
+			list.Items = append(list.Items, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(list); err != nil {
+			t.Error(err)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return &rest.Config{Host: server.URL}
+}
+
+func TestResolveNamespaces(t *testing.T) {
+	cfg := namespacesServer(t, map[string][]string{
+		"team=team1": {"team1-prod", "apps", "team1-dev"},
+	})
+
+	tests := []struct {
+		name    string
+		opts    Options
+		want    []string
+		wantErr bool
+	}{
+		{name: "all namespaces", want: []string{}},
+		{name: "namespaces", opts: Options{Namespaces: []string{"infra", "apps"}}, want: []string{"apps", "infra"}},
+		{
+			name: "namespace selector",
+			opts: Options{NamespaceSelector: "team=team1"},
+			want: []string{"apps", "team1-dev", "team1-prod"},
+		},
+		{
+			name: "namespaces merged with the selector",
+			opts: Options{Namespaces: []string{"infra", "apps"}, NamespaceSelector: "team=team1"},
+			want: []string{"apps", "infra", "team1-dev", "team1-prod"},
+		},
+		{name: "no namespace matching the selector", opts: Options{NamespaceSelector: "team=team2"}, wantErr: true},
+		{name: "invalid selector", opts: Options{NamespaceSelector: "team in"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.opts.ResolveNamespaces(context.TODO(), cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResolveNamespaces() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name               string
+		opts               Options
+		watchAllNamespaces bool
+		wantErr            bool
+	}{
+		{name: "all namespaces", watchAllNamespaces: true},
+		{name: "runtime namespace"},
+		{name: "namespaces", opts: Options{Namespaces: []string{"apps"}}, watchAllNamespaces: true},
+		{name: "namespace selector", opts: Options{NamespaceSelector: "team=team1"}, watchAllNamespaces: true},
+		{name: "namespaces and runtime namespace", opts: Options{Namespaces: []string{"apps"}}, wantErr: true},
+		{name: "namespace selector and runtime namespace", opts: Options{NamespaceSelector: "team=team1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate(tt.watchAllNamespaces)
+			if tt.wantErr && err == nil {
+				t.Error("expected an error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// listServer records the list requests and serves empty lists,
+// the watch requests are held open until they are cancelled.
+type listServer struct {
+	mu       sync.Mutex
+	requests map[string]string
+}
+
+// lists returns the paths of the list requests.
+func (s *listServer) lists() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	paths := make([]string, 0, len(s.requests))
+	for p := range s.requests {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// selector returns the label selector of the list request of the path.
+func (s *listServer) selector(path string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+func (s *listServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Query().Get("watch") == "true" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-req.Context().Done()
+		return
+	}
+
+	s.mu.Lock()
+	s.requests[req.URL.Path] = req.URL.Query().Get("labelSelector")
+	s.mu.Unlock()
+
+	list := map[string]interface{}{
+		"metadata": map[string]interface{}{"resourceVersion": "1"},
+		"items":    []interface{}{},
+	}
+	switch path.Base(req.URL.Path) {
+	case "configmaps":
+		list["apiVersion"], list["kind"] = "v1", "ConfigMapList"
+	case "kustomizations":
+		list["apiVersion"], list["kind"] = kustomizev1.GroupVersion.String(), "KustomizationList"
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(list)
+}
+
+// startCache starts the cache built by newCache against a test API server,
+// and waits for the informers of objs to be synced.
+func startCache(t *testing.T, newCache cache.NewCacheFunc, objs ...client.Object) *listServer {
+	s := &listServer{requests: make(map[string]string)}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := kustomizev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{corev1.SchemeGroupVersion, kustomizev1.GroupVersion})
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+	mapper.Add(kustomizev1.GroupVersion.WithKind(kustomizev1.KustomizationKind), meta.RESTScopeNamespace)
+
+	c, err := newCache(&rest.Config{Host: server.URL}, cache.Options{Scheme: scheme, Mapper: mapper})
+	if err != nil {
+		t.Fatalf("unable to build the cache: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() {
+		if err := c.Start(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+	for _, obj := range objs {
+		if _, err := c.GetInformer(ctx, obj); err != nil {
+			t.Fatalf("unable to get the informer: %v", err)
+		}
+	}
+	if !c.WaitForCacheSync(ctx) {
+		t.Fatal("unable to sync the cache")
+	}
+	return s
+}
+
+func TestNewCacheNamespaces(t *testing.T) {
+	tests := []struct {
+		name       string
+		namespaces []string
+		want       []string
+	}{
+		{name: "all namespaces", want: []string{"/api/v1/configmaps"}},
+		{name: "one namespace", namespaces: []string{"apps"}, want: []string{"/api/v1/namespaces/apps/configmaps"}},
+		{
+			name:       "many namespaces",
+			namespaces: []string{"apps", "infra"},
+			want:       []string{"/api/v1/namespaces/apps/configmaps", "/api/v1/namespaces/infra/configmaps"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newCache, err := Options{}.NewCache(tt.namespaces, &kustomizev1.Kustomization{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			s := startCache(t, newCache, &corev1.ConfigMap{})
+			if got := s.lists(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("listed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCacheLabelSelector(t *testing.T) {
+	t.Run("invalid selector", func(t *testing.T) {
+		if _, err := (Options{LabelSelector: "shard in"}).NewCache(nil, &kustomizev1.Kustomization{}); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("selector set only on the object type", func(t *testing.T) {
+		newCache, err := Options{LabelSelector: "sharding.fluxcd.io/key=shard1"}.NewCache(nil, &kustomizev1.Kustomization{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		s := startCache(t, newCache, &kustomizev1.Kustomization{}, &corev1.ConfigMap{})
+
+		kustomizations := "/apis/" + kustomizev1.GroupVersion.String() + "/kustomizations"
+		if got := s.selector(kustomizations); got != "sharding.fluxcd.io/key=shard1" {
+			t.Errorf("Kustomizations listed with the selector '%s'", got)
+		}
+		if got := s.selector("/api/v1/configmaps"); got != "" {
+			t.Errorf("ConfigMaps listed with the selector '%s'", got)
+		}
+	})
+}
+
+func TestLeaderElectionID(t *testing.T) {
+	const id = "kustomize-controller-leader-election"
+
+	if got := (Options{}).LeaderElectionID(id); got != id {
+		t.Errorf("LeaderElectionID() without selector = %s, want %s", got, id)
+	}
+
+	shard1 := Options{LabelSelector: "sharding.fluxcd.io/key=shard1"}.LeaderElectionID(id)
+	if want := id + "-d08a7d5069d2bdfb"; shard1 != want {
+		t.Errorf("LeaderElectionID() = %s, want %s", shard1, want)
+	}
+	if got := (Options{LabelSelector: "sharding.fluxcd.io/key=shard2"}).LeaderElectionID(id); got == shard1 {
+		t.Errorf("LeaderElectionID() is the same for different selectors: %s", got)
+	}
+}
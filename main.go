@@ -17,8 +17,10 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
 
 	flag "github.com/spf13/pflag"
@@ -40,6 +42,9 @@ import (
 
 	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
 	"github.com/fluxcd/kustomize-controller/controllers"
+	"github.com/fluxcd/kustomize-controller/internal/discovery"
+	"github.com/fluxcd/kustomize-controller/internal/tracing"
+	"github.com/fluxcd/kustomize-controller/internal/watch"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -60,16 +65,43 @@ func init() {
 
 func main() {
 	var (
-		metricsAddr           string
-		eventsAddr            string
-		healthAddr            string
-		concurrent            int
-		requeueDependency     time.Duration
-		clientOptions         client.Options
-		logOptions            logger.Options
-		leaderElectionOptions leaderelection.Options
-		watchAllNamespaces    bool
-		httpRetry             int
+		metricsAddr            string
+		eventsAddr             string
+		healthAddr             string
+		concurrent             int
+		requeueDependency      time.Duration
+		clientOptions          client.Options
+		logOptions             logger.Options
+		leaderElectionOptions  leaderelection.Options
+		discoveryOptions       discovery.Options
+		watchAllNamespaces     bool
+		watchOptions           watch.Options
+		tracingOptions         tracing.Options
+		httpRetry              int
+		statusReportInterval   time.Duration
+		reconcileBudget        time.Duration
+		verboseEvents          bool
+		namespaceFairness      bool
+		readOnly               bool
+		pruneDisabledFor       []string
+		maxRetryInterval       time.Duration
+		stallAfterFailures     int64
+		driftDetection         bool
+		attestationKeyFile     string
+		bootstrapRetry         time.Duration
+		diffEvents             bool
+		applyBatchSize         int
+		applyConcurrency       int
+		applyBatchInterval     time.Duration
+		maxDelta               string
+		lockWaitThreshold      time.Duration
+		artifactCacheDir       string
+		defaultServiceAccount  string
+		noCrossNamespaceRefs   bool
+		restrictToOwnNamespace bool
+		insecureKubeConfigExec bool
+		canaryAnalysis         bool
+		enableWebhook          bool
 	)
 
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
@@ -80,13 +112,70 @@ func main() {
 	flag.BoolVar(&watchAllNamespaces, "watch-all-namespaces", true,
 		"Watch for custom resources in all namespaces, if set to false it will only watch the runtime namespace.")
 	flag.IntVar(&httpRetry, "http-retry", 9, "The maximum number of retries when failing to fetch artifacts over HTTP.")
+	flag.DurationVar(&statusReportInterval, "status-report-interval", 0,
+		"The interval at which the KustomizationStatusReports are updated in each namespace, the reports are disabled when set to 0.")
+	flag.DurationVar(&reconcileBudget, "reconcile-budget", 0,
+		"The maximum time spent applying objects in a single reconciliation, when exceeded the progress is checkpointed and the apply resumes in a subsequent reconciliation. The budget is disabled when set to 0.")
+	flag.BoolVar(&verboseEvents, "verbose-events", false,
+		"List all the changed objects in the events, instead of a summary with the number of objects per action.")
+	flag.BoolVar(&namespaceFairness, "namespace-fairness", false,
+		"Share the concurrent reconciles equally between the namespaces, so that a namespace with many Kustomizations can't delay the reconciliation of the others.")
+	flag.BoolVar(&readOnly, "read-only", false,
+		"Suspend the apply and the garbage collection of all Kustomizations, while reporting the changes of each revision in the status.")
+	flag.StringSliceVar(&pruneDisabledFor, "prune-disabled-for", nil,
+		"The kinds of objects that are never deleted by the garbage collection of any Kustomization e.g. PersistentVolumeClaim,Namespace.")
+	flag.DurationVar(&maxRetryInterval, "max-retry-interval", 0,
+		"The maximum interval at which failing Kustomizations are retried, the retry interval is doubled after each consecutive failure up to this value. The backoff is disabled when set to 0.")
+	flag.Int64Var(&stallAfterFailures, "stall-after-failures", 0,
+		"The number of consecutive failed reconciliations after which a Kustomization is marked as stalled. Disabled when set to 0.")
+	flag.BoolVar(&driftDetection, "drift-detection", false,
+		"Watch the objects applied by the Kustomizations and reconcile them as soon as they are modified or deleted on the cluster, instead of waiting for the next interval. The metadata of all the objects of the applied kinds is cached, which requires list and watch permissions on these kinds.")
+	flag.StringVar(&attestationKeyFile, "attestation-key-file", "",
+		"The path to an ed25519 private key in PKCS #8 PEM format, used to sign the attestations of the Kustomizations with spec.attest enabled.")
+	flag.DurationVar(&bootstrapRetry, "bootstrap-retry-interval", 0,
+		"The initial interval at which the Kustomizations that were never applied are retried, doubled after each failure up to their retry interval. Disabled when set to 0.")
+	flag.BoolVar(&diffEvents, "diff-events", false,
+		"Compute the changes with a server-side dry-run before each apply, and issue an event listing the objects to be created, configured and deleted.")
+	flag.IntVar(&applyBatchSize, "apply-batch-size", 0,
+		"The maximum number of objects applied in a batch, the reconcile budget is checked and the batch interval is awaited between batches. All the objects of a stage are applied in a single batch when set to 0.")
+	flag.IntVar(&applyConcurrency, "apply-concurrency", 1,
+		"The number of objects applied in parallel within a batch.")
+	flag.DurationVar(&applyBatchInterval, "apply-batch-interval", 0,
+		"The time to wait between the apply batches.")
+	flag.StringVar(&maxDelta, "max-delta", "",
+		"The maximum number or percentage of the inventory objects that a reconciliation can modify or delete e.g. 30%, the revisions exceeding it are not applied. Disabled when not set.")
+	flag.DurationVar(&lockWaitThreshold, "lock-wait-threshold", 30*time.Second,
+		"The time a reconciliation can wait for the kustomize builds of other Kustomizations before the delay is logged and reported with an event. Disabled when set to 0.")
+	flag.StringVar(&artifactCacheDir, "artifact-cache-dir", filepath.Join(os.TempDir(), "artifact-cache"),
+		"The directory where the artifact of the last healthy revision is kept for the Kustomizations with rollback enabled.")
+	flag.StringVar(&defaultServiceAccount, "default-service-account", "",
+		"The service account to impersonate when a Kustomization doesn't specify one, in the namespace of the Kustomization.")
+	flag.BoolVar(&noCrossNamespaceRefs, "no-cross-namespace-refs", false,
+		"When set to true, the Kustomizations referring to sources or dependencies in other namespaces are not reconciled.")
+	flag.BoolVar(&restrictToOwnNamespace, "restrict-to-own-namespace", false,
+		"When set to true, the Kustomizations can only apply objects to their own namespace, unless the NamespaceConfig named after their namespace in the controller namespace allows other namespaces.")
+	flag.BoolVar(&insecureKubeConfigExec, "insecure-kubeconfig-exec", false,
+		"Allow the kubeconfigs of the KubeConfig secrets to run exec credential and auth provider plugins, and to read files, in the controller container.")
+	flag.BoolVar(&canaryAnalysis, "canary-analysis", false,
+		"Mark the Kustomizations as progressing until the Flagger canary analysis of the Deployments they configure completes.")
+	flag.BoolVar(&enableWebhook, "enable-webhook", false,
+		"Serve the validating admission webhook of the Kustomizations on port 9443, the TLS certificate is read from the controller-runtime default directory.")
 	clientOptions.BindFlags(flag.CommandLine)
 	logOptions.BindFlags(flag.CommandLine)
 	leaderElectionOptions.BindFlags(flag.CommandLine)
+	discoveryOptions.BindFlags(flag.CommandLine)
+	watchOptions.BindFlags(flag.CommandLine)
+	tracingOptions.BindFlags(flag.CommandLine)
 	flag.Parse()
 
 	ctrl.SetLogger(logger.NewLogger(logOptions))
 
+	shutdownTracing, err := tracingOptions.Setup(context.Background(), controllerName)
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+
 	var eventRecorder *events.Recorder
 	if eventsAddr != "" {
 		if er, err := events.NewRecorder(eventsAddr, controllerName); err != nil {
@@ -100,12 +189,28 @@ func main() {
 	metricsRecorder := metrics.NewRecorder()
 	crtlmetrics.Registry.MustRegister(metricsRecorder.Collectors()...)
 
+	if err := watchOptions.Validate(watchAllNamespaces); err != nil {
+		setupLog.Error(err, "invalid watch flags")
+		os.Exit(1)
+	}
 	watchNamespace := ""
 	if !watchAllNamespaces {
 		watchNamespace = os.Getenv("RUNTIME_NAMESPACE")
 	}
 
 	restConfig := client.GetConfigOrDie(clientOptions)
+	tracing.WrapConfig(restConfig)
+	watchNamespaces, err := watchOptions.ResolveNamespaces(context.Background(), restConfig)
+	if err != nil {
+		setupLog.Error(err, "unable to select the namespaces to watch")
+		os.Exit(1)
+	}
+	newCache, err := watchOptions.NewCache(watchNamespaces, &kustomizev1.Kustomization{})
+	if err != nil {
+		setupLog.Error(err, "unable to create the cache")
+		os.Exit(1)
+	}
+
 	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
 		Scheme:                        scheme,
 		MetricsBindAddress:            metricsAddr,
@@ -116,8 +221,10 @@ func main() {
 		LeaseDuration:                 &leaderElectionOptions.LeaseDuration,
 		RenewDeadline:                 &leaderElectionOptions.RenewDeadline,
 		RetryPeriod:                   &leaderElectionOptions.RetryPeriod,
-		LeaderElectionID:              fmt.Sprintf("%s-leader-election", controllerName),
+		LeaderElectionID:              watchOptions.LeaderElectionID(fmt.Sprintf("%s-leader-election", controllerName)),
 		Namespace:                     watchNamespace,
+		MapperProvider:                discoveryOptions.NewRESTMapper,
+		NewCache:                      newCache,
 		Logger:                        ctrl.Log,
 	})
 	if err != nil {
@@ -139,15 +246,61 @@ func main() {
 		MaxConcurrentReconciles:   concurrent,
 		DependencyRequeueInterval: requeueDependency,
 		HTTPRetry:                 httpRetry,
+		ReconcileBudget:           reconcileBudget,
+		VerboseEvents:             verboseEvents,
+		NamespaceFairness:         namespaceFairness,
+		ReadOnly:                  readOnly,
+		PruneDisabledFor:          pruneDisabledFor,
+		ControllerNamespace:       os.Getenv("RUNTIME_NAMESPACE"),
+		MaxRetryInterval:          maxRetryInterval,
+		StallAfterFailures:        stallAfterFailures,
+		DriftDetection:            driftDetection,
+		AttestationKeyFile:        attestationKeyFile,
+		BootstrapRetryInterval:    bootstrapRetry,
+		DiffEvents:                diffEvents,
+		ApplyBatchSize:            applyBatchSize,
+		ApplyConcurrency:          applyConcurrency,
+		ApplyBatchInterval:        applyBatchInterval,
+		MaxDelta:                  maxDelta,
+		LockWaitThreshold:         lockWaitThreshold,
+		ArtifactCacheDir:          artifactCacheDir,
+		DefaultServiceAccount:     defaultServiceAccount,
+		NoCrossNamespaceRefs:      noCrossNamespaceRefs,
+		RestrictToOwnNamespace:    restrictToOwnNamespace,
+		InsecureKubeConfigExec:    insecureKubeConfigExec,
+		CanaryAnalysis:            canaryAnalysis,
+		DiscoveryOptions:          discoveryOptions,
 	}); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", kustomizev1.KustomizationKind)
 		os.Exit(1)
 	}
+	if enableWebhook {
+		(&controllers.KustomizationValidator{}).SetupWebhookWithManager(mgr)
+	}
+	if statusReportInterval > 0 {
+		if err = (&controllers.KustomizationStatusReportReconciler{
+			Client: mgr.GetClient(),
+		}).SetupWithManager(mgr, controllers.KustomizationStatusReportReconcilerOptions{
+			Interval: statusReportInterval,
+		}); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", kustomizev1.KustomizationStatusReportKind)
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
-		setupLog.Error(err, "problem running manager")
+	mgrErr := mgr.Start(ctrl.SetupSignalHandler())
+
+	// flush the spans of the last reconciliations
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		setupLog.Error(err, "unable to flush the traces")
+	}
+
+	if mgrErr != nil {
+		setupLog.Error(mgrErr, "problem running manager")
 		os.Exit(1)
 	}
 }
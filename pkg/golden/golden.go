@@ -0,0 +1,111 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package golden renders Kustomizations against local fixture directories
+// the same way the kustomize-controller does, and compares the output to golden
+// files. It can be used by platform teams to write regression tests for the
+// patches and variable substitutions of their Kustomizations.
+package golden
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+	"github.com/fluxcd/kustomize-controller/controllers"
+)
+
+var update = flag.Bool("update-golden", false, "rewrite the golden files with the rendered output")
+
+// Options holds the options of the rendering.
+type Options struct {
+	// Client is used to read the Secrets and ConfigMaps referenced by the
+	// decryption and the variable substitutions of the Kustomization,
+	// defaults to a client with no objects.
+	Client client.Client
+
+	// Update rewrites the golden files with the rendered output instead of
+	// comparing them, also enabled with the '-update-golden' test flag.
+	Update bool
+}
+
+// Render returns the multi-doc YAML of the objects the controller would apply
+// for the Kustomization, with the fixture directory as the artifact root.
+// The fixture directory is left unchanged.
+func Render(kustomization kustomizev1.Kustomization, fixtureDir string, opts Options) ([]byte, error) {
+	kubeClient := opts.Client
+	if kubeClient == nil {
+		kubeClient = fake.NewClientBuilder().Build()
+	}
+	return controllers.Render(context.Background(), kubeClient, kustomization, fixtureDir)
+}
+
+// Assert renders the Kustomization against the fixture directory and fails
+// the test if the output differs from the golden file, reporting a unified diff.
+func Assert(t testing.TB, kustomization kustomizev1.Kustomization, fixtureDir, goldenFile string, opts Options) {
+	t.Helper()
+
+	got, err := Render(kustomization, fixtureDir, opts)
+	if err != nil {
+		t.Fatalf("unable to render %s: %v", fixtureDir, err)
+	}
+
+	if opts.Update || *update {
+		if err := os.MkdirAll(filepath.Dir(goldenFile), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(goldenFile, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatalf("unable to read the golden file, run the test with -update-golden to create it: %v", err)
+	}
+	if diff := Diff(want, got, goldenFile); diff != "" {
+		t.Errorf("rendered output differs from the golden file:\n%s", diff)
+	}
+}
+
+// Diff returns the unified diff between the golden and the rendered output,
+// or an empty string if they are identical.
+func Diff(want, got []byte, goldenFile string) string {
+	if string(want) == string(got) {
+		return ""
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(want)),
+		B:        difflib.SplitLines(string(got)),
+		FromFile: goldenFile,
+		ToFile:   "rendered",
+		Context:  3,
+	})
+	if err != nil {
+		return fmt.Sprintf("unable to compute the diff: %v", err)
+	}
+	return diff
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golden
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+	"github.com/fluxcd/pkg/apis/kustomize"
+)
+
+func testKustomization() kustomizev1.Kustomization {
+	kustomization := kustomizev1.Kustomization{}
+	kustomization.SetName("backend")
+	kustomization.SetNamespace("flux-system")
+	kustomization.Spec.Path = "./apps"
+	kustomization.Spec.TargetNamespace = "staging"
+	kustomization.Spec.Images = []kustomize.Image{{Name: "ghcr.io/stefanprodan/podinfo", NewTag: "5.2.1"}}
+	kustomization.Spec.Patches = []kustomize.Patch{{
+		Patch: `[{"op": "replace", "path": "/spec/replicas", "value": 2}]`,
+		Target: kustomize.Selector{
+			Kind: "Deployment",
+			Name: "backend",
+		},
+	}}
+	kustomization.Spec.PostBuild = &kustomizev1.PostBuild{
+		Substitute: map[string]string{"cluster_name": "staging-1"},
+		SubstituteFrom: []kustomizev1.SubstituteReference{
+			{Kind: "ConfigMap", Name: "cluster-vars"},
+		},
+	}
+	return kustomization
+}
+
+func TestAssert(t *testing.T) {
+	kubeClient := fake.NewClientBuilder().WithObjects(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster-vars", Namespace: "flux-system"},
+		Data:       map[string]string{"region": "us-east-1"},
+	}).Build()
+
+	Assert(t, testKustomization(), "testdata", filepath.Join("testdata", "backend.golden.yaml"), Options{Client: kubeClient})
+
+	if _, err := ioutil.ReadFile(filepath.Join("testdata", "apps", "kustomization-gc-labels.yaml")); err == nil {
+		t.Error("expected the fixture directory to be left unchanged")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	kubeClient := fake.NewClientBuilder().WithObjects(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster-vars", Namespace: "flux-system"},
+		Data:       map[string]string{"region": "us-west-2"},
+	}).Build()
+
+	got, err := Render(testKustomization(), "testdata", Options{Client: kubeClient})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want, err := ioutil.ReadFile(filepath.Join("testdata", "backend.golden.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff := Diff(want, got, "backend.golden.yaml")
+	if !strings.Contains(diff, "-          value: us-east-1") || !strings.Contains(diff, "+          value: us-west-2") {
+		t.Errorf("expected the diff to report the changed substitution, got:\n%s", diff)
+	}
+}
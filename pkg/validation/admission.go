@@ -0,0 +1,85 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	admissionDeniedRegexp  = regexp.MustCompile(`(?s)admission webhook "([^"]+)" denied the request:\s*(.*)`)
+	gatekeeperPolicyRegexp = regexp.MustCompile(`^\[([^\]]+)\]\s*(.*)$`)
+	kyvernoPolicyRegexp    = regexp.MustCompile(`(?s)blocked due to the following policies\s*\n\s*([^\s:]+):\s*\n\s*(.*)`)
+)
+
+// AdmissionDeniedError is the error returned when an admission webhook,
+// usually a policy engine like OPA Gatekeeper or Kyverno, denies
+// the validation or the apply of an object.
+type AdmissionDeniedError struct {
+	// Webhook is the name of the admission webhook that denied the request.
+	Webhook string
+	// Policy is the name of the policy (Gatekeeper constraint or Kyverno
+	// policy) that denied the request, if it could be determined.
+	Policy string
+	// Message is the reason of the denial reported by the webhook.
+	Message string
+}
+
+func (e *AdmissionDeniedError) Error() string {
+	if e.Policy == "" {
+		return fmt.Sprintf("denied by admission webhook '%s': %s; update the manifests to comply with the cluster policies",
+			e.Webhook, e.Message)
+	}
+	return fmt.Sprintf("denied by policy '%s' (admission webhook '%s'): %s; update the manifests to comply with the policy or ask the cluster admin for an exception",
+		e.Policy, e.Webhook, e.Message)
+}
+
+// ParseAdmissionDenial extracts the webhook, the policy and the message
+// from the output of a denied request. Returns nil if the output
+// does not contain an admission denial.
+// Supported formats:
+// admission webhook "validation.gatekeeper.sh" denied the request: [constraint] message
+// admission webhook "validate.kyverno.svc" denied the request: ... blocked due to the following policies policy: rule: message
+// admission webhook "validating-webhook.openpolicyagent.org" denied the request: message
+func ParseAdmissionDenial(output string) *AdmissionDeniedError {
+	matches := admissionDeniedRegexp.FindStringSubmatch(output)
+	if matches == nil {
+		return nil
+	}
+
+	result := &AdmissionDeniedError{
+		Webhook: matches[1],
+		Message: strings.TrimSpace(matches[2]),
+	}
+
+	if m := kyvernoPolicyRegexp.FindStringSubmatch(result.Message); m != nil {
+		result.Policy = m[1]
+		result.Message = strings.TrimSpace(m[2])
+	} else if m := gatekeeperPolicyRegexp.FindStringSubmatch(firstLine(result.Message)); m != nil {
+		result.Policy = m[1]
+		result.Message = strings.TrimSpace(m[2])
+	}
+
+	result.Message = firstLine(result.Message)
+	return result
+}
+
+func firstLine(s string) string {
+	return strings.TrimSpace(strings.SplitN(s, "\n", 2)[0])
+}
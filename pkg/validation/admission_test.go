@@ -0,0 +1,85 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"testing"
+)
+
+func TestParseAdmissionDenial(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   *AdmissionDeniedError
+	}{
+		{
+			name:   "gatekeeper",
+			output: `Error from server (Forbidden): error when creating "test.yaml": admission webhook "validation.gatekeeper.sh" denied the request: [ns-must-have-owner] you must provide labels: {"owner"}`,
+			want: &AdmissionDeniedError{
+				Webhook: "validation.gatekeeper.sh",
+				Policy:  "ns-must-have-owner",
+				Message: `you must provide labels: {"owner"}`,
+			},
+		},
+		{
+			name: "kyverno",
+			output: `Error from server: error when creating "test.yaml": admission webhook "validate.kyverno.svc-fail" denied the request: 
+
+resource Deployment/default/nginx was blocked due to the following policies
+
+require-labels:
+  check-for-labels: 'validation error: label app.kubernetes.io/name is required. Rule check-for-labels failed at path /metadata/labels/app.kubernetes.io/name/'
+`,
+			want: &AdmissionDeniedError{
+				Webhook: "validate.kyverno.svc-fail",
+				Policy:  "require-labels",
+				Message: "check-for-labels: 'validation error: label app.kubernetes.io/name is required. Rule check-for-labels failed at path /metadata/labels/app.kubernetes.io/name/'",
+			},
+		},
+		{
+			name:   "opa",
+			output: `Error from server: error when creating "test.yaml": admission webhook "validating-webhook.openpolicyagent.org" denied the request: image 'nginx' comes from untrusted registry`,
+			want: &AdmissionDeniedError{
+				Webhook: "validating-webhook.openpolicyagent.org",
+				Message: "image 'nginx' comes from untrusted registry",
+			},
+		},
+		{
+			name:   "not denied",
+			output: `The Service "backend" is invalid: spec.type: Unsupported value: "Ingress"`,
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseAdmissionDenial(tt.output)
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("expected no denial, got %v", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatalf("expected denial %v, got nil", tt.want)
+			}
+			if *got != *tt.want {
+				t.Errorf("expected %+v, got %+v", *tt.want, *got)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package validation validates the Kubernetes objects rendered by a kustomize
+// build against a cluster, the same way the kustomize-controller does before
+// applying them. It can be used by other tools, e.g. in CI pipelines, to get
+// the same validation results as the controller.
+package validation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ClientMode checks that the kinds of the objects are served by the API server.
+	ClientMode = "client"
+
+	// ServerMode performs a server-side dry-run apply of the objects,
+	// in addition to the client checks.
+	ServerMode = "server"
+
+	// FieldManager is the name of the field manager used for the dry-run apply.
+	FieldManager = "kustomize-controller"
+)
+
+// DryRunFunc performs a server-side dry-run apply of the object.
+type DryRunFunc func(ctx context.Context, obj *unstructured.Unstructured) error
+
+// Options holds the configuration of a Validator.
+type Options struct {
+	// Mode is the validation mode, either ClientMode or ServerMode.
+	Mode string
+
+	// Force skips the immutable field errors returned by the dry-run,
+	// as the objects are recreated at apply time.
+	Force bool
+
+	// DryRun overrides the server-side dry-run apply,
+	// defaults to a server-side apply with the DryRunAll option.
+	DryRun DryRunFunc
+
+	// Fallback skips the objects whose kinds are not served by the API server,
+	// instead of failing the validation, e.g. when the CRD is installed by another
+	// Kustomization reconciled at the same time. The objects that can't be dry-run
+	// for the same reason are validated client-side only.
+	Fallback bool
+
+	// AllErrors validates all the objects and returns the errors of all the
+	// invalid objects, instead of stopping at the first invalid object.
+	AllErrors bool
+}
+
+// Errors holds the validation errors of several objects.
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the first error, so that an AdmissionDeniedError
+// of the first invalid object can be retrieved with errors.As.
+func (e Errors) Unwrap() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e[0]
+}
+
+// Validator validates the objects rendered by a kustomize build.
+type Validator struct {
+	client client.Client
+	opts   Options
+}
+
+// NewValidator returns a Validator for the cluster of the given client.
+func NewValidator(kubeClient client.Client, opts Options) *Validator {
+	v := &Validator{
+		client: kubeClient,
+		opts:   opts,
+	}
+	if v.opts.DryRun == nil {
+		v.opts.DryRun = v.dryRun
+	}
+	return v
+}
+
+// Validate validates the objects of a kustomize build. The objects of the kinds
+// defined by the CRDs of the same build, and the objects in the namespaces
+// created by the same build, can't be validated before the apply and are skipped.
+// The namespace of the namespaced objects that don't specify one is set to 'default'.
+// When the validation is denied by an admission webhook, the returned error
+// wraps an AdmissionDeniedError. With the AllErrors option, the returned error
+// is of type Errors when several objects are invalid.
+func (v *Validator) Validate(ctx context.Context, objects []*unstructured.Unstructured) error {
+	namespaces := make(map[string]bool)
+	definedKinds := make(map[string]bool)
+	for _, obj := range objects {
+		switch {
+		case obj.GetKind() == "Namespace" && obj.GroupVersionKind().Group == "":
+			namespaces[obj.GetName()] = true
+		case obj.GetKind() == "CustomResourceDefinition":
+			group, _, _ := unstructured.NestedString(obj.Object, "spec", "group")
+			kind, _, _ := unstructured.NestedString(obj.Object, "spec", "names", "kind")
+			definedKinds[group+"/"+kind] = true
+		}
+	}
+
+	var errs Errors
+	for _, obj := range objects {
+		gvk := obj.GroupVersionKind()
+		if definedKinds[gvk.Group+"/"+gvk.Kind] {
+			continue
+		}
+		err := v.validateObject(ctx, obj, namespaces)
+		if err == nil {
+			continue
+		}
+		if !v.opts.AllErrors || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return err
+		}
+		errs = append(errs, err)
+	}
+
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return errs
+	}
+}
+
+// validateObject validates the object, the namespaces
+// created by the same build are ignored by the dry-run.
+func (v *Validator) validateObject(ctx context.Context, obj *unstructured.Unstructured, namespaces map[string]bool) error {
+	log := logr.FromContextOrDiscard(ctx)
+
+	gvk := obj.GroupVersionKind()
+	if _, err := v.client.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+		if v.opts.Fallback && apimeta.IsNoMatchError(err) {
+			log.Info("validation skipped, the kind is not served by the API server", "object", objectID(obj))
+			return nil
+		}
+		return fmt.Errorf("validation failed: %s %w", objectID(obj), err)
+	}
+	if err := SetDefaultNamespace(v.client.RESTMapper(), obj); err != nil {
+		return fmt.Errorf("validation failed: %w", err)
+	}
+
+	if v.opts.Mode != ServerMode {
+		return nil
+	}
+
+	if err := v.opts.DryRun(ctx, obj); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("validation timeout: %w", ctx.Err())
+		}
+		if apierrors.IsNotFound(err) && namespaces[obj.GetNamespace()] {
+			return nil
+		}
+		if v.opts.Fallback && IsKindNotServedError(err) {
+			log.Info("validated client-side, the kind is not served by the API server", "object", objectID(obj))
+			return nil
+		}
+		if v.opts.Force && IsImmutableError(err) {
+			// the object will be recreated at apply time
+			log.Info("object will be recreated due to an immutable field change", "object", objectID(obj))
+			return nil
+		}
+		if denied := ParseAdmissionDenial(err.Error()); denied != nil {
+			return fmt.Errorf("validation failed: %w", denied)
+		}
+		return fmt.Errorf("validation failed: %s %w", objectID(obj), err)
+	}
+	return nil
+}
+
+func (v *Validator) dryRun(ctx context.Context, obj *unstructured.Unstructured) error {
+	return v.client.Patch(ctx, obj.DeepCopy(), client.Apply,
+		client.ForceOwnership, client.FieldOwner(FieldManager), client.DryRunAll)
+}
+
+// SetDefaultNamespace sets the namespace of namespaced objects
+// that don't specify one to 'default'.
+func SetDefaultNamespace(mapper apimeta.RESTMapper, obj *unstructured.Unstructured) error {
+	if obj.GetNamespace() != "" {
+		return nil
+	}
+
+	gvk := obj.GroupVersionKind()
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		if apimeta.IsNoMatchError(err) {
+			// the kind may be defined by a CRD of the same build
+			return nil
+		}
+		return err
+	}
+
+	if mapping.Scope.Name() == apimeta.RESTScopeNameNamespace {
+		obj.SetNamespace("default")
+	}
+	return nil
+}
+
+// IsKindNotServedError returns true if the request failed because
+// the kind of the object is not served by the API server.
+func IsKindNotServedError(err error) bool {
+	if apimeta.IsNoMatchError(err) {
+		return true
+	}
+	var status apierrors.APIStatus
+	if apierrors.IsNotFound(err) && errors.As(err, &status) {
+		details := status.Status().Details
+		return details == nil || details.Name == ""
+	}
+	return false
+}
+
+// IsImmutableError returns true if the apply
+// failed due to an immutable field change.
+func IsImmutableError(err error) bool {
+	if !apierrors.IsInvalid(err) && !apierrors.IsForbidden(err) {
+		return false
+	}
+	return strings.Contains(err.Error(), "field is immutable") ||
+		strings.Contains(err.Error(), "updates to") && strings.Contains(err.Error(), "are forbidden")
+}
+
+func objectID(obj *unstructured.Unstructured) string {
+	id := strings.ToLower(obj.GetKind())
+	if obj.GetNamespace() != "" {
+		return fmt.Sprintf("%s/%s/%s", id, obj.GetNamespace(), obj.GetName())
+	}
+	return fmt.Sprintf("%s/%s", id, obj.GetName())
+}
@@ -0,0 +1,257 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// mapperClient overrides the REST mapper of the fake client.
+type mapperClient struct {
+	client.Client
+	mapper apimeta.RESTMapper
+}
+
+func (c *mapperClient) RESTMapper() apimeta.RESTMapper {
+	return c.mapper
+}
+
+func newObject(apiVersion, kind, namespace, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return obj
+}
+
+func TestValidate(t *testing.T) {
+	mapper := apimeta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, apimeta.RESTScopeRoot)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, apimeta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Service"}, apimeta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition"}, apimeta.RESTScopeRoot)
+	kubeClient := &mapperClient{Client: fake.NewClientBuilder().Build(), mapper: mapper}
+
+	crd := newObject("apiextensions.k8s.io/v1", "CustomResourceDefinition", "", "certificates.cert-manager.io")
+	_ = unstructured.SetNestedField(crd.Object, "cert-manager.io", "spec", "group")
+	_ = unstructured.SetNestedField(crd.Object, "Certificate", "spec", "names", "kind")
+
+	immutable := apierrors.NewInvalid(schema.GroupKind{Kind: "Service"}, "backend", field.ErrorList{
+		field.Invalid(field.NewPath("spec", "clusterIP"), "10.200.133.61", "field is immutable"),
+	})
+	denied := errors.New(`admission webhook "validation.gatekeeper.sh" denied the request: [must-have-owner] you must provide labels: {"owner"}`)
+
+	dryRun := func(ctx context.Context, obj *unstructured.Unstructured) error {
+		switch {
+		case obj.GetNamespace() == "test":
+			return apierrors.NewNotFound(schema.GroupResource{Resource: "namespaces"}, "test")
+		case obj.GetKind() == "Service":
+			return immutable
+		case obj.GetName() == "denied":
+			return denied
+		case obj.GetKind() == "Namespace" && obj.GetName() == "stale":
+			// the kind was removed after the REST mapping was cached
+			return apierrors.NewNotFound(schema.GroupResource{}, "")
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name    string
+		opts    Options
+		objects []*unstructured.Unstructured
+		wantErr string
+	}{
+		{
+			name: "client skips the kinds defined in the build",
+			opts: Options{Mode: ClientMode},
+			objects: []*unstructured.Unstructured{
+				crd,
+				newObject("cert-manager.io/v1", "Certificate", "default", "test"),
+				newObject("v1", "ConfigMap", "", "test"),
+			},
+		},
+		{
+			name:    "client fails for unknown kinds",
+			opts:    Options{Mode: ClientMode},
+			objects: []*unstructured.Unstructured{newObject("apps/v1", "Deployment", "default", "test")},
+			wantErr: "validation failed: deployment/default/test",
+		},
+		{
+			name: "server skips the objects in the namespaces defined in the build",
+			opts: Options{Mode: ServerMode, DryRun: dryRun},
+			objects: []*unstructured.Unstructured{
+				newObject("v1", "Namespace", "", "test"),
+				newObject("v1", "ConfigMap", "test", "test"),
+			},
+		},
+		{
+			name:    "server fails for missing namespaces",
+			opts:    Options{Mode: ServerMode, DryRun: dryRun},
+			objects: []*unstructured.Unstructured{newObject("v1", "ConfigMap", "test", "test")},
+			wantErr: "validation failed: configmap/test/test",
+		},
+		{
+			name:    "server fails for immutable fields",
+			opts:    Options{Mode: ServerMode, DryRun: dryRun},
+			objects: []*unstructured.Unstructured{newObject("v1", "Service", "default", "backend")},
+			wantErr: "field is immutable",
+		},
+		{
+			name:    "server with force skips immutable fields",
+			opts:    Options{Mode: ServerMode, Force: true, DryRun: dryRun},
+			objects: []*unstructured.Unstructured{newObject("v1", "Service", "default", "backend")},
+		},
+		{
+			name:    "server fails for unknown kinds",
+			opts:    Options{Mode: ServerMode, DryRun: dryRun},
+			objects: []*unstructured.Unstructured{newObject("apps/v1", "Deployment", "default", "test")},
+			wantErr: "validation failed: deployment/default/test",
+		},
+		{
+			name: "server with fallback skips unknown kinds",
+			opts: Options{Mode: ServerMode, Fallback: true, DryRun: dryRun},
+			objects: []*unstructured.Unstructured{
+				newObject("apps/v1", "Deployment", "default", "test"),
+				newObject("v1", "Namespace", "", "stale"),
+				newObject("v1", "ConfigMap", "default", "test"),
+			},
+		},
+		{
+			name:    "server with fallback fails for missing namespaces",
+			opts:    Options{Mode: ServerMode, Fallback: true, DryRun: dryRun},
+			objects: []*unstructured.Unstructured{newObject("v1", "ConfigMap", "test", "test")},
+			wantErr: "validation failed: configmap/test/test",
+		},
+		{
+			name:    "server reports admission denials",
+			opts:    Options{Mode: ServerMode, DryRun: dryRun},
+			objects: []*unstructured.Unstructured{newObject("v1", "ConfigMap", "default", "denied")},
+			wantErr: "denied by policy 'must-have-owner'",
+		},
+		{
+			name: "server stops at the first invalid object",
+			opts: Options{Mode: ServerMode, DryRun: dryRun},
+			objects: []*unstructured.Unstructured{
+				newObject("v1", "Service", "default", "backend"),
+				newObject("v1", "ConfigMap", "default", "denied"),
+			},
+			wantErr: "validation failed: service/default/backend",
+		},
+		{
+			name: "server with all errors reports all the invalid objects",
+			opts: Options{Mode: ServerMode, AllErrors: true, DryRun: dryRun},
+			objects: []*unstructured.Unstructured{
+				newObject("v1", "Service", "default", "backend"),
+				newObject("v1", "ConfigMap", "default", "test"),
+				newObject("v1", "ConfigMap", "default", "denied"),
+			},
+			wantErr: "field is immutable; validation failed: denied by policy 'must-have-owner'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewValidator(kubeClient, tt.opts).Validate(context.TODO(), tt.objects)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing '%s', got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestSetDefaultNamespace(t *testing.T) {
+	mapper := apimeta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, apimeta.RESTScopeRoot)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, apimeta.RESTScopeNamespace)
+
+	cm := newObject("v1", "ConfigMap", "", "test")
+	ns := newObject("v1", "Namespace", "", "test")
+	cr := newObject("cert-manager.io/v1", "Certificate", "", "test")
+	for _, obj := range []*unstructured.Unstructured{cm, ns, cr} {
+		if err := SetDefaultNamespace(mapper, obj); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if cm.GetNamespace() != "default" {
+		t.Errorf("expected the namespace of the ConfigMap to be defaulted")
+	}
+	if ns.GetNamespace() != "" || cr.GetNamespace() != "" {
+		t.Errorf("expected the namespace of cluster-scoped and unknown kinds to be left empty")
+	}
+}
+
+func TestIsImmutableError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "immutable field",
+			err: apierrors.NewInvalid(schema.GroupKind{Kind: "Service"}, "backend", field.ErrorList{
+				field.Invalid(field.NewPath("spec", "clusterIP"), "10.200.133.61", "field is immutable"),
+			}),
+			want: true,
+		},
+		{
+			name: "forbidden update",
+			err: apierrors.NewInvalid(schema.GroupKind{Group: "apps", Kind: "StatefulSet"}, "db", field.ErrorList{
+				field.Forbidden(field.NewPath("spec"), "updates to statefulset spec for fields other than 'replicas' are forbidden"),
+			}),
+			want: true,
+		},
+		{
+			name: "invalid value",
+			err: apierrors.NewInvalid(schema.GroupKind{Kind: "Service"}, "backend", field.ErrorList{
+				field.NotSupported(field.NewPath("spec", "type"), "Ingress", []string{"ClusterIP"}),
+			}),
+			want: false,
+		},
+		{
+			name: "conflict",
+			err:  apierrors.NewConflict(schema.GroupResource{Resource: "services"}, "backend", nil),
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsImmutableError(tt.err); got != tt.want {
+				t.Errorf("expected %v, got %v for %v", tt.want, got, tt.err)
+			}
+		})
+	}
+}